@@ -11,6 +11,9 @@
     <xsl:variable name="vLower" select="'abcdefghijklmnopqrstuvwxyz'"/>
     <xsl:variable name="vUpper" select="'ABCDEFGHIJKLMNOPQRSTUVWXYZ'"/>
 
+    <!-- Written either side of the text of each abstract, so the text conversion can tell where they are -->
+    <xsl:param name="abstract-marker" select="''"/>
+
     <!-- journal-meta -->
 
     <xsl:template match="journal-meta/*" priority="0.0">
@@ -132,7 +135,7 @@
         <xsl:param name="caps">
             <xsl:value-of select="concat(translate(substring($abstype,1,1), $vLower, $vUpper), substring($abstype, 2))"/>
         </xsl:param>
-        <section>
+        <section class="abstract">
             <xsl:choose>
                 <xsl:when test="@abstract-type">
                     <h2>
@@ -146,7 +149,9 @@
                     <h2 id="Abstract">Abstract</h2>
                 </xsl:otherwise>
             </xsl:choose>
+            <xsl:value-of select="$abstract-marker"/>
             <xsl:apply-templates/>
+            <xsl:value-of select="$abstract-marker"/>
         </section>
     </xsl:template>
 
@@ -0,0 +1,214 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package annotate finds terms in the text of papers, using dictionaries or any other Annotator.
+package annotate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An Annotator finds terms in the text of a paper. Dictionaries are the original annotator, but
+// anything that can take text and return offsets of terms can be used, and several can be mixed in one
+// run. New kinds of annotator register a factory under a name, either from Go code in an init function,
+// or by using the "command" annotator to wrap an external program.
+
+type AnnotatorMatch struct {
+	Offset     int    `json:"offset"`
+	Term       string `json:"term"`
+	WikiDataID string `json:"wikidata"`
+	Source     string `json:"source"`            // The dictionary or annotator that found the match
+	Version    string `json:"version,omitempty"` // The version of the dictionary or annotator, if known
+}
+
+type Annotator interface {
+	Name() string
+	Annotate(text []byte) ([]AnnotatorMatch, error)
+}
+
+// A factory makes an annotator given the configuration string from the command line
+type AnnotatorFactory func(config string) (Annotator, error)
+
+var annotatorFactoriesLock sync.Mutex
+var annotatorFactories = make(map[string]AnnotatorFactory)
+
+func RegisterAnnotator(kind string, factory AnnotatorFactory) {
+	annotatorFactoriesLock.Lock()
+	defer annotatorFactoriesLock.Unlock()
+
+	annotatorFactories[kind] = factory
+}
+
+// NewAnnotator makes an annotator from a specification of the form kind:config
+func NewAnnotator(spec string) (Annotator, error) {
+	annotatorFactoriesLock.Lock()
+	defer annotatorFactoriesLock.Unlock()
+
+	parts := strings.SplitN(spec, ":", 2)
+	factory, ok := annotatorFactories[parts[0]]
+	if !ok {
+		known := make([]string, 0, len(annotatorFactories))
+		for kind := range annotatorFactories {
+			known = append(known, kind)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("Unknown annotator %s, expected one of %s", parts[0], strings.Join(known, ", "))
+	}
+
+	config := ""
+	if len(parts) > 1 {
+		config = parts[1]
+	}
+	return factory(config)
+}
+
+// Annotators that only suit text in some languages can say which, and are skipped for papers in others
+type LanguageSpecific interface {
+	Languages() []string
+}
+
+// AnnotatorsForLanguage picks the annotators to run over a paper in the given language, adjusting how
+// dictionaries match to suit it. If the language isn't known then all the annotators are used as they are.
+func AnnotatorsForLanguage(annotators []Annotator, language string) []Annotator {
+
+	if len(language) == 0 {
+		return annotators
+	}
+
+	res := make([]Annotator, 0, len(annotators))
+	for _, annotator := range annotators {
+		if specific, ok := annotator.(LanguageSpecific); ok && len(specific.Languages()) > 0 {
+			found := false
+			for _, supported := range specific.Languages() {
+				if supported == language {
+					found = true
+					break
+				}
+			}
+			if found == false {
+				continue
+			}
+		}
+		if dict, ok := annotator.(Dictionary); ok {
+			annotator = dict.ForLanguage(language)
+		}
+		res = append(res, annotator)
+	}
+	return res
+}
+
+// Sorting interface for matches from all annotators
+
+type AnnotatorMatchesByOffset []AnnotatorMatch
+
+func (a AnnotatorMatchesByOffset) Len() int           { return len(a) }
+func (a AnnotatorMatchesByOffset) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a AnnotatorMatchesByOffset) Less(i, j int) bool { return a[i].Offset < a[j].Offset }
+
+// RunAnnotators runs every annotator over the text, returning all their matches in order of offset.
+func RunAnnotators(annotators []Annotator, text []byte) ([]AnnotatorMatch, error) {
+
+	total_matches := make([]AnnotatorMatch, 0)
+
+	for _, annotator := range annotators {
+		matches, err := annotator.Annotate(text)
+		if err != nil {
+			return nil, fmt.Errorf("Annotator %s failed: %v", annotator.Name(), err)
+		}
+		total_matches = append(total_matches, matches...)
+	}
+
+	// Stable so that matches at the same offset stay in annotator order between runs
+	sort.Stable(AnnotatorMatchesByOffset(total_matches))
+
+	return total_matches, nil
+}
+
+// Built in annotators
+
+func init() {
+	RegisterAnnotator("dictionary", func(config string) (Annotator, error) {
+		if IsRemoteDictionary(config) {
+			return FetchDictionary(config)
+		}
+		dict, err := LoadDictionaryFromFile(config)
+		if err != nil {
+			return nil, err
+		}
+		return dict, nil
+	})
+	RegisterAnnotator("command", NewCommandAnnotator)
+}
+
+// CommandAnnotator runs an external program, which is given the paper text on stdin and should write
+// a JSON list of matches (with offset, term, and optionally wikidata fields) to stdout. This is the
+// easiest way to plug in NER services and the like.
+type CommandAnnotator struct {
+	name string
+	args []string
+}
+
+func NewCommandAnnotator(config string) (Annotator, error) {
+	args := strings.Fields(config)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Command annotator needs a command to run")
+	}
+	return CommandAnnotator{
+		name: filepath.Base(args[0]),
+		args: args,
+	}, nil
+}
+
+func (a CommandAnnotator) Name() string {
+	return a.name
+}
+
+func (a CommandAnnotator) Annotate(text []byte) ([]AnnotatorMatch, error) {
+
+	cmd := exec.Command(a.args[0], a.args[1:]...)
+	cmd.Stdin = bytes.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Annotator %s failed: %v: %s", a.name, err, stderr.String())
+	}
+
+	var matches []AnnotatorMatch
+	err = json.Unmarshal(output, &matches)
+	if err != nil {
+		return nil, fmt.Errorf("Annotator %s gave bad output: %v", a.name, err)
+	}
+
+	for i := range matches {
+		if matches[i].Offset < 0 || matches[i].Offset+len(matches[i].Term) > len(text) ||
+			string(text[matches[i].Offset:matches[i].Offset+len(matches[i].Term)]) != matches[i].Term {
+			return nil, fmt.Errorf("Annotator %s gave term %q at %d, which isn't in the text there",
+				a.name, matches[i].Term, matches[i].Offset)
+		}
+		if len(matches[i].Source) == 0 {
+			matches[i].Source = a.name
+		}
+	}
+
+	return matches, nil
+}
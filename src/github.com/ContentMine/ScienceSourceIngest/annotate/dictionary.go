@@ -0,0 +1,266 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package annotate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ContentMine/ahocorasick"
+)
+
+type DictionaryLog struct {
+	Type  string `json:"type"`
+	Query string `json:"query"`
+	Time  int64  `json:"time"`
+}
+
+type DictionaryEntryIdentifiers struct {
+	ContentMine string `json:"contentmine"`
+	WikiData    string `json:"wikidata"`
+}
+
+type DictionaryEntry struct {
+	Name        string                     `json:"name"`
+	Term        string                     `json:"term"`
+	Identifiers DictionaryEntryIdentifiers `json:"identifiers"`
+}
+
+type Dictionary struct {
+	Identifier string            `json:"id"`
+	Log        []DictionaryLog   `json:"log"`
+	Entries    []DictionaryEntry `json:"entries"`
+
+	Matcher *ahocorasick.Matcher
+	Options DictionaryOptions `json:"-"`
+	Version string            `json:"-"` // where we know it, e.g. git:commit or sha256:checksum
+}
+
+// Parsing
+
+func LoadDictionaryFromFile(path string) (Dictionary, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Dictionary{}, err
+	}
+
+	dict, err := parseDictionary(data)
+	if err != nil {
+		return Dictionary{}, err
+	}
+	// Local files can be edited at any time, so the best version we have is what is in them now
+	dict.Version = "sha256:" + dataChecksum(data)
+	return dict, nil
+}
+
+func LoadDictionariesFromDirectory(directory_path string) ([]Dictionary, error) {
+
+	files, err := ioutil.ReadDir(directory_path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Dictionary{}
+
+	for _, f := range files {
+		p := path.Join(directory_path, f.Name())
+		if filepath.Ext(p) == ".json" {
+			dict, err := LoadDictionaryFromFile(p)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, dict)
+		}
+	}
+
+	return res, nil
+}
+
+// Helper functions
+
+func (d Dictionary) FindMatches(prose []byte) []AnnotatorMatch {
+
+	// When ignoring case we record the term as it appears in the text, so that it matches what is at the offset
+	text := prose
+	if d.Options.IgnoreCase {
+		prose = lowerASCII(prose)
+	}
+	hits := d.Matcher.Match(prose)
+
+	res := make([]AnnotatorMatch, 0, len(hits))
+	for i := 0; i < len(hits); i++ {
+		hit := hits[i]
+		entry := d.Entries[hit.Key]
+		end := hit.Position + len(entry.Term)
+
+		if len(entry.Term) < d.Options.MinLength {
+			continue
+		}
+		if d.Options.WholeWords && !isWordBoundary(prose, hit.Position, end) {
+			continue
+		}
+
+		res = append(res, AnnotatorMatch{
+			Offset:     hit.Position,
+			Term:       string(text[hit.Position:end]),
+			WikiDataID: entry.Identifiers.WikiData,
+			Source:     d.Identifier,
+			Version:    d.Version,
+		})
+	}
+
+	return res
+}
+
+// Annotator interface
+
+func (d Dictionary) Name() string {
+	return d.Identifier
+}
+
+func (d Dictionary) Annotate(prose []byte) ([]AnnotatorMatch, error) {
+	return d.FindMatches(prose), nil
+}
+
+// Matching options
+
+// Each dictionary can be matched differently, as a dictionary of gene symbols wants exact case and
+// whole words, whereas a dictionary of disease names may not.
+type DictionaryOptions struct {
+	WholeWords bool     // only match terms that aren't part of a longer word
+	IgnoreCase bool     // match terms regardless of ASCII case
+	MinLength  int      // ignore terms shorter than this many bytes
+	Languages  []string // only match papers in these languages, if given
+}
+
+// ParseDictionaryOptions reads a comma separated list of options, e.g. "wholewords,ignorecase,minlength=3".
+// The language option can be given more than once.
+func ParseDictionaryOptions(value string) (DictionaryOptions, error) {
+
+	var options DictionaryOptions
+	for _, option := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		switch parts[0] {
+		case "":
+		case "wholewords":
+			options.WholeWords = true
+		case "ignorecase":
+			options.IgnoreCase = true
+		case "minlength":
+			if len(parts) != 2 {
+				return options, fmt.Errorf("Dictionary option minlength needs a value, e.g. minlength=3")
+			}
+			length, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return options, fmt.Errorf("Dictionary option minlength must be a number, not %s", parts[1])
+			}
+			options.MinLength = length
+		case "language":
+			if len(parts) != 2 || len(parts[1]) == 0 {
+				return options, fmt.Errorf("Dictionary option language needs a language code, e.g. language=en")
+			}
+			options.Languages = append(options.Languages, strings.ToLower(parts[1]))
+		default:
+			return options, fmt.Errorf("Unknown dictionary option %s, expected wholewords, ignorecase, minlength, or language", parts[0])
+		}
+	}
+	return options, nil
+}
+
+// WithOptions returns a copy of the dictionary that matches using the given options.
+func (d Dictionary) WithOptions(options DictionaryOptions) Dictionary {
+
+	raw := make([]string, len(d.Entries))
+	for idx, entry := range d.Entries {
+		raw[idx] = entry.Term
+		if options.IgnoreCase {
+			raw[idx] = string(lowerASCII([]byte(entry.Term)))
+		}
+	}
+
+	d.Matcher = ahocorasick.NewStringMatcher(raw)
+	d.Options = options
+	return d
+}
+
+func (d Dictionary) Languages() []string {
+	return d.Options.Languages
+}
+
+// Languages written without spaces between words have no word boundaries for wholewords to look for
+var languagesWithoutSpaces = map[string]bool{"zh": true, "ja": true, "th": true}
+
+// ForLanguage returns a copy of the dictionary with its matching rules adjusted for text in the language.
+func (d Dictionary) ForLanguage(language string) Dictionary {
+	if languagesWithoutSpaces[language] {
+		d.Options.WholeWords = false
+	}
+	return d
+}
+
+// ApplyDictionaryOptions sets the options for the annotator if it is a dictionary, using the options
+// given for its identifier, or those given for "*" if there are none.
+func ApplyDictionaryOptions(annotator Annotator, options map[string]DictionaryOptions) Annotator {
+
+	dict, ok := annotator.(Dictionary)
+	if !ok {
+		return annotator
+	}
+	if dictOptions, ok := options[dict.Identifier]; ok {
+		return dict.WithOptions(dictOptions)
+	}
+	if dictOptions, ok := options["*"]; ok {
+		return dict.WithOptions(dictOptions)
+	}
+	return dict
+}
+
+// lowerASCII lowers the case of ASCII letters only, so that byte offsets into the result are the same
+// as those into the original.
+func lowerASCII(data []byte) []byte {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		res[i] = b
+	}
+	return res
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordBoundary(prose []byte, start int, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRune(prose[:start]); isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(prose) {
+		if r, _ := utf8.DecodeRune(prose[end:]); isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
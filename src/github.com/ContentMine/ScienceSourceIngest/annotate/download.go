@@ -0,0 +1,277 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package annotate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/hashicorp/errwrap"
+)
+
+// Dictionaries are maintained in repositories such as https://github.com/ContentMine/dictionaries, so
+// rather than make people keep local copies in step we can fetch them. A dictionary can be given as a
+// URL, or as github:owner/repo/path/to/dictionary.json, optionally followed by @ref to pick a branch, tag,
+// or commit (the default is master). Either form can end with #sha256=checksum, in which case the
+// download must match it, so a dictionary can't change underneath a run without someone noticing.
+//
+// So that runs are reproducible, and don't depend on GitHub being up, downloads are cached on disk by
+// version: GitHub dictionaries by the commit their ref resolved to, and others by their checksum. Giving
+// a commit as the ref, or a checksum, pins the dictionary to that version.
+
+// Where downloaded dictionaries are kept, or "" to not cache them
+var DictionaryCacheDirectory string = defaultDictionaryCacheDirectory()
+
+func defaultDictionaryCacheDirectory() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ScienceSourceIngest", "dictionaries")
+}
+
+const dictionaryDownloadTimeout time.Duration = 60 * time.Second
+
+const gitHubDictionaryPrefix string = "github:"
+
+// IsRemoteDictionary is true if the dictionary source needs downloading rather than reading from disk.
+func IsRemoteDictionary(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") ||
+		strings.HasPrefix(source, gitHubDictionaryPrefix)
+}
+
+// splitChecksum separates any #sha256= suffix from the source.
+func splitChecksum(source string) (string, string, error) {
+	parts := strings.SplitN(source, "#", 2)
+	if len(parts) == 1 {
+		return source, "", nil
+	}
+	if !strings.HasPrefix(parts[1], "sha256=") {
+		return "", "", fmt.Errorf("Dictionary checksum must be given as #sha256=hex, not #%s", parts[1])
+	}
+	return parts[0], strings.ToLower(strings.TrimPrefix(parts[1], "sha256=")), nil
+}
+
+// gitHubSource is a dictionary in a GitHub repository.
+type gitHubSource struct {
+	Owner string
+	Repo  string
+	Path  string
+	Ref   string
+}
+
+func parseGitHubSource(source string) (gitHubSource, error) {
+
+	var res gitHubSource
+	spec := strings.TrimPrefix(source, gitHubDictionaryPrefix)
+	res.Ref = "master"
+	if at := strings.LastIndex(spec, "@"); at != -1 {
+		res.Ref = spec[at+1:]
+		spec = spec[:at]
+	}
+	parts := strings.SplitN(spec, "/", 3)
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 || len(res.Ref) == 0 {
+		return res, fmt.Errorf("GitHub dictionaries must be given as github:owner/repo/path[@ref], not %s", source)
+	}
+	res.Owner, res.Repo, res.Path = parts[0], parts[1], parts[2]
+	return res, nil
+}
+
+func (source gitHubSource) URL(commit string) string {
+	return fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", source.Owner, source.Repo, commit, source.Path)
+}
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// resolveCommit finds the commit the source's ref currently points at.
+func (source gitHubSource) resolveCommit() (string, error) {
+
+	if commitPattern.MatchString(source.Ref) {
+		return source.Ref, nil
+	}
+
+	data, err := downloadDictionaryData(fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s",
+		source.Owner, source.Repo, url.PathEscape(source.Ref)), "application/vnd.github.sha")
+	if err != nil {
+		return "", errwrap.Wrapf("Failed to find commit for dictionary ref: {{err}}", err)
+	}
+	commit := strings.TrimSpace(string(data))
+	if !commitPattern.MatchString(commit) {
+		return "", fmt.Errorf("GitHub gave %q as the commit for %s", commit, source.Ref)
+	}
+	return commit, nil
+}
+
+func downloadDictionaryData(location string, accept string) ([]byte, error) {
+
+	logging.Logf(logging.LogVerbose, "Downloading %s", location)
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+
+	// Loading dictionaries can't be cancelled, so this just waits its turn
+	err = ratelimit.WaitForURL(context.Background(), location)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: dictionaryDownloadTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Status code %d fetching %s", resp.StatusCode, location)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Cache helpers, where failing to cache isn't fatal as we can always download again
+
+func readCachedDictionary(key string) ([]byte, bool) {
+	if len(DictionaryCacheDirectory) == 0 {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(DictionaryCacheDirectory, key))
+	if err != nil {
+		return nil, false
+	}
+	logging.Logf(logging.LogVerbose, "Using cached dictionary %s", key)
+	return data, true
+}
+
+func writeCachedDictionary(key string, data []byte) {
+	if len(DictionaryCacheDirectory) == 0 {
+		return
+	}
+	filename := filepath.Join(DictionaryCacheDirectory, key)
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filename, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Failed to cache dictionary %s: %v", key, err)
+	}
+}
+
+func dataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func verifyChecksum(data []byte, checksum string) error {
+	if len(checksum) == 0 {
+		return nil
+	}
+	if actual := dataChecksum(data); actual != checksum {
+		return fmt.Errorf("Dictionary checksum is %s, expected %s", actual, checksum)
+	}
+	return nil
+}
+
+func parseDictionary(data []byte) (Dictionary, error) {
+	var dict Dictionary
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(&dict)
+	if err != nil {
+		return Dictionary{}, err
+	}
+	return dict.WithOptions(DictionaryOptions{}), nil
+}
+
+// FetchDictionary downloads a dictionary from a URL or GitHub, checking its checksum if one is given,
+// or loads it from the cache if we have that version already.
+func FetchDictionary(source string) (Dictionary, error) {
+
+	location, checksum, err := splitChecksum(source)
+	if err != nil {
+		return Dictionary{}, err
+	}
+
+	var data []byte
+	var version, key string
+	if strings.HasPrefix(location, gitHubDictionaryPrefix) {
+		github, err := parseGitHubSource(location)
+		if err != nil {
+			return Dictionary{}, err
+		}
+		commit, err := github.resolveCommit()
+		if err != nil {
+			return Dictionary{}, err
+		}
+		version = "git:" + commit
+		key = filepath.Join("github", github.Owner, github.Repo, commit, filepath.FromSlash(github.Path))
+
+		cached, ok := readCachedDictionary(key)
+		if ok {
+			data = cached
+		} else {
+			data, err = downloadDictionaryData(github.URL(commit), "")
+			if err != nil {
+				return Dictionary{}, err
+			}
+		}
+	} else {
+		// Without a checksum we can't know if a cached copy is the right version, so always download
+		cached, ok := []byte(nil), false
+		if len(checksum) > 0 {
+			key = filepath.Join("sha256", checksum+".json")
+			cached, ok = readCachedDictionary(key)
+		}
+		if ok {
+			data = cached
+		} else {
+			data, err = downloadDictionaryData(location, "")
+			if err != nil {
+				return Dictionary{}, err
+			}
+		}
+		version = "sha256:" + dataChecksum(data)
+		key = filepath.Join("sha256", dataChecksum(data)+".json")
+	}
+
+	err = verifyChecksum(data, checksum)
+	if err != nil {
+		return Dictionary{}, fmt.Errorf("%v for %s", err, location)
+	}
+	dict, err := parseDictionary(data)
+	if err != nil {
+		return Dictionary{}, err
+	}
+	writeCachedDictionary(key, data)
+
+	dict.Version = version
+	return dict, nil
+}
@@ -0,0 +1,66 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package annotate
+
+// Anchor points record a phrase either side of the term found, so that a human (or a later tool) can
+// find the place in the text again even if the character offsets have drifted.
+
+type SearchDirection int
+
+const (
+	SearchDirectionBackward SearchDirection = -1
+	SearchDirectionForward                  = 1
+)
+
+const PhraseTargetSize int = 100
+
+// FindPhrase returns roughly PhraseTargetSize bytes of text before or after the offset, extended to
+// the next space so words aren't cut in half.
+func FindPhrase(prose []byte, startOffset int, direction SearchDirection) string {
+	return FindPhraseOfSize(prose, startOffset, direction, PhraseTargetSize)
+}
+
+// FindPhraseOfSize is FindPhrase for phrases of roughly the given number of bytes.
+func FindPhraseOfSize(prose []byte, startOffset int, direction SearchDirection, size int) string {
+
+	targetOffset := startOffset + (size * int(direction))
+
+	// Need better terminating condition here
+	for true {
+		if direction == SearchDirectionBackward {
+			if targetOffset < 0 {
+				targetOffset = 0
+				break
+			}
+		} else {
+			if targetOffset > (len(prose) - 1) {
+				targetOffset = len(prose) - 1
+				break
+			}
+		}
+
+		if prose[targetOffset] == byte(' ') {
+			break
+		}
+
+		targetOffset = targetOffset + (1 * int(direction))
+	}
+
+	if startOffset > targetOffset {
+		startOffset, targetOffset = targetOffset, startOffset
+	}
+
+	return string(prose[startOffset:targetOffset])
+}
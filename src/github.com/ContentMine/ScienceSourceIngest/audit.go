@@ -0,0 +1,57 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// The audit command checks the annotations of each paper against the text they were found in, without
+// talking to the server.
+
+func runAudit(args []string) {
+
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	var options commandFlags
+	options.register(flags)
+	flags.Parse(args)
+
+	found := 0
+	for _, processor := range options.processors() {
+		article, err := processor.Article()
+		if err != nil {
+			continue
+		}
+		text, err := processor.Text()
+		if err != nil {
+			log.Printf("Failed to read text of paper %s: %v", processor.Paper.ID(), err)
+			found += 1
+			continue
+		}
+
+		mismatches := article.AuditOffsets(text)
+		for _, mismatch := range mismatches {
+			fmt.Printf("%s\t%v\n", processor.Paper.ID(), mismatch)
+		}
+		found += len(mismatches)
+	}
+
+	if found != 0 {
+		os.Exit(1)
+	}
+}
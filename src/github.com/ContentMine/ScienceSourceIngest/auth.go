@@ -0,0 +1,156 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The auth command gets access tokens for a consumer that isn't owner-only, which needs the account's
+// owner to authorise it in a browser, and saves them in the -oauth file for the other commands to use.
+
+// openBrowser tries to open the URL for the person at the keyboard, but they can always copy it from
+// the terminal if this doesn't work.
+func openBrowser(target string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", target).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
+}
+
+func prompt(input *bufio.Reader, question string) (string, error) {
+	fmt.Printf("%s ", question)
+	answer, err := input.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+func runAuth(args []string) {
+
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	var url_base, oauth_tokens_path, consumer_key, consumer_secret string
+	var oauth2, no_browser bool
+	flags.StringVar(&url_base, "urlbase", "http://localhost:8181", "Base URL for science source.")
+	flags.StringVar(&oauth_tokens_path, "oauth", "oauth.json", "JSON file to save the oauth credentials in.")
+	flags.StringVar(&consumer_key, "key", "", "Consumer key, or client ID for OAuth 2. Defaults to the one in the -oauth file.")
+	flags.StringVar(&consumer_secret, "secret", "", "Consumer secret, or client secret for OAuth 2. Defaults to the one in the -oauth file.")
+	flags.BoolVar(&oauth2, "oauth2", false, "The consumer is an OAuth 2 one.")
+	flags.BoolVar(&no_browser, "nobrowser", false, "Don't try to open the authorisation page in a browser.")
+	flags.Parse(args)
+
+	// Fill in whatever we weren't given from an existing file, such as one with just the consumer in
+	if len(consumer_key) == 0 || len(consumer_secret) == 0 {
+		credentials, err := wikibase.LoadCredentials(oauth_tokens_path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Need -key and -secret, or an -oauth file with the consumer in: %v\n", err)
+			os.Exit(2)
+		}
+		tokens := credentials.Tokens()
+		if len(consumer_key) == 0 {
+			consumer_key = tokens.Consumer.Key
+		}
+		if len(consumer_secret) == 0 {
+			consumer_secret = tokens.Consumer.Secret
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	input := bufio.NewReader(os.Stdin)
+	var tokens wikibase.CredentialTokens
+	if oauth2 {
+		authorise_url := wikibase.OAuth2AuthoriseURL(url_base, consumer_key)
+		showAuthorisePage(authorise_url, no_browser)
+
+		answer, err := prompt(input, "Once you've allowed access, paste the code, or the whole address you were sent to:")
+		if err != nil {
+			panic(err)
+		}
+		// The code comes back as a query parameter on the consumer's callback URL
+		if callback, err := url.Parse(answer); err == nil && len(callback.Query().Get("code")) != 0 {
+			answer = callback.Query().Get("code")
+		}
+		tokens, err = wikibase.AuthoriseOAuth2Code(ctx, url_base, consumer_key, consumer_secret, answer)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		request, authorise_url, err := wikibase.RequestOAuthToken(ctx, url_base, consumer_key, consumer_secret)
+		if err != nil {
+			panic(err)
+		}
+		showAuthorisePage(authorise_url, no_browser)
+
+		verifier, err := prompt(input, "Once you've allowed access, enter the verification code:")
+		if err != nil {
+			panic(err)
+		}
+		tokens, err = wikibase.AuthoriseOAuthToken(ctx, url_base, consumer_key, consumer_secret, request, verifier)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Check the tokens work before saving them over whatever was there
+	client, err := wikibase.NewNetworkClient(wikibase.NewCredentials(tokens), url_base, wikibase.NetworkOptions{
+		LookupTimeout: wikibase.DefaultLookupTimeout,
+	})
+	if err != nil {
+		panic(err)
+	}
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	// Keep how the old file marked the server, so re-authorising doesn't drop a production marker
+	if existing, err := wikibase.LoadCredentials(oauth_tokens_path); err == nil {
+		tokens.Environment = existing.Tokens().Environment
+		tokens.AllowProduction = existing.Tokens().AllowProduction
+	}
+	err = wikibase.SaveCredentials(oauth_tokens_path, tokens)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Authorised as %s, credentials saved to %s\n", user, oauth_tokens_path)
+}
+
+func showAuthorisePage(authorise_url string, no_browser bool) {
+	fmt.Printf("To allow access, log in to the server as the account to use and visit:\n\n    %s\n\n", authorise_url)
+	if no_browser == false {
+		if err := openBrowser(authorise_url); err != nil {
+			fmt.Printf("Couldn't open a browser (%v), so please copy the address into one.\n", err)
+		}
+	}
+}
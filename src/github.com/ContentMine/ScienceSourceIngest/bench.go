@@ -0,0 +1,272 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The bench command uploads synthetic articles to a test instance and reports how fast it went, so
+// that changes to how we talk to the server can be measured rather than guessed at.
+
+// latencies collects how long each kind of API request took.
+type latencies struct {
+	lock     sync.Mutex
+	byAction map[string][]time.Duration
+	failures map[string]int
+}
+
+func newLatencies() *latencies {
+	return &latencies{
+		byAction: make(map[string][]time.Duration),
+		failures: make(map[string]int),
+	}
+}
+
+func (l *latencies) observe(action string, elapsed time.Duration, err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.byAction[action] = append(l.byAction[action], elapsed)
+	if err != nil {
+		l.failures[action] += 1
+	}
+}
+
+// percentile expects the durations to be sorted
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	index := int(float64(len(durations))*p/100.0+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(durations) {
+		index = len(durations) - 1
+	}
+	return durations[index]
+}
+
+func sortedDurations(durations []time.Duration) []time.Duration {
+	res := append([]time.Duration{}, durations...)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func (l *latencies) report(out io.Writer) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	actions := make([]string, 0, len(l.byAction))
+	for action := range l.byAction {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tREQUESTS\tFAILED\tP50\tP90\tP99\tMAX")
+	for _, action := range actions {
+		durations := sortedDurations(l.byAction[action])
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%v\t%v\n", action, len(durations), l.failures[action],
+			percentile(durations, 50).Round(time.Millisecond), percentile(durations, 90).Round(time.Millisecond),
+			percentile(durations, 99).Round(time.Millisecond), durations[len(durations)-1].Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+// uploadSyntheticArticle does everything ingest does with an article once it has been annotated, and
+// returns the article so it can be cleaned up, even if uploading it failed part way.
+func uploadSyntheticArticle(ctx context.Context, client *sciencesource.ScienceSourceClient, title string, annotations int) (*sciencesource.ScienceSourceArticle, error) {
+
+	article, text, err := sciencesource.NewSyntheticArticle(title, annotations, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(text)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.UploadPaper(ctx, article, f.Name())
+	if err != nil {
+		return article, err
+	}
+	err = client.CreateArticleItemTree(ctx, article, func() error { return nil })
+	if err != nil {
+		return article, err
+	}
+	err = client.ReconsileArticleItemTree(article)
+	if err != nil {
+		return article, err
+	}
+	return article, client.PopulateAritcleItemTree(ctx, article, func() error { return nil })
+}
+
+// uploadResults collects how each synthetic article went, from however many workers are uploading.
+type uploadResults struct {
+	lock         sync.Mutex
+	articleTimes []time.Duration
+	items        int
+	failed       int
+	uploaded     []*sciencesource.ScienceSourceArticle
+}
+
+func (r *uploadResults) add(title string, article *sciencesource.ScienceSourceArticle, elapsed time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if article != nil {
+		r.uploaded = append(r.uploaded, article)
+		r.items += len(article.ItemIDs())
+	}
+	if err != nil {
+		log.Printf("Failed to upload %s: %v", title, err)
+		r.failed += 1
+		return
+	}
+	r.articleTimes = append(r.articleTimes, elapsed)
+}
+
+func (r *uploadResults) counts() (uploaded int, failed int, items int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.articleTimes), r.failed, r.items
+}
+
+func (r *uploadResults) print(elapsed time.Duration, annotations int, recorded *latencies) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fmt.Printf("Articles:\t%d uploaded, %d failed, %d annotations each\n", len(r.articleTimes), r.failed, annotations)
+	fmt.Printf("Items:\t\t%d in %v (%.2f items/second)\n", r.items, elapsed.Round(time.Millisecond), float64(r.items)/elapsed.Seconds())
+	if len(r.articleTimes) > 0 {
+		sorted := sortedDurations(r.articleTimes)
+		fmt.Printf("Per article:\tp50 %v, p90 %v, max %v\n", percentile(sorted, 50).Round(time.Millisecond),
+			percentile(sorted, 90).Round(time.Millisecond), sorted[len(sorted)-1].Round(time.Millisecond))
+	}
+	fmt.Println()
+	recorded.report(os.Stdout)
+}
+
+// cleanup deletes the synthetic articles, without recording the requests as deleting shouldn't count
+// towards the numbers.
+func (r *uploadResults) cleanup(ctx context.Context, client *sciencesource.ScienceSourceClient) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	client.Network().SetRequestObserver(nil)
+	for _, article := range r.uploaded {
+		err := client.DeleteArticle(ctx, article, fmt.Sprintf("Removing benchmark article (run %s)", client.RunID))
+		if err != nil {
+			log.Printf("Failed to delete %s: %v", article.ScienceSourceArticleTitle, err)
+		}
+	}
+}
+
+func syntheticTitle(runID string, i int) string {
+	return fmt.Sprintf("ScienceSourceIngest benchmark %s %d", runID, i)
+}
+
+// benchFlags are shared by bench and loadtest
+type benchFlags struct {
+	commandFlags
+	assertUser  string
+	articles    int
+	annotations int
+	keep        bool
+}
+
+func (f *benchFlags) register(flags *flag.FlagSet, articles int) {
+	f.registerServer(flags)
+	flags.StringVar(&f.assertUser, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flags.IntVar(&f.articles, "articles", articles, "Number of synthetic articles to upload.")
+	flags.IntVar(&f.annotations, "annotations", 20, "Number of annotations in each synthetic article.")
+	flags.BoolVar(&f.keep, "keep", false, "Leave the synthetic articles on the server rather than deleting them afterwards.")
+	flags.BoolVar(&f.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&f.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&f.veryVerbose, "vv", false, "Log full API requests and responses.")
+}
+
+func (f *benchFlags) connect(ctx context.Context) (*sciencesource.ScienceSourceClient, *latencies) {
+
+	logging.SetLogLevel(f.quiet, f.verbose, f.veryVerbose)
+	f.guardProduction("uploading synthetic articles")
+
+	client := f.commandFlags.connect(ctx, wikibase.NetworkOptions{Assert: f.assertUser})
+	client.SetRunID(sciencesource.NewRunID())
+	client.ProtectionLevel = wikibase.ProtectionLevelNone
+
+	recorded := newLatencies()
+	client.Network().SetRequestObserver(recorded.observe)
+	return client, recorded
+}
+
+func runBench(args []string) {
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	var options benchFlags
+	options.register(flags, 5)
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, recorded := options.connect(ctx)
+
+	var results uploadResults
+	start := time.Now()
+	for i := 0; i < options.articles && ctx.Err() == nil; i++ {
+		title := syntheticTitle(client.RunID, i)
+		logging.Logf(logging.LogNormal, "Uploading %s", title)
+
+		article_start := time.Now()
+		article, err := uploadSyntheticArticle(ctx, client, title, options.annotations)
+		results.add(title, article, time.Since(article_start), err)
+	}
+	results.print(time.Since(start), options.annotations, recorded)
+
+	if options.keep == false {
+		results.cleanup(ctx, client)
+	}
+
+	if results.failed != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,114 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The citations command looks up the works each article cites in OpenCitations, and records them on
+// the article items, linking articles that are both on the server.
+
+func runCitations(args []string) {
+
+	flags := flag.NewFlagSet("citations", flag.ExitOnError)
+	var options commandFlags
+	var dry_run bool
+	var assert_user string
+	options.register(flags)
+	options.registerServer(flags)
+	flags.BoolVar(&dry_run, "dryrun", false, "Only list the citations that would be recorded, rather than recording them.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flags.Parse(args)
+
+	if dry_run == false {
+		options.guardProduction("citations")
+	}
+	processors := options.processors()
+
+	// Citations can be to any article we've ingested, not just those picked by -only and -skip
+	feed, err := sciencesource.LoadFeedFromFile(options.feedPath)
+	if err != nil {
+		panic(err)
+	}
+	items := make(map[string]string)
+	for _, paper := range loadLibrary(feed, options.targetPath, sciencesource.PaperFilter{}) {
+		processor := sciencesource.PaperProcessor{Paper: paper, TargetDirectory: options.targetPath}
+		article, err := processor.Article()
+		if err != nil || len(article.ID) == 0 || len(paper.DOI.Value) == 0 {
+			continue
+		}
+		items[sciencesource.NormaliseDOI(paper.DOI.Value)] = string(article.ID)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all citations were recorded: %v", err)
+			os.Exit(1)
+		}
+
+		article, err := processor.Article()
+		if err != nil || article.Complete == false || len(processor.Paper.DOI.Value) == 0 {
+			continue
+		}
+
+		cited, err := sciencesource.FetchCitedDOIs(ctx, sciencesource.NormaliseDOI(processor.Paper.DOI.Value))
+		if err != nil {
+			log.Printf("Failed to fetch citations of paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+
+		changed, err := sciSourceClient.LinkCitations(ctx, article, cited, items, dry_run, func() error {
+			return processor.SaveArticle(article)
+		})
+		for _, citation := range changed {
+			fmt.Printf("%s\t%s\t%s\n", processor.Paper.ID(), citation.DOI, citation.Item)
+		}
+		if err != nil {
+			log.Printf("Failed to record citations of paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+		}
+		if dry_run == false {
+			sciSourceClient.RecordRevisions(article)
+			if save_err := processor.SaveArticle(article); save_err != nil {
+				log.Printf("Failed to save paper record for %s: %v", processor.Paper.ID(), save_err)
+				failed += 1
+			}
+		}
+	}
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The cleanup command removes the orphans found by the orphans command. As deleting things is hard to
+// undo, by default it only says what it would delete, and it never touches items created by anyone other
+// than the account we're using.
+
+func runCleanup(args []string) {
+
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	var options commandFlags
+	var really_delete bool
+	var assert_user string
+	options.register(flags)
+	options.registerServer(flags)
+	flags.BoolVar(&really_delete, "delete", false, "Actually delete the orphaned items, rather than just listing what would be deleted.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check deletes are made as a logged in user or bot, or none.")
+	flags.Parse(args)
+
+	if really_delete {
+		options.guardProduction("cleanup -delete")
+	}
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAPER\tITEM\tKIND\tACTION")
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			w.Flush()
+			log.Printf("Stopping before all papers were cleaned up: %v", err)
+			os.Exit(1)
+		}
+
+		article, err := processor.Article()
+		if err != nil || len(article.ID) == 0 {
+			continue
+		}
+
+		orphans, err := sciSourceClient.FindOrphans(ctx, string(article.ID))
+		if err != nil {
+			log.Printf("Failed to check paper %s for orphans: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+
+		for _, orphan := range orphans {
+			action := "would delete"
+			if really_delete {
+				err = sciSourceClient.DeleteOrphan(ctx, orphan)
+				action = "deleted"
+			} else {
+				err = sciSourceClient.CheckOrphanCreator(ctx, orphan)
+			}
+			if err != nil {
+				action = fmt.Sprintf("left alone: %v", err)
+				failed += 1
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", processor.Paper.ID(), orphan.ID, orphan.Kind, action)
+		}
+	}
+	w.Flush()
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
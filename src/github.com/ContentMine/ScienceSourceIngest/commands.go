@@ -0,0 +1,276 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Commands other than ingest are given as the first argument, each with its own flags.
+
+type command struct {
+	summary string
+	run     func(args []string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"audit":       {"Check each annotation's character number points at its term and phrases in the text", runAudit},
+		"auth":        {"Authorise a consumer in a browser and save the access tokens for it", runAuth},
+		"bench":       {"Upload synthetic articles to a test server and report how fast it went", runBench},
+		"citations":   {"Record the works each article cites, from OpenCitations", runCitations},
+		"cleanup":     {"Delete orphaned items that this account created on the server", runCleanup},
+		"diff":        {"Show what differs between two saved copies of an article, or a saved copy and the server", runDiff},
+		"doctor":      {"Check credentials, account rights, the data schema, the query service, and local tools are ready for ingest", runDoctor},
+		"enqueue":     {"Put papers from the feed on a Redis queue for ingest workers elsewhere to take", runEnqueue},
+		"export":      {"Bundle each paper's XML, HTML, and annotations into a ZIP file with a manifest of checksums", runExport},
+		"import":      {"Unpack papers bundled by export, so ingest can finish uploading them here", runImport},
+		"loadtest":    {"Upload synthetic articles several at a time to check a staging server can take the load", runLoadTest},
+		"orphans":     {"List items on the server that belong to an article but aren't in its anchor chain", runOrphans},
+		"props":       {"List the server's IDs for each property and item the tool uses, and check they match the data schema", runProps},
+		"repair":      {"Fix the order of anchor chains on the server from the anchor points' character numbers", runRepair},
+		"rerun":       {"Replay an earlier ingest run, optionally only the papers that failed", runRerun},
+		"retractions": {"Record retractions of articles since they were ingested", runRetractions},
+		"stats":       {"Summarise the annotations found in papers, to judge dictionaries before uploading", runStats},
+		"status":      {"Show how far each paper in the feed has got, and optionally check that against the server", runStatus},
+		"undo":        {"Delete what earlier writes created and revert what they edited, from the write log", runUndo},
+		"update":      {"Upload corrected text for articles, moving their annotations to match", runUpdate},
+		"wikidata":    {"Link papers' Wikidata items back to their articles, from a checked plan", runWikidata},
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nWith no command, papers in the feed are ingested. Other commands are:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\t%s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(out, "\nFlags for ingest:\n")
+	flag.PrintDefaults()
+}
+
+// Shared setup
+
+// The flags that commands use to pick papers and look at them on the server
+type commandFlags struct {
+	feedPath          string
+	targetPath        string
+	urlBase           string
+	oauthTokensPath   string
+	schemaPage        string
+	lookupTimeout     time.Duration
+	labelLanguages    stringListFlag
+	rateLimits        stringListFlag
+	confirmProduction bool
+	filter            sciencesource.PaperFilter
+	quiet             bool
+	verbose           bool
+	veryVerbose       bool
+}
+
+func (f *commandFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.feedPath, "feed", "", "JSON feed of papers, required")
+	flags.StringVar(&f.targetPath, "output", ".", "Directory the results were stored in.")
+	flags.Var((*stringListFlag)(&f.filter.Only), "only", "Only include papers matching this PMCID, Wikidata ID, DOI, or state:name. Can be repeated.")
+	flags.Var((*stringListFlag)(&f.filter.Skip), "skip", "Skip papers matching this PMCID, Wikidata ID, DOI, or state:name. Can be repeated.")
+	flags.BoolVar(&f.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&f.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&f.veryVerbose, "vv", false, "Log full API requests and responses.")
+}
+
+// registerServer adds the flags for commands that talk to the server.
+func (f *commandFlags) registerServer(flags *flag.FlagSet) {
+	flags.StringVar(&f.urlBase, "urlbase", "http://localhost:8181", "Base URL for science source.")
+	flags.StringVar(&f.oauthTokensPath, "oauth", "oauth.json", "JSON file with oauth credentials in.")
+	flags.StringVar(&f.schemaPage, "schema", "", "Wiki page describing the server's properties and items, e.g. Data_schema, rather than looking them up by label.")
+	flags.DurationVar(&f.lookupTimeout, "lookuptimeout", wikibase.DefaultLookupTimeout, "Time allowed for each API lookup, or 0 for no limit.")
+	flags.Var(&f.labelLanguages, "language", "Language to look up property and item labels in, defaults to en. Can be repeated to fall back to other languages.")
+	flags.Var(&f.rateLimits, "ratelimit", "Most requests a second to make to a source, as source=rate, where source is europepmc, crossref, ncbi, wikidata, wiki, another known source, or a host name. Can be repeated.")
+	flags.BoolVar(&f.confirmProduction, productionFlagName, false, "Confirm that deleting, rewriting, or uploading lots to a production server is intended.")
+}
+
+// processors loads the feed and returns a processor for each paper selected, in PMCID order.
+func (f *commandFlags) processors() []sciencesource.PaperProcessor {
+
+	logging.SetLogLevel(f.quiet, f.verbose, f.veryVerbose)
+
+	if err := f.filter.Validate(); err != nil {
+		panic(err)
+	}
+	feed, err := sciencesource.LoadFeedFromFile(f.feedPath)
+	if err != nil {
+		panic(err)
+	}
+	library := loadLibrary(feed, f.targetPath, f.filter)
+
+	ids := make([]string, 0, len(library))
+	for id := range library {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	res := make([]sciencesource.PaperProcessor, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, sciencesource.PaperProcessor{Paper: library[id], TargetDirectory: f.targetPath})
+	}
+	return res
+}
+
+// connect gets a client that can look things up on the server, without creating anything that is missing.
+func (f *commandFlags) connect(ctx context.Context, options wikibase.NetworkOptions) *sciencesource.ScienceSourceClient {
+
+	sciSourceClient := f.dial(options)
+	err := f.resolveSchema(ctx, sciSourceClient)
+	if err != nil {
+		panic(err)
+	}
+	return sciSourceClient
+}
+
+// dial gets a client for the server, without looking up any of the properties or items it needs yet.
+func (f *commandFlags) dial(options wikibase.NetworkOptions) *sciencesource.ScienceSourceClient {
+	if err := ratelimit.Configure(f.rateLimits); err != nil {
+		panic(err)
+	}
+	options.LookupTimeout = f.lookupTimeout
+	options.LabelLanguages = f.labelLanguages
+	options.WriteLogPath = path.Join(f.targetPath, sciencesource.WriteLogFileName)
+	return connectToServer([]string{f.oauthTokensPath}, f.urlBase, options)
+}
+
+// resolveSchema maps the properties and items the client needs from the schema page if there is one, or
+// else by label, without creating any. Whatever can be mapped is, even if something fails.
+func (f *commandFlags) resolveSchema(ctx context.Context, sciSourceClient *sciencesource.ScienceSourceClient) error {
+	if len(f.schemaPage) != 0 {
+		return sciSourceClient.ApplySchemaPage(ctx, f.schemaPage)
+	}
+	return sciSourceClient.ResolveSchemaByLabel(ctx, false)
+}
+
+func loadLibrary(feed sciencesource.PaperFeed, target_path string, filter sciencesource.PaperFilter) map[string]sciencesource.Paper {
+
+	// the SPARQL seems to have duplicates in, so let's check
+	library := make(map[string]sciencesource.Paper)
+	for _, paper := range feed.Results.Papers {
+		if _, prs := library[paper.ID()]; prs == true {
+			log.Printf("Found a duplicate paper: %v", paper.ID())
+		} else {
+			library[paper.ID()] = paper
+		}
+	}
+	// and then drop any the operator asked us not to process
+	for id, paper := range library {
+		processor := sciencesource.PaperProcessor{Paper: paper, TargetDirectory: target_path}
+		if filter.Matches(paper, processor.State) == false {
+			delete(library, id)
+		}
+	}
+	return library
+}
+
+// paperSources looks up the sources to fetch paper XML from, in the order given.
+func paperSources(names []string) []sciencesource.PaperSource {
+
+	if len(names) == 0 {
+		names = []string{sciencesource.DefaultPaperSource}
+	}
+	res := make([]sciencesource.PaperSource, 0, len(names))
+	for _, name := range names {
+		source, err := sciencesource.LookupPaperSource(name)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, source)
+	}
+	return res
+}
+
+// collectStats gathers statistics on the annotations of all the papers that have got that far.
+func collectStats(processors []sciencesource.PaperProcessor) *sciencesource.AnnotationStats {
+
+	stats := sciencesource.NewAnnotationStats()
+	for _, processor := range processors {
+		article, err := processor.Article()
+		if err != nil {
+			continue
+		}
+		length, err := processor.TextLength()
+		if err != nil {
+			log.Printf("Failed to read text of paper %s: %v", processor.Paper.ID(), err)
+		}
+		stats.Add(article, length)
+	}
+	return stats
+}
+
+// connectToServer makes a client that writes as the account in the first credentials file, or spreads
+// its writes over all of them if there are several.
+func connectToServer(oauth_tokens_paths []string, url_base string, options wikibase.NetworkOptions) *sciencesource.ScienceSourceClient {
+
+	switch options.Assert {
+	case "user", "bot":
+	case "none":
+		options.Assert = ""
+	default:
+		panic(fmt.Errorf("Assert must be one of user, bot, or none, not %s", options.Assert))
+	}
+
+	credentials := make([]*wikibase.Credentials, 0, len(oauth_tokens_paths))
+	for _, oauth_tokens_path := range oauth_tokens_paths {
+		account, load_err := wikibase.LoadCredentials(oauth_tokens_path)
+		if load_err != nil {
+			panic(load_err)
+		}
+		credentials = append(credentials, account)
+	}
+	sciSourceClient, err := sciencesource.NewScienceSourceClient(credentials, url_base, options)
+	if err != nil {
+		panic(err)
+	}
+	return sciSourceClient
+}
+
+// orderedPapers lists the papers from the library in the order the feed gave them.
+func orderedPapers(feed sciencesource.PaperFeed, library map[string]sciencesource.Paper) []sciencesource.Paper {
+
+	res := make([]sciencesource.Paper, 0, len(library))
+	seen := make(map[string]bool)
+	for _, paper := range feed.Results.Papers {
+		if _, ok := library[paper.ID()]; ok && seen[paper.ID()] == false {
+			res = append(res, library[paper.ID()])
+			seen[paper.ID()] = true
+		}
+	}
+	return res
+}
@@ -0,0 +1,124 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package convert
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+)
+
+// Figures in JATS are fig elements, with a label ("Figure 1"), a caption, and a graphic whose href
+// names the image file in the publisher's package. Like the metadata we stream through the XML rather
+// than loading it all.
+
+type Figure struct {
+	ID      string
+	Label   string
+	Caption string
+	Graphic string
+}
+
+func LoadPaperFiguresFromFile(path string) ([]Figure, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadPaperFigures(f)
+}
+
+func LoadPaperFigures(r io.Reader) ([]Figure, error) {
+
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	figures := make([]Figure, 0)
+	var stack []string
+	var figure *Figure
+	var label, caption strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			stack = append(stack, name)
+
+			switch name {
+			case "fig":
+				figure = &Figure{}
+				label.Reset()
+				caption.Reset()
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "id" {
+						figure.ID = attr.Value
+					}
+				}
+			case "graphic":
+				if figure != nil && len(figure.Graphic) == 0 {
+					for _, attr := range t.Attr {
+						if attr.Name.Local == "href" {
+							figure.Graphic = attr.Value
+						}
+					}
+				}
+			case "p", "title":
+				// Keep paragraphs in a caption apart
+				if figure != nil && elementInPath(stack, "caption") && caption.Len() > 0 {
+					caption.WriteString(" ")
+				}
+			}
+
+		case xml.CharData:
+			if figure != nil {
+				if elementInPath(stack, "caption") {
+					caption.Write(t)
+				} else if len(stack) > 0 && stack[len(stack)-1] == "label" {
+					label.Write(t)
+				}
+			}
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if name == "fig" && figure != nil {
+				if len(figure.Graphic) > 0 {
+					figure.Label = strings.TrimSpace(label.String())
+					figure.Caption = strings.Join(strings.Fields(caption.String()), " ")
+					figures = append(figures, *figure)
+				}
+				figure = nil
+			}
+		}
+	}
+
+	return figures, nil
+}
@@ -0,0 +1,392 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package convert turns JATS XML papers into the wikitext/HTML we upload and the plain text we mine,
+// and reads the metadata we need from the front matter.
+package convert
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	europmc "github.com/ContentMine/go-europmc"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Loading the entire JATS document into memory just to read a few fields from the front matter
+// doesn't scale to very large articles (some reviews and supplements are hundreds of pages), so
+// instead we stream through the XML tokens and stop as soon as we reach the article body.
+
+type PaperMetadata struct {
+	Title        string
+	JournalTitle string
+	JournalISSNs []string // Print and electronic, if given
+	FirstAuthor  *europmc.ContributorName
+	Authors      []PaperAuthor
+	Funding      []FundingAward
+	Keywords     []string // Given by the authors
+	DOI          string
+	PMID         string
+	Language     string // The ISO 639-1 code, if the paper says
+
+	// Papers often only give the year, or year and month, of publication
+	PublicationDate          time.Time
+	PublicationDatePrecision wikibase.TimePrecision
+}
+
+// An author of the paper, in the order given, with the text of each of their affiliations
+type PaperAuthor struct {
+	Name         europmc.ContributorName
+	Affiliations []string
+}
+
+// A funder of the paper and the IDs of the awards it made, from the paper's funding-group. Awards
+// given by several funders are listed once for each.
+type FundingAward struct {
+	Funder   string
+	FunderID string // Usually the funder's DOI in the Crossref Funder Registry, if given
+	AwardIDs []string
+}
+
+func LoadPaperMetadataFromFile(path string) (PaperMetadata, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return PaperMetadata{}, err
+	}
+	defer f.Close()
+
+	return LoadPaperMetadata(f)
+}
+
+func LoadPaperMetadata(r io.Reader) (PaperMetadata, error) {
+
+	var metadata PaperMetadata
+
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	// The stack of element names we're currently inside, and the text we're collecting if any
+	var stack []string
+	var text *strings.Builder
+	var author *europmc.ContributorName
+	inAuthor := false
+	var authorAffs [][]string // The IDs of each author's affiliations
+
+	// Affiliations are usually listed apart from the authors and referred to by ID
+	var aff *strings.Builder
+	affID := ""
+	affs := make(map[string]string)
+	affOrder := make([]string, 0)
+	dateParts := make(map[string]int)
+	var funders []FundingAward
+	var awardIDs []string
+	authorKeywords := false
+	articleIDType := ""
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return PaperMetadata{}, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if name == "body" || name == "back" {
+				resolveAffiliations(&metadata, authorAffs, affs, affOrder)
+				return metadata, nil
+			}
+			stack = append(stack, name)
+
+			switch name {
+			case "article":
+				if lang := attribute(t, "lang"); len(lang) != 0 && len(metadata.Language) == 0 {
+					metadata.Language = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+				}
+			case "article-title", "journal-title", "surname", "given-names", "issn":
+				text = &strings.Builder{}
+			case "article-id":
+				if elementInPath(stack, "article-meta") {
+					text = &strings.Builder{}
+					articleIDType = attribute(t, "pub-id-type")
+				}
+			case "year", "month", "day":
+				if elementInPath(stack, "pub-date") {
+					text = &strings.Builder{}
+				}
+			case "award-group":
+				funders = nil
+				awardIDs = nil
+			case "kwd-group":
+				// Other kinds of keyword group are things like lists of abbreviations
+				kind := attribute(t, "kwd-group-type")
+				authorKeywords = len(kind) == 0 || strings.Contains(kind, "author")
+			case "kwd":
+				if authorKeywords && elementInPath(stack, "article-meta") {
+					text = &strings.Builder{}
+				}
+			case "funding-source", "institution", "institution-id", "award-id":
+				if elementInPath(stack, "award-group") {
+					text = &strings.Builder{}
+				}
+			case "contrib":
+				inAuthor = false
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "contrib-type" && attr.Value == "author" {
+						inAuthor = true
+						author = &europmc.ContributorName{}
+						metadata.Authors = append(metadata.Authors, PaperAuthor{})
+						authorAffs = append(authorAffs, nil)
+					}
+				}
+			case "xref":
+				if inAuthor && attribute(t, "ref-type") == "aff" {
+					i := len(authorAffs) - 1
+					authorAffs[i] = append(authorAffs[i], strings.Fields(attribute(t, "rid"))...)
+				}
+			case "aff":
+				aff = &strings.Builder{}
+				affID = attribute(t, "id")
+			}
+
+		case xml.CharData:
+			if text != nil {
+				text.Write(t)
+			}
+			// Affiliations often start with a footnote label, which isn't part of the address
+			if aff != nil && !elementInPath(stack, "label") && !elementInPath(stack, "sup") {
+				aff.Write(t)
+				aff.WriteString(" ")
+			}
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			name := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			switch name {
+			case "article-title":
+				// References also have article titles, but we stop before those
+				if len(metadata.Title) == 0 && elementInPath(stack, "title-group") {
+					metadata.Title = strings.TrimSpace(text.String())
+				}
+			case "journal-title":
+				if len(metadata.JournalTitle) == 0 {
+					metadata.JournalTitle = strings.TrimSpace(text.String())
+				}
+			case "article-id":
+				if text != nil {
+					switch articleIDType {
+					case "doi":
+						metadata.DOI = strings.TrimSpace(text.String())
+					case "pmid":
+						metadata.PMID = strings.TrimSpace(text.String())
+					}
+				}
+			case "issn":
+				if issn := strings.TrimSpace(text.String()); len(issn) != 0 && elementInPath(stack, "journal-meta") {
+					metadata.JournalISSNs = append(metadata.JournalISSNs, issn)
+				}
+			case "funding-source":
+				// The name is either directly in the funding source or wrapped up with the funder's ID
+				if text != nil {
+					if name := strings.TrimSpace(text.String()); len(name) != 0 {
+						funders = append(funders, FundingAward{Funder: name})
+					}
+				} else if len(funders) > 0 && len(funders[len(funders)-1].Funder) == 0 {
+					funders = funders[:len(funders)-1]
+				}
+			case "institution-id":
+				if text != nil && elementInPath(stack, "funding-source") {
+					if len(funders) == 0 || len(funders[len(funders)-1].FunderID) != 0 {
+						funders = append(funders, FundingAward{})
+					}
+					funders[len(funders)-1].FunderID = strings.TrimSpace(text.String())
+				}
+			case "institution":
+				if text != nil && elementInPath(stack, "funding-source") {
+					if len(funders) == 0 || len(funders[len(funders)-1].Funder) != 0 {
+						funders = append(funders, FundingAward{})
+					}
+					funders[len(funders)-1].Funder = strings.TrimSpace(text.String())
+				}
+			case "kwd":
+				if text != nil {
+					if keyword := strings.Join(strings.Fields(text.String()), " "); len(keyword) != 0 {
+						metadata.Keywords = append(metadata.Keywords, keyword)
+					}
+				}
+			case "award-id":
+				if text != nil {
+					if id := strings.TrimSpace(text.String()); len(id) != 0 {
+						awardIDs = append(awardIDs, id)
+					}
+				}
+			case "award-group":
+				for _, funder := range funders {
+					funder.AwardIDs = awardIDs
+					metadata.Funding = append(metadata.Funding, funder)
+				}
+				funders = nil
+				awardIDs = nil
+			case "surname":
+				if inAuthor {
+					author.Surname = strings.TrimSpace(text.String())
+				}
+			case "given-names":
+				if inAuthor {
+					author.GivenNames = strings.TrimSpace(text.String())
+				}
+			case "contrib":
+				if inAuthor {
+					if metadata.FirstAuthor == nil {
+						metadata.FirstAuthor = author
+					}
+					metadata.Authors[len(metadata.Authors)-1].Name = *author
+				}
+				inAuthor = false
+			case "aff":
+				if aff != nil {
+					// We put a space between each piece of text in case elements run together, so tidy
+					// up those before punctuation
+					affText := strings.Join(strings.Fields(aff.String()), " ")
+					affText = strings.Trim(strings.Replace(affText, " ,", ",", -1), " ,;")
+					if inAuthor {
+						// Written out in full inside the author
+						i := len(metadata.Authors) - 1
+						metadata.Authors[i].Affiliations = append(metadata.Authors[i].Affiliations, affText)
+					} else if !elementInPath(stack, "contrib") {
+						if len(affID) == 0 {
+							affID = fmt.Sprintf("#%d", len(affOrder))
+						}
+						affs[affID] = affText
+						affOrder = append(affOrder, affID)
+					}
+				}
+				aff = nil
+			case "year", "month", "day":
+				if text != nil {
+					if value, err := strconv.Atoi(strings.TrimSpace(text.String())); err == nil {
+						dateParts[name] = value
+					}
+				}
+			case "pub-date":
+				// Papers can have several publication dates (print, electronic, etc.), we take the first
+				// that has at least a year
+				if metadata.PublicationDatePrecision == 0 {
+					metadata.PublicationDate, metadata.PublicationDatePrecision = publicationDateFromParts(dateParts)
+				}
+				dateParts = make(map[string]int)
+			}
+
+			// Inline markup inside a title or keyword shouldn't stop us collecting the rest of the text
+			if text != nil && !elementInPath(stack, "article-title") && !elementInPath(stack, "journal-title") &&
+				!elementInPath(stack, "kwd") {
+				text = nil
+			}
+		}
+	}
+
+	resolveAffiliations(&metadata, authorAffs, affs, affOrder)
+	return metadata, nil
+}
+
+func attribute(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// resolveAffiliations fills in the text of each author's affiliations from the IDs they referred to.
+// If no author refers to any then every listed affiliation is taken to apply to every author, as is
+// usual for papers from a single institution.
+func resolveAffiliations(metadata *PaperMetadata, authorAffs [][]string, affs map[string]string, affOrder []string) {
+
+	referenced := false
+	for _, ids := range authorAffs {
+		if len(ids) > 0 {
+			referenced = true
+		}
+	}
+
+	for i := range metadata.Authors {
+		if referenced {
+			for _, id := range authorAffs[i] {
+				if text, ok := affs[id]; ok && len(text) != 0 {
+					metadata.Authors[i].Affiliations = append(metadata.Authors[i].Affiliations, text)
+				}
+			}
+		} else if len(metadata.Authors[i].Affiliations) == 0 {
+			for _, id := range affOrder {
+				if len(affs[id]) != 0 {
+					metadata.Authors[i].Affiliations = append(metadata.Authors[i].Affiliations, affs[id])
+				}
+			}
+		}
+	}
+
+	// Group authors and the like have no name, and don't make sense as authors on their own
+	named := metadata.Authors[:0]
+	for _, author := range metadata.Authors {
+		if len(author.Name.Surname) != 0 || len(author.Name.GivenNames) != 0 {
+			named = append(named, author)
+		}
+	}
+	metadata.Authors = named
+}
+
+func elementInPath(stack []string, name string) bool {
+	for _, element := range stack {
+		if element == name {
+			return true
+		}
+	}
+	return false
+}
+
+func publicationDateFromParts(parts map[string]int) (time.Time, wikibase.TimePrecision) {
+
+	year, ok := parts["year"]
+	if !ok || year == 0 {
+		return time.Time{}, 0
+	}
+
+	month, ok := parts["month"]
+	if !ok || month < 1 || month > 12 {
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), wikibase.TimePrecisionYear
+	}
+
+	day, ok := parts["day"]
+	if !ok || day < 1 || day > 31 {
+		return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), wikibase.TimePrecisionMonth
+	}
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), wikibase.TimePrecisionDay
+}
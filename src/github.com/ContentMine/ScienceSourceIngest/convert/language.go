@@ -0,0 +1,105 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package convert
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Most papers say what language they're in with xml:lang on the article element, but not all do, so we
+// can also guess from the text. Languages written without spaces between words are spotted by their
+// script, and the rest by counting common short words, which is crude but reliable over a whole paper.
+
+// How much of the text to look at, as the start of a paper is as good a guide as all of it
+const languageSampleSize int = 64 * 1024
+
+// Fewer common words than this and we don't trust the guess
+const languageMinimumHits int = 20
+
+var languageStopWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "with", "for", "was"},
+	"de": {"der", "die", "und", "das", "ist", "mit", "nicht", "von", "den", "wurde"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "dans", "pour", "que"},
+	"es": {"el", "la", "los", "las", "y", "del", "que", "en", "una", "por"},
+	"it": {"il", "della", "che", "di", "per", "sono", "una", "nel", "gli", "delle"},
+	"pt": {"o", "os", "da", "do", "que", "em", "uma", "com", "para", "foram"},
+	"nl": {"de", "het", "een", "van", "en", "is", "dat", "met", "voor", "werd"},
+}
+
+// DetectLanguage guesses the ISO 639-1 code of the language of the text, or returns "" if it can't tell.
+func DetectLanguage(text []byte) string {
+
+	if len(text) > languageSampleSize {
+		text = text[:languageSampleSize]
+	}
+
+	// Count scripts first, as word counting is no good for languages without spaces
+	letters := 0
+	scripts := make(map[string]int)
+	for rest := text; len(rest) > 0; {
+		r, size := utf8.DecodeRune(rest)
+		rest = rest[size:]
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters += 1
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			scripts["ja"] += 1
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"] += 1
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"] += 1
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"] += 1
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Chinese characters, so a fair amount of kana means Japanese
+	if scripts["ja"] > letters/20 {
+		return "ja"
+	}
+	for _, language := range []string{"ko", "zh", "ru"} {
+		if scripts[language] > letters/2 {
+			return language
+		}
+	}
+
+	words := make(map[string]int)
+	for _, word := range bytes.FieldsFunc(bytes.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		words[string(word)] += 1
+	}
+	best := ""
+	best_hits := 0
+	for language, stop_words := range languageStopWords {
+		hits := 0
+		for _, word := range stop_words {
+			hits += words[word]
+		}
+		// Ties go to the first in alphabetical order, so the answer doesn't depend on map order
+		if hits > best_hits || (hits == best_hits && language < best) {
+			best = language
+			best_hits = hits
+		}
+	}
+	if best_hits < languageMinimumHits {
+		return ""
+	}
+	return best
+}
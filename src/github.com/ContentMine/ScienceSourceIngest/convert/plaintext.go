@@ -0,0 +1,172 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package convert
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	europmc "github.com/ContentMine/go-europmc"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Not every source has papers as JATS, some just have the plain text and some metadata, so we wrap what
+// they have in a minimal JATS document, which then goes through the same conversion as everything else.
+
+type PlainTextPaper struct {
+	Title    string
+	Journal  string
+	DOI      string
+	PMCID    string
+	Authors  []europmc.ContributorName
+	Abstract string
+	Text     string // Paragraphs are separated by blank lines
+
+	PublicationDate          time.Time
+	PublicationDatePrecision wikibase.TimePrecision // Zero if the date isn't known
+}
+
+// SplitAuthorName makes a guess at the surname and given names of an author given as one string, either
+// as "Surname, Given Names" or "Given Names Surname".
+func SplitAuthorName(name string) europmc.ContributorName {
+	if i := strings.Index(name, ","); i >= 0 {
+		return europmc.ContributorName{
+			Surname:    strings.TrimSpace(name[:i]),
+			GivenNames: strings.TrimSpace(name[i+1:]),
+		}
+	}
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return europmc.ContributorName{}
+	}
+	return europmc.ContributorName{
+		Surname:    words[len(words)-1],
+		GivenNames: strings.Join(words[:len(words)-1], " "),
+	}
+}
+
+// plainTextParagraphs splits the text on blank lines, joining up the lines of each paragraph, as text
+// taken from PDFs has line breaks wherever the lines were wrapped.
+func plainTextParagraphs(text string) []string {
+	res := make([]string, 0)
+	for _, block := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n\n") {
+		paragraph := strings.Join(strings.Fields(block), " ")
+		if len(paragraph) != 0 {
+			res = append(res, paragraph)
+		}
+	}
+	return res
+}
+
+func WritePlainTextAsJATS(w io.Writer, paper PlainTextPaper) error {
+
+	b := bufio.NewWriter(w)
+	text := func(s string) {
+		xml.EscapeText(b, []byte(s))
+	}
+	element := func(name string, s string) {
+		fmt.Fprintf(b, "<%s>", name)
+		text(s)
+		fmt.Fprintf(b, "</%s>", name)
+	}
+
+	b.WriteString(xml.Header)
+	b.WriteString("<article article-type=\"research-article\">\n<front>\n")
+	if len(paper.Journal) != 0 {
+		b.WriteString("<journal-meta><journal-title-group>")
+		element("journal-title", paper.Journal)
+		b.WriteString("</journal-title-group></journal-meta>\n")
+	}
+
+	b.WriteString("<article-meta>\n")
+	if len(paper.PMCID) != 0 {
+		b.WriteString("<article-id pub-id-type=\"pmcid\">")
+		text(strings.TrimPrefix(paper.PMCID, "PMC"))
+		b.WriteString("</article-id>\n")
+	}
+	if len(paper.DOI) != 0 {
+		b.WriteString("<article-id pub-id-type=\"doi\">")
+		text(paper.DOI)
+		b.WriteString("</article-id>\n")
+	}
+	b.WriteString("<title-group>")
+	element("article-title", paper.Title)
+	b.WriteString("</title-group>\n")
+
+	if len(paper.Authors) != 0 {
+		b.WriteString("<contrib-group>\n")
+		for _, author := range paper.Authors {
+			b.WriteString("<contrib contrib-type=\"author\"><name>")
+			element("surname", author.Surname)
+			if len(author.GivenNames) != 0 {
+				element("given-names", author.GivenNames)
+			}
+			b.WriteString("</name></contrib>\n")
+		}
+		b.WriteString("</contrib-group>\n")
+	}
+
+	if paper.PublicationDatePrecision != 0 {
+		b.WriteString("<pub-date pub-type=\"epub\">")
+		if paper.PublicationDatePrecision >= wikibase.TimePrecisionDay {
+			fmt.Fprintf(b, "<day>%d</day>", paper.PublicationDate.Day())
+		}
+		if paper.PublicationDatePrecision >= wikibase.TimePrecisionMonth {
+			fmt.Fprintf(b, "<month>%d</month>", paper.PublicationDate.Month())
+		}
+		fmt.Fprintf(b, "<year>%d</year></pub-date>\n", paper.PublicationDate.Year())
+	}
+
+	if len(paper.Abstract) != 0 {
+		b.WriteString("<abstract>\n")
+		for _, paragraph := range plainTextParagraphs(paper.Abstract) {
+			element("p", paragraph)
+			b.WriteString("\n")
+		}
+		b.WriteString("</abstract>\n")
+	}
+	b.WriteString("</article-meta>\n</front>\n<body>\n")
+
+	for _, paragraph := range plainTextParagraphs(paper.Text) {
+		element("p", paragraph)
+		b.WriteString("\n")
+	}
+	b.WriteString("</body>\n</article>\n")
+
+	return b.Flush()
+}
+
+func SavePlainTextAsJATS(path string, paper PlainTextPaper) error {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = WritePlainTextAsJATS(f, paper)
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
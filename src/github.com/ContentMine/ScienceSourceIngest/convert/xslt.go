@@ -0,0 +1,233 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// The conversion itself is done by xsltproc using the jats-*.xsl stylesheets, which are expected to be
+// in the current working directory. xsltproc has to load the whole document to transform it, but does so
+// in a process of its own, and we copy what it writes straight to disk rather than holding it here. That
+// doesn't make conversion streamed: the HTML is read back whole to upload it, and the text to annotate
+// it, so a paper's HTML and text still have to fit in memory.
+
+type Converter struct {
+	XSLTProcPath string
+}
+
+// ToHTML converts the paper to the HTML we upload as the article page, with the given header and footer
+// wikitext around it.
+func (c Converter) ToHTML(xmlFileName string, htmlFileName string, header string, footer string) error {
+
+	f, err := os.Create(htmlFileName)
+	if err != nil {
+		return errwrap.Wrapf("Error creating HTML target file: {{err}}", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(header))
+	if err != nil {
+		return errwrap.Wrapf("Error when writing header: {{err}}", err)
+	}
+
+	cmd := exec.Cmd{
+		Path: c.XSLTProcPath,
+		Args: []string{"xsltproc", "jats-parsoid.xsl", xmlFileName},
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errwrap.Wrapf("Error generating output handle for xsltproc: {{err}}", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errwrap.Wrapf("Error generating error handle for xsltproc: {{err}}", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return errwrap.Wrapf("Error running xsltproc: {{err}}", err)
+	}
+
+	// We need to ditch the '<!DOCTYPE html>' (15 characters) from the start of the XSLT
+	n := 0
+	for count := len("<!DOCTYPE html>"); count > 0; count -= n {
+		stash := make([]byte, count)
+		n, err = stdout.Read(stash)
+		if err != nil {
+			errprose, _ := ioutil.ReadAll(stderr)
+			errtext := fmt.Sprintf("Error typing to find DOCTYPE tag: {{err}}. Error output from xsltproc: %s", errprose)
+			return errwrap.Wrapf(errtext, err)
+		}
+	}
+
+	_, err = io.Copy(f, stdout)
+	if err != nil {
+		return errwrap.Wrapf("Error copying file contents: {{err}}", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		errprose, _ := ioutil.ReadAll(stderr)
+		errtext := fmt.Sprintf("Error when waiting for xsltproc: {{err}}. Error output from xsltproc: %s", errprose)
+		return errwrap.Wrapf(errtext, err)
+	}
+
+	// write the footer
+	_, err = f.Write([]byte(footer))
+	if err != nil {
+		return errwrap.Wrapf("Error when writing footer: {{err}}", err)
+	}
+
+	return nil
+}
+
+// A span of the text, in bytes
+type TextRange struct {
+	Start int
+	End   int
+}
+
+func (r TextRange) Contains(offset int, length int) bool {
+	return offset >= r.Start && offset+length <= r.End
+}
+
+// The stylesheets write this either side of each abstract when asked, which we then take out again. It's
+// from the Unicode private use area, so shouldn't turn up in papers.
+const abstractMarker string = "\uE000"
+
+// ToText converts the paper to the plain text that annotators are run over.
+func (c Converter) ToText(xmlFileName string, textFileName string) error {
+	_, err := c.ToTextWithAbstracts(xmlFileName, textFileName)
+	return err
+}
+
+// ToTextWithAbstracts converts the paper to plain text as ToText does, and returns where in the text the
+// paper's abstracts are.
+func (c Converter) ToTextWithAbstracts(xmlFileName string, textFileName string) ([]TextRange, error) {
+
+	f, err := os.Create(textFileName)
+	if err != nil {
+		return nil, errwrap.Wrapf("Error generating text mining target file: {{err}}", err)
+	}
+	defer f.Close()
+
+	cmd := exec.Cmd{
+		Path: "/usr/bin/xsltproc",
+		Args: []string{"xsltproc", "--stringparam", "abstract-marker", abstractMarker, "jats-text.xsl", xmlFileName},
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errwrap.Wrapf("Error generating file handles for xsltproc: {{err}}", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, errwrap.Wrapf("Error generating error handle for xsltproc: {{err}}", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errwrap.Wrapf("Error running xsltproc: {{err}}", err)
+	}
+
+	text := newAbstractMarkerWriter(f)
+	_, copy_err := io.Copy(text, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		errprose, _ := ioutil.ReadAll(stderr)
+		errtext := fmt.Sprintf("Error when waiting for xsltproc: {{err}}. Error output from xsltproc: %s", errprose)
+		return nil, errwrap.Wrapf(errtext, err)
+	}
+	if copy_err == nil {
+		copy_err = text.Flush()
+	}
+	if copy_err != nil {
+		return nil, errwrap.Wrapf("Error copying file contents: {{err}}", copy_err)
+	}
+
+	return text.abstracts, nil
+}
+
+// abstractMarkerWriter takes the markers out of the text written through it, noting the spans they were
+// around. A marker can be split between writes, so anything at the end of a write that could be the start
+// of one is held back until the next write, or Flush.
+type abstractMarkerWriter struct {
+	w         io.Writer
+	written   int // how much text has gone through to w
+	start     int // where the abstract we're in started, or -1
+	held      []byte
+	abstracts []TextRange
+}
+
+func newAbstractMarkerWriter(w io.Writer) *abstractMarkerWriter {
+	return &abstractMarkerWriter{w: w, start: -1, abstracts: make([]TextRange, 0)}
+}
+
+func (m *abstractMarkerWriter) pass(data []byte) error {
+	n, err := m.w.Write(data)
+	m.written += n
+	return err
+}
+
+func (m *abstractMarkerWriter) Write(p []byte) (int, error) {
+
+	marker := []byte(abstractMarker)
+	data := p
+	if len(m.held) != 0 {
+		data = append(m.held, p...)
+		m.held = nil
+	}
+
+	for {
+		i := bytes.Index(data, marker)
+		if i == -1 {
+			break
+		}
+		if err := m.pass(data[:i]); err != nil {
+			return 0, err
+		}
+		data = data[i+len(marker):]
+		if m.start == -1 {
+			m.start = m.written
+		} else {
+			m.abstracts = append(m.abstracts, TextRange{Start: m.start, End: m.written})
+			m.start = -1
+		}
+	}
+
+	keep := 0
+	for k := len(marker) - 1; k > 0; k-- {
+		if bytes.HasSuffix(data, marker[:k]) {
+			keep = k
+			break
+		}
+	}
+	if err := m.pass(data[:len(data)-keep]); err != nil {
+		return 0, err
+	}
+	m.held = append([]byte(nil), data[len(data)-keep:]...)
+	return len(p), nil
+}
+
+// Flush writes out anything held back, which at the end of the text can't be a marker after all.
+func (m *abstractMarkerWriter) Flush() error {
+	held := m.held
+	m.held = nil
+	return m.pass(held)
+}
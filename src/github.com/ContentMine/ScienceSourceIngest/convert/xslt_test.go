@@ -0,0 +1,83 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package convert
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAbstractMarkerWriter(t *testing.T) {
+
+	m := abstractMarker
+	tests := []struct {
+		text      string
+		expected  string
+		abstracts []TextRange
+	}{
+		{text: "No abstract here", expected: "No abstract here", abstracts: []TextRange{}},
+		{text: "Title\n" + m + "Abstract\n" + m + "Body", expected: "Title\nAbstract\nBody",
+			abstracts: []TextRange{{Start: 6, End: 15}}},
+		{text: m + "One" + m + " " + m + "Two" + m, expected: "One Two",
+			abstracts: []TextRange{{Start: 0, End: 3}, {Start: 4, End: 7}}},
+		{text: "Café " + m + "naïve" + m, expected: "Café naïve",
+			abstracts: []TextRange{{Start: 6, End: 12}}},
+		// Part of a marker at the end is just text
+		{text: "Ends with " + m[:2], expected: "Ends with " + m[:2], abstracts: []TextRange{}},
+		{text: "", expected: "", abstracts: []TextRange{}},
+	}
+
+	for _, test := range tests {
+		// However xsltproc's output is split up between writes, even mid marker, the result is the same
+		for split := 0; split <= len(test.text); split++ {
+			var out bytes.Buffer
+			w := newAbstractMarkerWriter(&out)
+			for _, part := range []string{test.text[:split], test.text[split:]} {
+				n, err := w.Write([]byte(part))
+				if err != nil || n != len(part) {
+					t.Fatalf("%q split at %d: wrote %d of %d, %v", test.text, split, n, len(part), err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("%q split at %d: got %q, expected %q", test.text, split, out.String(), test.expected)
+			}
+			if !reflect.DeepEqual(w.abstracts, test.abstracts) {
+				t.Errorf("%q split at %d: got abstracts %v, expected %v", test.text, split, w.abstracts, test.abstracts)
+			}
+		}
+	}
+}
+
+func TestAbstractMarkerWriterByteAtATime(t *testing.T) {
+
+	text := "A" + abstractMarker + "BC" + abstractMarker + "D"
+	var out bytes.Buffer
+	w := newAbstractMarkerWriter(&out)
+	for i := 0; i < len(text); i++ {
+		if _, err := w.Write([]byte{text[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "ABCD" || !reflect.DeepEqual(w.abstracts, []TextRange{{Start: 1, End: 3}}) {
+		t.Errorf("Got %q and %v", out.String(), w.abstracts)
+	}
+}
@@ -0,0 +1,87 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The diff command shows what differs between two saved copies of an article's state, or between a
+// saved copy and the article's items on the server. Like diff(1) it exits with 1 if there were any
+// differences, so it can be used in scripts.
+
+func runDiff(args []string) {
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	var options commandFlags
+	flags.BoolVar(&options.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&options.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&options.veryVerbose, "vv", false, "Log full API requests and responses.")
+	options.registerServer(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [flags] [old scisource.json] [new scisource.json]\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "With one file, it is compared with the article's items on the server.\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 && flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	logging.SetLogLevel(options.quiet, options.verbose, options.veryVerbose)
+
+	old, err := sciencesource.LoadScienceSourceArticle(flags.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+
+	var differences []sciencesource.ArticleDifference
+	if flags.NArg() == 2 {
+		new, err := sciencesource.LoadScienceSourceArticle(flags.Arg(1))
+		if err != nil {
+			panic(err)
+		}
+		differences, err = sciencesource.DiffArticles(old, new)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: "none"})
+		differences, err = sciSourceClient.DiffArticleWithServer(ctx, old)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+	if len(differences) != 0 {
+		os.Exit(1)
+	}
+}
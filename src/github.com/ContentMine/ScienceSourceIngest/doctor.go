@@ -0,0 +1,189 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The doctor command checks that everything an ingest run needs is in place before starting one: that
+// the credentials work, the account can do what ingest will ask of it, the data schema resolves on the
+// server, the Wikidata query service can be reached, and the converters are installed. Each check says
+// what to do about it if it fails, and the command exits with 1 if any did. Checks of things only some
+// runs need are warnings rather than failures.
+
+type doctorResult struct {
+	check  string
+	status string // ok, warning, or failed
+	detail string
+	advice string
+}
+
+// Rights ingest can't work without, whatever it is asked to do
+var doctorRequiredRights = []string{"edit", "createpage", "item-create"}
+
+func runDoctor(args []string) {
+
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	var options commandFlags
+	var xslt_proc_path, protection_level, assert_user string
+	flags.BoolVar(&options.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&options.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&options.veryVerbose, "vv", false, "Log full API requests and responses.")
+	flags.StringVar(&xslt_proc_path, "xsltproc", "/usr/bin/xsltproc", "Location of xsltproc tool, as for ingest.")
+	flags.StringVar(&protection_level, "protect", "sysop", "Protection level ingest will use for article pages, or none.")
+	flags.StringVar(&assert_user, "assert", "user", "How ingest will have the server check writes, user, bot, or none.")
+	options.registerServer(flags)
+	flags.Parse(args)
+
+	logging.SetLogLevel(options.quiet, options.verbose, options.veryVerbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make([]doctorResult, 0)
+	add := func(check string, err error, detail string, advice string) {
+		if err != nil {
+			results = append(results, doctorResult{check: check, status: "failed", detail: err.Error(), advice: advice})
+		} else {
+			results = append(results, doctorResult{check: check, status: "ok", detail: detail})
+		}
+	}
+	warn := func(check string, detail string, advice string) {
+		results = append(results, doctorResult{check: check, status: "warning", detail: detail, advice: advice})
+	}
+
+	// Local tools first, as they don't depend on anything else working
+	if _, err := exec.LookPath(xslt_proc_path); err != nil {
+		add("xsltproc", err, "", "Install xsltproc (e.g. apt-get install xsltproc), or give its location with -xsltproc.")
+	} else {
+		add("xsltproc", nil, xslt_proc_path, "")
+	}
+	for _, stylesheet := range []string{"jats-parsoid.xsl", "jats-text.xsl"} {
+		if _, err := os.Stat(stylesheet); err != nil {
+			add(stylesheet, err, "", "Run the tool from the directory with the JATS stylesheets in.")
+		} else {
+			add(stylesheet, nil, "found", "")
+		}
+	}
+	if path, err := exec.LookPath("pdftotext"); err != nil {
+		warn("pdftotext", "not installed", "Install poppler-utils if papers are to be fetched with -source semanticscholar.")
+	} else {
+		add("pdftotext", nil, path, "")
+	}
+
+	err := sciencesource.CheckQueryService(ctx)
+	add("query service", err, "reachable", "Check this machine can reach query.wikidata.org, which -mesh needs.")
+
+	// Everything else needs the server, which needs credentials
+	_, err = wikibase.LoadCredentials(options.oauthTokensPath)
+	add("credentials", err, options.oauthTokensPath, "Run the auth command to authorise a consumer and save its tokens, or give the file with -oauth.")
+	if err == nil {
+		sciSourceClient := options.dial(wikibase.NetworkOptions{Assert: "none"})
+		accounts, err := sciSourceClient.Network().AccountRights(ctx)
+		detail := ""
+		if err == nil {
+			detail = fmt.Sprintf("logged in to %s as %s", options.urlBase, accounts[0].User)
+		}
+		add("login", err, detail, "Check -urlbase, and that the consumer hasn't been revoked; if it has, run auth again.")
+
+		for _, account := range accounts {
+			required := append([]string{}, doctorRequiredRights...)
+			if assert_user == "bot" {
+				required = append(required, "bot")
+			}
+			if protection_level != wikibase.ProtectionLevelNone && len(protection_level) != 0 {
+				required = append(required, "protect")
+			}
+			missing := make([]string, 0)
+			for _, right := range required {
+				if account.Has(right) == false {
+					missing = append(missing, right)
+				}
+			}
+			check := "rights of " + account.User
+			if len(missing) != 0 {
+				add(check, fmt.Errorf("missing %s", strings.Join(missing, ", ")), "",
+					"Ask a server admin to add the user to a group with these rights, or use -protect none or -assert user.")
+			} else {
+				add(check, nil, strings.Join(required, ", "), "")
+			}
+			if len(options.schemaPage) == 0 && account.Has("property-create") == false {
+				warn(check, "can't create properties", "Ingest creates any missing properties unless -schema is given, so ask for property-create or check props reports nothing missing.")
+			}
+			if account.Has("delete") == false {
+				warn(check, "can't delete", "Only needed by -rollback, cleanup, and undo.")
+			}
+		}
+
+		err = options.resolveSchema(ctx, sciSourceClient)
+		if err == nil {
+			entries, entries_err := sciSourceClient.SchemaEntries(ctx)
+			err = entries_err
+			if err == nil {
+				problems := 0
+				for _, entry := range entries {
+					if len(entry.Problem) != 0 {
+						problems += 1
+					}
+				}
+				if problems != 0 {
+					err = fmt.Errorf("%d properties or items don't match the data schema", problems)
+				}
+			}
+		}
+		add("schema", err, "all labels resolve", "Run the props command to see which properties and items are missing or wrong.")
+	}
+
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.check, result.status, result.detail)
+		if result.status == "failed" {
+			failures += 1
+		}
+	}
+	w.Flush()
+
+	advice := make([]string, 0)
+	for _, result := range results {
+		if len(result.advice) != 0 {
+			advice = append(advice, fmt.Sprintf("%s: %s", result.check, result.advice))
+		}
+	}
+	if len(advice) != 0 {
+		fmt.Println()
+		for _, line := range advice {
+			fmt.Println(line)
+		}
+	}
+
+	if failures != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+)
+
+// The export command bundles each paper's XML, HTML, text, and article state into a ZIP file of its own,
+// with a manifest of checksums, for archiving or handing to collaborators. It only reads what's on disk,
+// so needs no server.
+
+func runExport(args []string) {
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	var options commandFlags
+	var export_path string
+	options.register(flags)
+	flags.StringVar(&export_path, "to", "", "Directory to write each paper's PMCID.zip to, defaults to the output directory.")
+	flags.Parse(args)
+
+	processors := options.processors()
+
+	if len(export_path) == 0 {
+		export_path = options.targetPath
+	}
+	err := os.MkdirAll(export_path, 0755)
+	if err != nil {
+		panic(err)
+	}
+
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAPER\tFILES\tARCHIVE\tRESULT")
+
+	for _, processor := range processors {
+		id := processor.Paper.ID()
+		filename := path.Join(export_path, id+".zip")
+
+		manifest, err := processor.Export(filename)
+		switch {
+		case err == sciencesource.ErrNothingToExport:
+			fmt.Fprintf(w, "%s\t-\t-\tskipped, not fetched yet\n", id)
+		case err != nil:
+			fmt.Fprintf(w, "%s\t-\t-\tfailed: %v\n", id, err)
+			failures += 1
+		default:
+			fmt.Fprintf(w, "%s\t%d\t%s\tok\n", id, len(manifest.Files), filename)
+			logging.Logf(logging.LogVerbose, "Exported %s to %s", id, filename)
+		}
+	}
+	w.Flush()
+
+	if failures != 0 {
+		os.Exit(1)
+	}
+}
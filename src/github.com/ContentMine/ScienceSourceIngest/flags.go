@@ -0,0 +1,31 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"strings"
+)
+
+// stringListFlag lets a command line flag be specified multiple times, collecting each value.
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
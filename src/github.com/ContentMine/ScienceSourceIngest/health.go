@@ -0,0 +1,199 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// With -healthaddr, ingest serves /healthz and /readyz over HTTP, so that workers left running on a
+// queue can be supervised like any other service. /healthz fails if requests to the server have been
+// failing for longer than -healthstale, which a supervisor can take as a sign to restart the worker.
+// /readyz also fails if the queue can't be reached, or we aren't connected to the server yet, or the
+// credentials no longer work. Both return the same JSON report of what they found.
+
+// How long a check of the credentials is trusted for, as each one is a request to the server
+const healthCredentialCheckInterval time.Duration = time.Minute
+
+// How long each probe may spend asking Redis or the server
+const healthProbeTimeout time.Duration = 10 * time.Second
+
+type healthReport struct {
+	Status         string     `json:"status"`
+	Queue          string     `json:"queue,omitempty"`
+	QueueDepth     *int       `json:"queue_depth,omitempty"`
+	LastAPISuccess *time.Time `json:"last_api_success,omitempty"`
+	LastAPIFailure *time.Time `json:"last_api_failure,omitempty"`
+	LastAPIError   string     `json:"last_api_error,omitempty"`
+	Credentials    string     `json:"credentials,omitempty"`
+	Problems       []string   `json:"problems,omitempty"`
+}
+
+type healthServer struct {
+	queue       *sciencesource.JobQueue // a connection of its own, so probes don't wait behind a blocking pop
+	needsServer bool
+	staleAfter  time.Duration
+	started     time.Time
+
+	// The lock is never held while asking Redis or the server, so one slow probe doesn't hold up others
+	lock               sync.Mutex
+	client             *sciencesource.ScienceSourceClient
+	credentialsChecked time.Time
+	credentialsError   error
+}
+
+// startHealthServer starts serving the health endpoints on the address until the context is done. The
+// queue is optional, and needsServer says whether the run talks to the server at all.
+func startHealthServer(ctx context.Context, addr string, queue *sciencesource.JobQueue, needsServer bool, staleAfter time.Duration) *healthServer {
+
+	h := &healthServer{
+		queue:       queue,
+		needsServer: needsServer,
+		staleAfter:  staleAfter,
+		started:     time.Now(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, true)
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Health endpoints stopped: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	return h
+}
+
+// setClient tells the health server about the client once we've connected to the server.
+func (h *healthServer) setClient(client *sciencesource.ScienceSourceClient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.client = client
+}
+
+func (h *healthServer) currentClient() *sciencesource.ScienceSourceClient {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.client
+}
+
+// checkCredentials says whether the client's accounts are still logged in, asking the server at most
+// once every healthCredentialCheckInterval. The check doesn't count as API activity, as it would
+// otherwise hide ingest's own requests failing from /healthz.
+func (h *healthServer) checkCredentials(ctx context.Context, client *sciencesource.ScienceSourceClient) error {
+
+	h.lock.Lock()
+	if time.Since(h.credentialsChecked) < healthCredentialCheckInterval {
+		defer h.lock.Unlock()
+		return h.credentialsError
+	}
+	h.lock.Unlock()
+
+	_, err := client.Network().AccountUsers(wikibase.WithoutActivity(ctx))
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.credentialsChecked = time.Now()
+	h.credentialsError = err
+	return err
+}
+
+func (h *healthServer) report(ctx context.Context, ready bool) healthReport {
+
+	report := healthReport{Problems: make([]string, 0)}
+
+	client := h.currentClient()
+	if client != nil {
+		success, failure, err := client.Network().APIActivity()
+		if !success.IsZero() {
+			report.LastAPISuccess = &success
+		}
+		if !failure.IsZero() {
+			report.LastAPIFailure = &failure
+			report.LastAPIError = err.Error()
+		}
+
+		// Requests failing now and then is normal, but nothing getting through for a while isn't
+		since := success
+		if since.IsZero() {
+			since = h.started
+		}
+		if failure.After(success) && time.Since(since) > h.staleAfter {
+			report.Problems = append(report.Problems, fmt.Sprintf("no successful API call for %v",
+				time.Since(since).Round(time.Second)))
+		}
+	}
+
+	if ready {
+		if h.queue != nil {
+			report.Queue = h.queue.Name
+			depth, err := h.queue.Length(ctx)
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("queue unreachable: %v", err))
+			} else {
+				report.QueueDepth = &depth
+			}
+		}
+		if h.needsServer {
+			if client == nil {
+				report.Problems = append(report.Problems, "not connected to the server yet")
+			} else if err := h.checkCredentials(ctx, client); err != nil {
+				report.Credentials = err.Error()
+				report.Problems = append(report.Problems, "credentials not valid")
+			} else {
+				report.Credentials = "valid"
+			}
+		}
+	}
+
+	report.Status = "ok"
+	if len(report.Problems) != 0 {
+		report.Status = "unavailable"
+	}
+	return report
+}
+
+func (h *healthServer) serve(w http.ResponseWriter, r *http.Request, ready bool) {
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthProbeTimeout)
+	defer cancel()
+	report := h.report(ctx, ready)
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,78 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+)
+
+// The import command unpacks bundles made by export into the output directory, so ingest can finish
+// uploading the papers on another machine, such as one with the credentials for the server.
+
+func runImport(args []string) {
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	var target_path string
+	var replace, quiet, verbose bool
+	flags.StringVar(&target_path, "output", ".", "Directory to unpack the papers into.")
+	flags.BoolVar(&replace, "replace", false, "Replace the state of papers that already have some here.")
+	flags.BoolVar(&quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&verbose, "v", false, "Log each paper imported.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [flags] [bundle.zip]...\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	logging.SetLogLevel(quiet, verbose, false)
+
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BUNDLE\tPAPER\tFILES\tRESULT")
+
+	for _, filename := range flags.Args() {
+		manifest, err := sciencesource.ImportExport(filename, target_path, replace)
+		paper := manifest.Paper
+		if len(paper) == 0 {
+			paper = "-"
+		}
+		switch {
+		case err == sciencesource.ErrAlreadyHaveState:
+			fmt.Fprintf(w, "%s\t%s\t-\tnot imported, already have state (use -replace to overwrite)\n", filename, paper)
+			failures += 1
+		case err != nil:
+			fmt.Fprintf(w, "%s\t%s\t-\tfailed: %v\n", filename, paper, err)
+			failures += 1
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%d\tok\n", filename, paper, len(manifest.Files))
+			logging.Logf(logging.LogVerbose, "Imported %s from %s, exported %v", paper, filename, manifest.Exported)
+		}
+	}
+	w.Flush()
+
+	if failures != 0 {
+		os.Exit(1)
+	}
+}
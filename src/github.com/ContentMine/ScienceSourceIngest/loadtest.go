@@ -0,0 +1,153 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// The loadtest command is bench with several uploads running at once, for checking a staging server
+// can take the load before starting a big ingest. As it can do real damage to a server it insists on
+// being told the server's host name as well as its URL.
+
+func runLoadTest(args []string) {
+
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	var options benchFlags
+	var target string
+	var concurrency, max_failures int
+	var duration, ramp, interval time.Duration
+	options.register(flags, 100)
+	flags.StringVar(&target, "target", "", "Host name of the -urlbase server, required to confirm it is a staging server.")
+	flags.IntVar(&concurrency, "concurrency", 4, "Number of articles to upload at once.")
+	flags.DurationVar(&duration, "duration", 0, "Stop starting new articles after this long, or 0 for no limit.")
+	flags.DurationVar(&ramp, "ramp", 0, "Spread starting the concurrent uploads over this long.")
+	flags.DurationVar(&interval, "interval", 30*time.Second, "How often to log progress.")
+	flags.IntVar(&max_failures, "maxfailures", 10, "Stop starting new articles after this many have failed, or 0 for no limit.")
+	flags.Parse(args)
+
+	server, err := url.Parse(options.urlBase)
+	if err != nil {
+		panic(err)
+	}
+	if target != server.Hostname() {
+		fmt.Fprintf(os.Stderr, "loadtest needs -target %s to confirm that is a staging server\n", server.Hostname())
+		os.Exit(2)
+	}
+	if concurrency < 1 {
+		fmt.Fprintf(os.Stderr, "-concurrency must be at least 1\n")
+		os.Exit(2)
+	}
+	if options.articles == 0 && duration == 0 {
+		fmt.Fprintf(os.Stderr, "Unlimited -articles needs a -duration\n")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, recorded := options.connect(ctx)
+
+	// Running out of time stops new articles being handed out, but the ones in progress are finished
+	// so that they can be cleaned up properly.
+	load_ctx := ctx
+	if duration > 0 {
+		var cancel context.CancelFunc
+		load_ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	var results uploadResults
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := 0; options.articles == 0 || i < options.articles; i++ {
+			if _, failed, _ := results.counts(); max_failures > 0 && failed >= max_failures {
+				logging.Logf(logging.LogNormal, "Stopping after %d failed articles", failed)
+				return
+			}
+			select {
+			case next <- i:
+			case <-load_ctx.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			if ramp > 0 {
+				select {
+				case <-time.After(ramp * time.Duration(worker) / time.Duration(concurrency)):
+				case <-load_ctx.Done():
+					return
+				}
+			}
+			for i := range next {
+				title := syntheticTitle(client.RunID, i)
+				logging.Logf(logging.LogVerbose, "Uploading %s", title)
+
+				article_start := time.Now()
+				article, err := uploadSyntheticArticle(ctx, client, title, options.annotations)
+				results.add(title, article, time.Since(article_start), err)
+			}
+		}(worker)
+	}
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last_items := 0
+		for {
+			select {
+			case <-ticker.C:
+				uploaded, failed, items := results.counts()
+				logging.Logf(logging.LogNormal, "%v: %d articles uploaded, %d failed, %d items (%.2f items/second)",
+					time.Since(start).Round(time.Second), uploaded, failed, items, float64(items-last_items)/interval.Seconds())
+				last_items = items
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	results.print(time.Since(start), options.annotations, recorded)
+
+	if options.keep == false {
+		results.cleanup(ctx, client)
+	}
+
+	if _, failed, _ := results.counts(); failed != 0 {
+		os.Exit(1)
+	}
+}
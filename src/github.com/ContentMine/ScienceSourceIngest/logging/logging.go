@@ -0,0 +1,57 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package logging controls how chatty the ingest tool and its libraries are.
+package logging
+
+import (
+	"log"
+)
+
+// Failures and warnings are always logged with log.Printf directly. Everything else goes through Logf
+// so the operator can choose how chatty the tool is.
+
+type LogLevel int
+
+const (
+	LogQuiet   LogLevel = iota // only failures and warnings
+	LogNormal                  // progress through the batch
+	LogVerbose                 // pipeline detail and a line per API call
+	LogDebug                   // full API requests and responses
+)
+
+var logLevel = LogNormal
+
+func SetLogLevel(quiet bool, verbose bool, veryVerbose bool) {
+	switch {
+	case veryVerbose:
+		logLevel = LogDebug
+	case verbose:
+		logLevel = LogVerbose
+	case quiet:
+		logLevel = LogQuiet
+	default:
+		logLevel = LogNormal
+	}
+}
+
+func Logf(level LogLevel, format string, v ...interface{}) {
+	if level <= logLevel {
+		log.Printf(format, v...)
+	}
+}
+
+func Enabled(level LogLevel) bool {
+	return level <= logLevel
+}
@@ -15,19 +15,27 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path"
+	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
-	"github.com/ContentMine/wikibase"
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/tracing"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
 )
 
-// These will be set by the build script to something meaningful
-var Remote string
-var Version string
-
 // We could fire off 100 requests at once, but that's not being nice to
 // either the local machine or PMC's API, so we limite the number of
 // concurrent paper requests here
@@ -37,23 +45,280 @@ var xsl_file_list = []string{"jats-text.xsl", "jats-parsoid.xsl", "jats-common.x
 
 func main() {
 
+	// Ingesting papers is what we do by default, but there are also commands for looking after them
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command.run(os.Args[2:])
+			return
+		}
+	}
+
 	var feed_path string
 	var target_path string
 	var dictionaries_path string
 	var url_base string
 	var oauth_tokens_path string
+	var extra_accounts stringListFlag
+	var account_write_rate int
 	var xslt_proc_path string
+	var compress_requests bool
+	var assert_user string
+	var refresh_pages bool
+	var verify_claims bool
+	var categories stringListFlag
+	var create_talk_pages bool
+	var header_template_path string
+	var upload_media bool
+	var media_template_path string
+	var watchlist string
+	var protection_level string
+	var interactive bool
+	var filter sciencesource.PaperFilter
+	var quiet, verbose, very_verbose bool
+	var time_code_value string
+	var schema_page string
+	var annotator_specs stringListFlag
+	var hook_specs stringListFlag
+	var lookup_timeout, upload_timeout, write_timeout time.Duration
+	var failure_threshold int
+	var failure_pause time.Duration
+	var read_cache_size int
+	var rollback bool
+	var skip_existing bool
+	var force bool
+	var accept_threshold float64
+	var item_terms_path string
+	var rerun_id string
+	var notify_specs stringListFlag
+	var error_reporter_specs stringListFlag
+	var trace_endpoint string
+	var notify_threshold int
+	var failed_only bool
+	var describe_items bool
+	var label_languages stringListFlag
+	var phrase_size int
+	var hit_summary_path string
+	var dictionary_option_specs stringListFlag
+	var dictionary_cache_path string
+	var source_names stringListFlag
+	var rate_limits stringListFlag
+	var check_doaj bool
+	var issn_allowlist_path string
+	var retractions string
+	var mesh bool
+	var funding bool
+	var affiliations bool
+	var abstract_only bool
+	var keywords bool
+	var main_subject_minimum int
+	var confirm_production bool
+	var canary int
+	var checkpoint_interval int
+	var item_concurrency int
+	var compress_state bool
+	var redis_url, queue_name, upload_queue_name string
+	var queue_wait time.Duration
+	var prepare_only bool
+	var health_addr string
+	var health_stale time.Duration
+	var worker_name string
+	var claim_timeout time.Duration
+	var claim_store_location string
+	flag.Usage = usage
 	flag.StringVar(&feed_path, "feed", "", "JSON feed of papers, required")
 	flag.StringVar(&target_path, "output", ".", "Directory to store the results, required")
 	flag.StringVar(&dictionaries_path, "dictionaries", "", "Directory of dictionaries to load.")
 	flag.StringVar(&url_base, "urlbase", "http://localhost:8181", "Base URL for science source.")
 	flag.StringVar(&oauth_tokens_path, "oauth", "oauth.json", "JSON file with oauth credentials in.")
+	flag.Var(&extra_accounts, "account", "JSON file with oauth credentials for another account to spread writes over. Can be repeated.")
+	flag.BoolVar(&confirm_production, productionFlagName, false, fmt.Sprintf("Confirm that ingesting more than %d papers to a production server is intended.", productionBatchLimit))
+	flag.IntVar(&account_write_rate, "accountrate", 0, "Most writes a minute to make as each account, or 0 for no limit.")
+	flag.Var(&rate_limits, "ratelimit", "Most requests a second to make to a source, as source=rate, where source is europepmc, crossref, ncbi, wikidata, wiki, another known source, or a host name. Can be repeated.")
+	flag.Var(&source_names, "source", "Where to fetch paper XML from: europepmc, fatcat, wayback, core, or semanticscholar. Can be repeated to try each in turn, defaults to europepmc.")
+	flag.BoolVar(&check_doaj, "doaj", false, "Only ingest papers from journals listed in the Directory of Open Access Journals, or in -issns.")
+	flag.StringVar(&issn_allowlist_path, "issns", "", "File of journal ISSNs, one per line, to only ingest papers from, along with any in DOAJ if -doaj is given.")
+	flag.StringVar(&retractions, "retractions", "", "Check papers for retractions before ingesting them, and either refuse or flag those that have been retracted.")
+	flag.BoolVar(&mesh, "mesh", false, "Add the MeSH headings PubMed gives each paper to its article as subject annotations.")
+	flag.BoolVar(&funding, "funding", false, "Add statements to each article item for the funders and grants given in the paper.")
+	flag.BoolVar(&affiliations, "affiliations", false, "Add statements to each article item for its authors, with their affiliations matched to organisations using ROR.")
+	flag.BoolVar(&abstract_only, "abstractonly", false, "Only annotate terms found in each paper's abstract, though the whole paper is still uploaded.")
+	flag.BoolVar(&keywords, "keywords", false, "Add the authors' keywords for each paper that match Wikidata items as main subjects of the article item.")
+	flag.IntVar(&main_subject_minimum, "mainsubjects", 0, "Add Wikidata items annotated at least this many times in an article as main subjects of the article item, or 0 not to.")
+	flag.StringVar(&redis_url, "redis", "", "Redis server to take papers from a queue on, as redis://[:password@]host:port[/db], rather than from the feed.")
+	flag.StringVar(&queue_name, "queue", "", fmt.Sprintf("Queue on the -redis server to take papers from, defaults to %s, or %s unless -prepareonly.", sciencesource.DefaultPaperQueue, sciencesource.DefaultPreparedQueue))
+	flag.StringVar(&upload_queue_name, "uploadqueue", "", "With -prepareonly, queue on the -redis server to put prepared papers on for uploading elsewhere.")
+	flag.DurationVar(&queue_wait, "queuewait", time.Minute, "Stop taking papers from the queue once it has been empty this long, or 0 to wait for ever.")
+	flag.BoolVar(&prepare_only, "prepareonly", false, "Only fetch, convert, and annotate papers, without needing or talking to the server.")
+	flag.StringVar(&health_addr, "healthaddr", "", "Address to serve /healthz and /readyz on, e.g. :8080, for supervising long running workers.")
+	flag.DurationVar(&health_stale, "healthstale", 5*time.Minute, "With -healthaddr, how long API calls can fail for before /healthz does.")
+	flag.DurationVar(&claim_timeout, "claimtimeout", 0, "Claim each paper before processing it, so several copies can share the output directory, taking over claims with no heartbeat for this long. 0 doesn't claim papers.")
+	flag.StringVar(&worker_name, "worker", "", "Name to claim papers as, defaults to the host name and run ID, and to keep jobs taken off the -redis queue under until they are done, defaults to the host name.")
+	flag.StringVar(&claim_store_location, "claimstore", "", "With -claimtimeout, keep claims in a shared database, given as a postgres:// URL or sqlite:path, rather than in each paper's folder.")
 	flag.StringVar(&xslt_proc_path, "xsltproc", "/usr/bin/xsltproc", "Location off xsltproc tool.")
+	flag.BoolVar(&compress_requests, "gzip", false, "Compress large request bodies (e.g. article HTML) sent to the wikibase server.")
+	flag.StringVar(&assert_user, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flag.BoolVar(&refresh_pages, "refresh", false, "Purge and null edit article pages once uploaded.")
+	flag.BoolVar(&verify_claims, "verifyclaims", false, "Fetch each article's items back once uploaded and check their statements are as sent.")
+	flag.BoolVar(&create_talk_pages, "talkpages", false, "Create a talk page with a provenance notice for each uploaded article.")
+	flag.StringVar(&header_template_path, "header", "", "Template file of wikitext to put at the top of each article page.")
+	flag.BoolVar(&upload_media, "media", false, "Upload each paper's figures as files, with their source and licence, and link them from the article item.")
+	flag.StringVar(&media_template_path, "mediatemplate", "", "Template file of wikitext for the file page of each uploaded figure.")
+	flag.BoolVar(&interactive, "interactive", false, "Show a summary of each paper and ask before uploading it.")
+	flag.IntVar(&canary, "canary", 0, "Process just this many papers first, then show links to them and ask before doing the rest.")
+	flag.StringVar(&item_terms_path, "itemterms", "", "JSON file of labels and descriptions, by language, to give created article, anchor point, and annotation items.")
+	flag.BoolVar(&describe_items, "describe", false, "Give created items English descriptions and aliases that say what they are, for any kind of item -itemterms doesn't cover.")
+	flag.StringVar(&protection_level, "protect", "sysop", "Protection level for uploaded article pages, or none.")
+	flag.StringVar(&watchlist, "watchlist", "", "Either add or remove created pages and items to/from the account's watchlist.")
+	flag.Var(&categories, "category", "Category to add to article pages, can be repeated. May use {journal}, {subject}, {batch}, and {dictionary}.")
+	flag.Var((*stringListFlag)(&filter.Only), "only", "Only process papers matching this PMCID, Wikidata ID, DOI, or state:name. Can be repeated.")
+	flag.Var((*stringListFlag)(&filter.Skip), "skip", "Skip papers matching this PMCID, Wikidata ID, DOI, or state:name. Can be repeated.")
+	flag.StringVar(&time_code_value, "timecode", "", "Time code to record on created items (RFC3339, YYYY-MM-DD, or Unix time), defaults to today.")
+	flag.StringVar(&schema_page, "schema", "", "Wiki page describing the server's properties and items, e.g. Data_schema, rather than looking them up by label.")
+	flag.Var(&annotator_specs, "annotator", "Extra annotator to run, as kind:config, e.g. command:/path/to/ner. Can be repeated.")
+	flag.Var(&hook_specs, "hook", "Command to run at a pipeline stage, as when-stage=command, e.g. post-annotated=./check.sh. Can be repeated.")
+	flag.DurationVar(&lookup_timeout, "lookuptimeout", wikibase.DefaultLookupTimeout, "Time allowed for each API lookup, or 0 for no limit.")
+	flag.DurationVar(&upload_timeout, "uploadtimeout", wikibase.DefaultUploadTimeout, "Time allowed for each article page upload, or 0 for no limit.")
+	flag.DurationVar(&write_timeout, "writetimeout", wikibase.DefaultWriteTimeout, "Time allowed for each item or claim write, or 0 for no limit.")
+	flag.IntVar(&failure_threshold, "maxfailures", 10, "Pause writing to the server after this many consecutive failed writes, or 0 to never pause.")
+	flag.DurationVar(&failure_pause, "failurepause", 5*time.Minute, "How long to pause after too many failed writes, or 0 to stop the batch instead.")
+	flag.IntVar(&read_cache_size, "readcache", 64, "Megabytes of entity lookups and searches to remember during the run, or 0 to always ask the server.")
+	flag.Var(&label_languages, "language", "Language to look up property and item labels in, defaults to en. Can be repeated to fall back to other languages.")
+	flag.IntVar(&phrase_size, "phrasesize", annotate.PhraseTargetSize, "Roughly how many bytes of text to record before and after each annotation.")
+	flag.Var(&dictionary_option_specs, "dictionaryoptions", "Matching options for a dictionary, as id=options, e.g. genes=wholewords,minlength=3, or *=options for all dictionaries. Can be repeated.")
+	flag.StringVar(&dictionary_cache_path, "dictionarycache", annotate.DictionaryCacheDirectory, "Directory to keep downloaded dictionaries in, or none to always download them.")
+	flag.StringVar(&hit_summary_path, "hitsummary", "", "File to save a JSON summary of how each dictionary did to, once all papers are processed.")
+	flag.Float64Var(&accept_threshold, "acceptthreshold", 0.0, "Use the closest label search finds on the server if no label matches exactly and it's at least this similar (0 to 1), e.g. 0.9. 0 never does.")
+	flag.BoolVar(&skip_existing, "skipexisting", false, "Skip papers that already have an article item on the server, unless we have a record of creating it.")
+	flag.BoolVar(&force, "force", false, "Upload papers even if pages with the same title or DOI are already on the server, and resume them even if someone else has edited their items.")
+	flag.IntVar(&checkpoint_interval, "checkpointevery", 1, "Save each article's state after creating or populating this many of its items.")
+	flag.IntVar(&item_concurrency, "itemconcurrency", 1, "Number of each article's items to create or populate at once.")
+	flag.BoolVar(&compress_state, "compressstate", false, "Save each article's state gzip compressed.")
+	flag.StringVar(&sciencesource.StateFormat, "stateformat", sciencesource.StateFormatJSON, "Format to save new papers' state in, json or cbor.")
+	flag.StringVar(&sciencesource.NormalisationForm, "normalise", sciencesource.NormalisationNFC, "Unicode normalisation form to put paper text and HTML in before uploading: NFC, NFD, NFKC, NFKD, or none.")
+	flag.StringVar(&sciencesource.ControlCharacters, "controlchars", sciencesource.ControlCharactersStrip, "What to do with control characters in paper text and HTML before uploading: strip, replace, or keep.")
+	flag.BoolVar(&rollback, "rollback", false, "Delete the items created for an article if creating or linking them fails part way. Off by default, as it deletes from the server.")
+	flag.Var(&notify_specs, "notify", "Where to send a notification when the batch finishes, as slack:webhook-url or smtp://user@host:port?from=address&to=addresses. Can be repeated.")
+	flag.IntVar(&notify_threshold, "notifythreshold", 0, "Also notify as soon as this many papers have failed, or 0 to only notify when the batch finishes.")
+	flag.StringVar(&trace_endpoint, "trace", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OpenTelemetry collector to send trace spans for each paper's stages and API calls to, e.g. http://localhost:4318. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT.")
+	flag.Var(&error_reporter_specs, "errorreport", "Where to report papers that fail, with details of the paper and failure, as sentry:DSN. Can be repeated.")
+	flag.StringVar(&rerun_id, "rerun", "", "Only process the papers of this earlier run, ignoring -only and -skip. Used by the rerun command.")
+	flag.BoolVar(&failed_only, "failedonly", false, "With -rerun, only process the papers that failed or weren't finished in that run.")
+	flag.BoolVar(&quiet, "quiet", false, "Only log failures and warnings.")
+	flag.BoolVar(&verbose, "v", false, "Log pipeline detail and each API call.")
+	flag.BoolVar(&very_verbose, "vv", false, "Log full API requests and responses.")
 	flag.Parse()
 
-	log.Printf("Feed to parse: %s", feed_path)
+	logging.SetLogLevel(quiet, verbose, very_verbose)
+	sciencesource.CompressState = compress_state
+	if err := sciencesource.ValidateStateFormat(); err != nil {
+		panic(err)
+	}
+	if err := sciencesource.ValidateNormalisationForm(); err != nil {
+		panic(err)
+	}
+	if err := sciencesource.ValidateControlCharacters(); err != nil {
+		panic(err)
+	}
+	if err := ratelimit.Configure(rate_limits); err != nil {
+		panic(err)
+	}
+	if claim_timeout > 0 {
+		if err := sciencesource.ValidateClaimTimeout(claim_timeout); err != nil {
+			panic(err)
+		}
+	}
+
+	if len(trace_endpoint) > 0 {
+		tracing.Configure(trace_endpoint, "ScienceSourceIngest", sciencesource.Version)
+		defer tracing.Shutdown()
+	}
 
-	feed, err := LoadFeedFromFile(feed_path)
+	if err := filter.Validate(); err != nil {
+		panic(err)
+	}
+
+	var time_code time.Time
+	if len(time_code_value) > 0 {
+		parsed, precision, err := wikibase.ParseTimeInput(time_code_value)
+		if err != nil {
+			panic(err)
+		}
+		if precision != wikibase.TimePrecisionDay {
+			panic(fmt.Errorf("Time code must give a day, not just %s", time_code_value))
+		}
+		time_code = parsed
+	}
+
+	// Interrupting the tool cancels any API calls in flight and stops us starting any more papers, so that
+	// state on disk is left consistent and the run can be resumed later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// With -redis, papers come from a queue rather than the feed
+	var queue, upload_queue *sciencesource.JobQueue
+	var err error
+	if len(redis_url) > 0 {
+		if len(queue_name) == 0 {
+			queue_name = sciencesource.DefaultPreparedQueue
+			if prepare_only {
+				queue_name = sciencesource.DefaultPaperQueue
+			}
+		}
+		// The jobs a worker is working on are kept under its name, so one restarted under the same name
+		// can put back those it was working on when it stopped
+		queue_worker := worker_name
+		if len(queue_worker) == 0 {
+			queue_worker, err = os.Hostname()
+			if err != nil {
+				panic(err)
+			}
+		}
+		queue, err = sciencesource.NewJobQueue(redis_url, queue_name, queue_worker)
+		if err != nil {
+			panic(err)
+		}
+		defer queue.Close()
+		requeued, err := queue.Requeue(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if requeued != 0 {
+			logging.Logf(logging.LogNormal, "Put %d unfinished jobs from an earlier run of %s back on %s", requeued, queue_worker, queue_name)
+		}
+		if len(upload_queue_name) > 0 {
+			upload_queue, err = sciencesource.NewJobQueue(redis_url, upload_queue_name, queue_worker)
+			if err != nil {
+				panic(err)
+			}
+			defer upload_queue.Close()
+		}
+	} else if len(upload_queue_name) > 0 {
+		panic(fmt.Errorf("-uploadqueue needs a -redis server"))
+	}
+
+	var health *healthServer
+	if len(health_addr) > 0 {
+		var health_queue *sciencesource.JobQueue
+		if queue != nil {
+			health_queue, err = sciencesource.NewJobQueue(redis_url, queue_name, queue.Worker)
+			if err != nil {
+				panic(err)
+			}
+			defer health_queue.Close()
+		}
+		health = startHealthServer(ctx, health_addr, health_queue, prepare_only == false, health_stale)
+	}
+
+	var feed sciencesource.PaperFeed
+	switch {
+	case queue != nil && prepare_only:
+		// Papers are taken off the queue one at a time as they are prepared
+	case queue != nil:
+		feed, err = drainPreparedQueue(ctx, queue, queue_wait, target_path)
+	default:
+		logging.Logf(logging.LogVerbose, "Feed to parse: %s", feed_path)
+		feed, err = sciencesource.LoadFeedFromFile(feed_path)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -66,34 +331,263 @@ func main() {
         }
 	}
 
-	// the SPARQL seems to have duplicates in, so let's check
-	library := make(map[string]Paper)
-	for _, paper := range feed.Results.Papers {
-		if _, prs := library[paper.ID()]; prs == true {
-			log.Printf("Found a duplicate paper: %v", paper.ID())
-		} else {
-			library[paper.ID()] = paper
+	var header_template *template.Template
+	if len(header_template_path) > 0 {
+		header_template, err = sciencesource.LoadHeaderTemplate(header_template_path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var media_template *template.Template
+	if len(media_template_path) > 0 {
+		media_template, err = sciencesource.LoadMediaTemplate(media_template_path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Replaying a run means doing the papers it did, rather than the ones the filter picks now
+	if len(rerun_id) > 0 {
+		previous, err := sciencesource.LoadRunRecord(target_path, rerun_id)
+		if err != nil {
+			panic(err)
 		}
+		ids := previous.PaperIDs(failed_only)
+		if len(ids) == 0 {
+			logging.Logf(logging.LogNormal, "No papers from run %s to process", rerun_id)
+			return
+		}
+		filter = sciencesource.PaperFilter{Only: ids}
+	}
+
+	library := loadLibrary(feed, target_path, filter)
+	logging.Logf(logging.LogNormal, "We have %d papers to process", len(library))
+	if len(library) > productionBatchLimit && prepare_only == false {
+		guardProduction(url_base, append([]string{oauth_tokens_path}, extra_accounts...), confirm_production,
+			fmt.Sprintf("ingesting %d papers", len(library)))
 	}
-	log.Printf("We have %d papers to process", len(library))
 
 	// Load the dictionaries of terms we want to create annotations for
-	dictionaries, err := LoadDictionariesFromDirectory(dictionaries_path)
+	dictionaries, err := annotate.LoadDictionariesFromDirectory(dictionaries_path)
 	if err != nil {
 		panic(err)
 	}
-	log.Printf("We have loaded %d dictionaries", len(dictionaries))
+	logging.Logf(logging.LogNormal, "We have loaded %d dictionaries", len(dictionaries))
+	for _, dict := range dictionaries {
+		logging.Logf(logging.LogVerbose, "Dict %s has %d entries", dict.Identifier, len(dict.Entries))
+	}
+
+	if dictionary_cache_path == "none" {
+		dictionary_cache_path = ""
+	}
+	annotate.DictionaryCacheDirectory = dictionary_cache_path
+
+	// Each dictionary can be matched with its own options
+	dictionary_options := make(map[string]annotate.DictionaryOptions)
+	for _, spec := range dictionary_option_specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			panic(fmt.Errorf("Dictionary options must be given as id=options, not %s", spec))
+		}
+		options, err := annotate.ParseDictionaryOptions(parts[1])
+		if err != nil {
+			panic(err)
+		}
+		dictionary_options[parts[0]] = options
+	}
+
+	sources := paperSources(source_names)
+
+	switch retractions {
+	case "", sciencesource.RetractionsRefuse, sciencesource.RetractionsFlag:
+	default:
+		panic(fmt.Errorf("Retractions must be refuse or flag, not %s", retractions))
+	}
+
+	var journal_check *sciencesource.JournalCheck
+	if check_doaj || len(issn_allowlist_path) > 0 {
+		journal_check = &sciencesource.JournalCheck{DOAJ: check_doaj}
+		if len(issn_allowlist_path) > 0 {
+			journal_check.Allowlist, err = sciencesource.LoadISSNAllowlist(issn_allowlist_path)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Dictionaries are just one kind of annotator, so gather them up with any others asked for
+	annotators := make([]annotate.Annotator, 0, len(dictionaries)+len(annotator_specs))
 	for _, dict := range dictionaries {
-		log.Printf("Dict %s has %d entries", dict.Identifier, len(dict.Entries))
+		annotators = append(annotators, annotate.ApplyDictionaryOptions(dict, dictionary_options))
+	}
+	for _, spec := range annotator_specs {
+		annotator, err := annotate.NewAnnotator(spec)
+		if err != nil {
+			panic(err)
+		}
+		annotators = append(annotators, annotate.ApplyDictionaryOptions(annotator, dictionary_options))
+	}
+
+	// Each annotation records which dictionary found it, so they need telling apart
+	names := make(map[string]bool)
+	for _, annotator := range annotators {
+		if names[annotator.Name()] {
+			panic(fmt.Errorf("More than one dictionary or annotator is called %s", annotator.Name()))
+		}
+		names[annotator.Name()] = true
+	}
+
+	hooks := make(sciencesource.Hooks)
+	for _, spec := range hook_specs {
+		err := hooks.Add(spec)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// The confirmer is also used to check near miss labels when we look up the schema, and to ask
+	// whether to carry on after the canary papers, but only asks about each paper if interactive
+	var confirmer, paper_confirmer *sciencesource.Confirmer
+	if interactive || canary > 0 {
+		confirmer = sciencesource.NewConfirmer(url_base, os.Stdin, os.Stdout)
+	}
+	if interactive {
+		paper_confirmer = confirmer
+	}
+
+	var claims sciencesource.ClaimStore
+	if claim_timeout > 0 && len(claim_store_location) != 0 {
+		claim_store, err := sciencesource.OpenClaimStore(claim_store_location)
+		if err != nil {
+			panic(err)
+		}
+		defer claim_store.Close()
+		claims = claim_store
+	}
+
+	newProcessor := func(paper sciencesource.Paper) sciencesource.PaperProcessor {
+		return sciencesource.PaperProcessor{
+			Paper:              paper,
+			TargetDirectory:    target_path,
+			XSLTProcPath:       xslt_proc_path,
+			Categories:         categories,
+			HeaderTemplate:     header_template,
+			Confirmer:          paper_confirmer,
+			Hooks:              hooks,
+			TimeCode:           time_code,
+			PhraseSize:         phrase_size,
+			UploadMedia:        upload_media,
+			MediaTemplate:      media_template,
+			Sources:            sources,
+			JournalCheck:       journal_check,
+			RetractionCheck:    retractions,
+			MeSH:               mesh,
+			Funding:            funding,
+			Affiliations:       affiliations,
+			AbstractOnly:       abstract_only,
+			Keywords:           keywords,
+			MainSubjectMinimum: main_subject_minimum,
+			Claims:             claims,
+		}
+	}
+
+	// Preparing papers for uploading elsewhere doesn't need the server at all
+	if prepare_only {
+		failed := preparePapers(ctx, orderedPapers(feed, library), queue, queue_wait, upload_queue, newProcessor, annotators)
+		if failed != 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
 	// Connect to Science Source instance and get any information we need
-	oauthInfo, load_err := wikibase.LoadOauthInformation(oauth_tokens_path)
-	if load_err != nil {
-		panic(load_err)
+	sciSourceClient := connectToServer(append([]string{oauth_tokens_path}, extra_accounts...), url_base,
+		wikibase.NetworkOptions{
+			CompressRequests: compress_requests,
+			Assert:           assert_user,
+			LookupTimeout:    lookup_timeout,
+			UploadTimeout:    upload_timeout,
+			WriteTimeout:     write_timeout,
+			FailureThreshold: failure_threshold,
+			FailurePause:     failure_pause,
+			ReadCacheBytes:   read_cache_size * 1024 * 1024,
+			AccountWriteRate: account_write_rate,
+			LabelLanguages:   label_languages,
+			WriteLogPath:     path.Join(target_path, sciencesource.WriteLogFileName),
+		})
+	sciSourceClient.RefreshPages = refresh_pages
+	sciSourceClient.VerifyClaims = verify_claims
+	sciSourceClient.SchemaPage = schema_page
+	sciSourceClient.ProtectionLevel = protection_level
+	sciSourceClient.Watchlist, err = wikibase.ParseWatchlistAction(watchlist)
+	if err != nil {
+		panic(err)
+	}
+	sciSourceClient.CreateTalkPages = create_talk_pages
+	sciSourceClient.RollbackOnFailure = rollback
+	sciSourceClient.CheckpointInterval = checkpoint_interval
+	sciSourceClient.ItemConcurrency = item_concurrency
+	sciSourceClient.SkipExisting = skip_existing
+	sciSourceClient.Force = force
+	sciSourceClient.LabelAcceptThreshold = accept_threshold
+	if len(item_terms_path) > 0 {
+		sciSourceClient.ItemTerms, err = sciencesource.LoadItemTermsConfig(item_terms_path)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if describe_items {
+		sciSourceClient.ItemTerms = sciSourceClient.ItemTerms.WithDefaults()
+	}
+	if health != nil {
+		health.setClient(sciSourceClient)
+	}
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+	if len(worker_name) == 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		worker_name = fmt.Sprintf("%s/%s", host, sciSourceClient.RunID)
+	}
+	paper_ids := make([]string, 0, len(library))
+	for id := range library {
+		paper_ids = append(paper_ids, id)
 	}
-	sciSourceClient := NewScienceSourceClient(oauthInfo, url_base)
-	err = sciSourceClient.GetConfigurationFromServer()
+	run_record, err := sciencesource.NewRunRecord(target_path, sciSourceClient.RunID, os.Args[1:], paper_ids)
+	if err != nil {
+		panic(err)
+	}
+	err = run_record.Save()
+	if err != nil {
+		panic(err)
+	}
+	notifications := &sciencesource.BatchNotifications{
+		RunID:            sciSourceClient.RunID,
+		FailureThreshold: notify_threshold,
+	}
+	for _, spec := range notify_specs {
+		notifier, err := sciencesource.NewNotifier(spec)
+		if err != nil {
+			panic(err)
+		}
+		notifications.Notifiers = append(notifications.Notifiers, notifier)
+	}
+	error_reporters := make([]sciencesource.ErrorReporter, 0, len(error_reporter_specs))
+	for _, spec := range error_reporter_specs {
+		reporter, err := sciencesource.NewErrorReporter(spec)
+		if err != nil {
+			panic(err)
+		}
+		error_reporters = append(error_reporters, reporter)
+	}
+	if interactive {
+		sciSourceClient.LabelConfirmer = confirmer
+	}
+
+	err = sciSourceClient.GetConfigurationFromServer(ctx)
 	if err != nil {
 		panic(err)
 	}
@@ -104,10 +598,38 @@ func main() {
 	// easy to read the code here, so I've chosen to use both mechanisms for
 	// the sake of code clarity
 	var wg sync.WaitGroup
+	var stop_err error
 	sem := make(chan bool, concurrencyLimit)
-	for _, paper := range library {
+	ordered := orderedPapers(feed, library)
+	for i, paper := range ordered {
 		to_process := paper
+
+		// Canary papers have to be finished and looked at on the wiki before the rest are started
+		if canary > 0 && i == canary {
+			wg.Wait()
+			canaries := make([]sciencesource.PaperProcessor, 0, canary)
+			for _, done := range ordered[:canary] {
+				canaries = append(canaries, sciencesource.PaperProcessor{Paper: done, TargetDirectory: target_path})
+			}
+			carry_on, err := confirmer.ConfirmCanary(canaries, len(ordered)-canary)
+			if err == nil && carry_on == false {
+				err = fmt.Errorf("Stopped after the %d canary papers", canary)
+			}
+			if err != nil {
+				log.Printf("Stopping before all papers were processed: %v", err)
+				stop_err = err
+				break
+			}
+		}
+
 		sem <- true
+		// If the server has been failing then this waits for it to have a rest before we carry on
+		if err := sciSourceClient.Network().WaitForServer(ctx); err != nil {
+			<-sem
+			log.Printf("Stopping before all papers were processed: %v", err)
+			stop_err = err
+			break
+		}
 		wg.Add(1)
 
 		go func() {
@@ -115,18 +637,56 @@ func main() {
 				wg.Done()
 				<-sem
 			}()
-			log.Printf("Process paper %s", to_process.ID())
+			logging.Logf(logging.LogNormal, "Process paper %s", to_process.ID())
 
-			var processor = PaperProcessor{
-				Paper:           to_process,
-				TargetDirectory: target_path,
-				XSLTProcPath:    xslt_proc_path,
+			processor := newProcessor(to_process)
+			var err error
+			if claim_timeout > 0 {
+				err = processor.WithClaim(ctx, worker_name, claim_timeout, func(ctx context.Context) error {
+					return processor.ProcessPaperSafely(ctx, annotators, sciSourceClient)
+				})
+			} else {
+				err = processor.ProcessPaperSafely(ctx, annotators, sciSourceClient)
+			}
+			// Another worker has it, so it's up to them how it goes
+			if claimed, ok := err.(sciencesource.ClaimedElsewhereError); ok {
+				logging.Logf(logging.LogNormal, "Skipping paper %s, claimed by %s", to_process.ID(), claimed.Worker)
+				return
 			}
-			err := processor.ProcessPaper(dictionaries, sciSourceClient)
 			if err != nil {
 				log.Printf("Failed to process paper %s: %v", to_process.ID(), err)
+
+				// Being interrupted isn't a failure worth reporting
+				if ctx.Err() == nil {
+					sciencesource.ReportError(error_reporters, processor.NewErrorReport(sciSourceClient.RunID, url_base, err))
+				}
 			}
+			if record_err := run_record.SetOutcome(to_process.ID(), err); record_err != nil {
+				log.Printf("Failed to record outcome of paper %s: %v", to_process.ID(), record_err)
+			}
+			notifications.PaperDone(to_process.ID(), err)
 		}()
 	}
 	wg.Wait()
+	sciSourceClient.Network().LogReadCacheStats()
+	sciSourceClient.Network().LogAccountStats()
+	if err := run_record.Finish(); err != nil {
+		log.Printf("Failed to save run record: %v", err)
+	}
+	processors := make([]sciencesource.PaperProcessor, 0, len(library))
+	for _, paper := range library {
+		processors = append(processors, sciencesource.PaperProcessor{Paper: paper, TargetDirectory: target_path})
+	}
+	if err := sciSourceClient.SaveRunProvenance(run_record, processors); err != nil {
+		log.Printf("Failed to save run provenance: %v", err)
+	}
+	notifications.BatchDone(stop_err)
+
+	// Let dictionary maintainers know how their dictionaries did
+	if len(hit_summary_path) > 0 {
+		err := collectStats(processors).SaveHitSummary(hit_summary_path, dictionaries)
+		if err != nil {
+			log.Printf("Failed to save dictionary hit summary: %v", err)
+		}
+	}
 }
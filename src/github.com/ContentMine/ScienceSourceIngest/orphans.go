@@ -0,0 +1,79 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The orphans command lists items on the server that belong to an article but aren't part of its anchor
+// chain, so someone can review them. It only reads from the server.
+
+func runOrphans(args []string) {
+
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	var options commandFlags
+	options.register(flags)
+	options.registerServer(flags)
+	flags.Parse(args)
+
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: "none"})
+
+	found := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAPER\tARTICLE\tITEM\tKIND\tREASON")
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			w.Flush()
+			log.Printf("Stopping before all papers were checked: %v", err)
+			os.Exit(1)
+		}
+
+		// We can only look for orphans of articles we know the item for
+		article, err := processor.Article()
+		if err != nil || len(article.ID) == 0 {
+			continue
+		}
+
+		orphans, err := sciSourceClient.FindOrphans(ctx, string(article.ID))
+		if err != nil {
+			log.Printf("Failed to check paper %s for orphans: %v", processor.Paper.ID(), err)
+			continue
+		}
+		for _, orphan := range orphans {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", processor.Paper.ID(), article.ID, orphan.ID, orphan.Kind, orphan.Reason)
+		}
+		found += len(orphans)
+	}
+	w.Flush()
+
+	if found != 0 {
+		os.Exit(1)
+	}
+}
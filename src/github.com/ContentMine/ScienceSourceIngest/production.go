@@ -0,0 +1,85 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The same tool is pointed at staging servers and the live ScienceSource wiki, often with nothing but
+// -urlbase and -oauth to tell the runs apart, so anything that deletes, rewrites, or makes a lot of edits
+// checks first whether it is about to do so on production. If it is, the operator has to say so with
+// -yes-i-mean-production, or the credentials file has to opt in with "allow_production".
+
+// The live wiki is always production, whatever its credentials file says
+var productionHosts = []string{"sciencesource.wmflabs.org"}
+
+// Ingesting more papers than this in one run counts as a mass edit
+const productionBatchLimit int = 20
+
+const productionFlagName string = "yes-i-mean-production"
+
+// isProductionTarget says whether the server is production, and whether all the accounts being used
+// have opted in to writing to it.
+func isProductionTarget(url_base string, oauth_tokens_paths []string) (bool, bool) {
+
+	production := false
+	server, err := url.Parse(url_base)
+	if err != nil {
+		panic(err)
+	}
+	for _, host := range productionHosts {
+		if server.Hostname() == host {
+			production = true
+		}
+	}
+
+	allowed := len(oauth_tokens_paths) > 0
+	for _, oauth_tokens_path := range oauth_tokens_paths {
+		credentials, err := wikibase.LoadCredentials(oauth_tokens_path)
+		if err != nil {
+			panic(err)
+		}
+		tokens := credentials.Tokens()
+		if tokens.Environment == wikibase.EnvironmentProduction {
+			production = true
+		}
+		if tokens.AllowProduction == false {
+			allowed = false
+		}
+	}
+	return production, allowed
+}
+
+// guardProduction stops the program if the operation is about to be run against production without
+// the operator having confirmed it.
+func guardProduction(url_base string, oauth_tokens_paths []string, confirmed bool, operation string) {
+
+	production, allowed := isProductionTarget(url_base, oauth_tokens_paths)
+	if production == false || confirmed || allowed {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s is a production server, so %s needs -%s to confirm it\n", url_base, operation, productionFlagName)
+	os.Exit(2)
+}
+
+// guardProduction checks a command's server, for commands that delete or rewrite things on it.
+func (f *commandFlags) guardProduction(operation string) {
+	guardProduction(f.urlBase, []string{f.oauthTokensPath}, f.confirmProduction, operation)
+}
@@ -0,0 +1,125 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The props command shows how the properties and items in the data schema map onto the server, resolved
+// the same way ingest would resolve them but without creating anything, so an operator can check a new
+// instance is set up as expected before uploading to it. It exits with 1 if anything is missing or
+// doesn't match.
+
+type propsOutput struct {
+	URL        string            `json:"url"`
+	Properties map[string]string `json:"properties"`
+	Items      map[string]string `json:"items"`
+	Problems   map[string]string `json:"problems,omitempty"`
+}
+
+func runProps(args []string) {
+
+	flags := flag.NewFlagSet("props", flag.ExitOnError)
+	var options commandFlags
+	var as_json bool
+	flags.BoolVar(&options.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&options.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&options.veryVerbose, "vv", false, "Log full API requests and responses.")
+	flags.BoolVar(&as_json, "json", false, "Print the mapping as JSON rather than a table.")
+	options.registerServer(flags)
+	flags.Parse(args)
+
+	logging.SetLogLevel(options.quiet, options.verbose, options.veryVerbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// We want to list whatever did resolve, so failures here are only warned about, and show up in the
+	// entries below anyway
+	sciSourceClient := options.dial(wikibase.NetworkOptions{Assert: "none"})
+	if err := options.resolveSchema(ctx, sciSourceClient); err != nil {
+		log.Printf("%v", err)
+	}
+
+	entries, err := sciSourceClient.SchemaEntries(ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	problems := 0
+	for _, entry := range entries {
+		if len(entry.Problem) != 0 {
+			problems += 1
+		}
+	}
+
+	if as_json {
+		output := propsOutput{
+			URL:        options.urlBase,
+			Properties: make(map[string]string),
+			Items:      make(map[string]string),
+			Problems:   make(map[string]string),
+		}
+		for _, entry := range entries {
+			if entry.Kind == "property" {
+				output.Properties[entry.Label] = entry.ID
+			} else {
+				output.Items[entry.Label] = entry.ID
+			}
+			if len(entry.Problem) != 0 {
+				output.Problems[entry.Label] = entry.Problem
+			}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(output)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tLABEL\tID\tDATA TYPE\tPROBLEM")
+		for _, entry := range entries {
+			id, data_type, problem := entry.ID, entry.ServerDataType, entry.Problem
+			if len(id) == 0 {
+				id = "-"
+			}
+			if len(data_type) == 0 {
+				data_type = "-"
+			}
+			if len(problem) == 0 {
+				problem = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Kind, entry.Label, id, data_type, problem)
+		}
+		w.Flush()
+	}
+
+	if problems != 0 {
+		os.Exit(1)
+	}
+}
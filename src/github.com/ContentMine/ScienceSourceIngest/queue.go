@@ -0,0 +1,199 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+)
+
+// Splitting ingest over machines with work queues: the enqueue command puts papers from the feed on a
+// queue, ingest -prepareonly takes them off and passes them on prepared, and a plain ingest with -redis
+// takes the prepared papers and uploads them.
+
+func runEnqueue(args []string) {
+
+	flags := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	var options commandFlags
+	var redis_url, queue_name string
+	var requeue bool
+	options.register(flags)
+	flags.StringVar(&redis_url, "redis", "", "Redis server to queue papers on, as redis://[:password@]host:port[/db], required.")
+	flags.StringVar(&queue_name, "queue", sciencesource.DefaultPaperQueue, "Queue to put the papers on.")
+	flags.BoolVar(&requeue, "requeue", false, "Rather than queue papers from the feed, put back on the queue the jobs workers took off it but never finished, for when those workers have stopped for good.")
+	flags.Parse(args)
+
+	if len(redis_url) == 0 {
+		panic(fmt.Errorf("The enqueue command needs a -redis server"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	queue, err := sciencesource.NewJobQueue(redis_url, queue_name, "")
+	if err != nil {
+		panic(err)
+	}
+	defer queue.Close()
+
+	if requeue {
+		count, err := queue.RequeueAll(ctx)
+		if err != nil {
+			log.Printf("Failed to requeue unfinished jobs: %v", err)
+			os.Exit(1)
+		}
+		logging.Logf(logging.LogNormal, "Put %d unfinished jobs back on %s", count, queue_name)
+		return
+	}
+
+	processors := options.processors()
+
+	for _, processor := range processors {
+		err := queue.Push(ctx, sciencesource.QueueJob{Paper: processor.Paper})
+		if err != nil {
+			log.Printf("Failed to queue paper %s: %v", processor.Paper.ID(), err)
+			os.Exit(1)
+		}
+		fmt.Println(processor.Paper.ID())
+	}
+	logging.Logf(logging.LogNormal, "Queued %d papers on %s", len(processors), queue_name)
+}
+
+// preparePapers fetches, converts, and annotates the papers, or if there is a queue the papers taken off
+// it, putting each one on the upload queue if there is one. Returns the number that failed.
+func preparePapers(ctx context.Context, papers []sciencesource.Paper, queue *sciencesource.JobQueue, wait time.Duration,
+	upload_queue *sciencesource.JobQueue, newProcessor func(sciencesource.Paper) sciencesource.PaperProcessor,
+	annotators []annotate.Annotator) int {
+
+	next := func() (*sciencesource.QueueJob, error) {
+		if queue != nil {
+			return queue.Pop(ctx, wait)
+		}
+		if len(papers) == 0 || ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		job := &sciencesource.QueueJob{Paper: papers[0]}
+		papers = papers[1:]
+		return job, nil
+	}
+
+	failed := 0
+	for {
+		job, err := next()
+		if err != nil {
+			log.Printf("Stopping before all papers were prepared: %v", err)
+			return failed + 1
+		}
+		if job == nil {
+			return failed
+		}
+
+		processor := newProcessor(job.Paper)
+		logging.Logf(logging.LogNormal, "Prepare paper %s", processor.Paper.ID())
+		err = processor.PreparePaper(ctx, annotators)
+		if err == nil && upload_queue != nil {
+			var prepared sciencesource.QueueJob
+			prepared, err = processor.PreparedJob()
+			if err == nil {
+				err = upload_queue.Push(ctx, prepared)
+			}
+		}
+		if err == nil && queue != nil {
+			if queue_err := queue.Done(ctx, *job); queue_err != nil {
+				log.Printf("Failed to mark paper %s done on the queue: %v", processor.Paper.ID(), queue_err)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to prepare paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+			if queue != nil && ctx.Err() == nil {
+				if queue_err := queue.Failed(ctx, *job); queue_err != nil {
+					log.Printf("Failed to queue paper %s as failed: %v", processor.Paper.ID(), queue_err)
+				}
+			}
+		}
+	}
+}
+
+// drainPreparedQueue takes prepared papers off the queue until it has been empty for the wait, unpacking
+// each into the output directory, and returns them as a feed. As the papers are then taken off the queue
+// for good, the feed is also saved in the output directory, so a run that is stopped part way can be
+// finished by giving it as the -feed.
+func drainPreparedQueue(ctx context.Context, queue *sciencesource.JobQueue, wait time.Duration, target_path string) (sciencesource.PaperFeed, error) {
+
+	var feed sciencesource.PaperFeed
+	if wait == 0 {
+		return feed, fmt.Errorf("Uploading from a queue needs a -queuewait, so it knows when the batch is done")
+	}
+	// Even if we're stopped part way, what has been taken off the queue needs saving
+	var pop_err error
+	taken := make([]sciencesource.QueueJob, 0)
+	for {
+		job, err := queue.Pop(ctx, wait)
+		if err != nil {
+			pop_err = err
+			break
+		}
+		if job == nil {
+			break
+		}
+		processor := sciencesource.PaperProcessor{Paper: job.Paper, TargetDirectory: target_path}
+		err = processor.UnpackJob(*job)
+		if err != nil {
+			log.Printf("Failed to unpack paper %s: %v", job.Paper.ID(), err)
+			if queue_err := queue.Failed(ctx, *job); queue_err != nil {
+				log.Printf("Failed to queue paper %s as failed: %v", job.Paper.ID(), queue_err)
+			}
+			continue
+		}
+		feed.Results.Papers = append(feed.Results.Papers, job.Paper)
+		taken = append(taken, *job)
+	}
+	if len(feed.Results.Papers) == 0 {
+		return feed, pop_err
+	}
+
+	filename := path.Join(target_path, fmt.Sprintf("queued-%s.json", time.Now().UTC().Format("20060102T150405Z")))
+	f, err := os.Create(filename)
+	if err != nil {
+		return feed, err
+	}
+	defer f.Close()
+	err = json.NewEncoder(f).Encode(feed)
+	if err != nil {
+		return feed, err
+	}
+	logging.Logf(logging.LogNormal, "Took %d papers off queue %s, saved as feed %s", len(feed.Results.Papers), queue.Name, filename)
+
+	// Only now the feed is saved are they safe to forget, even if we're being stopped
+	for _, job := range taken {
+		if err := queue.Done(context.Background(), job); err != nil {
+			log.Printf("Failed to mark paper %s done on the queue: %v", job.Paper.ID(), err)
+		}
+	}
+	return feed, pop_err
+}
@@ -0,0 +1,191 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package ratelimit spaces out requests to each upstream service, so that a big batch of papers
+// processed in parallel doesn't make more requests a second than a provider allows and get the tool's
+// IP address banned.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests are grouped by source, worked out from the host they go to. Every request to a source waits
+// its turn on the one limiter for that source, however many papers are being processed at once. Hosts
+// we don't know are their own source, named by the host, and requests to the wiki we upload to are the
+// wiki source, whatever its host.
+
+const Wiki string = "wiki"
+
+// Which hosts belong to each source we know about
+var sourceHosts = map[string][]string{
+	"europepmc":       {"europepmc.org", "www.ebi.ac.uk"},
+	"crossref":        {"api.crossref.org"},
+	"unpaywall":       {"api.unpaywall.org"},
+	"ncbi":            {"www.ncbi.nlm.nih.gov", "eutils.ncbi.nlm.nih.gov"},
+	"wikidata":        {"www.wikidata.org", "query.wikidata.org"},
+	"opencitations":   {"opencitations.net"},
+	"semanticscholar": {"api.semanticscholar.org"},
+	"core":            {"api.core.ac.uk", "core.ac.uk"},
+	"doaj":            {"doaj.org"},
+	"ror":             {"api.ror.org"},
+	"wayback":         {"archive.org", "web.archive.org"},
+	"fatcat":          {"api.fatcat.wiki"},
+	"github":          {"api.github.com", "raw.githubusercontent.com"},
+}
+
+// Limits that apply unless the operator says otherwise, from the providers' own documentation: NCBI's
+// E-utilities allow three requests a second without an API key, and Semantic Scholar one.
+var defaultRates = map[string]float64{
+	"ncbi":            3,
+	"semanticscholar": 1,
+}
+
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+var (
+	lock     sync.Mutex
+	limiters = make(map[string]*limiter)
+)
+
+func init() {
+	for source, rate := range defaultRates {
+		SetRate(source, rate)
+	}
+}
+
+// Sources lists the names of the sources we know the hosts of.
+func Sources() []string {
+	res := make([]string, 0, len(sourceHosts))
+	for source := range sourceHosts {
+		res = append(res, source)
+	}
+	res = append(res, Wiki)
+	sort.Strings(res)
+	return res
+}
+
+// SetRate limits the source to the given number of requests a second, or removes its limit if rate is
+// zero.
+func SetRate(source string, rate float64) {
+	lock.Lock()
+	defer lock.Unlock()
+	if rate <= 0 {
+		delete(limiters, source)
+		return
+	}
+	limiters[source] = &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Configure sets the limits from specs of the form source=rate, where source is one of Sources or a
+// host name, and rate is in requests a second. Nothing is set unless every spec is valid.
+func Configure(specs []string) error {
+	rates := make(map[string]float64, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return fmt.Errorf("Rate limit must be given as source=requests per second, not %q", spec)
+		}
+		source := strings.ToLower(parts[0])
+		if !knownSource(source) {
+			return fmt.Errorf("Unknown rate limit source %q, expected a host name or one of %s", parts[0],
+				strings.Join(Sources(), ", "))
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("Rate limit for %s must be a number of requests per second, not %q", parts[0], parts[1])
+		}
+		// A host that belongs to a source we know is limited along with the rest of that source
+		rates[SourceForHost(source)] = rate
+	}
+	for source, rate := range rates {
+		SetRate(source, rate)
+	}
+	return nil
+}
+
+// knownSource says if the source is one we know the hosts of, or looks like a host name. Any other name
+// is most likely a misspelling of a source, which would otherwise quietly have no effect.
+func knownSource(source string) bool {
+	if _, ok := sourceHosts[source]; ok || source == Wiki {
+		return true
+	}
+	return strings.Contains(source, ".")
+}
+
+// SourceForHost names the source the host belongs to, which is the host itself if it isn't one we know.
+func SourceForHost(host string) string {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for source, hosts := range sourceHosts {
+		for _, known := range hosts {
+			if host == known {
+				return source
+			}
+		}
+	}
+	return host
+}
+
+// Wait blocks until the source's limit allows another request, or the context is done.
+func Wait(ctx context.Context, source string) error {
+
+	lock.Lock()
+	l, ok := limiters[source]
+	if !ok {
+		lock.Unlock()
+		return nil
+	}
+	// Each caller takes the next free slot, so callers queue up in the order they arrived
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	lock.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// WaitForURL waits for the limit of the source the URL's host belongs to.
+func WaitForURL(ctx context.Context, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	return Wait(ctx, SourceForHost(u.Host))
+}
@@ -0,0 +1,93 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The repair command puts the anchor chains of articles on the server back in order.
+
+func runRepair(args []string) {
+
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	var options commandFlags
+	var dry_run bool
+	var assert_user string
+	var force bool
+	options.register(flags)
+	options.registerServer(flags)
+	flags.BoolVar(&dry_run, "dryrun", false, "Only list the links that need fixing, rather than fixing them.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flags.BoolVar(&force, "force", false, "Carry on fixing links even if someone else has edited the article's page or items since we last did.")
+	flags.Parse(args)
+
+	if dry_run == false {
+		options.guardProduction("repair")
+	}
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	sciSourceClient.Force = force
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all papers were repaired: %v", err)
+			os.Exit(1)
+		}
+
+		article, err := processor.Article()
+		if err != nil || len(article.ID) == 0 {
+			continue
+		}
+
+		fixes, err := sciSourceClient.RepairAnchorChain(ctx, article, dry_run)
+		for _, fix := range fixes {
+			fmt.Printf("%s\t%v\n", processor.Paper.ID(), fix)
+		}
+		if dry_run == false && len(fixes) != 0 {
+			sciSourceClient.RecordRevisions(article)
+			if save_err := processor.SaveArticle(article); save_err != nil {
+				log.Printf("Failed to save paper record for %s: %v", processor.Paper.ID(), save_err)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to repair paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+		logging.Logf(logging.LogVerbose, "Paper %s needed %d links fixing", processor.Paper.ID(), len(fixes))
+	}
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
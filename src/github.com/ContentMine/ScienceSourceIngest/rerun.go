@@ -0,0 +1,76 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+)
+
+// The rerun command replays an earlier ingest run with the same arguments and papers. It runs the tool
+// again as a new process, from the directory the original run was started in, so relative paths in the
+// arguments still work.
+
+func runRerun(args []string) {
+
+	flags := flag.NewFlagSet("rerun", flag.ExitOnError)
+	var target_path string
+	var failed_only bool
+	flags.StringVar(&target_path, "output", ".", "Directory the run's results were stored in.")
+	flags.BoolVar(&failed_only, "failed", false, "Only rerun papers that failed or weren't finished.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s rerun [flags] [run id]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	record, err := sciencesource.LoadRunRecord(target_path, flags.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+
+	// Later flags win, so these override any from the original run if it was itself a rerun
+	rerun_args := append([]string{}, record.Args...)
+	rerun_args = append(rerun_args, "-rerun", record.ID, fmt.Sprintf("-failedonly=%v", failed_only))
+
+	executable, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	logging.Logf(logging.LogNormal, "Replaying run %s from %s", record.ID, record.Directory)
+
+	cmd := exec.Command(executable, rerun_args...)
+	cmd.Dir = record.Directory
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if exit_err, ok := err.(*exec.ExitError); ok {
+		os.Exit(exit_err.ExitCode())
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,100 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The retractions command checks finished articles for retractions and expressions of concern that
+// have appeared since they were ingested, and adds a statement to the article item for each new one.
+
+func runRetractions(args []string) {
+
+	flags := flag.NewFlagSet("retractions", flag.ExitOnError)
+	var options commandFlags
+	var dry_run bool
+	var assert_user string
+	options.register(flags)
+	options.registerServer(flags)
+	flags.BoolVar(&dry_run, "dryrun", false, "Only list the notices found, rather than recording them.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flags.Parse(args)
+
+	if dry_run == false {
+		options.guardProduction("retractions")
+	}
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all papers were checked: %v", err)
+			os.Exit(1)
+		}
+
+		article, err := processor.Article()
+		if err != nil || article.Complete == false || len(processor.Paper.DOI.Value) == 0 {
+			continue
+		}
+
+		notices, err := sciencesource.FetchEditorialNotices(ctx, sciencesource.NormaliseDOI(processor.Paper.DOI.Value))
+		if err != nil {
+			log.Printf("Failed to check paper %s for retraction: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+		for _, notice := range article.AddNotices(notices) {
+			fmt.Printf("%s\t%s\t%s\n", processor.Paper.ID(), notice.Type, notice.DOI)
+		}
+		if dry_run {
+			continue
+		}
+
+		err = sciSourceClient.RecordNotices(ctx, article, func() error {
+			return processor.SaveArticle(article)
+		})
+		if err != nil {
+			log.Printf("Failed to record notices for paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+		}
+		sciSourceClient.RecordRevisions(article)
+		if save_err := processor.SaveArticle(article); save_err != nil {
+			log.Printf("Failed to save paper record for %s: %v", processor.Paper.ID(), save_err)
+			failed += 1
+		}
+	}
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
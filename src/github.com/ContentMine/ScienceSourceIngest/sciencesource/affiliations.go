@@ -0,0 +1,259 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// To look at the corpus by institution we need to know where each author worked, in a form that can be
+// compared between papers, which the free text affiliations in papers aren't. ROR (the Research
+// Organization Registry) can match affiliation text to an organisation, which also gives us the
+// organisation's Wikidata item. Each author gets an "author name string" statement on the article item,
+// with their place in the author list and their affiliations as qualifiers, linking to an item for the
+// organisation where ROR knows it.
+// See https://ror.readme.io/docs/match-organization-affiliations
+
+const rorAffiliationURL string = "https://api.ror.org/v2/organizations?affiliation=%s"
+
+// The property on article items for authors, and the qualifiers on it
+const (
+	authorNameProperty          string = "author name string"
+	seriesOrdinalProperty       string = "series ordinal"
+	affiliationStringProperty   string = "affiliation string"
+	affiliationRORProperty      string = "affiliation ROR ID"
+	affiliationWikidataProperty string = "affiliation Wikidata item code"
+)
+
+type ArticleAffiliation struct {
+	Text             string `json:"text"`
+	ROR              string `json:"ror,omitempty"` // The ROR ID, without the https://ror.org/ prefix
+	Name             string `json:"name,omitempty"`
+	WikiDataItemCode string `json:"wikidata,omitempty"`
+	Item             string `json:"item,omitempty"` // The organisation's item on the server
+}
+
+// An author of the article, and how far through recording them we are
+type ArticleAuthor struct {
+	Name         string               `json:"name"`
+	Affiliations []ArticleAffiliation `json:"affiliations,omitempty"`
+	Claim        string               `json:"claim,omitempty"`
+	Qualifiers   []string             `json:"qualifiers,omitempty"` // Those already added to the claim
+}
+
+type rorAffiliationResponse struct {
+	Items []struct {
+		Chosen       bool `json:"chosen"`
+		Organization struct {
+			ID    string `json:"id"`
+			Names []struct {
+				Value string   `json:"value"`
+				Types []string `json:"types"`
+			} `json:"names"`
+			ExternalIDs []struct {
+				Type      string   `json:"type"`
+				All       []string `json:"all"`
+				Preferred string   `json:"preferred"`
+			} `json:"external_ids"`
+		} `json:"organization"`
+	} `json:"items"`
+}
+
+// Many authors on a paper, and many papers, share affiliations, so remember what ROR told us
+var rorCacheLock sync.Mutex
+var rorCache = make(map[string]ArticleAffiliation)
+
+// MatchAffiliation asks ROR which organisation the affiliation text is for. If ROR isn't confident of
+// a match then only the text is returned.
+func MatchAffiliation(ctx context.Context, text string) (ArticleAffiliation, error) {
+
+	rorCacheLock.Lock()
+	cached, ok := rorCache[text]
+	rorCacheLock.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var response rorAffiliationResponse
+	err := getJSON(ctx, fmt.Sprintf(rorAffiliationURL, url.QueryEscape(text)), nil, &response)
+	if err != nil {
+		return ArticleAffiliation{}, err
+	}
+
+	res := ArticleAffiliation{Text: text}
+	for _, item := range response.Items {
+		if item.Chosen == false {
+			continue
+		}
+		organization := item.Organization
+		res.ROR = strings.TrimPrefix(organization.ID, "https://ror.org/")
+		for _, name := range organization.Names {
+			for _, kind := range name.Types {
+				if kind == "ror_display" {
+					res.Name = name.Value
+				}
+			}
+		}
+		for _, id := range organization.ExternalIDs {
+			if id.Type != "wikidata" {
+				continue
+			}
+			res.WikiDataItemCode = id.Preferred
+			if len(res.WikiDataItemCode) == 0 && len(id.All) > 0 {
+				res.WikiDataItemCode = id.All[0]
+			}
+		}
+		break
+	}
+
+	rorCacheLock.Lock()
+	rorCache[text] = res
+	rorCacheLock.Unlock()
+	return res, nil
+}
+
+// FindAuthorAffiliations matches the affiliations of each of the paper's authors to organisations.
+func FindAuthorAffiliations(ctx context.Context, authors []convert.PaperAuthor) ([]ArticleAuthor, error) {
+
+	res := make([]ArticleAuthor, len(authors))
+	for i, author := range authors {
+		res[i].Name = strings.TrimSpace(author.Name.GivenNames + " " + author.Name.Surname)
+		for _, text := range author.Affiliations {
+			affiliation, err := MatchAffiliation(ctx, text)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to match affiliation %q: %v", text, err)
+			}
+			if len(affiliation.ROR) == 0 {
+				logging.Logf(logging.LogVerbose, "No ROR match for affiliation %q", text)
+			}
+			res[i].Affiliations = append(res[i].Affiliations, affiliation)
+		}
+	}
+	return res, nil
+}
+
+type authorQualifier struct {
+	property string
+	dataType string
+	value    string
+}
+
+func (q authorQualifier) key() string {
+	return q.property + "=" + q.value
+}
+
+// qualifiers lists what should be on the author's claim: their place in the author list and, for each
+// affiliation, its text and whatever we know of the organisation.
+func (author *ArticleAuthor) qualifiers(ordinal int) []authorQualifier {
+
+	res := []authorQualifier{{seriesOrdinalProperty, "string", fmt.Sprintf("%d", ordinal)}}
+	for _, affiliation := range author.Affiliations {
+		res = append(res, authorQualifier{affiliationStringProperty, "string", affiliation.Text})
+		if len(affiliation.Item) != 0 {
+			res = append(res, authorQualifier{affiliationProperty, "wikibase-item", affiliation.Item})
+		}
+		if len(affiliation.ROR) != 0 {
+			res = append(res, authorQualifier{affiliationRORProperty, "external-id", affiliation.ROR})
+		}
+		if len(affiliation.WikiDataItemCode) != 0 {
+			res = append(res, authorQualifier{affiliationWikidataProperty, "external-id", affiliation.WikiDataItemCode})
+		}
+	}
+	return res
+}
+
+// RecordAuthors makes a statement on the article item for each author that doesn't have one yet, and adds
+// any of their qualifiers not yet recorded. The checkpoint is called after each change so the caller can
+// save the article.
+func (c *ScienceSourceClient) RecordAuthors(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	for i := range article.Authors {
+		author := &article.Authors[i]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if len(author.Claim) == 0 {
+			property, err := c.extraProperty(ctx, authorNameProperty, "string")
+			if err != nil {
+				return err
+			}
+			author.Claim, err = c.network.CreateClaim(ctx, string(article.ID), property, author.Name)
+			if err != nil {
+				return err
+			}
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+
+		for j := range author.Affiliations {
+			affiliation := &author.Affiliations[j]
+			if len(affiliation.ROR) == 0 || len(affiliation.Item) != 0 {
+				continue
+			}
+			item, err := c.organisationItem(ctx, *affiliation)
+			if err != nil {
+				return err
+			}
+			affiliation.Item = item
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+
+		recorded := make(map[string]bool, len(author.Qualifiers))
+		for _, key := range author.Qualifiers {
+			recorded[key] = true
+		}
+		for _, qualifier := range author.qualifiers(i + 1) {
+			if recorded[qualifier.key()] {
+				continue
+			}
+			property, err := c.extraProperty(ctx, qualifier.property, qualifier.dataType)
+			if err != nil {
+				return err
+			}
+			var value interface{} = qualifier.value
+			if qualifier.dataType == "wikibase-item" {
+				value, err = wikibase.NewItemValue(qualifier.value)
+				if err != nil {
+					return err
+				}
+			}
+			err = c.network.SetQualifier(ctx, author.Claim, property, value)
+			if err != nil {
+				return err
+			}
+			recorded[qualifier.key()] = true
+			author.Qualifiers = append(author.Qualifiers, qualifier.key())
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"fmt"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+)
+
+// The phrases and distances on an anchor point can all be worked out from the text, the term, and where
+// it was found, so rather than have everything that makes anchor points work them out (and risk them
+// disagreeing), they are filled in here.
+
+// FillAnchorContext sets the preceding and following phrases of each anchor point, of roughly phraseSize
+// bytes (or the default if zero), and the distances between neighbouring anchor points. The anchor
+// points must have their term and character number set, and be in order.
+func (article *ScienceSourceArticle) FillAnchorContext(text []byte, phraseSize int) error {
+
+	if phraseSize <= 0 {
+		phraseSize = annotate.PhraseTargetSize
+	}
+
+	for i := 0; i < len(article.Annotations); i++ {
+		anchor := &article.Annotations[i]
+		offset := anchor.CharacterNumber - article.BodyOffset
+		end := offset + len(anchor.Annotation.TermFound)
+		if offset < 0 || end > len(text) {
+			return fmt.Errorf("Anchor point %d for %q at %d is outside text of length %d", i,
+				anchor.Annotation.TermFound, offset, len(text))
+		}
+
+		anchor.PrecedingPhrase = annotate.FindPhraseOfSize(text, offset, annotate.SearchDirectionBackward, phraseSize)
+		anchor.FollowingPhrase = annotate.FindPhraseOfSize(text, end, annotate.SearchDirectionForward, phraseSize)
+
+		anchor.DistanceToPreceding = nil
+		if i > 0 {
+			distanceToPreceding := anchor.CharacterNumber - article.Annotations[i-1].CharacterNumber
+			anchor.DistanceToPreceding = &distanceToPreceding
+		}
+		anchor.DistanceToFollowing = nil
+		if i < (len(article.Annotations) - 1) {
+			distanceToFollowing := article.Annotations[i+1].CharacterNumber - anchor.CharacterNumber
+			anchor.DistanceToFollowing = &distanceToFollowing
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// When a paper's state looks wrong it helps to see exactly what changed between two saved copies of it,
+// say from before and after a text update, or between what we saved and what is on the server now. The
+// saved JSON is compared field by field, with annotations paired up by their anchor point item where
+// they have one, and otherwise by where they are and what term they found, so that an annotation
+// added or removed part way through doesn't make every later one look different.
+
+// ArticleDifference is one field that differs, named by its JSON path in the saved article.
+type ArticleDifference struct {
+	Path string
+	Old  string // Empty if the field was added
+	New  string // Empty if the field was removed
+}
+
+func (d ArticleDifference) String() string {
+	switch {
+	case len(d.Old) == 0:
+		return fmt.Sprintf("%s: added %s", d.Path, d.New)
+	case len(d.New) == 0:
+		return fmt.Sprintf("%s: removed %s", d.Path, d.Old)
+	default:
+		return fmt.Sprintf("%s: %s -> %s", d.Path, d.Old, d.New)
+	}
+}
+
+// Added or removed values longer than this are cut short, as whole annotations can be long
+const maxDifferenceValueLength int = 120
+
+func differenceValue(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	if len(encoded) > maxDifferenceValueLength {
+		return string(encoded[:maxDifferenceValueLength]) + "..."
+	}
+	return string(encoded)
+}
+
+// genericJSON turns the article into maps and slices, so it can be walked without knowing its types.
+func genericJSON(article *ScienceSourceArticle) (map[string]interface{}, error) {
+	data, err := json.Marshal(article)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]interface{}
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
+
+func diffValues(path string, old interface{}, new interface{}, res *[]ArticleDifference) {
+
+	switch {
+	case old == nil && new == nil:
+		return
+	case old == nil:
+		*res = append(*res, ArticleDifference{Path: path, New: differenceValue(new)})
+		return
+	case new == nil:
+		*res = append(*res, ArticleDifference{Path: path, Old: differenceValue(old)})
+		return
+	}
+
+	old_map, old_is_map := old.(map[string]interface{})
+	new_map, new_is_map := new.(map[string]interface{})
+	if old_is_map && new_is_map {
+		keys := make([]string, 0, len(old_map)+len(new_map))
+		for key := range old_map {
+			keys = append(keys, key)
+		}
+		for key := range new_map {
+			if _, ok := old_map[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if len(path) != 0 {
+				child = path + "." + key
+			}
+			diffValues(child, old_map[key], new_map[key], res)
+		}
+		return
+	}
+
+	old_list, old_is_list := old.([]interface{})
+	new_list, new_is_list := new.([]interface{})
+	if old_is_list && new_is_list {
+		for i := 0; i < len(old_list) || i < len(new_list); i++ {
+			var old_item, new_item interface{}
+			if i < len(old_list) {
+				old_item = old_list[i]
+			}
+			if i < len(new_list) {
+				new_item = new_list[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), old_item, new_item, res)
+		}
+		return
+	}
+
+	old_value := differenceValue(old)
+	new_value := differenceValue(new)
+	if old_value != new_value {
+		*res = append(*res, ArticleDifference{Path: path, Old: old_value, New: new_value})
+	}
+}
+
+// anchorKeys gives the ways an annotation in the saved JSON can be matched with one in another copy:
+// by its anchor point item, and by its character number and term.
+func anchorKeys(anchor interface{}) (string, string) {
+
+	fields, _ := anchor.(map[string]interface{})
+	id := ""
+	if item, ok := fields["item"].(map[string]interface{}); ok {
+		id, _ = item["id"].(string)
+	}
+	term := ""
+	if annotation, ok := fields["annotation"].(map[string]interface{}); ok {
+		term, _ = annotation["term"].(string)
+	}
+	return id, fmt.Sprintf("%v/%s", fields["character"], term)
+}
+
+// describeAnchor sums up an annotation that is only in one copy, as the whole of it is too long to show.
+func describeAnchor(anchor interface{}) string {
+	id, _ := anchorKeys(anchor)
+	fields, _ := anchor.(map[string]interface{})
+	term := ""
+	if annotation, ok := fields["annotation"].(map[string]interface{}); ok {
+		term, _ = annotation["term"].(string)
+	}
+	if len(id) == 0 {
+		id = "with no item"
+	}
+	return fmt.Sprintf("anchor point %s for %q at character %v", id, term, fields["character"])
+}
+
+// pairAnchors matches up the annotations of two copies of an article, returning pairs of indexes, with
+// -1 for an annotation only in one copy, in the order of the old copy with additions at the end.
+func pairAnchors(old []interface{}, new []interface{}) [][2]int {
+
+	new_by_id := make(map[string]int)
+	new_by_place := make(map[string]int)
+	for i, anchor := range new {
+		id, place := anchorKeys(anchor)
+		if len(id) != 0 {
+			new_by_id[id] = i
+		}
+		if _, ok := new_by_place[place]; !ok {
+			new_by_place[place] = i
+		}
+	}
+
+	// Items are the surer match, so pair up all those first
+	paired := make(map[int]bool)
+	res := make([][2]int, len(old))
+	for i, anchor := range old {
+		res[i] = [2]int{i, -1}
+		id, _ := anchorKeys(anchor)
+		if j, ok := new_by_id[id]; ok && len(id) != 0 {
+			res[i][1] = j
+			paired[j] = true
+		}
+	}
+	for i, anchor := range old {
+		_, place := anchorKeys(anchor)
+		if j, ok := new_by_place[place]; ok && res[i][1] == -1 && !paired[j] {
+			res[i][1] = j
+			paired[j] = true
+		}
+	}
+	for j := range new {
+		if !paired[j] {
+			res = append(res, [2]int{-1, j})
+		}
+	}
+	return res
+}
+
+// DiffArticles returns the differences between two saved copies of an article.
+func DiffArticles(old *ScienceSourceArticle, new *ScienceSourceArticle) ([]ArticleDifference, error) {
+
+	old_fields, err := genericJSON(old)
+	if err != nil {
+		return nil, err
+	}
+	new_fields, err := genericJSON(new)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ArticleDifference, 0)
+
+	old_anchors, _ := old_fields["annotations"].([]interface{})
+	new_anchors, _ := new_fields["annotations"].([]interface{})
+	delete(old_fields, "annotations")
+	delete(new_fields, "annotations")
+	diffValues("", old_fields, new_fields, &res)
+
+	for _, pair := range pairAnchors(old_anchors, new_anchors) {
+		var path string
+		var old_anchor, new_anchor interface{}
+		switch {
+		case pair[1] == -1:
+			res = append(res, ArticleDifference{Path: fmt.Sprintf("annotations[%d]", pair[0]),
+				Old: describeAnchor(old_anchors[pair[0]])})
+			continue
+		case pair[0] == -1:
+			res = append(res, ArticleDifference{Path: fmt.Sprintf("annotations[%d]", pair[1]),
+				New: describeAnchor(new_anchors[pair[1]])})
+			continue
+		case pair[0] == pair[1]:
+			path = fmt.Sprintf("annotations[%d]", pair[0])
+			old_anchor = old_anchors[pair[0]]
+			new_anchor = new_anchors[pair[1]]
+		default:
+			path = fmt.Sprintf("annotations[%d->%d]", pair[0], pair[1])
+			old_anchor = old_anchors[pair[0]]
+			new_anchor = new_anchors[pair[1]]
+		}
+		diffValues(path, old_anchor, new_anchor, &res)
+	}
+
+	return res, nil
+}
+
+// DiffArticleWithServer returns how the article's items on the server differ from the saved article:
+// items that have gone, and statements whose values aren't the saved ones. The saved value is the old
+// one, and the server's the new.
+func (c *ScienceSourceClient) DiffArticleWithServer(ctx context.Context, article *ScienceSourceArticle) ([]ArticleDifference, error) {
+
+	entities, err := c.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ArticleDifference, 0)
+	for _, item := range article.verifiedItems() {
+		if len(item.id) == 0 {
+			continue
+		}
+		entity, ok := entities[string(item.id)]
+		if !ok || !entity.Exists() {
+			res = append(res, ArticleDifference{Path: item.path, Old: string(item.id)})
+			continue
+		}
+		discrepancies, err := c.compareClaims(entity, item.item)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(discrepancies, func(i, j int) bool {
+			return discrepancies[i].Property < discrepancies[j].Property
+		})
+		for _, discrepancy := range discrepancies {
+			found := ""
+			if len(discrepancy.Found) != 0 {
+				found = fmt.Sprintf("%q", strings.Join(discrepancy.Found, `", "`))
+			}
+			res = append(res, ArticleDifference{
+				Path: fmt.Sprintf("%s (%s) %s", item.path, item.id, discrepancy.Property),
+				Old:  fmt.Sprintf("%q", discrepancy.Expected),
+				New:  found,
+			})
+		}
+	}
+	return res, nil
+}
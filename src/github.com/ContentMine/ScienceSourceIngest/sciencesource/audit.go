@@ -0,0 +1,87 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Everything about an annotation hangs off its character number, so if the offsets are wrong, for
+// instance because the text was regenerated with a different stylesheet or an annotator miscounted, the
+// annotations point at the wrong words. We can check this by looking at what is actually at each offset
+// in the text we mined.
+
+type OffsetMismatch struct {
+	Anchor   int // index in the article's annotations
+	Field    string
+	Expected string
+	Found    string
+	Context  string // the text around the offset
+}
+
+func (mismatch OffsetMismatch) String() string {
+	return fmt.Sprintf("anchor %d %s: expected %q, found %q, in %q", mismatch.Anchor, mismatch.Field,
+		mismatch.Expected, mismatch.Found, mismatch.Context)
+}
+
+// How much text either side of an offset to show
+const auditContextSize int = 40
+
+func textAround(text []byte, start int, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	return strings.Replace(string(text[start:end]), "\n", " ", -1)
+}
+
+// AuditOffsets checks each anchor point's term and phrases against the text at its character number.
+func (article *ScienceSourceArticle) AuditOffsets(text []byte) []OffsetMismatch {
+
+	res := make([]OffsetMismatch, 0)
+	for i, anchor := range article.Annotations {
+		term := anchor.Annotation.TermFound
+		offset := anchor.CharacterNumber - article.BodyOffset
+		end := offset + len(term)
+		context := textAround(text, offset-auditContextSize, end+auditContextSize)
+
+		if offset < 0 || end > len(text) {
+			res = append(res, OffsetMismatch{Anchor: i, Field: "character number", Expected: term,
+				Found: fmt.Sprintf("offset %d outside text of length %d", offset, len(text)), Context: context})
+			continue
+		}
+
+		if found := string(text[offset:end]); found != term {
+			res = append(res, OffsetMismatch{Anchor: i, Field: "term found", Expected: term, Found: found, Context: context})
+		}
+		if anchor.Annotation.LengthOfTermFound != len(term) {
+			res = append(res, OffsetMismatch{Anchor: i, Field: "length of term found", Expected: fmt.Sprintf("%d", len(term)),
+				Found: fmt.Sprintf("%d", anchor.Annotation.LengthOfTermFound), Context: context})
+		}
+		if !bytes.HasSuffix(text[:offset], []byte(anchor.PrecedingPhrase)) {
+			res = append(res, OffsetMismatch{Anchor: i, Field: "preceding phrase", Expected: anchor.PrecedingPhrase,
+				Found: textAround(text, offset-len(anchor.PrecedingPhrase), offset), Context: context})
+		}
+		if !bytes.HasPrefix(text[end:], []byte(anchor.FollowingPhrase)) {
+			res = append(res, OffsetMismatch{Anchor: i, Field: "following phrase", Expected: anchor.FollowingPhrase,
+				Found: textAround(text, end, end+len(anchor.FollowingPhrase)), Context: context})
+		}
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Categories are specified as patterns, which can contain the following placeholders that are filled
+// in per article:
+//
+//   {journal}    - the journal the paper was published in
+//   {subject}    - the main subject of the paper
+//   {batch}      - the date of the ingest run, as YYYY-MM-DD
+//   {dictionary} - expands to one category per dictionary that found terms in the paper
+
+func cleanCategoryName(name string) string {
+	// These characters would break the wiki link syntax
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '[', ']', '|', '{', '}', '\n', '\r':
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+func expandCategoryPatterns(patterns []string, paper Paper, dictionaries []string, batch time.Time) []string {
+
+	replacer := strings.NewReplacer(
+		"{journal}", paper.JournalLabel.Value,
+		"{subject}", paper.MainSubjectLabel.Value,
+		"{batch}", fmt.Sprintf("%04d-%02d-%02d", batch.Year(), batch.Month(), batch.Day()),
+	)
+
+	seen := make(map[string]bool)
+	res := make([]string, 0, len(patterns))
+	add := func(name string) {
+		name = cleanCategoryName(name)
+		if len(name) > 0 && seen[name] == false {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		pattern = replacer.Replace(pattern)
+		if strings.Contains(pattern, "{dictionary}") {
+			for _, dictionary := range dictionaries {
+				add(strings.Replace(pattern, "{dictionary}", dictionary, -1))
+			}
+		} else {
+			add(pattern)
+		}
+	}
+
+	return res
+}
+
+func (article *ScienceSourceArticle) DictionariesUsed() []string {
+
+	found := make(map[string]bool)
+	for _, anchor := range article.Annotations {
+		found[anchor.Annotation.DictionaryName] = true
+	}
+
+	res := make([]string, 0, len(found))
+	for name := range found {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func (processor PaperProcessor) appendCategoriesToHTML(article *ScienceSourceArticle) error {
+
+	if len(processor.Categories) == 0 {
+		return nil
+	}
+
+	categories := expandCategoryPatterns(processor.Categories, processor.Paper, article.DictionariesUsed(), time.Now())
+
+	f, err := os.OpenFile(processor.targetHTMLFileName(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, category := range categories {
+		_, err = fmt.Fprintf(f, "[[Category:%s]]\n", category)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,685 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal CBOR (RFC 8949) encoder and decoder, doing just enough for saving article state: it works
+// on the same structs as encoding/json, going by their json tags, so the CBOR has the same fields as the
+// JSON would. Structs are encoded as maps keyed by field name, times as tagged RFC 3339 strings, and nil
+// pointers, slices, and maps as null. Only definite lengths are written or read.
+
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+)
+
+const (
+	cborFalse   byte = 0xf4
+	cborTrue    byte = 0xf5
+	cborNull    byte = 0xf6
+	cborUndef   byte = 0xf7
+	cborFloat16 byte = 0xf9
+	cborFloat32 byte = 0xfa
+	cborFloat64 byte = 0xfb
+
+	// Tag for a date and time as an RFC 3339 string
+	cborTagDateTime uint64 = 0
+)
+
+var cborTimeType = reflect.TypeOf(time.Time{})
+
+// The fields of a struct as encoding/json would see them
+type cborField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var cborFieldCache sync.Map
+
+func cborFields(t reflect.Type) []cborField {
+
+	if cached, ok := cborFieldCache.Load(t); ok {
+		return cached.([]cborField)
+	}
+
+	res := make([]cborField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		// Embedded structs without a name of their own have their fields promoted
+		if field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct {
+			for _, promoted := range cborFields(field.Type) {
+				promoted.index = append([]int{i}, promoted.index...)
+				res = append(res, promoted)
+			}
+			continue
+		}
+		if len(field.PkgPath) != 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		omit_empty := false
+		for _, option := range parts[1:] {
+			if option == "omitempty" {
+				omit_empty = true
+			}
+		}
+		res = append(res, cborField{name: name, index: []int{i}, omitEmpty: omit_empty})
+	}
+
+	cborFieldCache.Store(t, res)
+	return res
+}
+
+// cborIsEmpty is as for omitempty in encoding/json
+func cborIsEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Encoding
+
+type cborEncoder struct {
+	w       *bufio.Writer
+	scratch [9]byte
+}
+
+// encodeCBOR writes the value to w as CBOR.
+func encodeCBOR(w io.Writer, value interface{}) error {
+	encoder := &cborEncoder{w: bufio.NewWriter(w)}
+	err := encoder.encode(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+	return encoder.w.Flush()
+}
+
+func (e *cborEncoder) head(major byte, n uint64) error {
+	b := e.scratch[:]
+	switch {
+	case n < 24:
+		b[0] = major<<5 | byte(n)
+		b = b[:1]
+	case n <= math.MaxUint8:
+		b[0], b[1] = major<<5|24, byte(n)
+		b = b[:2]
+	case n <= math.MaxUint16:
+		b[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		b = b[:3]
+	case n <= math.MaxUint32:
+		b[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		b = b[:5]
+	default:
+		b[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *cborEncoder) text(s string) error {
+	err := e.head(cborText, uint64(len(s)))
+	if err != nil {
+		return err
+	}
+	_, err = e.w.WriteString(s)
+	return err
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+
+	if !v.IsValid() {
+		return e.w.WriteByte(cborNull)
+	}
+	if v.Type() == cborTimeType {
+		err := e.head(cborTag, cborTagDateTime)
+		if err != nil {
+			return err
+		}
+		return e.text(v.Interface().(time.Time).Format(time.RFC3339Nano))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.w.WriteByte(cborNull)
+		}
+		return e.encode(v.Elem())
+
+	case reflect.Bool:
+		if v.Bool() {
+			return e.w.WriteByte(cborTrue)
+		}
+		return e.w.WriteByte(cborFalse)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n < 0 {
+			return e.head(cborNegative, uint64(-1-n))
+		}
+		return e.head(cborUnsigned, uint64(n))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.head(cborUnsigned, v.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		b := e.scratch[:]
+		b[0] = cborFloat64
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v.Float()))
+		_, err := e.w.Write(b)
+		return err
+
+	case reflect.String:
+		return e.text(v.String())
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return e.w.WriteByte(cborNull)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			err := e.head(cborBytes, uint64(v.Len()))
+			if err != nil {
+				return err
+			}
+			_, err = e.w.Write(v.Bytes())
+			return err
+		}
+		err := e.head(cborArray, uint64(v.Len()))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			err = e.encode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("Can't encode %v in CBOR, as its keys aren't strings", v.Type())
+		}
+		if v.IsNil() {
+			return e.w.WriteByte(cborNull)
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		err := e.head(cborMap, uint64(len(keys)))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			err = e.text(key.String())
+			if err != nil {
+				return err
+			}
+			err = e.encode(v.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Struct:
+		fields := cborFields(v.Type())
+		present := make([]cborField, 0, len(fields))
+		for _, field := range fields {
+			if !field.omitEmpty || !cborIsEmpty(v.FieldByIndex(field.index)) {
+				present = append(present, field)
+			}
+		}
+		err := e.head(cborMap, uint64(len(present)))
+		if err != nil {
+			return err
+		}
+		for _, field := range present {
+			err = e.text(field.name)
+			if err != nil {
+				return err
+			}
+			err = e.encode(v.FieldByIndex(field.index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Can't encode %v in CBOR", v.Type())
+}
+
+// Decoding
+
+// State files can be cut short or corrupted, so the decoder trusts nothing it reads: every length is
+// checked against the bytes left before anything is allocated for it, and nesting is limited, so a bad
+// file is an error rather than a panic or an attempt to allocate gigabytes.
+
+// Deeper than article state ever nests
+const cborMaxDepth int = 64
+
+type cborDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// decodeCBOR reads one CBOR item from r into the value, which must be a pointer.
+func decodeCBOR(r io.Reader, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Can't decode CBOR into %T", value)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return io.EOF
+	}
+	decoder := &cborDecoder{data: data}
+	return decoder.decode(v.Elem())
+}
+
+func (d *cborDecoder) remaining() uint64 {
+	return uint64(len(d.data) - d.pos)
+}
+
+// read returns the next n bytes, failing if there aren't that many left
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > d.remaining() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the initial byte of an item and its argument, which is the value, length, or tag number
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	initial := b[0]
+	major, info := initial>>5, initial&0x1f
+
+	var size uint64
+	switch {
+	case info < 24:
+		return initial, major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("Unsupported CBOR item 0x%02x", initial)
+	}
+
+	b, err = d.read(size)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var n uint64
+	for _, octet := range b {
+		n = n<<8 | uint64(octet)
+	}
+	return initial, major, n, nil
+}
+
+// checkCount checks there are enough bytes left for count items, each of which takes at least size
+func (d *cborDecoder) checkCount(count uint64, size uint64, what string) error {
+	if count > d.remaining()/size {
+		return fmt.Errorf("CBOR %s of %d items is longer than the %d bytes left", what, count, d.remaining())
+	}
+	return nil
+}
+
+func (d *cborDecoder) text(major byte, n uint64) (string, error) {
+	if major != cborText && major != cborBytes {
+		return "", fmt.Errorf("Expected a CBOR string, found major type %d", major)
+	}
+	b, err := d.read(n)
+	return string(b), err
+}
+
+// float reads a simple value's float, given its initial byte and argument
+func cborFloat(initial byte, n uint64) (float64, bool) {
+	switch initial {
+	case cborFloat64:
+		return math.Float64frombits(n), true
+	case cborFloat32:
+		return float64(math.Float32frombits(uint32(n))), true
+	case cborFloat16:
+		// Half precision, which we don't write but other encoders might
+		sign := 1.0
+		if n&0x8000 != 0 {
+			sign = -1.0
+		}
+		exponent := int((n >> 10) & 0x1f)
+		mantissa := float64(n & 0x3ff)
+		switch exponent {
+		case 0:
+			return sign * math.Ldexp(mantissa, -24), true
+		case 31:
+			if mantissa == 0 {
+				return math.Inf(int(sign)), true
+			}
+			return math.NaN(), true
+		}
+		return sign * math.Ldexp(mantissa+1024, exponent-25), true
+	}
+	return 0, false
+}
+
+func (d *cborDecoder) decode(v reflect.Value) error {
+
+	d.depth += 1
+	defer func() { d.depth -= 1 }()
+	if d.depth > cborMaxDepth {
+		return fmt.Errorf("CBOR nests more than %d deep", cborMaxDepth)
+	}
+
+	initial, major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	if initial == cborNull || initial == cborUndef {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeItem(v.Elem(), initial, major, n)
+	}
+	return d.decodeItem(v, initial, major, n)
+}
+
+func (d *cborDecoder) decodeItem(v reflect.Value, initial byte, major byte, n uint64) error {
+
+	mismatch := func() error {
+		return fmt.Errorf("Can't decode CBOR major type %d into %v", major, v.Type())
+	}
+
+	if v.Type() == cborTimeType {
+		if major != cborTag || n != cborTagDateTime {
+			return mismatch()
+		}
+		_, text_major, length, err := d.head()
+		if err != nil {
+			return err
+		}
+		text, err := d.text(text_major, length)
+		if err != nil {
+			return err
+		}
+		when, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(when))
+		return nil
+	}
+
+	switch major {
+	case cborUnsigned, cborNegative:
+		overflow := func() error {
+			return fmt.Errorf("CBOR integer doesn't fit in %v", v.Type())
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if n > math.MaxInt64 {
+				return overflow()
+			}
+			value := int64(n)
+			if major == cborNegative {
+				value = -1 - value
+			}
+			if v.OverflowInt(value) {
+				return overflow()
+			}
+			v.SetInt(value)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if major == cborNegative {
+				return mismatch()
+			}
+			if v.OverflowUint(n) {
+				return overflow()
+			}
+			v.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			if major == cborNegative {
+				v.SetFloat(-1 - float64(n))
+			} else {
+				v.SetFloat(float64(n))
+			}
+		default:
+			return mismatch()
+		}
+		return nil
+
+	case cborBytes, cborText:
+		text, err := d.text(major, n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(text)
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes([]byte(text))
+		default:
+			return mismatch()
+		}
+		return nil
+
+	case cborArray:
+		if err := d.checkCount(n, 1, "array"); err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), int(n), int(n)))
+		case reflect.Array:
+			if uint64(v.Len()) != n {
+				return fmt.Errorf("Can't decode CBOR array of %d into %v", n, v.Type())
+			}
+		default:
+			return mismatch()
+		}
+		for i := 0; i < int(n); i++ {
+			err := d.decode(v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case cborMap:
+		if err := d.checkCount(n, 2, "map"); err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return mismatch()
+			}
+			v.Set(reflect.MakeMapWithSize(v.Type(), int(n)))
+			for i := uint64(0); i < n; i++ {
+				key, err := d.key()
+				if err != nil {
+					return err
+				}
+				element := reflect.New(v.Type().Elem()).Elem()
+				err = d.decode(element)
+				if err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), element)
+			}
+			return nil
+
+		case reflect.Struct:
+			fields := cborFields(v.Type())
+			for i := uint64(0); i < n; i++ {
+				key, err := d.key()
+				if err != nil {
+					return err
+				}
+				found := false
+				for _, field := range fields {
+					if field.name == key {
+						err = d.decode(v.FieldByIndex(field.index))
+						found = true
+						break
+					}
+				}
+				if !found {
+					// Like encoding/json, fields we don't know are ignored
+					err = d.skip()
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return mismatch()
+
+	case cborTag:
+		// Tags we don't know just wrap the value
+		return d.decode(v)
+
+	case cborSimple:
+		switch initial {
+		case cborFalse, cborTrue:
+			if v.Kind() != reflect.Bool {
+				return mismatch()
+			}
+			v.SetBool(initial == cborTrue)
+			return nil
+		}
+		if value, ok := cborFloat(initial, n); ok {
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return mismatch()
+			}
+			v.SetFloat(value)
+			return nil
+		}
+	}
+	return mismatch()
+}
+
+func (d *cborDecoder) key() (string, error) {
+	_, major, n, err := d.head()
+	if err != nil {
+		return "", err
+	}
+	return d.text(major, n)
+}
+
+// skip reads past an item we have nowhere to put
+func (d *cborDecoder) skip() error {
+
+	d.depth += 1
+	defer func() { d.depth -= 1 }()
+	if d.depth > cborMaxDepth {
+		return fmt.Errorf("CBOR nests more than %d deep", cborMaxDepth)
+	}
+
+	_, major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case cborBytes, cborText:
+		_, err = d.read(n)
+		return err
+	case cborArray:
+		if err := d.checkCount(n, 1, "array"); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		if err := d.checkCount(n, 2, "map"); err != nil {
+			return err
+		}
+		for i := uint64(0); i < 2*n; i++ {
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		return d.skip()
+	}
+	return nil
+}
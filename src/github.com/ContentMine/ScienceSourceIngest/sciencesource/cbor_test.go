@@ -0,0 +1,190 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+type cborTestValue struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count"`
+	Small    int8              `json:"small"`
+	Negative int64             `json:"negative"`
+	Unsigned uint32            `json:"unsigned"`
+	Ratio    float64           `json:"ratio"`
+	Flag     bool              `json:"flag"`
+	Data     []byte            `json:"data"`
+	When     time.Time         `json:"when"`
+	Maybe    *string           `json:"maybe,omitempty"`
+	Nothing  *int              `json:"nothing"`
+	Tags     []string          `json:"tags"`
+	Counts   map[string]int    `json:"counts"`
+	Nested   []cborTestValue   `json:"nested,omitempty"`
+	Skipped  string            `json:"-"`
+	Extra    map[string]string `json:"extra,omitempty"`
+}
+
+type cborTestNest []cborTestNest
+
+func testArticle() ScienceSourceArticle {
+	doi := "10.1000/xyz123"
+	distance := 12
+	when := time.Date(2018, 11, 5, 14, 3, 2, 123456789, time.UTC)
+	article := ScienceSourceArticle{
+		ArticleTextTitle: "A paper about café society",
+		DOI:              &doi,
+		TimeCode:         when,
+		PublicationDate:  &when,
+		BodyOffset:       42,
+	}
+	article.ID = "Q1"
+	for i, term := range []string{"aspirin", "fibrin", "naïve"} {
+		anchor := ScienceSourceAnchorPoint{CharacterNumber: 100 * i, PrecedingPhrase: "before", FollowingPhrase: "after", TimeCode: when}
+		if i > 0 {
+			anchor.DistanceToPreceding = &distance
+		}
+		anchor.Annotation.TermFound = term
+		anchor.Annotation.LengthOfTermFound = len(term)
+		article.Annotations = append(article.Annotations, anchor)
+	}
+	return article
+}
+
+func encodeTestCBOR(t *testing.T, value interface{}) []byte {
+	var buffer bytes.Buffer
+	if err := encodeCBOR(&buffer, value); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+
+	maybe := "perhaps"
+	values := []interface{}{
+		&cborTestValue{},
+		&cborTestValue{
+			Name:     "everything",
+			Count:    1 << 40,
+			Small:    -128,
+			Negative: math.MinInt64,
+			Unsigned: math.MaxUint32,
+			Ratio:    -0.25,
+			Flag:     true,
+			Data:     []byte{0, 1, 2, 255},
+			When:     time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+			Maybe:    &maybe,
+			Tags:     []string{"a", "", "ü"},
+			Counts:   map[string]int{"one": 1, "minus": -1},
+			Nested:   []cborTestValue{{Name: "inner", Counts: map[string]int{}}},
+			Extra:    map[string]string{"key": "value"},
+		},
+	}
+	article := testArticle()
+	values = append(values, &article)
+
+	for _, value := range values {
+		data := encodeTestCBOR(t, value)
+
+		// A fresh value of the same type to decode into
+		var decoded interface{}
+		switch value.(type) {
+		case *cborTestValue:
+			decoded = &cborTestValue{}
+		case *ScienceSourceArticle:
+			decoded = &ScienceSourceArticle{}
+		}
+		if err := decodeCBOR(bytes.NewReader(data), decoded); err != nil {
+			t.Fatalf("Decoding %T: %v", value, err)
+		}
+
+		// The CBOR should carry the same fields as the JSON would
+		want, _ := json.Marshal(value)
+		got, _ := json.Marshal(decoded)
+		if !bytes.Equal(want, got) {
+			t.Errorf("Round trip changed the value:\n got %s\nwant %s", got, want)
+		}
+	}
+}
+
+func TestCBORTruncated(t *testing.T) {
+
+	article := testArticle()
+	data := encodeTestCBOR(t, &article)
+
+	for length := 0; length < len(data); length++ {
+		var decoded ScienceSourceArticle
+		if err := decodeCBOR(bytes.NewReader(data[:length]), &decoded); err == nil {
+			t.Errorf("Decoding the first %d of %d bytes succeeded", length, len(data))
+		}
+	}
+}
+
+func TestCBORCorrupt(t *testing.T) {
+
+	deep := bytes.Repeat([]byte{0x81}, 100000)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		value interface{}
+	}{
+		{"huge text length", []byte{0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'}, new(string)},
+		{"text longer than data", []byte{0x78, 0x20, 'a', 'b'}, new(string)},
+		{"huge array length", []byte{0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, new([]int)},
+		{"array longer than data", []byte{0x98, 0x40, 0x01, 0x02}, new([]int)},
+		{"huge map length", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x61, 'a', 0x01}, new(map[string]int)},
+		{"huge map length into struct", []byte{0xbb, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x61, 'a', 0x01}, new(cborTestValue)},
+		{"huge skipped array", []byte{0xa1, 0x61, 'z', 0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, new(cborTestValue)},
+		{"huge skipped text", []byte{0xa1, 0x61, 'z', 0x7b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, new(cborTestValue)},
+		{"deep nesting", deep, new(cborTestNest)},
+		{"deep skipped nesting", append([]byte{0xa1, 0x61, 'z'}, deep...), new(cborTestValue)},
+		{"integer overflows int8", []byte{0x18, 0xff}, new(int8)},
+		{"integer overflows int64", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, new(int64)},
+		{"negative into unsigned", []byte{0x20}, new(uint)},
+		{"reserved additional info", []byte{0x1c}, new(int)},
+		{"wrong type", []byte{0x61, 'a'}, new(int)},
+		{"bad time", []byte{0xc0, 0x63, 'b', 'a', 'd'}, new(time.Time)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := decodeCBOR(bytes.NewReader(test.data), test.value); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// Corrupting any byte of real state must never panic, though it may still decode
+func TestCBORCorruptBytes(t *testing.T) {
+
+	article := testArticle()
+	data := encodeTestCBOR(t, &article)
+
+	for i := range data {
+		for _, corrupt := range []byte{0x00, 0x1b, 0x5b, 0x7b, 0x9b, 0xbb, 0xff} {
+			changed := append([]byte{}, data...)
+			changed[i] = corrupt
+			var decoded ScienceSourceArticle
+			decodeCBOR(bytes.NewReader(changed), &decoded)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// OpenCitations knows which works cite which for far more papers than we can get from the full text,
+// so we can ask it for the references of each article we've ingested and add them to the article item.
+// Every reference gets a statement with the cited DOI, and if the cited paper is an article on the
+// server too it also gets a statement linking the two article items, building up the citation graph
+// between them. See https://opencitations.net/index/coci/api/v1
+
+const openCitationsReferencesURL string = "https://opencitations.net/index/coci/api/v1/references/%s"
+
+// The properties on article items for the works they cite
+const citesWorkProperty string = "cites work"
+const citesDOIProperty string = "cites DOI"
+
+// What we know about each work an article cites, and how far through recording it we are
+type ArticleCitation struct {
+	DOI       string `json:"doi"`
+	Item      string `json:"item,omitempty"` // The article item for the cited work, if it's on the server
+	DOIClaim  string `json:"doi_claim,omitempty"`
+	ItemClaim string `json:"item_claim,omitempty"`
+}
+
+// NormaliseDOI puts a DOI in the form we compare them in, as DOIs aren't case sensitive and are often
+// given as URLs.
+func NormaliseDOI(doi string) string {
+	doi = strings.TrimSpace(doi)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "http://dx.doi.org/", "doi:"} {
+		if len(doi) >= len(prefix) && strings.EqualFold(doi[:len(prefix)], prefix) {
+			doi = doi[len(prefix):]
+			break
+		}
+	}
+	return strings.ToLower(doi)
+}
+
+type openCitationsReference struct {
+	Citing string `json:"citing"`
+	Cited  string `json:"cited"`
+}
+
+// FetchCitedDOIs asks OpenCitations for the DOIs of the works cited by the paper with the given DOI.
+func FetchCitedDOIs(ctx context.Context, doi string) ([]string, error) {
+
+	var references []openCitationsReference
+	err := getJSON(ctx, fmt.Sprintf(openCitationsReferencesURL, url.PathEscape(doi)), nil, &references)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(references))
+	seen := make(map[string]bool)
+	for _, reference := range references {
+		// Some versions of the API give several space separated IDs, each with a prefix
+		cited := ""
+		for _, id := range strings.Fields(reference.Cited) {
+			if strings.Contains(id, ":") == false || strings.HasPrefix(id, "doi:") {
+				cited = NormaliseDOI(id)
+				break
+			}
+		}
+		if len(cited) != 0 && seen[cited] == false {
+			seen[cited] = true
+			res = append(res, cited)
+		}
+	}
+	return res, nil
+}
+
+// LinkCitations records the cited works on the article item, given the article items we know of by DOI.
+// Citations already recorded are left alone, apart from linking any whose cited work has since been
+// ingested. The checkpoint is called after each statement is made so the caller can save the article,
+// and the citations that needed statements are returned. In a dry run nothing is changed on the server.
+func (c *ScienceSourceClient) LinkCitations(ctx context.Context, article *ScienceSourceArticle, cited []string,
+	items map[string]string, dryRun bool, checkpoint func() error) ([]ArticleCitation, error) {
+
+	known := make(map[string]int, len(article.Citations))
+	for i, citation := range article.Citations {
+		known[citation.DOI] = i
+	}
+	for _, doi := range cited {
+		if _, ok := known[doi]; !ok {
+			known[doi] = len(article.Citations)
+			article.Citations = append(article.Citations, ArticleCitation{DOI: doi})
+		}
+	}
+
+	changed := make([]ArticleCitation, 0)
+	for i := range article.Citations {
+		citation := &article.Citations[i]
+		if len(citation.Item) == 0 {
+			citation.Item = items[citation.DOI]
+		}
+		if len(citation.DOIClaim) != 0 && (len(citation.Item) == 0 || len(citation.ItemClaim) != 0) {
+			continue
+		}
+		changed = append(changed, *citation)
+		if dryRun {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return changed, err
+		}
+
+		if len(citation.DOIClaim) == 0 {
+			property, err := c.extraProperty(ctx, citesDOIProperty, "external-id")
+			if err != nil {
+				return changed, err
+			}
+			citation.DOIClaim, err = c.network.CreateClaim(ctx, string(article.ID), property, citation.DOI)
+			if err != nil {
+				return changed, err
+			}
+			err = checkpoint()
+			if err != nil {
+				return changed, err
+			}
+		}
+		if len(citation.Item) != 0 && len(citation.ItemClaim) == 0 {
+			property, err := c.extraProperty(ctx, citesWorkProperty, "wikibase-item")
+			if err != nil {
+				return changed, err
+			}
+			value, err := wikibase.NewItemValue(citation.Item)
+			if err != nil {
+				return changed, err
+			}
+			citation.ItemClaim, err = c.network.CreateClaim(ctx, string(article.ID), property, value)
+			if err != nil {
+				return changed, err
+			}
+			err = checkpoint()
+			if err != nil {
+				return changed, err
+			}
+		}
+	}
+
+	logging.Logf(logging.LogVerbose, "Article %s cites %d works, %d needed statements", article.ID,
+		len(article.Citations), len(changed))
+	return changed, nil
+}
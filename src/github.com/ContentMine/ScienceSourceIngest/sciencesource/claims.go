@@ -0,0 +1,288 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// Several copies of the tool can work through one corpus together, each claiming a paper before
+// processing it so that only one works on it at a time. The holder refreshes the claim's heartbeat while
+// it works, and releases it when done. A claim whose heartbeat is older than the timeout is taken to
+// belong to a worker that died, and can be taken over. Every claim has a random token, so a worker only
+// ever refreshes or releases the claim it made, even if it has since been taken over and it claimed the
+// paper again under the same name.
+//
+// Claims are kept in a ClaimStore. By default that is a claim file in each paper's folder, which works if
+// the workers share the output directory, say over NFS. Otherwise they can share a database instead (see
+// claimstore_sql.go).
+
+const claimFileName string = "claim.json"
+
+// The shortest claim timeout allowed, as the heartbeat is sent every third of it.
+const MinimumClaimTimeout time.Duration = 3 * time.Second
+
+type PaperClaim struct {
+	Worker    string    `json:"worker"`
+	Token     string    `json:"token"`
+	Claimed   time.Time `json:"claimed"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// ClaimedElsewhereError is returned when another worker is processing the paper.
+type ClaimedElsewhereError struct {
+	PaperID string
+	Worker  string
+}
+
+func (e ClaimedElsewhereError) Error() string {
+	return fmt.Sprintf("Paper %s is claimed by worker %s", e.PaperID, e.Worker)
+}
+
+// ClaimStore keeps the claims workers have on papers.
+type ClaimStore interface {
+	// Claim claims the paper for the worker, taking over a claim that has had no heartbeat for the
+	// timeout. If another worker holds it a ClaimedElsewhereError is returned.
+	Claim(paperID string, worker string, timeout time.Duration) (PaperClaim, error)
+
+	// Heartbeat refreshes the claim, failing if it has been lost.
+	Heartbeat(paperID string, claim PaperClaim) error
+
+	// Release removes the claim, if it is still held.
+	Release(paperID string, claim PaperClaim) error
+}
+
+// ValidateClaimTimeout checks the timeout is long enough to send heartbeats within.
+func ValidateClaimTimeout(timeout time.Duration) error {
+	if timeout < MinimumClaimTimeout {
+		return fmt.Errorf("Claim timeout must be at least %v, not %v", MinimumClaimTimeout, timeout)
+	}
+	return nil
+}
+
+func newClaimToken() (string, error) {
+	token := make([]byte, 8)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func (processor PaperProcessor) targetClaimFileName() string {
+	return path.Join(processor.folderName(), claimFileName)
+}
+
+// claimStore is where the processor's claims are kept, which unless told otherwise is the paper's folder.
+func (processor PaperProcessor) claimStore() (ClaimStore, error) {
+	if processor.Claims != nil {
+		return processor.Claims, nil
+	}
+	err := processor.createFolderIfRequired()
+	if err != nil {
+		return nil, err
+	}
+	return fileClaimStore{filename: processor.targetClaimFileName()}, nil
+}
+
+// A claim file is never rewritten in place. Instead it is written to a temporary file which is then hard
+// linked into place, which fails if there is a claim file already, so a claim is only ever made if there
+// was none and other workers never read half a claim. To change or remove a claim a worker first renames
+// the claim file aside, which only one worker can do, and checks the token of what it moved: if that's
+// not the claim it expected it links it back, which again can't clobber a claim made in the meantime. A
+// new claim made whilst the old one is aside means the old one was lost, and the new one wins.
+type fileClaimStore struct {
+	filename string
+}
+
+func loadPaperClaim(filename string) (PaperClaim, error) {
+	var claim PaperClaim
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return claim, err
+	}
+	err = json.Unmarshal(data, &claim)
+	return claim, err
+}
+
+// linkClaim puts the claim in place, failing if there already is one.
+func linkClaim(filename string, claim PaperClaim) error {
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.%s.tmp", filename, claim.Token)
+	err = ioutil.WriteFile(temp, data, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	return os.Link(temp, filename)
+}
+
+// moveClaimAside renames the claim file out of the way, so nothing else can change it, and returns the
+// claim that was moved if it has the token. If it doesn't it is put back.
+func moveClaimAside(filename string, token string, aside string) (PaperClaim, bool, error) {
+	err := os.Rename(filename, aside)
+	if err != nil {
+		return PaperClaim{}, false, err
+	}
+	moved, err := loadPaperClaim(aside)
+	if err == nil && moved.Token == token {
+		return moved, true, nil
+	}
+	os.Link(aside, filename)
+	os.Remove(aside)
+	return moved, false, err
+}
+
+func (store fileClaimStore) Claim(paperID string, worker string, timeout time.Duration) (PaperClaim, error) {
+
+	token, err := newClaimToken()
+	if err != nil {
+		return PaperClaim{}, err
+	}
+	now := time.Now().UTC()
+	claim := PaperClaim{Worker: worker, Token: token, Claimed: now, Heartbeat: now}
+
+	err = linkClaim(store.filename, claim)
+	if err == nil || os.IsExist(err) == false {
+		return claim, err
+	}
+
+	existing, err := loadPaperClaim(store.filename)
+	if err != nil {
+		// Most likely the holder has just released it, and either way we'll try again next time
+		return PaperClaim{}, ClaimedElsewhereError{PaperID: paperID, Worker: "unknown"}
+	}
+	// A claim under our own name is from an earlier run of this worker, so can be taken over
+	if existing.Worker != worker && time.Since(existing.Heartbeat) < timeout {
+		return PaperClaim{}, ClaimedElsewhereError{PaperID: paperID, Worker: existing.Worker}
+	}
+
+	// Check the claim we move aside is the one we judged stale, rather than one made by a worker that
+	// beat us to it
+	stale := fmt.Sprintf("%s.%s.stale", store.filename, token)
+	moved, ok, err := moveClaimAside(store.filename, existing.Token, stale)
+	if err != nil {
+		return PaperClaim{}, ClaimedElsewhereError{PaperID: paperID, Worker: existing.Worker}
+	}
+	if ok == false {
+		return PaperClaim{}, ClaimedElsewhereError{PaperID: paperID, Worker: moved.Worker}
+	}
+	os.Remove(stale)
+	if existing.Worker != worker {
+		log.Printf("Taking over paper %s from worker %s, which has been silent since %v", paperID,
+			existing.Worker, existing.Heartbeat)
+	}
+
+	err = linkClaim(store.filename, claim)
+	if os.IsExist(err) {
+		return PaperClaim{}, ClaimedElsewhereError{PaperID: paperID, Worker: "unknown"}
+	}
+	return claim, err
+}
+
+func (store fileClaimStore) Heartbeat(paperID string, claim PaperClaim) error {
+
+	aside := fmt.Sprintf("%s.%s.beat", store.filename, claim.Token)
+	moved, ok, err := moveClaimAside(store.filename, claim.Token, aside)
+	if os.IsNotExist(err) {
+		return ClaimedElsewhereError{PaperID: paperID, Worker: "unknown"}
+	}
+	if err != nil {
+		return err
+	}
+	if ok == false {
+		return ClaimedElsewhereError{PaperID: paperID, Worker: moved.Worker}
+	}
+	defer os.Remove(aside)
+
+	moved.Heartbeat = time.Now().UTC()
+	err = linkClaim(store.filename, moved)
+	if os.IsExist(err) {
+		return ClaimedElsewhereError{PaperID: paperID, Worker: "unknown"}
+	}
+	return err
+}
+
+func (store fileClaimStore) Release(paperID string, claim PaperClaim) error {
+
+	aside := fmt.Sprintf("%s.%s.release", store.filename, claim.Token)
+	_, ok, err := moveClaimAside(store.filename, claim.Token, aside)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || ok == false {
+		return err
+	}
+	return os.Remove(aside)
+}
+
+// WithClaim runs process on the paper whilst holding a claim on it, sending a heartbeat every third of
+// the timeout. Should the claim be lost, the context given to process is cancelled.
+func (processor PaperProcessor) WithClaim(ctx context.Context, worker string, timeout time.Duration, process func(ctx context.Context) error) error {
+
+	err := ValidateClaimTimeout(timeout)
+	if err != nil {
+		return err
+	}
+	store, err := processor.claimStore()
+	if err != nil {
+		return err
+	}
+	claim, err := store.Claim(processor.Paper.ID(), worker, timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Release(processor.Paper.ID(), claim); err != nil {
+			log.Printf("Failed to release claim on paper %s: %v", processor.Paper.ID(), err)
+		}
+	}()
+
+	claim_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(timeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := store.Heartbeat(processor.Paper.ID(), claim); err != nil {
+					log.Printf("Lost claim on paper %s, stopping: %v", processor.Paper.ID(), err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return process(claim_ctx)
+}
@@ -0,0 +1,227 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The same checks are made of each kind of claim store, given as the store for a paper, as a claim file
+// is only for the paper whose folder it's in.
+func claimStores(t *testing.T) map[string]func(paperID string) ClaimStore {
+	dir := t.TempDir()
+	sqlite, err := OpenClaimStore("sqlite:" + path.Join(dir, "claims.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]func(paperID string) ClaimStore{
+		"file": func(paperID string) ClaimStore {
+			if err := os.MkdirAll(path.Join(dir, paperID), 0755); err != nil {
+				t.Fatal(err)
+			}
+			return fileClaimStore{filename: path.Join(dir, paperID, claimFileName)}
+		},
+		"sqlite": func(paperID string) ClaimStore {
+			return sqlite
+		},
+	}
+}
+
+func expectClaimedBy(t *testing.T, step string, err error, worker string) {
+	t.Helper()
+	claimed, ok := err.(ClaimedElsewhereError)
+	if ok == false {
+		t.Fatalf("%s: expected the paper to be claimed by %s, got %v", step, worker, err)
+	}
+	if claimed.Worker != worker {
+		t.Errorf("%s: expected the paper to be claimed by %s, not %s", step, worker, claimed.Worker)
+	}
+}
+
+func TestClaimStores(t *testing.T) {
+
+	for name, storeFor := range claimStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			store := storeFor("PMC1")
+			a, err := store.Claim("PMC1", "a", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.Claim("PMC1", "b", time.Hour)
+			expectClaimedBy(t, "fresh claim", err, "a")
+			if err := store.Heartbeat("PMC1", a); err != nil {
+				t.Fatalf("Heartbeat of held claim: %v", err)
+			}
+
+			// Once a has been quiet for longer than the timeout b can have it
+			time.Sleep(10 * time.Millisecond)
+			b, err := store.Claim("PMC1", "b", time.Millisecond)
+			if err != nil {
+				t.Fatalf("Taking over stale claim: %v", err)
+			}
+			expectClaimedBy(t, "heartbeat after takeover", store.Heartbeat("PMC1", a), "b")
+			if err := store.Release("PMC1", a); err != nil {
+				t.Fatalf("Release of lost claim: %v", err)
+			}
+			_, err = store.Claim("PMC1", "c", time.Hour)
+			expectClaimedBy(t, "claim after lost claim released", err, "b")
+
+			// A later run of b takes over its own claim, and the earlier run's claim is then lost
+			again, err := store.Claim("PMC1", "b", time.Hour)
+			if err != nil {
+				t.Fatalf("Claiming again as the same worker: %v", err)
+			}
+			if again.Token == b.Token {
+				t.Errorf("Claiming again reused token %s", b.Token)
+			}
+			expectClaimedBy(t, "heartbeat of earlier run", store.Heartbeat("PMC1", b), "b")
+			if err := store.Heartbeat("PMC1", again); err != nil {
+				t.Fatalf("Heartbeat of later run: %v", err)
+			}
+
+			if err := store.Release("PMC1", again); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Claim("PMC1", "c", time.Hour); err != nil {
+				t.Fatalf("Claim after release: %v", err)
+			}
+		})
+	}
+}
+
+func TestClaimStoresConcurrent(t *testing.T) {
+
+	for name, storeFor := range claimStores(t) {
+		t.Run(name, func(t *testing.T) {
+
+			tests := []struct {
+				paper string
+				stale bool
+			}{
+				{paper: "PMC2", stale: false},
+				{paper: "PMC3", stale: true},
+			}
+			for _, test := range tests {
+				store := storeFor(test.paper)
+				timeout := time.Hour
+				if test.stale {
+					if _, err := store.Claim(test.paper, "dead", time.Hour); err != nil {
+						t.Fatal(err)
+					}
+					time.Sleep(10 * time.Millisecond)
+					timeout = time.Millisecond
+				}
+
+				var wg sync.WaitGroup
+				var lock sync.Mutex
+				winners := make([]string, 0)
+				for i := 0; i < 8; i++ {
+					worker := string(rune('a' + i))
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, err := store.Claim(test.paper, worker, timeout)
+						if err == nil {
+							lock.Lock()
+							winners = append(winners, worker)
+							lock.Unlock()
+						} else if _, ok := err.(ClaimedElsewhereError); ok == false {
+							t.Errorf("Paper %s, worker %s: %v", test.paper, worker, err)
+						}
+					}()
+				}
+				wg.Wait()
+
+				// With a short timeout a winner's claim can itself go stale and be taken over, but then
+				// only the last winner holds the claim
+				if test.stale == false && len(winners) != 1 {
+					t.Errorf("Paper %s: expected one worker to claim it, got %v", test.paper, winners)
+				}
+				if len(winners) == 0 {
+					t.Errorf("Paper %s: no worker claimed it", test.paper)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateClaimTimeout(t *testing.T) {
+
+	tests := []struct {
+		timeout time.Duration
+		valid   bool
+	}{
+		{timeout: 0, valid: false},
+		{timeout: time.Nanosecond, valid: false},
+		{timeout: 2 * time.Nanosecond, valid: false},
+		{timeout: time.Second, valid: false},
+		{timeout: MinimumClaimTimeout, valid: true},
+		{timeout: time.Minute, valid: true},
+	}
+
+	for _, test := range tests {
+		err := ValidateClaimTimeout(test.timeout)
+		if (err == nil) != test.valid {
+			t.Errorf("Timeout %v: expected valid %v, got %v", test.timeout, test.valid, err)
+		}
+	}
+
+	// WithClaim checks it too, rather than start a ticker that can't tick
+	processor := PaperProcessor{TargetDirectory: t.TempDir()}
+	processor.Paper.PMCID.Value = "PMC4"
+	err := processor.WithClaim(context.Background(), "a", time.Nanosecond, func(ctx context.Context) error {
+		t.Error("Processed paper with an invalid claim timeout")
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected an error for a claim timeout of 1ns")
+	}
+}
+
+func TestWithClaim(t *testing.T) {
+
+	processor := PaperProcessor{TargetDirectory: t.TempDir()}
+	processor.Paper.PMCID.Value = "PMC5"
+
+	err := processor.WithClaim(context.Background(), "a", time.Minute, func(ctx context.Context) error {
+		// Whilst we have it nobody else can
+		store, err := processor.claimStore()
+		if err != nil {
+			return err
+		}
+		_, err = store.Claim(processor.Paper.ID(), "b", time.Minute)
+		expectClaimedBy(t, "claim during processing", err, "a")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And once we're done it's released
+	store, err := processor.claimStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Claim(processor.Paper.ID(), "b", time.Minute); err != nil {
+		t.Errorf("Claim after WithClaim finished: %v", err)
+	}
+}
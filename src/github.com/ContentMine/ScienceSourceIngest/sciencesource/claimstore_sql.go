@@ -0,0 +1,149 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Workers that don't share an output directory can keep their claims in a shared database instead, one
+// row per claimed paper. Postgres suits workers spread over several machines, and SQLite those on one
+// machine. Each change to a claim is a single statement that only touches the row if it still holds the
+// claim expected, so the database does the locking. Times are taken from the database's clock rather
+// than each worker's, so workers whose clocks disagree still agree on which claims are stale, and are
+// kept as microseconds since 1970 so the same table works in both.
+
+type claimDialect struct {
+	driver string
+	now    string // SQL for the current time in microseconds
+}
+
+var claimDialects = map[string]claimDialect{
+	"postgres": {driver: "postgres", now: "CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000000 AS BIGINT)"},
+	"sqlite":   {driver: "sqlite3", now: "CAST((julianday('now') - 2440587.5) * 86400000000 AS INTEGER)"},
+}
+
+const createClaimTable string = `CREATE TABLE IF NOT EXISTS paper_claims (
+	paper TEXT PRIMARY KEY,
+	worker TEXT NOT NULL,
+	token TEXT NOT NULL,
+	claimed BIGINT NOT NULL,
+	heartbeat BIGINT NOT NULL
+)`
+
+// SQLClaimStore keeps claims in a Postgres or SQLite database.
+type SQLClaimStore struct {
+	db      *sql.DB
+	dialect claimDialect
+}
+
+// OpenClaimStore connects to the database at location, which is either a postgres:// URL or sqlite: and
+// the path to a file, and makes the claims table if it isn't there.
+func OpenClaimStore(location string) (*SQLClaimStore, error) {
+
+	var dialect claimDialect
+	var source string
+	switch {
+	case strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://"):
+		dialect, source = claimDialects["postgres"], location
+	case strings.HasPrefix(location, "sqlite:"):
+		// Several processes can share the file, so wait for each other's writes rather than fail
+		dialect = claimDialects["sqlite"]
+		source = fmt.Sprintf("file:%s?_busy_timeout=10000", strings.TrimPrefix(location, "sqlite:"))
+	default:
+		return nil, fmt.Errorf("Claim store must be a postgres:// URL or sqlite: and a file path, not %s", location)
+	}
+
+	db, err := sql.Open(dialect.driver, source)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to open claim store: {{err}}", err)
+	}
+	_, err = db.Exec(createClaimTable)
+	if err != nil {
+		db.Close()
+		return nil, errwrap.Wrapf("Failed to create claims table: {{err}}", err)
+	}
+	return &SQLClaimStore{db: db, dialect: dialect}, nil
+}
+
+func (store *SQLClaimStore) Close() error {
+	return store.db.Close()
+}
+
+// holder says who has the paper, for when we find we don't.
+func (store *SQLClaimStore) holder(paperID string) ClaimedElsewhereError {
+	worker := "unknown"
+	store.db.QueryRow(`SELECT worker FROM paper_claims WHERE paper = $1`, paperID).Scan(&worker)
+	return ClaimedElsewhereError{PaperID: paperID, Worker: worker}
+}
+
+// changed says if a statement touched a row.
+func changed(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count != 0, err
+}
+
+func (store *SQLClaimStore) Claim(paperID string, worker string, timeout time.Duration) (PaperClaim, error) {
+
+	token, err := newClaimToken()
+	if err != nil {
+		return PaperClaim{}, err
+	}
+
+	// Either there's no claim, or it's stale, or it's from an earlier run of this worker. The parameters
+	// are numbered in the order they first appear, as SQLite numbers them that way.
+	ok, err := changed(store.db.Exec(fmt.Sprintf(`INSERT INTO paper_claims (paper, worker, token, claimed, heartbeat)
+		VALUES ($1, $2, $3, %[1]s, %[1]s)
+		ON CONFLICT (paper) DO UPDATE SET worker = excluded.worker, token = excluded.token,
+			claimed = excluded.claimed, heartbeat = excluded.heartbeat
+		WHERE paper_claims.worker = excluded.worker OR paper_claims.heartbeat < %[1]s - $4`, store.dialect.now),
+		paperID, worker, token, timeout.Microseconds()))
+	if err != nil {
+		return PaperClaim{}, errwrap.Wrapf("Failed to claim paper: {{err}}", err)
+	}
+	if ok == false {
+		return PaperClaim{}, store.holder(paperID)
+	}
+	now := time.Now().UTC()
+	return PaperClaim{Worker: worker, Token: token, Claimed: now, Heartbeat: now}, nil
+}
+
+func (store *SQLClaimStore) Heartbeat(paperID string, claim PaperClaim) error {
+
+	ok, err := changed(store.db.Exec(fmt.Sprintf(`UPDATE paper_claims SET heartbeat = %s WHERE paper = $1 AND token = $2`,
+		store.dialect.now), paperID, claim.Token))
+	if err != nil {
+		return errwrap.Wrapf("Failed to send claim heartbeat: {{err}}", err)
+	}
+	if ok == false {
+		return store.holder(paperID)
+	}
+	return nil
+}
+
+func (store *SQLClaimStore) Release(paperID string, claim PaperClaim) error {
+	_, err := store.db.Exec(`DELETE FROM paper_claims WHERE paper = $1 AND token = $2`, paperID, claim.Token)
+	return err
+}
@@ -0,0 +1,158 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Once an article is on the server people curate it, so before changing a page or item we've written
+// before we check that nobody else has edited it since our last write, and stop rather than overwrite
+// their work unless the operator tells us to. Only edits by other users count, so that a run that died
+// before saving the revisions it made doesn't trip over its own edits.
+
+type EditConflict struct {
+	Title    string
+	Revision int // The revision made by our last write
+	Editors  []string
+}
+
+func (conflict EditConflict) String() string {
+	return fmt.Sprintf("%s edited by %s since revision %d", conflict.Title, strings.Join(conflict.Editors, ", "),
+		conflict.Revision)
+}
+
+type EditConflictError struct {
+	Conflicts []EditConflict
+}
+
+func (e *EditConflictError) Error() string {
+	descriptions := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		descriptions[i] = conflict.String()
+	}
+	return fmt.Sprintf("Someone else has edited the article since we last did (%s), use -force to overwrite their changes",
+		strings.Join(descriptions, "; "))
+}
+
+// ourUsers is the set of users our accounts write as, which is only looked up once.
+func (c *ScienceSourceClient) ourUsers(ctx context.Context) (map[string]bool, error) {
+
+	c.usersLock.Lock()
+	defer c.usersLock.Unlock()
+
+	if c.users == nil {
+		users, err := c.network.AccountUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.users = make(map[string]bool, len(users))
+		for _, user := range users {
+			c.users[user] = true
+		}
+	}
+	return c.users, nil
+}
+
+// otherEditors returns the editors of the page since the given revision that aren't us
+func (c *ScienceSourceClient) otherEditors(ctx context.Context, title string, revision int) ([]string, error) {
+
+	ours, err := c.ourUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	editors, err := c.network.EditorsSince(ctx, title, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(editors))
+	for _, editor := range editors {
+		if ours[editor] == false {
+			res = append(res, editor)
+		}
+	}
+	return res, nil
+}
+
+// resolveEditConflicts returns an EditConflictError for the conflicts, unless we're forcing on, in which
+// case they're just logged.
+func (c *ScienceSourceClient) resolveEditConflicts(conflicts []EditConflict) error {
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if c.Force {
+		for _, conflict := range conflicts {
+			log.Printf("Overwriting changes to %v", conflict)
+		}
+		return nil
+	}
+	return &EditConflictError{Conflicts: conflicts}
+}
+
+// CheckItemsForEdits makes sure nobody else has edited the article's items since we last wrote them.
+// The entities are as fetched from the server, and items we have no revision for, or that aren't there,
+// are skipped.
+func (c *ScienceSourceClient) CheckItemsForEdits(ctx context.Context, article *ScienceSourceArticle, entities map[string]wikibase.Entity) error {
+
+	revisions := map[string]int{string(article.ID): article.Revision}
+	for _, anchor := range article.Annotations {
+		revisions[string(anchor.ID)] = anchor.Revision
+		revisions[string(anchor.Annotation.ID)] = anchor.Annotation.Revision
+	}
+
+	conflicts := make([]EditConflict, 0)
+	for _, id := range article.ItemIDs() {
+		entity, ok := entities[id]
+		revision := revisions[id]
+		if !ok || !entity.Exists() || revision == 0 || entity.LastRevID == revision {
+			continue
+		}
+		editors, err := c.otherEditors(ctx, entity.Title, revision)
+		if err != nil {
+			return err
+		}
+		if len(editors) != 0 {
+			conflicts = append(conflicts, EditConflict{Title: entity.Title, Revision: revision, Editors: editors})
+		}
+	}
+
+	return c.resolveEditConflicts(conflicts)
+}
+
+// CheckPageForEdits makes sure nobody else has edited the article page since we last did.
+func (c *ScienceSourceClient) CheckPageForEdits(ctx context.Context, article *ScienceSourceArticle) error {
+
+	if article.PageID == 0 || article.PageRevision == 0 {
+		return nil
+	}
+	current, err := c.network.CurrentPageRevision(ctx, article.PageID)
+	if err != nil || current == article.PageRevision {
+		return err
+	}
+
+	editors, err := c.otherEditors(ctx, article.ScienceSourceArticleTitle, article.PageRevision)
+	if err != nil || len(editors) == 0 {
+		return err
+	}
+	return c.resolveEditConflicts([]EditConflict{{Title: article.ScienceSourceArticleTitle,
+		Revision: article.PageRevision, Editors: editors}})
+}
@@ -0,0 +1,116 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Converted text can carry control characters over from the paper's XML, along with byte order marks
+// and the like, none of which belong on a wiki page: MediaWiki replaces most of them with U+FFFD when it
+// saves the page, which changes the length of the text under our anchor points. So before uploading we
+// either strip them or replace them ourselves, in the same way for the HTML and the text, and move the
+// anchor points to match. Whichever is asked for, line endings are made newlines, as MediaWiki does
+// that to CR LF pairs and lone carriage returns, and byte order marks are stripped.
+
+const (
+	ControlCharactersStrip   = "strip"
+	ControlCharactersReplace = "replace"
+	ControlCharactersKeep    = "keep"
+)
+
+// ControlCharacters says what to do with control characters in paper text and HTML before uploading.
+var ControlCharacters string = ControlCharactersStrip
+
+// ValidateControlCharacters checks ControlCharacters is something we know how to do.
+func ValidateControlCharacters() error {
+	return validateControlCharacters(ControlCharacters)
+}
+
+func validateControlCharacters(mode string) error {
+	switch mode {
+	case ControlCharactersStrip, ControlCharactersReplace, ControlCharactersKeep:
+		return nil
+	}
+	return fmt.Errorf("Control characters must be %s, %s, or %s, not %s", ControlCharactersStrip,
+		ControlCharactersReplace, ControlCharactersKeep, mode)
+}
+
+// hostileCharacter says whether MediaWiki would mangle the character: C0 and C1 controls other than tab
+// and newline, delete, byte order marks, and the noncharacters at the end of the basic plane.
+func hostileCharacter(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n':
+		return false
+	case r < 0x20 || (r >= 0x7f && r <= 0x9f):
+		return true
+	case r == 0xfeff || r == 0xfffe || r == 0xffff:
+		return true
+	}
+	return false
+}
+
+// replaceControlCharacters returns the text with any hostile characters stripped or replaced as the mode
+// says, along with the edits that made it, one for each run of them. The text must be valid UTF-8.
+func replaceControlCharacters(text []byte, mode string) ([]byte, []textEdit) {
+
+	if mode == ControlCharactersKeep {
+		return text, nil
+	}
+
+	var res []byte
+	var edits []textEdit
+	for offset := 0; offset < len(text); {
+		r, size := utf8.DecodeRune(text[offset:])
+		if hostileCharacter(r) == false {
+			if res != nil {
+				res = append(res, text[offset:offset+size]...)
+			}
+			offset += size
+			continue
+		}
+
+		// Only copy the text once we know it needs changing
+		if res == nil {
+			res = make([]byte, offset, len(text))
+			copy(res, text[:offset])
+			edits = make([]textEdit, 0)
+		}
+		var replacement []byte
+		switch {
+		case r == '\r' && offset+1 < len(text) && text[offset+1] == '\n':
+		case r == '\r':
+			replacement = []byte("\n")
+		case r == 0xfeff:
+		case mode == ControlCharactersReplace:
+			replacement = []byte(string(utf8.RuneError))
+		}
+		if last := len(edits) - 1; last >= 0 && edits[last].OldEnd == offset {
+			edits[last].OldEnd += size
+			edits[last].NewEnd += len(replacement)
+		} else {
+			edits = append(edits, textEdit{OldStart: offset, OldEnd: offset + size,
+				NewStart: len(res), NewEnd: len(res) + len(replacement)})
+		}
+		res = append(res, replacement...)
+		offset += size
+	}
+
+	if res == nil {
+		return text, nil
+	}
+	return res, edits
+}
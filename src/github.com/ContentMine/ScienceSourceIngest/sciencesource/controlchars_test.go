@@ -0,0 +1,118 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplaceControlCharacters(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		mode  string
+		input string
+		want  string
+		edits []textEdit
+	}{
+		{"nothing to do", ControlCharactersStrip, "plain text", "plain text", nil},
+		{"tabs and newlines are kept", ControlCharactersStrip, "tab\tand\nnewline", "tab\tand\nnewline", nil},
+		{"strip NUL", ControlCharactersStrip, "a\x00b", "ab", []textEdit{{1, 2, 1, 1}}},
+		{"replace NUL", ControlCharactersReplace, "a\x00b", "a\ufffdb", []textEdit{{1, 2, 1, 4}}},
+		{"adjacent controls are one edit", ControlCharactersStrip, "a\x00\x01\x02b", "ab", []textEdit{{1, 4, 1, 1}}},
+		{"separate runs are separate edits", ControlCharactersStrip, "\x00a\x01", "a",
+			[]textEdit{{0, 1, 0, 0}, {2, 3, 1, 1}}},
+		{"replace each of a run", ControlCharactersReplace, "a\x00\x01b", "a\ufffd\ufffdb", []textEdit{{1, 3, 1, 7}}},
+		{"CR LF becomes LF", ControlCharactersStrip, "line\r\nnext", "line\nnext", []textEdit{{4, 5, 4, 4}}},
+		{"lone CR becomes LF", ControlCharactersStrip, "line\rnext", "line\nnext", []textEdit{{4, 5, 4, 5}}},
+		{"lone CR becomes LF when replacing", ControlCharactersReplace, "line\rnext", "line\nnext", []textEdit{{4, 5, 4, 5}}},
+		{"CR at the end", ControlCharactersStrip, "end\r", "end\n", []textEdit{{3, 4, 3, 4}}},
+		{"BOM is stripped", ControlCharactersStrip, "\ufeffstart", "start", []textEdit{{0, 3, 0, 0}}},
+		{"BOM is stripped when replacing", ControlCharactersReplace, "\ufeffstart", "start", []textEdit{{0, 3, 0, 0}}},
+		{"DEL", ControlCharactersReplace, "a\x7fb", "a\ufffdb", []textEdit{{1, 2, 1, 4}}},
+		{"C1 control", ControlCharactersStrip, "a\u0085b", "ab", []textEdit{{1, 3, 1, 1}}},
+		{"noncharacter", ControlCharactersStrip, "a\ufffeb\uffff", "ab", []textEdit{{1, 4, 1, 1}, {5, 8, 2, 2}}},
+		{"multibyte text around controls", ControlCharactersStrip, "café\x07naïve", "cafénaïve", []textEdit{{5, 6, 5, 5}}},
+		{"keep leaves everything", ControlCharactersKeep, "a\x00b\r\n\ufeff", "a\x00b\r\n\ufeff", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, edits := replaceControlCharacters([]byte(test.input), test.mode)
+			if string(got) != test.want {
+				t.Errorf("Got %q, expected %q", got, test.want)
+			}
+			if !reflect.DeepEqual(edits, test.edits) {
+				t.Errorf("Got edits %v, expected %v", edits, test.edits)
+			}
+		})
+	}
+}
+
+func TestControlCharactersMoveOffsets(t *testing.T) {
+
+	input := "\ufeffIntro\x00\x01 aspirin\r\nthen\u0085 fibrin\rend"
+
+	tests := []struct {
+		mode string
+		term string
+		want int // offset of the term in the cleaned text
+	}{
+		{ControlCharactersStrip, "aspirin", 6},
+		{ControlCharactersStrip, "fibrin", 19},
+		{ControlCharactersReplace, "aspirin", 12},
+		{ControlCharactersReplace, "fibrin", 28},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode+" "+test.term, func(t *testing.T) {
+			const bodyOffset = 7
+			article := anchoredArticle(t, input, bodyOffset, test.term)
+
+			cleaning := textCleaning{controlCharacters: test.mode, normalisationForm: NormalisationNone}
+			cleaned, passes := cleanText([]byte(input), cleaning)
+			err := article.applyCleaning(cleaned, passes, cleaning, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset := article.Annotations[0].CharacterNumber - bodyOffset
+			if offset != test.want {
+				t.Errorf("Moved to %d, expected %d in %q", offset, test.want, cleaned)
+			}
+			if string(cleaned[offset:offset+len(test.term)]) != test.term {
+				t.Errorf("Anchor at %d doesn't line up with %q in %q", offset, test.term, cleaned)
+			}
+		})
+	}
+}
+
+func TestValidateControlCharacters(t *testing.T) {
+
+	for _, test := range []struct {
+		mode  string
+		valid bool
+	}{
+		{ControlCharactersStrip, true},
+		{ControlCharactersReplace, true},
+		{ControlCharactersKeep, true},
+		{"Strip", false},
+		{"", false},
+	} {
+		if err := validateControlCharacters(test.mode); (err == nil) != test.valid {
+			t.Errorf("%q: got %v", test.mode, err)
+		}
+	}
+}
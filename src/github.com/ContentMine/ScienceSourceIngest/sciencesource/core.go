@@ -0,0 +1,128 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	europmc "github.com/ContentMine/go-europmc"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// CORE aggregates open access papers from institutional repositories, so often has the text of papers
+// that never made it to Europe PMC. It only has the plain text, so we wrap that and CORE's metadata up
+// as JATS. The API needs a key, which is free from https://core.ac.uk/services/api, given in the
+// CORE_API_KEY environment variable. See https://api.core.ac.uk/docs/v3
+
+const coreSearchURL string = "https://api.core.ac.uk/v3/search/works?%s"
+const coreWorkURL string = "https://core.ac.uk/works/%d"
+
+type coreSource struct{}
+
+func (coreSource) Name() string {
+	return "core"
+}
+
+type coreWork struct {
+	ID            int    `json:"id"`
+	DOI           string `json:"doi"`
+	Title         string `json:"title"`
+	Abstract      string `json:"abstract"`
+	FullText      string `json:"fullText"`
+	PublishedDate string `json:"publishedDate"`
+	YearPublished int    `json:"yearPublished"`
+	Authors       []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Journals []struct {
+		Title string `json:"title"`
+	} `json:"journals"`
+}
+
+type coreSearchResponse struct {
+	Results []coreWork `json:"results"`
+}
+
+func (work coreWork) plainTextPaper(paper Paper) convert.PlainTextPaper {
+
+	res := convert.PlainTextPaper{
+		Title:    work.Title,
+		DOI:      work.DOI,
+		PMCID:    paper.ID(),
+		Abstract: work.Abstract,
+		Text:     work.FullText,
+		Authors:  make([]europmc.ContributorName, 0, len(work.Authors)),
+	}
+	if len(work.Journals) != 0 {
+		res.Journal = work.Journals[0].Title
+	}
+	for _, author := range work.Authors {
+		res.Authors = append(res.Authors, convert.SplitAuthorName(author.Name))
+	}
+	if date, err := time.Parse("2006-01-02T15:04:05", work.PublishedDate); err == nil {
+		res.PublicationDate, res.PublicationDatePrecision = date, wikibase.TimePrecisionDay
+	} else if work.YearPublished != 0 {
+		res.PublicationDate = time.Date(work.YearPublished, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.PublicationDatePrecision = wikibase.TimePrecisionYear
+	}
+	return res
+}
+
+func (coreSource) FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error) {
+
+	key := os.Getenv("CORE_API_KEY")
+	if len(key) == 0 {
+		return "", fmt.Errorf("CORE needs an API key in $CORE_API_KEY")
+	}
+
+	// Search by DOI if we can, otherwise by title, which has to then match exactly
+	query := url.Values{}
+	if len(paper.DOI.Value) != 0 {
+		query.Set("q", fmt.Sprintf("doi:%q", NormaliseDOI(paper.DOI.Value)))
+	} else {
+		query.Set("q", fmt.Sprintf("title:%q", paper.Title.Value))
+	}
+	query.Set("limit", "10")
+
+	var response coreSearchResponse
+	err := getJSON(ctx, fmt.Sprintf(coreSearchURL, query.Encode()),
+		http.Header{"Authorization": []string{"Bearer " + key}}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	for _, work := range response.Results {
+		if len(strings.TrimSpace(work.FullText)) == 0 {
+			continue
+		}
+		if len(paper.DOI.Value) == 0 && strings.EqualFold(strings.TrimSpace(work.Title), strings.TrimSpace(paper.Title.Value)) == false {
+			continue
+		}
+		err = convert.SavePlainTextAsJATS(filename, work.plainTextPaper(paper))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(coreWorkURL, work.ID), nil
+	}
+	return "", fmt.Errorf("CORE has no full text for %s", paper.ID())
+}
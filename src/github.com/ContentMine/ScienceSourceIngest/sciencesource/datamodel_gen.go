@@ -0,0 +1,240 @@
+// Code generated by gen_datamodel.go from datamodel.json. DO NOT EDIT.
+
+package sciencesource
+
+import (
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+type ScienceSourceAnnotation struct {
+	// Exists partly to let us look up the item ID on sci source, and as a place to store the uploaded
+	// wikibase item ID when we cache state to disk
+	wikibase.ItemHeader `json:"item" item:"annotation"`
+
+	// These fields we know beforehand
+	TermFound         string    `json:"term" property:"term found"`
+	LengthOfTermFound int       `json:"length" property:"length of term found"`
+	WikiDataItemCode  string    `json:"wikidata" property:"Wikidata item code"`
+	DictionaryName    string    `json:"dictionary" property:"dictionary name"`
+	TimeCode          time.Time `json:"time" property:"time code1"`
+
+	// These fields we only know from the science source instance
+	InstanceOf wikibase.ItemPropertyType `json:"instance_of" property:"instance of"`
+
+	// These we only know after we've uploaded the article document
+	ScienceSourceArticleTitle string `json:"science_source_title" property:"ScienceSource article title"`
+
+	// These fields we know after we've created the anchor point item
+	BasedOn wikibase.ItemPropertyType `json:"based_on" property:"based on,omitoncreate"` // Ref to article
+
+	// Internal program management
+	DictionaryVersion string `json:"dictionary_version,omitempty"` // Which version of the dictionary found the term, if known
+	Revision          int    `json:"revision,omitempty"`           // The revision of the item made by our latest write to it
+}
+
+func (item *ScienceSourceAnnotation) itemID() wikibase.ItemPropertyType {
+	return item.ID
+}
+
+func (item *ScienceSourceAnnotation) claims() []itemClaim {
+	res := make([]itemClaim, 0, 8)
+	res = append(res, itemClaim{Property: "term found", Value: item.TermFound})
+	res = append(res, itemClaim{Property: "length of term found", Value: item.LengthOfTermFound})
+	res = append(res, itemClaim{Property: "Wikidata item code", Value: item.WikiDataItemCode})
+	res = append(res, itemClaim{Property: "dictionary name", Value: item.DictionaryName})
+	res = append(res, itemClaim{Property: "time code1", Value: item.TimeCode, Precision: wikibase.TimePrecisionDay})
+	res = append(res, itemClaim{Property: "instance of", Value: item.InstanceOf})
+	res = append(res, itemClaim{Property: "ScienceSource article title", Value: item.ScienceSourceArticleTitle})
+	res = append(res, itemClaim{Property: "based on", Value: item.BasedOn})
+	return res
+}
+
+type ScienceSourceAnchorPoint struct {
+	// Exists partly to let us look up the item ID on sci source, and as a place to store the uploaded
+	// wikibase item ID when we cache state to disk
+	wikibase.ItemHeader `json:"item" item:"anchor point"`
+
+	// These fields we know beforehand
+	PrecedingPhrase     string    `json:"preceding_phrase" property:"preceding phrase"`
+	FollowingPhrase     string    `json:"following_phrase" property:"following phrase"`
+	DistanceToPreceding *int      `json:"preceding_distance,omitempty" property:"distance to preceding"`
+	DistanceToFollowing *int      `json:"following_distance,omitempty" property:"distance to following"`
+	CharacterNumber     int       `json:"character" property:"character number"`
+	TimeCode            time.Time `json:"time" property:"time code1"`
+
+	// These fields we only know from the science source instance
+	InstanceOf wikibase.ItemPropertyType `json:"instance_of" property:"instance of"`
+
+	// These we only know after we've uploaded the article document
+	ScienceSourceArticleTitle string `json:"science_source_title" property:"ScienceSource article title"`
+
+	// These fields we know after we've created the article item
+	AnchorPoint wikibase.ItemPropertyType `json:"point" property:"anchor point in,omitoncreate"` // Ref to article
+
+	// These we only know once we've uploaded all the annotations
+	PrecedingAnchorPoint *wikibase.ItemPropertyType `json:"preceding_anchor,omitempty" property:"preceding anchor point,omitoncreate"` // Ref to anchor point/article
+	FollowingAnchorPoint wikibase.ItemPropertyType  `json:"following_anchor" property:"following anchor point,omitoncreate"`           // Ref to anchor point/terminus
+	Anchors              wikibase.ItemPropertyType  `json:"anchors" property:"anchors,omitoncreate"`
+
+	// Internal program management
+	Annotation  ScienceSourceAnnotation `json:"annotation"`
+	TextChanged bool                    `json:"text_changed,omitempty"` // Set if a text update changed the text around the anchor, so it needs checking
+	Revision    int                     `json:"revision,omitempty"`     // The revision of the item made by our latest write to it
+}
+
+func (item *ScienceSourceAnchorPoint) itemID() wikibase.ItemPropertyType {
+	return item.ID
+}
+
+func (item *ScienceSourceAnchorPoint) claims() []itemClaim {
+	res := make([]itemClaim, 0, 12)
+	res = append(res, itemClaim{Property: "preceding phrase", Value: item.PrecedingPhrase})
+	res = append(res, itemClaim{Property: "following phrase", Value: item.FollowingPhrase})
+	if item.DistanceToPreceding != nil {
+		res = append(res, itemClaim{Property: "distance to preceding", Value: *item.DistanceToPreceding})
+	}
+	if item.DistanceToFollowing != nil {
+		res = append(res, itemClaim{Property: "distance to following", Value: *item.DistanceToFollowing})
+	}
+	res = append(res, itemClaim{Property: "character number", Value: item.CharacterNumber})
+	res = append(res, itemClaim{Property: "time code1", Value: item.TimeCode, Precision: wikibase.TimePrecisionDay})
+	res = append(res, itemClaim{Property: "instance of", Value: item.InstanceOf})
+	res = append(res, itemClaim{Property: "ScienceSource article title", Value: item.ScienceSourceArticleTitle})
+	res = append(res, itemClaim{Property: "anchor point in", Value: item.AnchorPoint})
+	if item.PrecedingAnchorPoint != nil {
+		res = append(res, itemClaim{Property: "preceding anchor point", Value: *item.PrecedingAnchorPoint})
+	}
+	res = append(res, itemClaim{Property: "following anchor point", Value: item.FollowingAnchorPoint})
+	res = append(res, itemClaim{Property: "anchors", Value: item.Anchors})
+	return res
+}
+
+type ScienceSourceArticle struct {
+	// Exists partly to let us look up the item ID on sci source, and as a place to store the uploaded
+	// wikibase item ID when we cache state to disk
+	wikibase.ItemHeader `json:"item" item:"article"`
+
+	// These fields we know beforehand
+	ScienceSourceArticleTitle string                     `json:"science_source_title" property:"ScienceSource article title"`
+	WikiDataItemCode          string                     `json:"wikidata" property:"Wikidata item code"`
+	DOI                       *string                    `json:"doi,omitempty" property:"DOI"`        // From the feed, or failing that the paper
+	PMID                      *string                    `json:"pmid,omitempty" property:"PubMed ID"` // From the paper, if it is in PubMed
+	PMCID                     *string                    `json:"pmcid,omitempty" property:"PMCID"`
+	Language                  *string                    `json:"language,omitempty" property:"language code"` // ISO 639-1, from the paper or guessed from its text
+	ArticleTextTitle          string                     `json:"title" property:"article text title"`
+	PublicationDate           *time.Time                 `json:"publication_date,omitempty" property:"publication date"` // Only set if known to the day
+	TimeCode                  time.Time                  `json:"time" property:"time code1"`
+	CharacterNumber           int                        `json:"character" property:"character number"` // always 0?
+	PrecedingPhrase           *string                    `json:"preceding_phrase,omitempty" property:"preceding phrase"`
+	FollowingPhrase           *string                    `json:"following_phrase,omitempty" property:"following phrase"`
+	PrecedingAnchorPoint      *wikibase.ItemPropertyType `json:"preceding_anchor,omitempty" property:"preceding anchor point"` // Always nil on article
+
+	// These fields we only know from the science source instance
+	InstanceOf wikibase.ItemPropertyType `json:"instance_of" property:"instance of"`
+
+	// These we only know after we've uploaded the article
+	PageID int `json:"page_id" property:"page ID"`
+
+	// These we only know once we've uploaded all the annotations
+	FollowingAnchorPoint wikibase.ItemPropertyType `json:"following_anchor" property:"following anchor point,omitoncreate"`
+
+	// Internal program management
+	Annotations          []ScienceSourceAnchorPoint `json:"annotations"`
+	PublicationDateValue *wikibase.WikibaseTime     `json:"publication_date_value,omitempty"` // Set if the date is less precise than a day
+	PublicationDateClaim string                     `json:"publication_date_claim,omitempty"` // Set once we've uploaded the above
+	BodyOffset           int                        `json:"body_offset,omitempty"`            // Added to all character numbers to allow for a custom header
+	Complete             bool                       `json:"complete,omitempty"`               // Set once everything is uploaded
+	Figures              []ArticleFigure            `json:"figures,omitempty"`                // Only set if figures are uploaded as files
+	TextSource           string                     `json:"text_source,omitempty"`            // Which source the paper XML came from
+	TextURL              string                     `json:"text_url,omitempty"`               // Where the paper XML came from
+	AbstractOnly         bool                       `json:"abstract_only,omitempty"`          // Set if only terms in the abstract were annotated
+	Subjects             []ScienceSourceAnnotation  `json:"subjects,omitempty"`               // Annotations about the whole article, such as MeSH headings, based on the article item
+	MainSubjects         []ArticleSubject           `json:"main_subjects,omitempty"`          // Wikidata items the article is about, from its keywords or annotations
+	Authors              []ArticleAuthor            `json:"authors,omitempty"`                // Only set if affiliations are looked up
+	Funding              []ArticleFunding           `json:"funding,omitempty"`                // The paper's funders and grants, from its XML
+	Notices              []EditorialNotice          `json:"notices,omitempty"`                // Retractions and expressions of concern for the paper
+	Citations            []ArticleCitation          `json:"citations,omitempty"`              // Only set once the cited works have been looked up
+	PendingItems         *PendingItemCreation       `json:"pending_items,omitempty"`          // Set whilst items are being created, so a later attempt can find any whose creation we never heard back about
+	WikidataClaim        string                     `json:"wikidata_claim,omitempty"`         // The claim on the paper's Wikidata item pointing back at the article, once written
+	Revision             int                        `json:"revision,omitempty"`               // The revision of the item made by our latest write to it
+	PageRevision         int                        `json:"page_revision,omitempty"`          // The revision of the article page made by our latest edit of it
+}
+
+func (item *ScienceSourceArticle) itemID() wikibase.ItemPropertyType {
+	return item.ID
+}
+
+func (item *ScienceSourceArticle) claims() []itemClaim {
+	res := make([]itemClaim, 0, 16)
+	res = append(res, itemClaim{Property: "ScienceSource article title", Value: item.ScienceSourceArticleTitle})
+	res = append(res, itemClaim{Property: "Wikidata item code", Value: item.WikiDataItemCode})
+	if item.DOI != nil {
+		res = append(res, itemClaim{Property: "DOI", Value: *item.DOI})
+	}
+	if item.PMID != nil {
+		res = append(res, itemClaim{Property: "PubMed ID", Value: *item.PMID})
+	}
+	if item.PMCID != nil {
+		res = append(res, itemClaim{Property: "PMCID", Value: *item.PMCID})
+	}
+	if item.Language != nil {
+		res = append(res, itemClaim{Property: "language code", Value: *item.Language})
+	}
+	res = append(res, itemClaim{Property: "article text title", Value: item.ArticleTextTitle})
+	if item.PublicationDate != nil {
+		res = append(res, itemClaim{Property: "publication date", Value: *item.PublicationDate, Precision: wikibase.TimePrecisionDay})
+	}
+	res = append(res, itemClaim{Property: "time code1", Value: item.TimeCode, Precision: wikibase.TimePrecisionDay})
+	res = append(res, itemClaim{Property: "character number", Value: item.CharacterNumber})
+	if item.PrecedingPhrase != nil {
+		res = append(res, itemClaim{Property: "preceding phrase", Value: *item.PrecedingPhrase})
+	}
+	if item.FollowingPhrase != nil {
+		res = append(res, itemClaim{Property: "following phrase", Value: *item.FollowingPhrase})
+	}
+	if item.PrecedingAnchorPoint != nil {
+		res = append(res, itemClaim{Property: "preceding anchor point", Value: *item.PrecedingAnchorPoint})
+	}
+	res = append(res, itemClaim{Property: "instance of", Value: item.InstanceOf})
+	res = append(res, itemClaim{Property: "page ID", Value: item.PageID})
+	res = append(res, itemClaim{Property: "following anchor point", Value: item.FollowingAnchorPoint})
+	return res
+}
+
+// The properties we need on the server, and their data types
+var schemaProperties = []SchemaProperty{
+	{Label: "DOI", DataType: "external-id"},
+	{Label: "PMCID", DataType: "external-id"},
+	{Label: "PubMed ID", DataType: "external-id"},
+	{Label: "ScienceSource article title", DataType: "string"},
+	{Label: "Wikidata item code", DataType: "external-id"},
+	{Label: "anchor point in", DataType: "wikibase-item"},
+	{Label: "anchors", DataType: "wikibase-item"},
+	{Label: "article text title", DataType: "string"},
+	{Label: "based on", DataType: "wikibase-item"},
+	{Label: "character number", DataType: "quantity"},
+	{Label: "dictionary name", DataType: "string"},
+	{Label: "distance to following", DataType: "quantity"},
+	{Label: "distance to preceding", DataType: "quantity"},
+	{Label: "following anchor point", DataType: "wikibase-item"},
+	{Label: "following phrase", DataType: "string"},
+	{Label: "instance of", DataType: "wikibase-item"},
+	{Label: "language code", DataType: "string"},
+	{Label: "length of term found", DataType: "quantity"},
+	{Label: "page ID", DataType: "quantity"},
+	{Label: "preceding anchor point", DataType: "wikibase-item"},
+	{Label: "preceding phrase", DataType: "string"},
+	{Label: "publication date", DataType: "time"},
+	{Label: "term found", DataType: "string"},
+	{Label: "time code1", DataType: "time"},
+}
+
+// The items we need on the server
+var schemaItems = []string{
+	"anchor point",
+	"annotation",
+	"article",
+	"terminus",
+}
@@ -0,0 +1,86 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The same paper can turn up in a feed under a different PMCID or Wikidata item, for instance if
+// Wikidata has duplicate items for it, so before uploading an article we look for pages with the same
+// title or that mention the same DOI. These are only possible duplicates, so the operator can tell us to
+// carry on regardless.
+
+type DuplicateArticleError struct {
+	Paper    string
+	Evidence []string
+}
+
+func (e *DuplicateArticleError) Error() string {
+	return fmt.Sprintf("Paper %s may already be on the server (%s), use -force to upload it anyway",
+		e.Paper, strings.Join(e.Evidence, "; "))
+}
+
+// FindDuplicates describes any pages on the server that look like they are for the same paper.
+func (c *ScienceSourceClient) FindDuplicates(ctx context.Context, paper Paper) ([]string, error) {
+
+	ours := paper.ScienceSourceArticleTitle()
+	res := make([]string, 0)
+
+	// Article pages are titled "title (PMCID)"
+	titles, err := c.network.PagesWithPrefix(ctx, fmt.Sprintf("%s (", paper.Title.Value))
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		if title != ours {
+			res = append(res, fmt.Sprintf("page %q has the same title", title))
+		}
+	}
+
+	// The DOI is on the talk page provenance notice, and may be in the article header
+	if doi := paper.DOI.Value; len(doi) > 0 {
+		titles, err := c.network.SearchPages(ctx, fmt.Sprintf("%q", doi))
+		if err != nil {
+			return nil, err
+		}
+		for _, title := range titles {
+			if title != ours && title != fmt.Sprintf("Talk:%s", ours) {
+				res = append(res, fmt.Sprintf("page %q mentions DOI %s", title, doi))
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// checkForDuplicates returns a DuplicateArticleError if the paper looks like it is already on the server.
+func (c *ScienceSourceClient) checkForDuplicates(ctx context.Context, paper Paper) error {
+
+	if c.Force {
+		return nil
+	}
+
+	evidence, err := c.FindDuplicates(ctx, paper)
+	if err != nil {
+		return err
+	}
+	if len(evidence) > 0 {
+		return &DuplicateArticleError{Paper: paper.ID(), Evidence: evidence}
+	}
+	return nil
+}
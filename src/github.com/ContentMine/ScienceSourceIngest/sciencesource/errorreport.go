@@ -0,0 +1,322 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// When the same failure keeps coming up across many runs it's easier to spot in one place than in log
+// files, so failed papers can be sent to an error reporter along with what we know about the paper and
+// the request that failed. Reporters are given as a specification, and currently the only kind is
+// sentry:[DSN], which works with Sentry and anything that speaks its envelope API.
+
+type ErrorReport struct {
+	RunID   string
+	PaperID string
+	Err     error
+	Tags    map[string]string // Short values to group and filter on, such as the paper's state
+	Extra   map[string]string // Anything else that helps work out what happened
+	Stack   []StackFrame      // Only known if processing the paper panicked
+}
+
+type ErrorReporter interface {
+	Report(report ErrorReport) error
+}
+
+func NewErrorReporter(spec string) (ErrorReporter, error) {
+	if strings.HasPrefix(spec, "sentry:") {
+		return NewSentryReporter(strings.TrimPrefix(spec, "sentry:"))
+	}
+	return nil, fmt.Errorf("Error reporter %s should be of the form sentry:DSN", spec)
+}
+
+// Stack traces
+
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"filename"`
+	Line     int    `json:"lineno"`
+}
+
+// PanicError is a panic while processing a paper, turned into an error so the rest of the batch can
+// carry on, with the stack of where it happened.
+type PanicError struct {
+	Value interface{}
+	Stack []StackFrame
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func callerStack(skip int) []StackFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	res := make([]StackFrame, 0, n)
+	for {
+		frame, more := frames.Next()
+		res = append(res, StackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			break
+		}
+	}
+	return res
+}
+
+// ProcessPaperSafely processes the paper, turning a panic into a PanicError rather than letting it take
+// down the whole batch.
+func (processor PaperProcessor) ProcessPaperSafely(ctx context.Context, annotators []annotate.Annotator, sciSourceClient *ScienceSourceClient) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Skip callerStack, this function, and the runtime's panic machinery
+			err = &PanicError{Value: r, Stack: callerStack(3)}
+		}
+	}()
+	return processor.ProcessPaper(ctx, annotators, sciSourceClient)
+}
+
+// NewErrorReport gathers what we know about a paper that failed.
+func (processor PaperProcessor) NewErrorReport(runID string, urlBase string, err error) ErrorReport {
+
+	report := ErrorReport{
+		RunID:   runID,
+		PaperID: processor.Paper.ID(),
+		Err:     err,
+		Tags: map[string]string{
+			"paper_state": string(processor.State()),
+			"server":      urlBase,
+		},
+		Extra: map[string]string{
+			"wikidata": processor.Paper.WikiDataID(),
+			"doi":      processor.Paper.DOI.Value,
+			"title":    processor.Paper.Title.Value,
+		},
+	}
+
+	if panicErr, ok := err.(*PanicError); ok {
+		report.Stack = panicErr.Stack
+	}
+
+	// Which API call failed, and how, is usually the most useful thing to know
+	if apiErr, ok := errwrap.GetType(err, &wikibase.APIError{}).(*wikibase.APIError); ok && apiErr != nil {
+		report.Tags["api_error"] = apiErr.Code
+	}
+
+	if article, article_err := processor.Article(); article_err == nil {
+		report.Extra["article_item"] = string(article.ID)
+		report.Extra["page_id"] = strconv.Itoa(article.PageID)
+		report.Extra["annotations"] = strconv.Itoa(len(article.Annotations))
+	}
+
+	return report
+}
+
+// Sentry
+
+// Events are sent to Sentry's envelope endpoint, which is what its own SDKs use now that the older store
+// endpoint is deprecated. We only ever send one event at a time, so an envelope is just its header, the
+// header of the one item, and the event, each on a line of its own.
+
+type SentryReporter struct {
+	dsn         string
+	envelopeURL string
+	publicKey   string
+	client      http.Client
+}
+
+// NewSentryReporter takes a DSN of the form https://key@host[:port][/path]/project, as given by Sentry.
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, fmt.Errorf("Sentry DSN has no key")
+	}
+	project := strings.Trim(u.Path, "/")
+	if len(project) == 0 {
+		return nil, fmt.Errorf("Sentry DSN has no project")
+	}
+
+	// The project is the last part of the path, anything before it is where Sentry is hosted
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i != -1 {
+		prefix = "/" + project[:i]
+		project = project[i+1:]
+	}
+
+	return &SentryReporter{
+		dsn:         dsn,
+		envelopeURL: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		publicKey:   u.User.Username(),
+		client:      http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace *struct {
+		Frames []StackFrame `json:"frames"`
+	} `json:"stacktrace,omitempty"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Release     string            `json:"release,omitempty"`
+	Transaction string            `json:"transaction"`
+	Fingerprint []string          `json:"fingerprint"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryEnvelopeHeader struct {
+	EventID string `json:"event_id"`
+	SentAt  string `json:"sent_at"`
+	DSN     string `json:"dsn"`
+}
+
+type sentryItemHeader struct {
+	Type   string `json:"type"`
+	Length int    `json:"length"`
+}
+
+// envelope wraps the event up to be sent.
+func (r *SentryReporter) envelope(event sentryEvent, sent time.Time) ([]byte, error) {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(sentryEnvelopeHeader{EventID: event.EventID, SentAt: sent.UTC().Format(time.RFC3339), DSN: r.dsn})
+	if err != nil {
+		return nil, err
+	}
+	item, err := json.Marshal(sentryItemHeader{Type: "event", Length: len(payload)})
+	if err != nil {
+		return nil, err
+	}
+
+	var res bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		res.Write(line)
+		res.WriteByte('\n')
+	}
+	return res.Bytes(), nil
+}
+
+func (r *SentryReporter) Report(report ErrorReport) error {
+
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return err
+	}
+
+	exception := sentryException{
+		Type:  fmt.Sprintf("%T", report.Err),
+		Value: report.Err.Error(),
+	}
+	if len(report.Stack) > 0 {
+		// Sentry wants the outermost call first
+		frames := make([]StackFrame, len(report.Stack))
+		for i, frame := range report.Stack {
+			frames[len(frames)-1-i] = frame
+		}
+		exception.Stacktrace = &struct {
+			Frames []StackFrame `json:"frames"`
+		}{Frames: frames}
+	}
+
+	tags := map[string]string{"run_id": report.RunID, "paper": report.PaperID}
+	for key, value := range report.Tags {
+		tags[key] = value
+	}
+
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "ScienceSourceIngest",
+		Release:     Version,
+		Transaction: report.PaperID,
+		Tags:        tags,
+		Extra:       report.Extra,
+	}
+	// Group by the kind of failure rather than by paper, or every paper would be its own issue
+	event.Fingerprint = []string{exception.Type, tags["paper_state"], tags["api_error"]}
+	event.Exception.Values = []sentryException{exception}
+
+	body, err := r.envelope(event, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.envelopeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=ScienceSourceIngest/%s",
+		r.publicKey, Version))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Sentry returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ReportError sends the report to every reporter, logging any that fail.
+func ReportError(reporters []ErrorReporter, report ErrorReport) {
+	for _, reporter := range reporters {
+		if err := reporter.Report(report); err != nil {
+			log.Printf("Failed to report error for paper %s: %v", report.PaperID, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+)
+
+// Feeds often overlap, and a paper may have been ingested by another run with a different output
+// directory, so we can ask the server whether it already has an article item for a paper before doing
+// any work on it.
+
+// FindArticleItems returns the IDs of article items on the server for the paper's Wikidata item.
+func (c *ScienceSourceClient) FindArticleItems(ctx context.Context, paper Paper) ([]string, error) {
+
+	wikidataPropertyID, err := c.PropertyID("Wikidata item code")
+	if err != nil {
+		return nil, err
+	}
+	instanceOfID, err := c.PropertyID("instance of")
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := c.network.FindEntitiesWithStatement(ctx, wikidataPropertyID, paper.WikiDataID())
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	// Annotations record the Wikidata item of their term with the same property, so only keep articles
+	entities, err := c.network.GetEntities(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	article := string(c.wikiBaseClient.ItemMap["article"])
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		entity, ok := entities[id]
+		if !ok || !entity.Exists() {
+			continue
+		}
+		for _, class := range entity.ItemClaims(instanceOfID) {
+			if class == article {
+				res = append(res, id)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// alreadyIngested checks the server for an article item for the paper if we've not recorded one, and
+// returns its ID if there is one.
+func (c *ScienceSourceClient) alreadyIngested(ctx context.Context, paper Paper, record *ScienceSourceArticle) (string, error) {
+
+	if !c.SkipExisting || (record != nil && len(record.ID) != 0) {
+		return "", nil
+	}
+
+	ids, err := c.FindArticleItems(ctx, paper)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
@@ -0,0 +1,155 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// An export bundles everything we have for a paper into one ZIP file, for archiving or handing to someone
+// who doesn't have this tool: the XML we fetched, the HTML we uploaded, the text the annotation offsets
+// refer to, and the article's state as JSON, whatever format it is kept in locally. A manifest lists each
+// file with its SHA-256, so whoever gets the bundle can check nothing was lost or changed on the way.
+
+const ExportManifestFileName string = "manifest.json"
+
+// ErrNothingToExport is returned for papers we've not fetched yet.
+var ErrNothingToExport = errors.New("Paper has not been fetched yet")
+
+type ExportedFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type ExportManifest struct {
+	Paper    string         `json:"paper"`
+	Exported time.Time      `json:"exported"`
+	Version  string         `json:"version,omitempty"`
+	PageID   int            `json:"page_id,omitempty"`
+	ItemID   string         `json:"item_id,omitempty"`
+	Files    []ExportedFile `json:"files"`
+}
+
+// Export writes the paper's bundle to the given file, replacing any earlier export, and returns the
+// manifest it put in it. Files the paper hasn't got yet are left out, but there must at least be the XML.
+func (processor PaperProcessor) Export(filename string) (ExportManifest, error) {
+
+	manifest := ExportManifest{
+		Paper:    processor.Paper.ID(),
+		Exported: time.Now().UTC(),
+		Version:  Version,
+	}
+
+	if !fileExists(processor.targetXMLFileName()) {
+		return manifest, ErrNothingToExport
+	}
+
+	// The state is re-encoded as JSON, rather than copied, so the bundle doesn't depend on how we keep it
+	var state []byte
+	if fileExists(processor.targetScienceSourceStateFileName()) {
+		article, err := processor.Article()
+		if err != nil {
+			return manifest, errwrap.Wrapf("Failed to load article state: {{err}}", err)
+		}
+		state, err = json.MarshalIndent(article, "", "  ")
+		if err != nil {
+			return manifest, err
+		}
+		manifest.PageID = article.PageID
+		manifest.ItemID = string(article.ID)
+	}
+
+	err := writeFileAtomically(filename, func(w io.Writer) error {
+		return writeExport(w, processor, state, &manifest)
+	})
+	return manifest, err
+}
+
+func writeExport(w io.Writer, processor PaperProcessor, state []byte, manifest *ExportManifest) error {
+
+	archive := zip.NewWriter(w)
+
+	for _, filename := range []string{processor.targetXMLFileName(), processor.targetHTMLFileName(),
+		processor.targetTextFileName()} {
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		file, err := addToExport(archive, path.Base(filename), manifest.Exported, data)
+		if err != nil {
+			return errwrap.Wrapf("Failed to add "+path.Base(filename)+" to export: {{err}}", err)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if state != nil {
+		file, err := addToExport(archive, "scisource.json", manifest.Exported, state)
+		if err != nil {
+			return errwrap.Wrapf("Failed to add article state to export: {{err}}", err)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// The manifest goes last, as it needs the checksums of everything else
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = addToExport(archive, ExportManifestFileName, manifest.Exported, data)
+	if err != nil {
+		return errwrap.Wrapf("Failed to add manifest to export: {{err}}", err)
+	}
+
+	return archive.Close()
+}
+
+func addToExport(archive *zip.Writer, name string, modified time.Time, data []byte) (ExportedFile, error) {
+
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return ExportedFile{}, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return ExportedFile{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	return ExportedFile{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}, nil
+}
@@ -12,7 +12,7 @@
 //   See the License for the specific language governing permissions and
 //   limitations under the License.
 
-package main
+package sciencesource
 
 import (
 	"encoding/json"
@@ -22,6 +22,8 @@ import (
 	"time"
 
 	europmc "github.com/ContentMine/go-europmc"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
 )
 
 type Header struct {
@@ -44,6 +46,11 @@ type Paper struct {
 	MainSubjectLabel DataValue `json:"mainsubjectLabel"`
 	PMCID            DataValue `json:"pmcid"`
 	Title            DataValue `json:"title"`
+	DOI              DataValue `json:"doi"` // optional, only present if the query asks for it
+
+	// Also optional, and used to find the paper in Semantic Scholar
+	ArXiv           DataValue `json:"arxiv"`
+	SemanticScholar DataValue `json:"s2"`
 }
 
 type Results struct {
@@ -97,6 +104,12 @@ func (paper Paper) WikiDataID() string {
 	return ""
 }
 
-func (paper Paper) PublicationDate() (time.Time, error) {
-	return time.Parse(time.RFC3339, paper.Date.Value)
+// The title we give the article page and item on Science Source
+func (paper Paper) ScienceSourceArticleTitle() string {
+	return fmt.Sprintf("%s (%s)", paper.Title.Value, paper.ID())
+}
+
+// Not all feeds give a full date, so we also return how precise the date is
+func (paper Paper) PublicationDate() (time.Time, wikibase.TimePrecision, error) {
+	return wikibase.ParseTimeInput(paper.Date.Value)
 }
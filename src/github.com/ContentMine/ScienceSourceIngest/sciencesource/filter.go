@@ -0,0 +1,89 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filters let operators pick out a subset of a feed without editing it. Each filter value is one of:
+//
+//   PMC1234567     - a PubMed Central ID
+//   Q1234          - a Wikidata item ID
+//   10.1234/abcd   - a DOI
+//   state:name     - papers at the given stage of processing, e.g. state:converted
+
+var wikiDataIDPattern = regexp.MustCompile(`^Q[0-9]+$`)
+
+type PaperFilter struct {
+	Only []string
+	Skip []string
+}
+
+func (filter PaperFilter) Validate() error {
+	for _, value := range append(append([]string{}, filter.Only...), filter.Skip...) {
+		if strings.HasPrefix(value, "state:") {
+			state := PaperState(strings.TrimPrefix(value, "state:"))
+			found := false
+			for _, known := range PaperStates {
+				if known == state {
+					found = true
+				}
+			}
+			if found == false {
+				return fmt.Errorf("Unknown state in filter %s, expected one of %v", value, PaperStates)
+			}
+		}
+	}
+	return nil
+}
+
+func filterValueMatches(value string, paper Paper, state func() PaperState) bool {
+	switch {
+	case strings.HasPrefix(value, "state:"):
+		return PaperState(strings.TrimPrefix(value, "state:")) == state()
+	case strings.HasPrefix(strings.ToUpper(value), "PMC"):
+		return strings.EqualFold(value, paper.ID())
+	case wikiDataIDPattern.MatchString(value):
+		return value == paper.WikiDataID()
+	case strings.HasPrefix(value, "10."):
+		// DOIs are case insensitive
+		return strings.EqualFold(value, paper.DOI.Value)
+	}
+	return false
+}
+
+// Matches takes a function to get the state as working it out means going to disk, and most filters
+// won't need it.
+func (filter PaperFilter) Matches(paper Paper, state func() PaperState) bool {
+
+	for _, value := range filter.Skip {
+		if filterValueMatches(value, paper, state) {
+			return false
+		}
+	}
+
+	if len(filter.Only) == 0 {
+		return true
+	}
+	for _, value := range filter.Only {
+		if filterValueMatches(value, paper, state) {
+			return true
+		}
+	}
+	return false
+}
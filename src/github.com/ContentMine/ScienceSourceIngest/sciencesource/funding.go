@@ -0,0 +1,249 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Papers say who funded them in their front matter, which lets us record on each article item which
+// funders it came from, and the IDs of their grants. Each funder has one item on the server that all the
+// articles it funded link to with a "funded by" statement, and each grant ID is a qualifier on the
+// statement for the funder that gave it. Papers name the same funder in many ways, so where we can we
+// identify funders by their DOI in the Crossref Funder Registry, either given in the paper or found by
+// searching the registry for the name, and funder items carry it as a "funder DOI" statement. Funders
+// the registry doesn't know are found by label instead.
+// See https://www.crossref.org/services/funder-registry/
+
+const crossrefFundersURL string = "https://api.crossref.org/funders?rows=5&query=%s"
+
+// Funder Registry DOIs all have this prefix
+const funderRegistryPrefix string = "10.13039/"
+
+// The property on article items for their funders, and the qualifier on it for the grant
+const fundedByProperty string = "funded by"
+const grantIDProperty string = "grant ID"
+
+// The property on funder items for their Funder Registry DOI
+const funderDOIProperty string = "funder DOI"
+
+// What we know about each funder of an article, and how far through recording it we are
+type ArticleFunding struct {
+	Funder   string   `json:"funder"`
+	FunderID string   `json:"funder_id,omitempty"` // Usually a Funder Registry DOI
+	Grants   []string `json:"grants,omitempty"`
+	Item     string   `json:"item,omitempty"` // The funder's item on the server
+	Claim    string   `json:"claim,omitempty"`
+
+	// How many of the grants have been added to the claim as qualifiers
+	GrantsRecorded int `json:"grants_recorded,omitempty"`
+}
+
+// FundingFromMetadata turns the awards in the paper's XML into one entry per funder, merging awards
+// from the same funder.
+func FundingFromMetadata(awards []convert.FundingAward) []ArticleFunding {
+
+	res := make([]ArticleFunding, 0, len(awards))
+	known := make(map[string]int)
+	for _, award := range awards {
+		key := strings.ToLower(award.Funder)
+		i, ok := known[key]
+		if !ok {
+			i = len(res)
+			known[key] = i
+			res = append(res, ArticleFunding{Funder: award.Funder, FunderID: award.FunderID})
+		}
+		if len(res[i].FunderID) == 0 {
+			res[i].FunderID = award.FunderID
+		}
+		for _, grant := range award.AwardIDs {
+			found := false
+			for _, existing := range res[i].Grants {
+				if existing == grant {
+					found = true
+					break
+				}
+			}
+			if found == false {
+				res[i].Grants = append(res[i].Grants, grant)
+			}
+		}
+	}
+	return res
+}
+
+type crossrefFundersResponse struct {
+	Message struct {
+		Items []struct {
+			ID       string   `json:"id"`
+			Name     string   `json:"name"`
+			AltNames []string `json:"alt-names"`
+		} `json:"items"`
+	} `json:"message"`
+}
+
+// FunderDOI gives the Funder Registry DOI for a funder, using the ID from the paper if it is one, and
+// otherwise searching the registry for a funder with exactly the name given, returning "" if there isn't
+// one.
+func FunderDOI(ctx context.Context, name string, id string) (string, error) {
+
+	if i := strings.Index(id, funderRegistryPrefix); i != -1 {
+		return NormaliseDOI(id[i:]), nil
+	}
+
+	var response crossrefFundersResponse
+	err := getJSON(ctx, fmt.Sprintf(crossrefFundersURL, url.QueryEscape(name)), nil, &response)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range response.Message.Items {
+		names := append([]string{item.Name}, item.AltNames...)
+		for _, candidate := range names {
+			if strings.EqualFold(strings.TrimSpace(candidate), name) {
+				return funderRegistryPrefix + item.ID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// funderItem finds the item for a funder, by its DOI if it has one and by its name otherwise, creating one
+// if there isn't one yet, and remembers it for other articles.
+func (c *ScienceSourceClient) funderItem(ctx context.Context, name string, doi string) (string, error) {
+	c.fundersLock.Lock()
+	defer c.fundersLock.Unlock()
+
+	key := name
+	if len(doi) != 0 {
+		key = doi
+	}
+	if id, ok := c.funders[key]; ok {
+		return id, nil
+	}
+	if c.funders == nil {
+		c.funders = make(map[string]string)
+	}
+
+	var ids []string
+	var err error
+	property := ""
+	if len(doi) != 0 {
+		property, err = c.extraProperty(ctx, funderDOIProperty, "external-id")
+		if err != nil {
+			return "", err
+		}
+		ids, err = c.network.FindEntitiesWithStatement(ctx, property, doi)
+	} else {
+		ids, err = c.network.FindEntitiesByLabel(ctx, name, "item")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	id := ""
+	switch len(ids) {
+	case 0:
+		logging.Logf(logging.LogNormal, "Creating funder item %q", name)
+		id, err = c.network.CreateEntity(ctx, "item", name, "")
+		if err != nil {
+			return "", err
+		}
+		if len(doi) != 0 {
+			_, err = c.network.CreateClaim(ctx, id, property, doi)
+			if err != nil {
+				return "", err
+			}
+		}
+	case 1:
+		id = ids[0]
+	default:
+		return "", fmt.Errorf("Funder %q is not unique, found items %s", key, strings.Join(ids, ", "))
+	}
+	c.funders[key] = id
+	return id, nil
+}
+
+// RecordFunding makes a statement on the article item for each of its funders that doesn't have one yet,
+// and adds any grant IDs not yet recorded. The checkpoint is called after each change so the caller can
+// save the article.
+func (c *ScienceSourceClient) RecordFunding(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	for i := range article.Funding {
+		funding := &article.Funding[i]
+		if len(funding.Claim) != 0 && funding.GrantsRecorded == len(funding.Grants) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if len(funding.Claim) == 0 {
+			if len(funding.Item) == 0 {
+				doi, err := FunderDOI(ctx, funding.Funder, funding.FunderID)
+				if err != nil {
+					return err
+				}
+				if len(doi) != 0 {
+					funding.FunderID = doi
+				}
+				item, err := c.funderItem(ctx, funding.Funder, doi)
+				if err != nil {
+					return err
+				}
+				funding.Item = item
+			}
+			property, err := c.extraProperty(ctx, fundedByProperty, "wikibase-item")
+			if err != nil {
+				return err
+			}
+			value, err := wikibase.NewItemValue(funding.Item)
+			if err != nil {
+				return err
+			}
+			funding.Claim, err = c.network.CreateClaim(ctx, string(article.ID), property, value)
+			if err != nil {
+				return err
+			}
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+
+		for funding.GrantsRecorded < len(funding.Grants) {
+			property, err := c.extraProperty(ctx, grantIDProperty, "string")
+			if err != nil {
+				return err
+			}
+			err = c.network.SetQualifier(ctx, funding.Claim, property, funding.Grants[funding.GrantsRecorded])
+			if err != nil {
+				return err
+			}
+			funding.GrantsRecorded += 1
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
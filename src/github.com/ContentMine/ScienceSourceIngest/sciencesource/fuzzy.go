@@ -0,0 +1,112 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Labels on an instance get edited by hand, and it's easy for "Anchor point" to become "anchor point",
+// which shouldn't stop a run. When there's no exact match for a label we take the closest label search
+// finds, if it's close enough to accept automatically or the operator says it's right.
+
+// labelSimilarity scores how alike two labels are, from 0 for nothing in common to 1 for the same
+// label ignoring case.
+func labelSimilarity(a string, b string) float64 {
+	x := []rune(strings.ToLower(strings.TrimSpace(a)))
+	y := []rune(strings.ToLower(strings.TrimSpace(b)))
+
+	longest := len(x)
+	if len(y) > longest {
+		longest = len(y)
+	}
+	if longest == 0 {
+		return 1.0
+	}
+
+	// Levenshtein distance, keeping just the previous row
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+
+	return 1.0 - float64(previous[len(y)])/float64(longest)
+}
+
+// fuzzyResolveEntity returns the ID of the closest match for a label that has no exact match, or an
+// empty string if there's nothing we're willing to use.
+func (c *ScienceSourceClient) fuzzyResolveEntity(ctx context.Context, label string, entityType string) (string, error) {
+
+	if c.LabelAcceptThreshold <= 0.0 && c.LabelConfirmer == nil {
+		return "", nil
+	}
+
+	results, err := c.network.SearchEntities(ctx, label, entityType)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "", nil
+	}
+
+	best := results[0]
+	best_score := labelSimilarity(label, best.Label)
+	for _, result := range results[1:] {
+		score := labelSimilarity(label, result.Label)
+		if score > best_score {
+			best = result
+			best_score = score
+		}
+	}
+
+	if c.LabelAcceptThreshold > 0.0 && best_score >= c.LabelAcceptThreshold {
+		logging.Logf(logging.LogNormal, "Using %s %s %q for label %q (similarity %.2f)", entityType, best.ID,
+			best.Label, label, best_score)
+		return best.ID, nil
+	}
+
+	if c.LabelConfirmer != nil {
+		ok, err := c.LabelConfirmer.ConfirmLabel(label, entityType, best.ID, best.Label, best_score)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return best.ID, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,270 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// This generates datamodel_gen.go from datamodel.json, which describes the items and properties in
+// the ScienceSource data schema and the structs we use to hold them. Run it with "go generate".
+//
+// As well as the structs it generates the tables of property and item labels we need from the server,
+// and for each struct a claims method listing the statements an item should have from its fields, which
+// is what we upload. It checks that every property used has a data type, that the Go type of each field
+// can hold values of that data type, and that every time field says how precise it is, so the structs
+// and schema can't drift apart.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+)
+
+type fieldDefinition struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	JSON         string `json:"json"`
+	Property     string `json:"property"`
+	OmitOnCreate bool   `json:"omitoncreate"`
+	Precision    string `json:"precision"`
+	Note         string `json:"note"`
+}
+
+type groupDefinition struct {
+	Comment string            `json:"comment"`
+	Fields  []fieldDefinition `json:"fields"`
+}
+
+type typeDefinition struct {
+	Name   string            `json:"name"`
+	Item   string            `json:"item"`
+	Groups []groupDefinition `json:"groups"`
+}
+
+type dataModel struct {
+	Properties map[string]string `json:"properties"`
+	Items      []string          `json:"items"`
+	Types      []typeDefinition  `json:"types"`
+}
+
+// Which Go types can hold values of each wikibase data type
+var compatibleTypes = map[string][]string{
+	"string":        {"string", "*string"},
+	"external-id":   {"string", "*string"},
+	"quantity":      {"int", "*int"},
+	"time":          {"time.Time", "*time.Time"},
+	"wikibase-item": {"wikibase.ItemPropertyType", "*wikibase.ItemPropertyType"},
+}
+
+// The precisions a time field can be uploaded with, and the constant for each
+var timePrecisions = map[string]string{
+	"year":  "wikibase.TimePrecisionYear",
+	"month": "wikibase.TimePrecisionMonth",
+	"day":   "wikibase.TimePrecisionDay",
+}
+
+func checkModel(model dataModel) []string {
+
+	var problems []string
+	used := make(map[string]bool)
+
+	for _, t := range model.Types {
+		if len(t.Item) == 0 {
+			problems = append(problems, fmt.Sprintf("%s has no item label", t.Name))
+		}
+		for _, group := range t.Groups {
+			for _, field := range group.Fields {
+				if len(field.Property) == 0 {
+					continue
+				}
+				used[field.Property] = true
+
+				if strings.HasSuffix(field.Type, "time.Time") {
+					if _, ok := timePrecisions[field.Precision]; !ok {
+						problems = append(problems, fmt.Sprintf("%s.%s needs a precision of year, month, or day",
+							t.Name, field.Name))
+					}
+				} else if len(field.Precision) > 0 {
+					problems = append(problems, fmt.Sprintf("%s.%s has a precision but is not a time",
+						t.Name, field.Name))
+				}
+
+				dataType, ok := model.Properties[field.Property]
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s uses property %q which has no data type",
+						t.Name, field.Name, field.Property))
+					continue
+				}
+				compatible := false
+				for _, goType := range compatibleTypes[dataType] {
+					if goType == field.Type {
+						compatible = true
+					}
+				}
+				if !compatible {
+					problems = append(problems, fmt.Sprintf("%s.%s is %s, which can't hold property %q of type %s",
+						t.Name, field.Name, field.Type, field.Property, dataType))
+				}
+			}
+		}
+	}
+
+	for label := range model.Properties {
+		if !used[label] {
+			problems = append(problems, fmt.Sprintf("Property %q is not used by any type", label))
+		}
+	}
+
+	return problems
+}
+
+func writeStruct(out *bytes.Buffer, t typeDefinition) {
+
+	fmt.Fprintf(out, "type %s struct {\n", t.Name)
+	fmt.Fprintf(out, "\t// Exists partly to let us look up the item ID on sci source, and as a place to store the uploaded\n")
+	fmt.Fprintf(out, "\t// wikibase item ID when we cache state to disk\n")
+	fmt.Fprintf(out, "\twikibase.ItemHeader `json:\"item\" item:%q`\n", t.Item)
+
+	for _, group := range t.Groups {
+		fmt.Fprintf(out, "\n\t// %s\n", group.Comment)
+		for _, field := range group.Fields {
+			tag := fmt.Sprintf("json:%q", field.JSON)
+			if len(field.Property) > 0 {
+				property := field.Property
+				if field.OmitOnCreate {
+					property = property + ",omitoncreate"
+				}
+				tag = fmt.Sprintf("%s property:%q", tag, property)
+			}
+			fmt.Fprintf(out, "\t%s %s `%s`", field.Name, field.Type, tag)
+			if len(field.Note) > 0 {
+				fmt.Fprintf(out, " // %s", field.Note)
+			}
+			fmt.Fprintf(out, "\n")
+		}
+	}
+
+	fmt.Fprintf(out, "}\n\n")
+}
+
+// writeClaims writes the methods that give the item's ID and list its statements
+func writeClaims(out *bytes.Buffer, t typeDefinition) {
+
+	count := 0
+	for _, group := range t.Groups {
+		for _, field := range group.Fields {
+			if len(field.Property) > 0 {
+				count += 1
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "func (item *%s) itemID() wikibase.ItemPropertyType {\n\treturn item.ID\n}\n\n", t.Name)
+
+	fmt.Fprintf(out, "func (item *%s) claims() []itemClaim {\n", t.Name)
+	fmt.Fprintf(out, "\tres := make([]itemClaim, 0, %d)\n", count)
+	for _, group := range t.Groups {
+		for _, field := range group.Fields {
+			if len(field.Property) == 0 {
+				continue
+			}
+			value := "item." + field.Name
+			if strings.HasPrefix(field.Type, "*") {
+				value = "*" + value
+			}
+			claim := fmt.Sprintf("itemClaim{Property: %q, Value: %s}", field.Property, value)
+			if len(field.Precision) > 0 {
+				claim = fmt.Sprintf("itemClaim{Property: %q, Value: %s, Precision: %s}", field.Property, value,
+					timePrecisions[field.Precision])
+			}
+			if strings.HasPrefix(field.Type, "*") {
+				fmt.Fprintf(out, "\tif item.%s != nil {\n\t\tres = append(res, %s)\n\t}\n", field.Name, claim)
+			} else {
+				fmt.Fprintf(out, "\tres = append(res, %s)\n", claim)
+			}
+		}
+	}
+	fmt.Fprintf(out, "\treturn res\n}\n\n")
+}
+
+func main() {
+
+	data, err := ioutil.ReadFile("datamodel.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var model dataModel
+	err = json.Unmarshal(data, &model)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	problems := checkModel(model)
+	if len(problems) > 0 {
+		log.Fatalf("datamodel.json has problems:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gen_datamodel.go from datamodel.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package sciencesource\n\n")
+	fmt.Fprintf(&out, "import (\n\t\"time\"\n\n\t\"github.com/ContentMine/ScienceSourceIngest/wikibase\"\n)\n\n")
+
+	for _, t := range model.Types {
+		writeStruct(&out, t)
+		writeClaims(&out, t)
+	}
+
+	labels := make([]string, 0, len(model.Properties))
+	for label := range model.Properties {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	fmt.Fprintf(&out, "// The properties we need on the server, and their data types\n")
+	fmt.Fprintf(&out, "var schemaProperties = []SchemaProperty{\n")
+	for _, label := range labels {
+		fmt.Fprintf(&out, "\t{Label: %q, DataType: %q},\n", label, model.Properties[label])
+	}
+	fmt.Fprintf(&out, "}\n\n")
+
+	items := append([]string{}, model.Items...)
+	for _, t := range model.Types {
+		items = append(items, t.Item)
+	}
+	sort.Strings(items)
+
+	fmt.Fprintf(&out, "// The items we need on the server\n")
+	fmt.Fprintf(&out, "var schemaItems = []string{\n")
+	for _, item := range items {
+		fmt.Fprintf(&out, "\t%q,\n", item)
+	}
+	fmt.Fprintf(&out, "}\n")
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatalf("Generated code does not compile: %v\n%s", err, out.String())
+	}
+
+	err = ioutil.WriteFile("datamodel_gen.go", source, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// Operators can supply their own wiki template invocation (e.g. an infobox) to go at the top of each
+// article page. This is a Go text/template file, with the fields of HeaderTemplateFields available.
+//
+// Anything the template renders as visible text comes before the article body on the page, so every
+// anchor's character number needs shifting by that amount. We ask the server to render the template
+// for us to find out how long that is, rather than guessing at what the wiki templates expand to.
+
+type HeaderTemplateFields struct {
+	Title       string
+	WikiDataID  string
+	PMCID       string
+	DOI         string
+	License     string
+	Journal     string
+	MainSubject string
+}
+
+func LoadHeaderTemplate(path string) (*template.Template, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New(path).Parse(string(data))
+}
+
+func (processor PaperProcessor) renderHeaderTemplate() (string, error) {
+
+	if processor.HeaderTemplate == nil {
+		return "", nil
+	}
+
+	fields := HeaderTemplateFields{
+		Title:       processor.Paper.Title.Value,
+		WikiDataID:  processor.Paper.WikiDataID(),
+		PMCID:       processor.Paper.ID(),
+		DOI:         processor.Paper.DOI.Value,
+		License:     processor.Paper.LicenseLabel.Value,
+		Journal:     processor.Paper.JournalLabel.Value,
+		MainSubject: processor.Paper.MainSubjectLabel.Value,
+	}
+
+	var buf bytes.Buffer
+	err := processor.HeaderTemplate.Execute(&buf, fields)
+	if err != nil {
+		return "", err
+	}
+
+	res := buf.String()
+	if len(res) > 0 && strings.HasSuffix(res, "\n") == false {
+		res = res + "\n"
+	}
+	return res, nil
+}
@@ -0,0 +1,195 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"plugin"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Hooks let people run their own checks or notifications before and after each stage of processing a
+// paper without having to change this tool. A hook is either an external command, or a Go plugin that
+// exports a RunHook function. Either way the hook is given a JSON description of the event, including
+// the article record as it stands, and if a hook fails then processing of that paper stops.
+
+type HookTiming string
+
+const (
+	HookPre  HookTiming = "pre"
+	HookPost HookTiming = "post"
+)
+
+var HookStages = []PaperState{
+	PaperStateFetched,
+	PaperStateConverted,
+	PaperStateAnnotated,
+	PaperStateUploaded,
+	PaperStateCreated,
+}
+
+type HookEvent struct {
+	Stage     PaperState            `json:"stage"`
+	When      HookTiming            `json:"when"`
+	PaperID   string                `json:"paper"`
+	Directory string                `json:"directory"`
+	Article   *ScienceSourceArticle `json:"article"`
+}
+
+type Hook interface {
+	Run(event HookEvent) error
+}
+
+// Hooks holds all the hooks for a run, keyed on timing and stage, e.g. "post-annotated"
+type Hooks map[string][]Hook
+
+func hookKey(when HookTiming, stage PaperState) string {
+	return fmt.Sprintf("%s-%s", when, stage)
+}
+
+// Add takes a specification of the form when-stage=command, or when-stage=plugin:/path/to/hook.so
+func (h Hooks) Add(spec string) error {
+
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return fmt.Errorf("Hook %s should be of the form when-stage=command", spec)
+	}
+
+	valid := false
+	for _, when := range []HookTiming{HookPre, HookPost} {
+		for _, stage := range HookStages {
+			if parts[0] == hookKey(when, stage) {
+				valid = true
+			}
+		}
+	}
+	if !valid {
+		return fmt.Errorf("Unknown hook point %s, expected pre or post, then one of %v", parts[0], HookStages)
+	}
+
+	var hook Hook
+	var err error
+	if strings.HasPrefix(parts[1], "plugin:") {
+		hook, err = NewPluginHook(strings.TrimPrefix(parts[1], "plugin:"))
+	} else {
+		hook, err = NewCommandHook(parts[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	h[parts[0]] = append(h[parts[0]], hook)
+	return nil
+}
+
+// Run calls each hook registered for this point in turn, stopping at the first that fails
+func (h Hooks) Run(when HookTiming, stage PaperState, processor PaperProcessor) error {
+
+	hooks := h[hookKey(when, stage)]
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	event := HookEvent{
+		Stage:     stage,
+		When:      when,
+		PaperID:   processor.Paper.ID(),
+		Directory: processor.folderName(),
+		Article:   processor.ScienceSourceRecord,
+	}
+
+	for _, hook := range hooks {
+		logging.Logf(logging.LogVerbose, "Running %s hook for %s", hookKey(when, stage), event.PaperID)
+		err := hook.Run(event)
+		if err != nil {
+			return fmt.Errorf("%s hook failed: %v", hookKey(when, stage), err)
+		}
+	}
+	return nil
+}
+
+// CommandHook runs an external command, with the event as JSON on stdin, and the main details also in
+// environment variables for simple shell scripts. A non-zero exit status is treated as failure.
+type CommandHook struct {
+	args []string
+}
+
+func NewCommandHook(command string) (Hook, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Hook has no command to run")
+	}
+	return CommandHook{args: args}, nil
+}
+
+func (h CommandHook) Run(event HookEvent) error {
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(h.args[0], h.args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"SCIENCESOURCE_STAGE="+string(event.Stage),
+		"SCIENCESOURCE_WHEN="+string(event.When),
+		"SCIENCESOURCE_PAPER="+event.PaperID,
+		"SCIENCESOURCE_DIRECTORY="+event.Directory,
+	)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		logging.Logf(logging.LogNormal, "%s: %s", h.args[0], strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// PluginHook calls a Go plugin built with -buildmode=plugin. As plugins can't import our types the
+// plugin must export "func RunHook(event []byte) error", and is given the same JSON a command gets.
+type PluginHook struct {
+	path string
+	run  func([]byte) error
+}
+
+func NewPluginHook(path string) (Hook, error) {
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	symbol, err := p.Lookup("RunHook")
+	if err != nil {
+		return nil, err
+	}
+	run, ok := symbol.(func([]byte) error)
+	if !ok {
+		return nil, fmt.Errorf("Plugin %s RunHook should be func([]byte) error, not %T", path, symbol)
+	}
+	return PluginHook{path: path, run: run}, nil
+}
+
+func (h PluginHook) Run(event HookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.run(data)
+}
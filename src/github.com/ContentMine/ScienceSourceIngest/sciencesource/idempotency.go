@@ -0,0 +1,129 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+	"github.com/hashicorp/errwrap"
+)
+
+// Creating an item is the one write we can't safely just try again: if the server made the item but we
+// never heard back, say because the request timed out, a second attempt leaves a duplicate that nothing
+// links to. So each item's creation is stamped with a key saying which item of which article it is, and
+// before creating any of an article's items we record when we started, so that if the attempt is cut
+// short the next one can look through what our accounts have created since then for items carrying the
+// keys it still needs, and adopt those rather than make new ones.
+
+// Our clock and the server's may not quite agree, so look a little further back than we need to
+const pendingItemClockSlack time.Duration = 5 * time.Minute
+
+// PendingItemCreation records an attempt at creating some of an article's items.
+type PendingItemCreation struct {
+	RunID   string    `json:"run_id"`
+	Started time.Time `json:"started"`
+}
+
+// SetRunID sets the ID of this run, which is also stamped on every write the client makes.
+func (c *ScienceSourceClient) SetRunID(runID string) {
+	c.RunID = runID
+	c.network.SetRunID(runID)
+}
+
+// itemKey names one of the article's items, and is unique on the server as the article's page ID is.
+func (article *ScienceSourceArticle) itemKey(kind string, index int) string {
+	return fmt.Sprintf("page%d/%s/%d", article.PageID, kind, index)
+}
+
+// missingItems returns the ID fields of the article's items that have no ID yet, by their key.
+func (article *ScienceSourceArticle) missingItems() map[string]*wikibase.ItemPropertyType {
+
+	res := make(map[string]*wikibase.ItemPropertyType)
+	if len(article.ID) == 0 {
+		res[article.itemKey("article", 0)] = &article.ID
+	}
+	for i := range article.Annotations {
+		if len(article.Annotations[i].Annotation.ID) == 0 {
+			res[article.itemKey("annotation", i)] = &article.Annotations[i].Annotation.ID
+		}
+		if len(article.Annotations[i].ID) == 0 {
+			res[article.itemKey("anchor", i)] = &article.Annotations[i].ID
+		}
+	}
+	for i := range article.Subjects {
+		if len(article.Subjects[i].ID) == 0 {
+			res[article.itemKey("subject", i)] = &article.Subjects[i].ID
+		}
+	}
+	return res
+}
+
+// createItem makes a new item with the given label, stamped with the key so a later attempt can find it.
+func (c *ScienceSourceClient) createItem(ctx context.Context, key string, label string) (wikibase.ItemPropertyType, error) {
+	id, err := c.network.CreateEntity(wikibase.WithIdempotencyKey(ctx, key), "item", label, "")
+	return wikibase.ItemPropertyType(id), err
+}
+
+// beginItemCreation adopts any items an earlier attempt created for the article without us recording
+// them, then notes that we're creating its items, saving the article with the checkpoint.
+func (c *ScienceSourceClient) beginItemCreation(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	missing := article.missingItems()
+	if len(missing) == 0 {
+		return nil
+	}
+	if article.PendingItems == nil {
+		article.PendingItems = &PendingItemCreation{RunID: c.RunID, Started: time.Now().UTC()}
+		return checkpoint()
+	}
+
+	// Keep the original start time, so if this attempt fails too the next still looks back far enough
+	ours, err := c.ourUsers(ctx)
+	if err != nil {
+		return err
+	}
+	users := make([]string, 0, len(ours))
+	for user := range ours {
+		users = append(users, user)
+	}
+	created, err := c.network.CreatedEntitiesByKey(ctx, users, article.PendingItems.Started.Add(-pendingItemClockSlack))
+	if err != nil {
+		return errwrap.Wrapf("Failed to look for items created by an earlier attempt: {{err}}", err)
+	}
+
+	found := 0
+	for key, field := range missing {
+		if id, ok := created[key]; ok {
+			*field = wikibase.ItemPropertyType(id)
+			found += 1
+		}
+	}
+	if found == 0 {
+		return nil
+	}
+	logging.Logf(logging.LogNormal, "Found %d items created by an earlier attempt (run %s)", found, article.PendingItems.RunID)
+	return checkpoint()
+}
+
+// endItemCreation notes that all the article's items have been created.
+func (article *ScienceSourceArticle) endItemCreation() {
+	if len(article.missingItems()) == 0 {
+		article.PendingItems = nil
+	}
+}
@@ -0,0 +1,43 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"strings"
+)
+
+// The article item gets statements for the paper's DOI, PubMed ID, and PMCID, so the copy on the server
+// is linked unambiguously to the bibliographic record. We get these from the feed where we can, and
+// from the paper's XML otherwise.
+
+// SetIdentifiers fills in any of the article's identifiers it doesn't have yet from those given, ignoring
+// any that are empty.
+func (article *ScienceSourceArticle) SetIdentifiers(doi string, pmid string, pmcid string) {
+
+	set := func(field **string, value string) {
+		value = strings.TrimSpace(value)
+		if *field == nil && len(value) != 0 {
+			*field = &value
+		}
+	}
+
+	// Wikidata keeps DOIs in upper case, and we may have been given one as a URL
+	set(&article.DOI, strings.ToUpper(NormaliseDOI(doi)))
+	set(&article.PMID, pmid)
+	if len(pmcid) != 0 && strings.HasPrefix(strings.ToUpper(pmcid), "PMC") == false {
+		pmcid = "PMC" + pmcid
+	}
+	set(&article.PMCID, pmcid)
+}
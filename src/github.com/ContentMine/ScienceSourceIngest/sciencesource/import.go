@@ -0,0 +1,160 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+)
+
+// Importing a bundle made by Export puts the paper's files back in its folder under the output directory,
+// so that ingest can carry on with the paper from where it had got to, say on a machine that has the
+// credentials for the server. Everything in the bundle is checked against the manifest first, so a
+// damaged bundle doesn't leave a paper half imported.
+
+// ErrAlreadyHaveState is returned when importing a paper we already have state for, as replacing it might
+// lose the record of items we've made for it on the server.
+var ErrAlreadyHaveState = errors.New("Paper already has state here")
+
+// Only the files Export writes are taken from a bundle, so it can't write anywhere else
+var exportFileNames = []string{"paper.xml", "paper.html", "paper.txt", "scisource.json"}
+
+// ReadExport loads the manifest from a bundle and every file it lists, checking each one's size and
+// checksum.
+func ReadExport(filename string) (ExportManifest, map[string][]byte, error) {
+
+	var manifest ExportManifest
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer archive.Close()
+
+	contents := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			return manifest, nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return manifest, nil, errwrap.Wrapf("Failed to read "+f.Name+" from bundle: {{err}}", err)
+		}
+		contents[f.Name] = data
+	}
+
+	data, ok := contents[ExportManifestFileName]
+	if ok == false {
+		return manifest, nil, fmt.Errorf("Bundle has no %s", ExportManifestFileName)
+	}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return manifest, nil, errwrap.Wrapf("Failed to decode bundle manifest: {{err}}", err)
+	}
+	if len(manifest.Paper) == 0 || strings.ContainsAny(manifest.Paper, `/\`) || strings.HasPrefix(manifest.Paper, ".") {
+		return manifest, nil, fmt.Errorf("Bundle manifest has an invalid paper ID: %q", manifest.Paper)
+	}
+
+	files := make(map[string][]byte)
+	for _, file := range manifest.Files {
+		known := false
+		for _, name := range exportFileNames {
+			known = known || file.Name == name
+		}
+		if known == false {
+			return manifest, nil, fmt.Errorf("Bundle manifest lists an unexpected file: %s", file.Name)
+		}
+		data, ok := contents[file.Name]
+		if ok == false {
+			return manifest, nil, fmt.Errorf("Bundle is missing %s", file.Name)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != strings.ToLower(file.SHA256) {
+			return manifest, nil, fmt.Errorf("Bundle's %s doesn't match its checksum in the manifest", file.Name)
+		}
+		files[file.Name] = data
+	}
+	if _, ok := files["paper.xml"]; ok == false {
+		return manifest, nil, fmt.Errorf("Bundle has no paper.xml")
+	}
+
+	return manifest, files, nil
+}
+
+// ImportExport unpacks the bundle into the paper's folder under the target directory, returning the
+// manifest. Unless told to replace it, it refuses to import a paper we already have state for.
+func ImportExport(filename string, targetDirectory string, replace bool) (ExportManifest, error) {
+
+	manifest, files, err := ReadExport(filename)
+	if err != nil {
+		return manifest, err
+	}
+
+	processor := PaperProcessor{TargetDirectory: targetDirectory}
+	processor.Paper.PMCID.Value = manifest.Paper
+
+	if replace == false && fileExists(processor.targetScienceSourceStateFileName()) {
+		return manifest, ErrAlreadyHaveState
+	}
+
+	// Decode the state before writing anything, so we don't unpack half a paper
+	var article *ScienceSourceArticle
+	if data, ok := files["scisource.json"]; ok {
+		article = &ScienceSourceArticle{}
+		err = json.NewDecoder(bytes.NewReader(data)).Decode(article)
+		if err != nil {
+			return manifest, errwrap.Wrapf("Failed to decode article state in bundle: {{err}}", err)
+		}
+	}
+
+	err = processor.createFolderIfRequired()
+	if err != nil {
+		return manifest, errwrap.Wrapf("Failed to create folder for paper: {{err}}", err)
+	}
+	for name, filename := range map[string]string{
+		"paper.xml":  processor.targetXMLFileName(),
+		"paper.html": processor.targetHTMLFileName(),
+		"paper.txt":  processor.targetTextFileName(),
+	} {
+		data, ok := files[name]
+		if ok == false {
+			continue
+		}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	// The state goes last, as having it is what tells ingest the paper is ready to upload, and it is
+	// saved in whichever format we keep state in here
+	if article != nil {
+		err = processor.SaveArticle(article)
+		if err != nil {
+			return manifest, errwrap.Wrapf("Failed to save article state: {{err}}", err)
+		}
+	}
+	return manifest, nil
+}
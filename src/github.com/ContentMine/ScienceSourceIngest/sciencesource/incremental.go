@@ -0,0 +1,125 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"log"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// When an article's items were only partly uploaded, for instance because a run died or someone has
+// deleted some items by hand, we want to upload just what is missing rather than starting again. Items
+// we have IDs for but that aren't on the server are forgotten so they get created again, and then any
+// links between items already on the server are pointed at the right items once the tree has been
+// reconciled.
+
+// An item link is a statement on one item in the article tree that refers to another.
+type itemLink struct {
+	entity   string
+	property string
+	value    wikibase.ItemPropertyType
+}
+
+// treeLinks lists the links the reconciled article tree should have between its items.
+func (article *ScienceSourceArticle) treeLinks() []itemLink {
+
+	res := []itemLink{
+		{string(article.ID), "following anchor point", article.FollowingAnchorPoint},
+	}
+	for _, anchor := range article.Annotations {
+		res = append(res,
+			itemLink{string(anchor.ID), "anchor point in", anchor.AnchorPoint},
+			itemLink{string(anchor.ID), "following anchor point", anchor.FollowingAnchorPoint},
+			itemLink{string(anchor.ID), "anchors", anchor.Anchors},
+			itemLink{string(anchor.Annotation.ID), "based on", anchor.Annotation.BasedOn},
+		)
+		if anchor.PrecedingAnchorPoint != nil {
+			res = append(res, itemLink{string(anchor.ID), "preceding anchor point", *anchor.PrecedingAnchorPoint})
+		}
+	}
+	return res
+}
+
+// RemoveMissingItems checks which of the article's items are on the server, and forgets the IDs of any
+// that aren't so that they are created again. The items that are on the server are returned.
+func (c *ScienceSourceClient) RemoveMissingItems(ctx context.Context, article *ScienceSourceArticle) (map[string]wikibase.Entity, error) {
+
+	entities, err := c.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]wikibase.Entity, len(entities))
+	for id, entity := range entities {
+		if entity.Exists() {
+			existing[id] = entity
+		}
+	}
+
+	missing := func(id *wikibase.ItemPropertyType) {
+		if len(*id) == 0 {
+			return
+		}
+		if _, ok := existing[string(*id)]; !ok {
+			log.Printf("Item %s is no longer on the server, so will be created again", *id)
+			*id = ""
+		}
+	}
+	missing(&article.ID)
+	for i := 0; i < len(article.Annotations); i++ {
+		missing(&article.Annotations[i].ID)
+		missing(&article.Annotations[i].Annotation.ID)
+	}
+
+	logging.Logf(logging.LogVerbose, "%d of %d items already on the server", len(existing), len(entities))
+	return existing, nil
+}
+
+// FixItemLinks updates link statements on items that were already on the server so they match the
+// reconciled article tree. Links that don't exist yet are left to be uploaded with the rest of the item.
+func (c *ScienceSourceClient) FixItemLinks(ctx context.Context, article *ScienceSourceArticle, existing map[string]wikibase.Entity) (int, error) {
+
+	fixed := 0
+	for _, link := range article.treeLinks() {
+		entity, ok := existing[link.entity]
+		if !ok || len(link.value) == 0 {
+			continue
+		}
+		propertyID, err := c.PropertyID(link.property)
+		if err != nil {
+			return fixed, err
+		}
+		statements := entity.Claims[propertyID]
+		if len(statements) == 0 || statements[0].MainSnak.ItemID() == string(link.value) {
+			continue
+		}
+
+		value, err := wikibase.NewItemValue(string(link.value))
+		if err != nil {
+			return fixed, err
+		}
+		logging.Logf(logging.LogVerbose, "Pointing %s of %s at %s rather than %s", link.property, link.entity,
+			link.value, statements[0].MainSnak.ItemID())
+		err = c.network.SetClaimValue(ctx, statements[0].ID, value)
+		if err != nil {
+			return fixed, err
+		}
+		fixed += 1
+	}
+	return fixed, nil
+}
@@ -0,0 +1,104 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// When ingesting the odd paper by hand it's useful to see what's about to be written to the server
+// before it happens. Papers can be processed concurrently, so the lock stops prompts interleaving.
+type Confirmer struct {
+	Target string
+
+	lock   sync.Mutex
+	input  *bufio.Reader
+	output io.Writer
+}
+
+func NewConfirmer(target string, input io.Reader, output io.Writer) *Confirmer {
+	return &Confirmer{
+		Target: target,
+		input:  bufio.NewReader(input),
+		output: output,
+	}
+}
+
+func (c *Confirmer) Confirm(paper Paper, article *ScienceSourceArticle) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(c.output, "\nTitle:       %s\n", article.ScienceSourceArticleTitle)
+	fmt.Fprintf(c.output, "Wikidata:    %s\n", paper.WikiDataID())
+	fmt.Fprintf(c.output, "PMCID:       %s\n", paper.ID())
+	fmt.Fprintf(c.output, "Annotations: %d\n", len(article.Annotations))
+	fmt.Fprintf(c.output, "Target:      %s\n", c.Target)
+
+	return c.ask("Upload this paper?")
+}
+
+// ConfirmLabel asks whether an entity with a near miss label is the one we were looking for. Labels are
+// resolved concurrently, so this shares the lock with paper prompts.
+func (c *Confirmer) ConfirmLabel(label string, entityType string, id string, found string, score float64) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(c.output, "\nNo %s on %s has the label %q\n", entityType, c.Target, label)
+	fmt.Fprintf(c.output, "Closest is %s %q (similarity %.2f)\n", id, found, score)
+
+	return c.ask(fmt.Sprintf("Use %s?", id))
+}
+
+// ConfirmCanary shows how the first few papers of a batch went, with links to check them on the wiki,
+// and asks whether to carry on with the rest.
+func (c *Confirmer) ConfirmCanary(processors []PaperProcessor, remaining int) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(c.output, "\nCanary papers on %s:\n", c.Target)
+	for _, processor := range processors {
+		article, err := processor.Article()
+		if err != nil || article.Complete == false {
+			fmt.Fprintf(c.output, "%s\tnot finished, see the log\n", processor.Paper.ID())
+			continue
+		}
+		fmt.Fprintf(c.output, "%s\t%s\t%s/entity/%s\t%d annotations\n", processor.Paper.ID(),
+			ArticleURL(c.Target, article), c.Target, article.ID, len(article.Annotations))
+	}
+
+	return c.ask(fmt.Sprintf("Carry on with the remaining %d papers?", remaining))
+}
+
+// ask keeps prompting until it gets a yes or no answer. The caller must hold the lock.
+func (c *Confirmer) ask(question string) (bool, error) {
+	for {
+		fmt.Fprintf(c.output, "%s [y/n]: ", question)
+		answer, err := c.input.ReadString('\n')
+		if err != nil && len(answer) == 0 {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Each generated item type lists the statements its fields make with a claims method, so uploading an
+// item's statements is done from that list rather than by the wikibase library walking its struct tags.
+// That way each value is sent as we build it, and times get the precision datamodel.json gives them. An
+// upload only creates the statements the item doesn't have yet, so it can be repeated after a failure
+// part way.
+
+type itemClaim struct {
+	Property  string                 // The label of the property
+	Value     interface{}            // A string, int, time.Time, or wikibase.ItemPropertyType
+	Precision wikibase.TimePrecision // How precise a time value is
+}
+
+type claimedItem interface {
+	itemID() wikibase.ItemPropertyType
+	claims() []itemClaim
+}
+
+// empty is true for values we don't upload, which are empty strings and links to items not made yet.
+func (claim itemClaim) empty() bool {
+	switch value := claim.Value.(type) {
+	case string:
+		return len(value) == 0
+	case wikibase.ItemPropertyType:
+		return len(value) == 0
+	}
+	return false
+}
+
+// dataValue gives the claim's value as the data value to send to the server.
+func (claim itemClaim) dataValue() (interface{}, error) {
+	switch value := claim.Value.(type) {
+	case string:
+		return value, nil
+	case wikibase.ItemPropertyType:
+		return wikibase.NewItemValue(string(value))
+	case int:
+		return wikibase.NewQuantityValue(value), nil
+	case time.Time:
+		return wikibase.NewWikibaseTime(value, claim.Precision), nil
+	}
+	return nil, fmt.Errorf("Property %s has a value of unsupported type %T", claim.Property, claim.Value)
+}
+
+// uploadItemClaims makes the item's statements on the server, skipping any property it already has a
+// statement for.
+func (c *ScienceSourceClient) uploadItemClaims(ctx context.Context, item claimedItem) error {
+
+	id := string(item.itemID())
+	if len(id) == 0 {
+		return fmt.Errorf("Can not upload claims for an item that has not been created")
+	}
+
+	claimed, err := c.network.ClaimedProperties(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, claim := range item.claims() {
+		if claim.empty() {
+			continue
+		}
+		propertyID, err := c.PropertyID(claim.Property)
+		if err != nil {
+			return err
+		}
+		if claimed[propertyID] {
+			continue
+		}
+
+		value, err := claim.dataValue()
+		if err != nil {
+			return err
+		}
+		_, err = c.network.CreateClaim(ctx, id, propertyID, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+func TestClaimDataValue(t *testing.T) {
+
+	when := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	item, err := wikibase.NewItemValue("Q7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		claim    itemClaim
+		expected interface{}
+	}{
+		{claim: itemClaim{Value: "text"}, expected: "text"},
+		{claim: itemClaim{Value: wikibase.ItemPropertyType("Q7")}, expected: item},
+		{claim: itemClaim{Value: 12}, expected: wikibase.NewQuantityValue(12)},
+		{claim: itemClaim{Value: when, Precision: wikibase.TimePrecisionDay},
+			expected: wikibase.NewWikibaseTime(when, wikibase.TimePrecisionDay)},
+		{claim: itemClaim{Value: when, Precision: wikibase.TimePrecisionYear},
+			expected: wikibase.NewWikibaseTime(when, wikibase.TimePrecisionYear)},
+	}
+
+	for _, test := range tests {
+		got, err := test.claim.dataValue()
+		if err != nil {
+			t.Errorf("Value %v: %v", test.claim.Value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Value %v: expected %v, got %v", test.claim.Value, test.expected, got)
+		}
+	}
+
+	if _, err := (itemClaim{Property: "p", Value: 1.5}).dataValue(); err == nil {
+		t.Error("Expected an error for a float value")
+	}
+}
+
+func TestGeneratedClaims(t *testing.T) {
+
+	date := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	article := ScienceSourceArticle{PublicationDate: &date, TimeCode: date}
+	article.ID = "Q2"
+
+	if article.itemID() != "Q2" {
+		t.Errorf("Expected item ID Q2, got %s", article.itemID())
+	}
+
+	found := make(map[string]itemClaim)
+	for _, claim := range article.claims() {
+		found[claim.Property] = claim
+	}
+	if claim, ok := found["publication date"]; ok == false || claim.Precision != wikibase.TimePrecisionDay {
+		t.Errorf("Expected publication date at day precision, got %v", claim)
+	}
+	if _, ok := found["preceding phrase"]; ok {
+		t.Error("Expected no claim for a nil preceding phrase")
+	}
+	if claim := found["article text title"]; claim.empty() == false {
+		t.Error("Expected an empty title to be skipped")
+	}
+}
+
+func TestExpectedClaims(t *testing.T) {
+
+	distance := 3
+	anchor := ScienceSourceAnchorPoint{
+		PrecedingPhrase:      "the cat",
+		DistanceToPreceding:  &distance,
+		CharacterNumber:      42,
+		TimeCode:             time.Date(2018, 7, 1, 23, 30, 0, 0, time.FixedZone("BST", 3600)),
+		InstanceOf:           "Q1",
+		FollowingAnchorPoint: "Q3",
+	}
+	anchor.ID = "Q2"
+
+	// Empty strings and items and nil pointers aren't uploaded, and times are compared by UTC date
+	expected := map[string]string{
+		"preceding phrase":       "the cat",
+		"distance to preceding":  "3",
+		"character number":       "42",
+		"time code1":             "2018-07-01",
+		"instance of":            "Q1",
+		"following anchor point": "Q3",
+	}
+	if got := expectedClaims(&anchor); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected claims %v, got %v", expected, got)
+	}
+
+	// Less precise times have zeros for the parts they don't give, as the server stores them
+	claim := itemClaim{Value: time.Date(2018, 7, 14, 0, 0, 0, 0, time.UTC), Precision: wikibase.TimePrecisionMonth}
+	if got := claim.expected(); got != "2018-07-00" {
+		t.Errorf("Expected month precision date 2018-07-00, got %s", got)
+	}
+}
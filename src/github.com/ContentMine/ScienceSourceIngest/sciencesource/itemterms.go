@@ -0,0 +1,205 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The wikibase library creates every item with a fixed English label ("article instance" and so on),
+// which isn't much help to readers of other languages, and leaves thousands of items that can't be told
+// apart in search results. Item terms let the operator give labels, descriptions, and aliases in any
+// number of languages for each kind of item we create, keyed by the same names as
+// the schema items: "article", "anchor point", and "annotation". They're patterns, with the following
+// placeholders filled in per item:
+//
+//   {title}     - the ScienceSource article title
+//   {term}      - the term found (anchor points and annotations)
+//   {character} - the character number of the anchor point (anchor points and annotations)
+//   {wikidata}  - the Wikidata item code of the article, or of the term for annotations
+//
+// Wikibase won't let two items have both the same label and description in a language, so descriptions
+// need placeholders to tell items apart.
+
+type ItemTerms struct {
+	Labels       map[string]string   `json:"labels"`
+	Descriptions map[string]string   `json:"descriptions"`
+	Aliases      map[string][]string `json:"aliases"`
+}
+
+type ItemTermsConfig map[string]ItemTerms
+
+// DefaultItemTerms describes items in English, so that they're findable and make sense in the wiki UI
+// without any configuration.
+var DefaultItemTerms = ItemTermsConfig{
+	"article": {
+		Descriptions: map[string]string{"en": "ScienceSource article {title}"},
+		Aliases:      map[string][]string{"en": {"{title}"}},
+	},
+	"anchor point": {
+		Descriptions: map[string]string{"en": "anchor point of '{term}' in {title} at character {character}"},
+	},
+	"annotation": {
+		Descriptions: map[string]string{"en": "annotation of '{term}' in {title} at character {character}"},
+		Aliases:      map[string][]string{"en": {"{term}", "{wikidata}"}},
+	},
+}
+
+// WithDefaults returns the configuration with the default terms filled in for any kind of item it
+// doesn't mention.
+func (config ItemTermsConfig) WithDefaults() ItemTermsConfig {
+	res := make(ItemTermsConfig, len(DefaultItemTerms))
+	for kind, terms := range DefaultItemTerms {
+		res[kind] = terms
+	}
+	for kind, terms := range config {
+		res[kind] = terms
+	}
+	return res
+}
+
+func LoadItemTermsConfig(filename string) (ItemTermsConfig, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config ItemTermsConfig
+	err = json.NewDecoder(f).Decode(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	for kind := range config {
+		switch kind {
+		case "article", "anchor point", "annotation":
+		default:
+			return nil, fmt.Errorf("Unknown item kind %q in %s, expected article, anchor point, or annotation", kind, filename)
+		}
+	}
+
+	return config, nil
+}
+
+// Wikibase's default limit on the length of labels, descriptions, and aliases. Titles can be longer than
+// this on their own, so we cut values short rather than have the edit rejected.
+const itemTermMaxLength = 250
+
+func expandItemTerm(pattern string, replacer *strings.Replacer) string {
+	value := []rune(strings.TrimSpace(replacer.Replace(pattern)))
+	if len(value) > itemTermMaxLength {
+		value = append(value[:itemTermMaxLength-1], '…')
+	}
+	return string(value)
+}
+
+func (terms ItemTerms) expand(replacer *strings.Replacer) wikibase.EntityTerms {
+
+	res := wikibase.EntityTerms{
+		Labels:       make(map[string]string, len(terms.Labels)),
+		Descriptions: make(map[string]string, len(terms.Descriptions)),
+		Aliases:      make(map[string][]string, len(terms.Aliases)),
+	}
+	for language, pattern := range terms.Labels {
+		if value := expandItemTerm(pattern, replacer); len(value) > 0 {
+			res.Labels[language] = value
+		}
+	}
+	for language, pattern := range terms.Descriptions {
+		if value := expandItemTerm(pattern, replacer); len(value) > 0 {
+			res.Descriptions[language] = value
+		}
+	}
+	for language, patterns := range terms.Aliases {
+		seen := make(map[string]bool)
+		for _, pattern := range patterns {
+			// An alias can't be the same as the label, and placeholders like {wikidata} may be empty
+			value := expandItemTerm(pattern, replacer)
+			if len(value) > 0 && !seen[value] && value != res.Labels[language] {
+				seen[value] = true
+				res.Aliases[language] = append(res.Aliases[language], value)
+			}
+		}
+	}
+
+	return res
+}
+
+func (c *ScienceSourceClient) setItemTerms(ctx context.Context, kind string, id string, replacer *strings.Replacer) error {
+
+	terms, ok := c.ItemTerms[kind]
+	if !ok || len(id) == 0 {
+		return nil
+	}
+
+	return c.network.SetEntityTerms(ctx, id, terms.expand(replacer))
+}
+
+// SetArticleItemTerms applies the configured labels and descriptions to every item of the article. Setting
+// terms an item already has doesn't make a new revision, so this is safe to repeat when resuming.
+func (c *ScienceSourceClient) SetArticleItemTerms(ctx context.Context, article *ScienceSourceArticle) error {
+
+	if len(c.ItemTerms) == 0 {
+		return nil
+	}
+
+	err := c.setItemTerms(ctx, "article", string(article.ID), strings.NewReplacer(
+		"{title}", article.ScienceSourceArticleTitle,
+		"{term}", "",
+		"{character}", "",
+		"{wikidata}", article.WikiDataItemCode,
+	))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(article.Annotations); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		anchor := &article.Annotations[i]
+
+		err := c.setItemTerms(ctx, "anchor point", string(anchor.ID), strings.NewReplacer(
+			"{title}", article.ScienceSourceArticleTitle,
+			"{term}", anchor.Annotation.TermFound,
+			"{character}", strconv.Itoa(anchor.CharacterNumber),
+			"{wikidata}", article.WikiDataItemCode,
+		))
+		if err != nil {
+			return err
+		}
+
+		err = c.setItemTerms(ctx, "annotation", string(anchor.Annotation.ID), strings.NewReplacer(
+			"{title}", article.ScienceSourceArticleTitle,
+			"{term}", anchor.Annotation.TermFound,
+			"{character}", strconv.Itoa(anchor.CharacterNumber),
+			"{wikidata}", anchor.Annotation.WikiDataItemCode,
+		))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// As an extra safeguard that only open access papers are uploaded, the paper's journal can be checked
+// against the Directory of Open Access Journals, or a list of ISSNs the operator trusts, before the paper
+// is converted. Journals are identified by the ISSNs in the paper's front matter.
+
+const doajJournalSearchURL string = "https://doaj.org/api/search/journals/%s"
+
+type JournalNotAllowedError struct {
+	Journal string
+	ISSNs   []string
+}
+
+func (e *JournalNotAllowedError) Error() string {
+	if len(e.ISSNs) == 0 {
+		return fmt.Sprintf("Journal %q has no ISSN, so can't be checked", e.Journal)
+	}
+	return fmt.Sprintf("Journal %q (ISSN %s) is not on the list of open access journals", e.Journal,
+		strings.Join(e.ISSNs, ", "))
+}
+
+type JournalCheck struct {
+	// Journals allowed whatever DOAJ says, if given
+	Allowlist map[string]bool
+
+	// Whether to ask DOAJ
+	DOAJ bool
+
+	// What DOAJ said about each ISSN, so we only ask once per journal
+	lock sync.Mutex
+	doaj map[string]bool
+}
+
+// NormaliseISSN puts an ISSN in the form NNNN-NNNX
+func NormaliseISSN(issn string) string {
+	issn = strings.ToUpper(strings.Replace(strings.TrimSpace(issn), "-", "", -1))
+	if len(issn) == 8 {
+		return issn[:4] + "-" + issn[4:]
+	}
+	return issn
+}
+
+// LoadISSNAllowlist reads a file with an ISSN per line. Blank lines and lines starting with # are
+// ignored.
+func LoadISSNAllowlist(path string) (map[string]bool, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res[NormaliseISSN(line)] = true
+	}
+	return res, scanner.Err()
+}
+
+type doajSearchResponse struct {
+	Total int `json:"total"`
+}
+
+func (check *JournalCheck) inDOAJ(ctx context.Context, issn string) (bool, error) {
+
+	check.lock.Lock()
+	listed, ok := check.doaj[issn]
+	check.lock.Unlock()
+	if ok {
+		return listed, nil
+	}
+
+	var response doajSearchResponse
+	err := getJSON(ctx, fmt.Sprintf(doajJournalSearchURL, url.PathEscape("issn:"+issn)), nil, &response)
+	if err != nil {
+		return false, err
+	}
+	listed = response.Total > 0
+
+	check.lock.Lock()
+	defer check.lock.Unlock()
+	if check.doaj == nil {
+		check.doaj = make(map[string]bool)
+	}
+	check.doaj[issn] = listed
+	return listed, nil
+}
+
+// Check returns a JournalNotAllowedError unless one of the journal's ISSNs is on the allowlist or, if
+// we're checking, in DOAJ.
+func (check *JournalCheck) Check(ctx context.Context, journal string, issns []string) error {
+
+	for _, issn := range issns {
+		issn = NormaliseISSN(issn)
+		if check.Allowlist[issn] {
+			return nil
+		}
+		if check.DOAJ {
+			listed, err := check.inDOAJ(ctx, issn)
+			if err != nil {
+				return fmt.Errorf("Failed to look up ISSN %s in DOAJ: %v", issn, err)
+			}
+			if listed {
+				return nil
+			}
+		}
+	}
+	return &JournalNotAllowedError{Journal: journal, ISSNs: issns}
+}
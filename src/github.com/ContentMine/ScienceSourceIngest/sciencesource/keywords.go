@@ -0,0 +1,179 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Authors often give keywords for their paper, which are a good summary of what it is about. Where a
+// keyword is exactly the label or an alias of a Wikidata item we add a "main subject" statement with
+// that item's code to the article item, qualified with the keyword as the author gave it. Keywords that
+// don't match an item, or that could be one of several, are left out rather than guessed at.
+
+const wikidataSearchURL string = "https://www.wikidata.org/w/api.php?action=wbsearchentities&format=json&type=item&language=en&limit=10&search=%s"
+
+// The property on article items for what they are about, and the qualifier for the author's keyword
+const mainSubjectProperty string = "main subject"
+const authorKeywordProperty string = "author keyword"
+
+// Where a main subject came from
+const (
+	SubjectSourceKeyword     = "keyword"
+	SubjectSourceAnnotations = "annotations"
+)
+
+// A Wikidata item the article is about, and how far through recording it we are
+type ArticleSubject struct {
+	WikiDataItemCode string `json:"wikidata"`
+	Source           string `json:"source"`
+	Keyword          string `json:"keyword,omitempty"`
+	Claim            string `json:"claim,omitempty"`
+	KeywordRecorded  bool   `json:"keyword_recorded,omitempty"`
+}
+
+type wikidataSearchResponse struct {
+	Search []struct {
+		ID    string `json:"id"`
+		Match struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"match"`
+	} `json:"search"`
+}
+
+// Keywords are shared by many papers, so remember what Wikidata told us
+var keywordCacheLock sync.Mutex
+var keywordCache = make(map[string]string)
+
+// ReconcileKeyword finds the Wikidata item whose label or alias is the keyword, returning "" if there
+// isn't exactly one.
+func ReconcileKeyword(ctx context.Context, keyword string) (string, error) {
+
+	key := strings.ToLower(keyword)
+	keywordCacheLock.Lock()
+	cached, ok := keywordCache[key]
+	keywordCacheLock.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var response wikidataSearchResponse
+	err := getJSON(ctx, fmt.Sprintf(wikidataSearchURL, url.QueryEscape(keyword)), nil, &response)
+	if err != nil {
+		return "", err
+	}
+
+	matches := make(map[string]bool)
+	for _, result := range response.Search {
+		if result.Match.Type != "label" && result.Match.Type != "alias" {
+			continue
+		}
+		if strings.EqualFold(result.Match.Text, keyword) {
+			matches[result.ID] = true
+		}
+	}
+	qid := ""
+	if len(matches) == 1 {
+		for id := range matches {
+			qid = id
+		}
+	}
+
+	keywordCacheLock.Lock()
+	keywordCache[key] = qid
+	keywordCacheLock.Unlock()
+	return qid, nil
+}
+
+// AddSubject adds a main subject to the article if it doesn't have that item already, returning
+// whether it was added.
+func (article *ScienceSourceArticle) AddSubject(subject ArticleSubject) bool {
+	for _, existing := range article.MainSubjects {
+		if existing.WikiDataItemCode == subject.WikiDataItemCode {
+			return false
+		}
+	}
+	article.MainSubjects = append(article.MainSubjects, subject)
+	return true
+}
+
+// findKeywordSubjects reconciles the authors' keywords with Wikidata, adding those that match as main
+// subjects of the article.
+func findKeywordSubjects(ctx context.Context, article *ScienceSourceArticle, keywords []string) error {
+
+	for _, keyword := range keywords {
+		qid, err := ReconcileKeyword(ctx, keyword)
+		if err != nil {
+			return fmt.Errorf("Failed to look up keyword %q: %v", keyword, err)
+		}
+		if len(qid) == 0 {
+			logging.Logf(logging.LogVerbose, "Keyword %q has no single Wikidata item", keyword)
+			continue
+		}
+		article.AddSubject(ArticleSubject{WikiDataItemCode: qid, Source: SubjectSourceKeyword, Keyword: keyword})
+	}
+	return nil
+}
+
+// RecordMainSubjects makes a statement on the article item for each main subject that doesn't have one
+// yet. The checkpoint is called after each change so the caller can save the article.
+func (c *ScienceSourceClient) RecordMainSubjects(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	for i := range article.MainSubjects {
+		subject := &article.MainSubjects[i]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if len(subject.Claim) == 0 {
+			property, err := c.extraProperty(ctx, mainSubjectProperty, "external-id")
+			if err != nil {
+				return err
+			}
+			subject.Claim, err = c.network.CreateClaim(ctx, string(article.ID), property, subject.WikiDataItemCode)
+			if err != nil {
+				return err
+			}
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(subject.Keyword) != 0 && subject.KeywordRecorded == false {
+			property, err := c.extraProperty(ctx, authorKeywordProperty, "string")
+			if err != nil {
+				return err
+			}
+			err = c.network.SetQualifier(ctx, subject.Claim, property, subject.Keyword)
+			if err != nil {
+				return err
+			}
+			subject.KeywordRecorded = true
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Figures can optionally be uploaded as files alongside the article text. Each file page says where the
+// figure came from and what licence it's under, as otherwise it couldn't be reused, and the article item
+// gets a "figure file" claim per figure so the files can be found from the article.
+//
+// The file page text defaults to a figure source template invocation, but operators can supply their
+// own Go text/template with the fields of MediaTemplateFields available.
+
+const MediaDescription string = `{{figure source
+| wikidata_code = %s
+| pmcid = %s
+| doi = %s
+| figure = %s
+| caption = %s
+| source = %s
+| license = %s
+| batch_date = %04d-%02d-%02d
+}}
+`
+
+// The property on article items that names each uploaded figure file
+const figureFileProperty string = "figure file"
+
+// What we know about each figure, and how far through uploading it we are
+type ArticleFigure struct {
+	Label    string `json:"label,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+	Graphic  string `json:"graphic"`
+	FileName string `json:"file_name"`
+	Uploaded bool   `json:"uploaded,omitempty"`
+	Claim    string `json:"claim,omitempty"` // Set once the article item links to the file
+}
+
+type MediaTemplateFields struct {
+	Title       string
+	WikiDataID  string
+	PMCID       string
+	DOI         string
+	License     string
+	Journal     string
+	FigureLabel string
+	Caption     string
+	SourceURL   string
+	BatchDate   time.Time
+}
+
+func LoadMediaTemplate(path string) (*template.Template, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New(path).Parse(string(data))
+}
+
+// Europe PMC serves figures from the paper's bin directory, and the graphic href often leaves off the
+// extension of what is usually a JPEG
+func (paper Paper) FigureURL(graphic string) string {
+	if len(path.Ext(graphic)) == 0 {
+		graphic += ".jpg"
+	}
+	return fmt.Sprintf("https://europepmc.org/articles/%s/bin/%s", paper.ID(), graphic)
+}
+
+// Template parameter values can't contain the characters that delimit templates
+func escapeTemplateValue(value string) string {
+	value = strings.Replace(value, "|", "{{!}}", -1)
+	value = strings.Replace(value, "{{", "", -1)
+	value = strings.Replace(value, "}}", "", -1)
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// The file name on the server for a figure. File names are shared across the whole wiki, so we prefix
+// the paper's ID to avoid clashes between papers whose figures have the same names.
+func figureFileName(paperID string, graphic string) string {
+	name := path.Base(graphic)
+	if len(path.Ext(name)) == 0 {
+		name += ".jpg"
+	}
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '#', '<', '>', '[', ']', '|', '{', '}', '/', ':':
+			return '-'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf("%s %s", paperID, name)
+}
+
+func (processor PaperProcessor) targetMediaFileName(figure ArticleFigure) string {
+	return path.Join(processor.folderName(), "media", path.Base(figure.FileName))
+}
+
+func (processor PaperProcessor) findFigures() ([]ArticleFigure, error) {
+
+	figures, err := convert.LoadPaperFiguresFromFile(processor.targetXMLFileName())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ArticleFigure, 0, len(figures))
+	seen := make(map[string]bool)
+	for _, figure := range figures {
+		name := figureFileName(processor.Paper.ID(), figure.Graphic)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		res = append(res, ArticleFigure{
+			Label:    figure.Label,
+			Caption:  figure.Caption,
+			Graphic:  figure.Graphic,
+			FileName: name,
+		})
+	}
+	return res, nil
+}
+
+func (processor PaperProcessor) renderMediaDescription(figure ArticleFigure) (string, error) {
+
+	batch := processor.timeCode()
+
+	if processor.MediaTemplate == nil {
+		return fmt.Sprintf(MediaDescription,
+			processor.Paper.WikiDataID(),
+			processor.Paper.ID(),
+			escapeTemplateValue(processor.Paper.DOI.Value),
+			escapeTemplateValue(figure.Label),
+			escapeTemplateValue(figure.Caption),
+			processor.Paper.FigureURL(figure.Graphic),
+			escapeTemplateValue(processor.Paper.LicenseLabel.Value),
+			batch.Year(), batch.Month(), batch.Day(),
+		), nil
+	}
+
+	fields := MediaTemplateFields{
+		Title:       processor.Paper.Title.Value,
+		WikiDataID:  processor.Paper.WikiDataID(),
+		PMCID:       processor.Paper.ID(),
+		DOI:         processor.Paper.DOI.Value,
+		License:     processor.Paper.LicenseLabel.Value,
+		Journal:     processor.Paper.JournalLabel.Value,
+		FigureLabel: figure.Label,
+		Caption:     figure.Caption,
+		SourceURL:   processor.Paper.FigureURL(figure.Graphic),
+		BatchDate:   batch,
+	}
+
+	var buf bytes.Buffer
+	err := processor.MediaTemplate.Execute(&buf, fields)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// figureProperty finds the property used to link articles to their figures. It's looked up on first use
+// so servers that don't take figures don't need it.
+func (c *ScienceSourceClient) figureProperty(ctx context.Context) (string, error) {
+	return c.extraProperty(ctx, figureFileProperty, "string")
+}
+
+// uploadFigures uploads each of the paper's figures that isn't on the server yet and links it from the
+// article item, saving the record after each step so that a resumed run carries on from there.
+func (processor PaperProcessor) uploadFigures(ctx context.Context, client *ScienceSourceClient) error {
+
+	if processor.UploadMedia == false {
+		return nil
+	}
+	article := processor.ScienceSourceRecord
+
+	if article.Figures == nil {
+		figures, err := processor.findFigures()
+		if err != nil {
+			return errwrap.Wrapf("Failed to find figures: {{err}}", err)
+		}
+		article.Figures = figures
+	}
+	if len(article.Figures) == 0 {
+		return nil
+	}
+
+	property, err := client.figureProperty(ctx)
+	if err != nil {
+		return errwrap.Wrapf("Failed to find figure property: {{err}}", err)
+	}
+
+	err = os.MkdirAll(path.Join(processor.folderName(), "media"), 0755)
+	if err != nil {
+		return err
+	}
+
+	for i := range article.Figures {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		figure := &article.Figures[i]
+
+		if figure.Uploaded == false {
+			filename := processor.targetMediaFileName(*figure)
+			err := fetchResource(ctx, processor.Paper.FigureURL(figure.Graphic), filename)
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("Failed to fetch figure %s: {{err}}", figure.Graphic), err)
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			text, err := processor.renderMediaDescription(*figure)
+			if err != nil {
+				return errwrap.Wrapf("Failed to render media template: {{err}}", err)
+			}
+
+			logging.Logf(logging.LogVerbose, "Uploading figure %s", figure.FileName)
+			err = client.network.UploadFile(ctx, figure.FileName, data, text,
+				fmt.Sprintf("Figure from %s", article.ScienceSourceArticleTitle))
+			if warning, ok := err.(*wikibase.UploadWarningError); ok {
+				// Either we uploaded it before but didn't get to record that, or another paper has the
+				// same image, and in both cases the file is there to link to
+				if warning.Has("exists") || warning.Has("duplicate") {
+					log.Printf("Using existing file for %s: %v", figure.FileName, err)
+					err = nil
+				}
+			}
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("Failed to upload figure %s: {{err}}", figure.FileName), err)
+			}
+			figure.Uploaded = true
+			err = article.Save(processor.targetScienceSourceStateFileName())
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(figure.Claim) == 0 {
+			claim, err := client.network.CreateClaim(ctx, string(article.ID), property, "File:"+figure.FileName)
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("Failed to link figure %s: {{err}}", figure.FileName), err)
+			}
+			figure.Claim = claim
+			err = article.Save(processor.targetScienceSourceStateFileName())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+)
+
+// PubMed indexers assign each paper a set of MeSH headings saying what it is about. These complement
+// the terms dictionaries find in the text, so they can be added to an article as subject annotations:
+// annotation items like those the dictionaries make, but based on the article item itself rather than
+// an anchor point, as they are about the whole article rather than a place in its text. Headings are
+// mapped to Wikidata using the MeSH descriptor ID property on Wikidata items, and headings with no
+// Wikidata item are left out.
+
+const (
+	pmcIDConverterURL string = "https://www.ncbi.nlm.nih.gov/pmc/utils/idconv/v1.0/?format=json&ids=%s"
+	pubMedFetchURL    string = "https://eutils.ncbi.nlm.nih.gov/entrez/eutils/efetch.fcgi?db=pubmed&retmode=xml&id=%s"
+	wikidataQueryURL  string = "https://query.wikidata.org/sparql?format=json&query=%s"
+)
+
+// Subject annotations record this as their dictionary name
+const MeSHDictionaryName string = "MeSH"
+
+type MeSHHeading struct {
+	DescriptorID string // e.g. D006801
+	Name         string
+	MajorTopic   bool
+}
+
+type pmcIDConverterResponse struct {
+	Records []struct {
+		PMID string `json:"pmid"`
+	} `json:"records"`
+}
+
+type pubMedArticleSet struct {
+	Headings []struct {
+		Descriptor struct {
+			ID         string `xml:"UI,attr"`
+			MajorTopic string `xml:"MajorTopicYN,attr"`
+			Name       string `xml:",chardata"`
+		} `xml:"DescriptorName"`
+	} `xml:"PubmedArticle>MedlineCitation>MeshHeadingList>MeshHeading"`
+}
+
+type wikidataQueryResponse struct {
+	Results struct {
+		Bindings []map[string]struct {
+			Value string `json:"value"`
+		} `json:"bindings"`
+	} `json:"results"`
+}
+
+// FetchPMID finds the PubMed ID of a paper from its PMCID, returning "" if it isn't in PubMed.
+func FetchPMID(ctx context.Context, pmcid string) (string, error) {
+
+	var response pmcIDConverterResponse
+	err := getJSON(ctx, fmt.Sprintf(pmcIDConverterURL, url.QueryEscape(pmcid)), nil, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Records) == 0 {
+		return "", nil
+	}
+	return response.Records[0].PMID, nil
+}
+
+// FetchMeSHHeadings gets the MeSH headings PubMed has for a paper.
+func FetchMeSHHeadings(ctx context.Context, pmid string) ([]MeSHHeading, error) {
+
+	address := fmt.Sprintf(pubMedFetchURL, url.QueryEscape(pmid))
+	req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = ratelimit.WaitForURL(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s returned %s", address, resp.Status)
+	}
+
+	var set pubMedArticleSet
+	err = xml.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse PubMed record %s: %v", pmid, err)
+	}
+
+	res := make([]MeSHHeading, 0, len(set.Headings))
+	for _, heading := range set.Headings {
+		res = append(res, MeSHHeading{
+			DescriptorID: heading.Descriptor.ID,
+			Name:         strings.TrimSpace(heading.Descriptor.Name),
+			MajorTopic:   heading.Descriptor.MajorTopic == "Y",
+		})
+	}
+	return res, nil
+}
+
+// MapMeSHToWikidata asks Wikidata which items have the given MeSH descriptor IDs, returning a map from
+// descriptor ID to QID.
+func MapMeSHToWikidata(ctx context.Context, descriptorIDs []string) (map[string]string, error) {
+
+	res := make(map[string]string, len(descriptorIDs))
+	if len(descriptorIDs) == 0 {
+		return res, nil
+	}
+
+	values := make([]string, len(descriptorIDs))
+	for i, id := range descriptorIDs {
+		values[i] = fmt.Sprintf("%q", id)
+	}
+	query := fmt.Sprintf("SELECT ?item ?mesh WHERE { VALUES ?mesh { %s } ?item wdt:P486 ?mesh . }", strings.Join(values, " "))
+
+	var response wikidataQueryResponse
+	err := getJSON(ctx, fmt.Sprintf(wikidataQueryURL, url.QueryEscape(query)), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	for _, binding := range response.Results.Bindings {
+		item := binding["item"].Value
+		qid := item[strings.LastIndex(item, "/")+1:]
+		// If several items claim the same descriptor then stick with the first, so runs agree
+		if _, ok := res[binding["mesh"].Value]; !ok {
+			res[binding["mesh"].Value] = qid
+		}
+	}
+	return res, nil
+}
+
+// findSubjectAnnotations looks up the paper's MeSH headings and adds those with Wikidata items to the
+// article as subject annotations.
+func (processor PaperProcessor) findSubjectAnnotations(ctx context.Context, article *ScienceSourceArticle) error {
+
+	pmid := ""
+	if article.PMID != nil {
+		pmid = *article.PMID
+	} else {
+		var err error
+		pmid, err = FetchPMID(ctx, processor.Paper.ID())
+		if err != nil {
+			return err
+		}
+	}
+	if len(pmid) == 0 {
+		logging.Logf(logging.LogNormal, "Paper %s is not in PubMed, so has no MeSH headings", processor.Paper.ID())
+		return nil
+	}
+
+	headings, err := FetchMeSHHeadings(ctx, pmid)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(headings))
+	for i, heading := range headings {
+		ids[i] = heading.DescriptorID
+	}
+	qids, err := MapMeSHToWikidata(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	today := processor.timeCode()
+	article.Subjects = make([]ScienceSourceAnnotation, 0, len(headings))
+	for _, heading := range headings {
+		qid, ok := qids[heading.DescriptorID]
+		if !ok {
+			logging.Logf(logging.LogVerbose, "MeSH heading %s (%s) has no Wikidata item", heading.Name, heading.DescriptorID)
+			continue
+		}
+		article.Subjects = append(article.Subjects, ScienceSourceAnnotation{
+			TermFound:                 heading.Name,
+			DictionaryName:            MeSHDictionaryName,
+			WikiDataItemCode:          qid,
+			LengthOfTermFound:         len(heading.Name),
+			TimeCode:                  today,
+			ScienceSourceArticleTitle: article.ScienceSourceArticleTitle,
+		})
+	}
+	return nil
+}
+
+// CreateSubjectAnnotations makes an item for each of the article's subject annotations that doesn't have
+// one yet, based on the article item. The checkpoint is called after each so the caller can save it.
+func (c *ScienceSourceClient) CreateSubjectAnnotations(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	err := c.beginItemCreation(ctx, article, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	for i := range article.Subjects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		subject := &article.Subjects[i]
+		subject.InstanceOf = c.wikiBaseClient.ItemMap["annotation"]
+		subject.BasedOn = article.ID
+
+		if len(subject.ID) == 0 {
+			id, err := c.createItem(ctx, article.itemKey("subject", i), "subject annotation instance")
+			if err != nil {
+				return err
+			}
+			subject.ID = id
+			err = checkpoint()
+			if err != nil {
+				return err
+			}
+		}
+		err := c.uploadItemClaims(ctx, subject)
+		if err != nil {
+			return err
+		}
+	}
+	article.endItemCreation()
+	return checkpoint()
+}
+
+// CheckQueryService asks the Wikidata query service a trivial question, to check it can be reached.
+func CheckQueryService(ctx context.Context) error {
+	var response struct {
+		Boolean bool `json:"boolean"`
+	}
+	return getJSON(ctx, fmt.Sprintf(wikidataQueryURL, url.QueryEscape("ASK {}")), nil, &response)
+}
@@ -0,0 +1,271 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// The same text can be written in Unicode in more than one way, such as an accented letter as one code
+// point or as a letter followed by a combining accent. Papers' XML mixes these, and MediaWiki normalises
+// what it is given to NFC, so unless we normalise the text and HTML ourselves before uploading them the
+// server's copy can end up a different length to ours, and every anchor point after the difference
+// drifts off its term. We also refuse to upload anything that isn't valid UTF-8, rather than leave the
+// server to replace the bad bytes.
+
+const (
+	NormalisationNone = "none"
+	NormalisationNFC  = "NFC"
+	NormalisationNFD  = "NFD"
+	NormalisationNFKC = "NFKC"
+	NormalisationNFKD = "NFKD"
+)
+
+// NormalisationForm is the Unicode normalisation form applied to paper text and HTML before uploading,
+// which should be the one the server uses.
+var NormalisationForm string = NormalisationNFC
+
+var normalisationForms = map[string]norm.Form{
+	NormalisationNFC:  norm.NFC,
+	NormalisationNFD:  norm.NFD,
+	NormalisationNFKC: norm.NFKC,
+	NormalisationNFKD: norm.NFKD,
+}
+
+// ValidateNormalisationForm checks NormalisationForm is one we know.
+func ValidateNormalisationForm() error {
+	if _, ok := normalisationForms[NormalisationForm]; ok || NormalisationForm == NormalisationNone {
+		return nil
+	}
+	return fmt.Errorf("Normalisation form must be %s, %s, %s, %s, or %s, not %s", NormalisationNFC, NormalisationNFD,
+		NormalisationNFKC, NormalisationNFKD, NormalisationNone, NormalisationForm)
+}
+
+type InvalidUTF8Error struct {
+	Name   string
+	Offset int
+}
+
+func (e InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("%s is not valid UTF-8: bad byte sequence at offset %d", e.Name, e.Offset)
+}
+
+// checkUTF8 returns an InvalidUTF8Error giving where the data first stops being valid UTF-8, if it does.
+func checkUTF8(name string, data []byte) error {
+	if utf8.Valid(data) {
+		return nil
+	}
+	for offset := 0; offset < len(data); {
+		r, size := utf8.DecodeRune(data[offset:])
+		if r == utf8.RuneError && size <= 1 {
+			return InvalidUTF8Error{Name: name, Offset: offset}
+		}
+		offset += size
+	}
+	return nil
+}
+
+// normaliseText returns the text in the given normalisation form, along with the edits that made it, one
+// for each run of characters that changed. Text that is already normalised is returned as is, with no edits.
+func normaliseText(text []byte, formName string) ([]byte, []textEdit) {
+
+	form, ok := normalisationForms[formName]
+	if ok == false || form.IsNormal(text) {
+		return text, nil
+	}
+
+	// Normalisation only ever changes characters between two boundaries, so doing each segment
+	// between boundaries separately tells us exactly where the text changed
+	res := make([]byte, 0, len(text))
+	edits := make([]textEdit, 0)
+	for offset := 0; offset < len(text); {
+		size := form.NextBoundary(text[offset:], true)
+		if size <= 0 {
+			size = len(text) - offset
+		}
+		segment := text[offset : offset+size]
+		normalised := form.Bytes(segment)
+		if !bytes.Equal(segment, normalised) {
+			edits = append(edits, textEdit{OldStart: offset, OldEnd: offset + size,
+				NewStart: len(res), NewEnd: len(res) + len(normalised)})
+		}
+		res = append(res, normalised...)
+		offset += size
+	}
+	return res, edits
+}
+
+// textCleaning is how text is made fit to upload: what is done with control characters, and the
+// normalisation form it is put in.
+type textCleaning struct {
+	controlCharacters string
+	normalisationForm string
+}
+
+// uploadCleaning is the cleaning ControlCharacters and NormalisationForm ask for.
+func uploadCleaning() textCleaning {
+	return textCleaning{controlCharacters: ControlCharacters, normalisationForm: NormalisationForm}
+}
+
+// cleanText runs the text through each of the passes that make it fit to upload, returning the edits
+// each pass made, in order.
+func cleanText(text []byte, cleaning textCleaning) ([]byte, [][]textEdit) {
+	text, replaced := replaceControlCharacters(text, cleaning.controlCharacters)
+	text, normalised := normaliseText(text, cleaning.normalisationForm)
+	return text, [][]textEdit{replaced, normalised}
+}
+
+// cleanString cleans a term or phrase in the same way as the text it came from.
+func cleanString(s string, cleaning textCleaning) string {
+	text, _ := cleanText([]byte(s), cleaning)
+	return string(text)
+}
+
+func editCount(passes [][]textEdit) int {
+	count := 0
+	for _, edits := range passes {
+		count += len(edits)
+	}
+	return count
+}
+
+// readCleaned reads the file, checking it is UTF-8, and returns its contents cleaned, along with the
+// edits that took.
+func readCleaned(filename string, cleaning textCleaning) ([]byte, [][]textEdit, error) {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkUTF8(filename, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleaned, passes := cleanText(data, cleaning)
+	return cleaned, passes, nil
+}
+
+// mapOffset finds where an offset in the old text is after the edits.
+func mapOffset(edits []textEdit, offset int) int {
+	shift := 0
+	for _, edit := range edits {
+		if edit.OldStart >= offset {
+			break
+		}
+		if edit.OldEnd > offset {
+			return edit.NewStart
+		}
+		shift += (edit.NewEnd - edit.NewStart) - (edit.OldEnd - edit.OldStart)
+	}
+	return offset + shift
+}
+
+// applyCleaning moves the article's anchor points to where their terms are in the cleaned text, and
+// cleans the terms and phrases to match it.
+func (article *ScienceSourceArticle) applyCleaning(text []byte, passes [][]textEdit, cleaning textCleaning,
+	phraseSize int) error {
+
+	if editCount(passes) == 0 {
+		return nil
+	}
+	// Check every anchor point lines up before moving any of them
+	offsets := make([]int, len(article.Annotations))
+	terms := make([]string, len(article.Annotations))
+	for i, anchor := range article.Annotations {
+		term := cleanString(anchor.Annotation.TermFound, cleaning)
+		offset := anchor.CharacterNumber - article.BodyOffset
+		for _, edits := range passes {
+			offset = mapOffset(edits, offset)
+		}
+		if offset < 0 || offset+len(term) > len(text) || string(text[offset:offset+len(term)]) != term {
+			return fmt.Errorf("Anchor point %d for %q no longer lines up with its term once the text is cleaned",
+				i, anchor.Annotation.TermFound)
+		}
+		offsets[i], terms[i] = offset, term
+	}
+
+	for i := range article.Annotations {
+		anchor := &article.Annotations[i]
+		anchor.CharacterNumber = offsets[i] + article.BodyOffset
+		anchor.Annotation.TermFound = terms[i]
+		anchor.Annotation.LengthOfTermFound = len(terms[i])
+	}
+	return article.FillAnchorContext(text, phraseSize)
+}
+
+// cleanContent cleans the paper's HTML and text before they are uploaded, moving the anchor points along
+// if that changed the length of the text before them, and saving the article if so. It refuses content
+// that isn't valid UTF-8, whatever cleaning is asked for.
+func (processor PaperProcessor) cleanContent(article *ScienceSourceArticle) error {
+
+	cleaning := uploadCleaning()
+	html, passes, err := readCleaned(processor.targetHTMLFileName(), cleaning)
+	if err != nil {
+		return err
+	}
+	if count := editCount(passes); count != 0 {
+		logging.Logf(logging.LogVerbose, "Cleaning %d parts of the HTML of paper %s", count, processor.Paper.ID())
+		err = ioutil.WriteFile(processor.targetHTMLFileName(), html, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	text, passes, err := readCleaned(processor.targetTextFileName(), cleaning)
+	if err != nil {
+		return err
+	}
+	count := editCount(passes)
+	if count == 0 {
+		return nil
+	}
+	logging.Logf(logging.LogVerbose, "Cleaning %d parts of the text of paper %s", count, processor.Paper.ID())
+
+	// Move the anchor points before touching the text, so if they can't be moved we've changed nothing
+	err = article.applyCleaning(text, passes, cleaning, processor.PhraseSize)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(processor.targetTextFileName(), text, 0644)
+	if err != nil {
+		return err
+	}
+	return article.Save(processor.targetScienceSourceStateFileName())
+}
+
+// cleanFiles cleans the paper's HTML and text, for when they have no anchor points yet.
+func (processor PaperProcessor) cleanFiles() error {
+	for _, filename := range []string{processor.targetHTMLFileName(), processor.targetTextFileName()} {
+		data, passes, err := readCleaned(filename, uploadCleaning())
+		if err != nil {
+			return err
+		}
+		if editCount(passes) == 0 {
+			continue
+		}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
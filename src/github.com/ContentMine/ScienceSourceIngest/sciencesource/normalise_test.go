@@ -0,0 +1,194 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNormaliseText(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		form  string
+		input string
+		want  string
+		edits int
+	}{
+		{"NFC already normal", NormalisationNFC, "café au lait", "café au lait", 0},
+		{"NFC combining accent", NormalisationNFC, "cafe\u0301 au lait", "café au lait", 1},
+		{"NFC two accents", NormalisationNFC, "cafe\u0301 and nai\u0308ve", "café and naïve", 2},
+		{"NFC keeps ligature", NormalisationNFC, "ﬁbrin", "ﬁbrin", 0},
+		{"NFD splits accent", NormalisationNFD, "café", "cafe\u0301", 1},
+		{"NFKC ligature", NormalisationNFKC, "ﬁbrin levels", "fibrin levels", 1},
+		{"NFKC superscript", NormalisationNFKC, "x² + y²", "x2 + y2", 2},
+		{"NFKC combining accent", NormalisationNFKC, "cafe\u0301", "café", 1},
+		{"none", NormalisationNone, "cafe\u0301 ﬁbrin", "cafe\u0301 ﬁbrin", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, edits := normaliseText([]byte(test.input), test.form)
+			if string(got) != test.want {
+				t.Errorf("Got %q, expected %q", got, test.want)
+			}
+			if len(edits) != test.edits {
+				t.Errorf("Got %d edits, expected %d: %v", len(edits), test.edits, edits)
+			}
+			// Each edit must replace exactly the old bytes with the new ones
+			for _, edit := range edits {
+				old := []byte(test.input)[edit.OldStart:edit.OldEnd]
+				if !bytes.Equal(got[edit.NewStart:edit.NewEnd], normalisationForms[test.form].Bytes(old)) {
+					t.Errorf("Edit %v doesn't match the normalised text", edit)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUTF8(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		offset int // -1 if valid
+	}{
+		{"plain ascii", -1},
+		{"café", -1},
+		{"bad \xff byte", 4},
+		{"café then \xc3", 11},
+	}
+
+	for _, test := range tests {
+		err := checkUTF8("test", []byte(test.input))
+		if test.offset < 0 {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", test.input, err)
+			}
+			continue
+		}
+		utf8_err, ok := err.(InvalidUTF8Error)
+		if !ok {
+			t.Errorf("%q: expected InvalidUTF8Error, got %v", test.input, err)
+		} else if utf8_err.Offset != test.offset {
+			t.Errorf("%q: got offset %d, expected %d", test.input, utf8_err.Offset, test.offset)
+		}
+	}
+}
+
+// anchoredArticle makes an article with an anchor point on each of the terms, found in order in the text.
+func anchoredArticle(t *testing.T, text string, bodyOffset int, terms ...string) *ScienceSourceArticle {
+	article := &ScienceSourceArticle{BodyOffset: bodyOffset}
+	from := 0
+	for _, term := range terms {
+		index := bytes.Index([]byte(text)[from:], []byte(term))
+		if index < 0 {
+			t.Fatalf("Term %q not in %q", term, text)
+		}
+		anchor := ScienceSourceAnchorPoint{CharacterNumber: bodyOffset + from + index}
+		anchor.Annotation.TermFound = term
+		anchor.Annotation.LengthOfTermFound = len(term)
+		article.Annotations = append(article.Annotations, anchor)
+		from += index + len(term)
+	}
+	return article
+}
+
+func TestApplyCleaningMovesAnchors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		form  string
+		text  string
+		terms []string
+		want  []string // the terms as they should read in the cleaned text
+	}{
+		{
+			name:  "NFC before and between terms",
+			form:  NormalisationNFC,
+			text:  "Cafe\u0301 society and aspirin, then nai\u0308ve fibrin and more aspirin.",
+			terms: []string{"aspirin", "fibrin", "aspirin"},
+			want:  []string{"aspirin", "fibrin", "aspirin"},
+		},
+		{
+			name:  "NFC inside a term",
+			form:  NormalisationNFC,
+			text:  "Patients given cafe\u0301ine and then aspirin.",
+			terms: []string{"cafe\u0301ine", "aspirin"},
+			want:  []string{"caféine", "aspirin"},
+		},
+		{
+			name:  "NFKC ligatures in and around terms",
+			form:  NormalisationNFKC,
+			text:  "Eﬃcacy of ﬁbrin, x² and aspirin.",
+			terms: []string{"ﬁbrin", "aspirin"},
+			want:  []string{"fibrin", "aspirin"},
+		},
+		{
+			name:  "control characters and NFC together",
+			form:  NormalisationNFC,
+			text:  "Line one\r\nCafe\u0301\x07 with aspirin\rand fibrin.",
+			terms: []string{"aspirin", "fibrin"},
+			want:  []string{"aspirin", "fibrin"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const bodyOffset = 100
+			article := anchoredArticle(t, test.text, bodyOffset, test.terms...)
+
+			cleaning := textCleaning{controlCharacters: ControlCharactersStrip, normalisationForm: test.form}
+			cleaned, passes := cleanText([]byte(test.text), cleaning)
+			if editCount(passes) == 0 {
+				t.Fatalf("Expected %q to need cleaning", test.text)
+			}
+			err := article.applyCleaning(cleaned, passes, cleaning, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, anchor := range article.Annotations {
+				term := anchor.Annotation.TermFound
+				if term != test.want[i] {
+					t.Errorf("Anchor %d: term is %q, expected %q", i, term, test.want[i])
+				}
+				if anchor.Annotation.LengthOfTermFound != len(term) {
+					t.Errorf("Anchor %d: length is %d, expected %d", i, anchor.Annotation.LengthOfTermFound, len(term))
+				}
+				offset := anchor.CharacterNumber - bodyOffset
+				if offset < 0 || offset+len(term) > len(cleaned) || string(cleaned[offset:offset+len(term)]) != term {
+					t.Errorf("Anchor %d at %d doesn't line up with %q in %q", i, offset, term, cleaned)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyCleaningRefusesMisalignedAnchors(t *testing.T) {
+
+	text := "Cafe\u0301 society and aspirin."
+	article := anchoredArticle(t, text, 0, "aspirin")
+	article.Annotations[0].CharacterNumber -= 2
+
+	cleaning := textCleaning{controlCharacters: ControlCharactersStrip, normalisationForm: NormalisationNFC}
+	cleaned, passes := cleanText([]byte(text), cleaning)
+	if err := article.applyCleaning(cleaned, passes, cleaning, 0); err == nil {
+		t.Fatal("Expected an error for an anchor that doesn't line up with its term")
+	}
+	if article.Annotations[0].CharacterNumber != bytes.Index([]byte(text), []byte("aspirin"))-2 {
+		t.Error("Anchor was moved despite the error")
+	}
+}
@@ -0,0 +1,220 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overnight batches are run without anyone watching, so notifiers let us tell people how a batch went
+// when it finishes, or as soon as enough papers have failed that someone should take a look. A notifier
+// is given as a specification:
+//
+//   slack:https://hooks.slack.com/...      - post to a Slack incoming webhook
+//   smtp://user@host:port?from=a&to=b,c    - send an email via the SMTP server
+//
+// The SMTP password is read from the SCIENCESOURCE_SMTP_PASSWORD environment variable, so it doesn't end
+// up in the run record or the process list.
+
+type Notifier interface {
+	Notify(subject string, message string) error
+}
+
+const smtpPasswordVariable string = "SCIENCESOURCE_SMTP_PASSWORD"
+
+// How long to wait for a notification to be sent before giving up on it
+const notifyTimeout time.Duration = 30 * time.Second
+
+func NewNotifier(spec string) (Notifier, error) {
+
+	if strings.HasPrefix(spec, "slack:") {
+		webhook := strings.TrimPrefix(spec, "slack:")
+		if _, err := url.ParseRequestURI(webhook); err != nil {
+			return nil, fmt.Errorf("Slack webhook %s is not a URL: %v", webhook, err)
+		}
+		return SlackNotifier{WebhookURL: webhook}, nil
+	}
+
+	if strings.HasPrefix(spec, "smtp://") {
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		notifier := EmailNotifier{
+			Server: u.Host,
+			From:   u.Query().Get("from"),
+		}
+		if u.Port() == "" {
+			notifier.Server = u.Host + ":25"
+		}
+		for _, to := range strings.Split(u.Query().Get("to"), ",") {
+			if to = strings.TrimSpace(to); len(to) > 0 {
+				notifier.To = append(notifier.To, to)
+			}
+		}
+		if len(notifier.From) == 0 || len(notifier.To) == 0 {
+			return nil, fmt.Errorf("Email notifier %s needs both from and to addresses", u.Redacted())
+		}
+		if u.User != nil {
+			notifier.Username = u.User.Username()
+			notifier.Password = os.Getenv(smtpPasswordVariable)
+		}
+		return notifier, nil
+	}
+
+	return nil, fmt.Errorf("Notifier %s should start slack: or smtp://", spec)
+}
+
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (n SlackNotifier) Notify(subject string, message string) error {
+
+	body, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", subject, message),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(n.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+type EmailNotifier struct {
+	Server   string // host:port
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (n EmailNotifier) Notify(subject string, message string) error {
+
+	var auth smtp.Auth
+	if len(n.Username) > 0 {
+		host := n.Server
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(strings.Replace(message, "\n", "\r\n", -1))
+
+	return smtp.SendMail(n.Server, auth, n.From, n.To, body.Bytes())
+}
+
+// BatchNotifications keeps count of how papers in a run are going, and sends notifications when the
+// failures reach the threshold (once per run) and when the batch is done. Failing to send a notification
+// is logged but doesn't stop the run.
+type BatchNotifications struct {
+	Notifiers        []Notifier
+	RunID            string
+	FailureThreshold int // 0 means only notify at the end of the batch
+
+	lock      sync.Mutex
+	succeeded int
+	failed    []string
+	alerted   bool
+}
+
+// The most failed papers to list in a notification, so a bad run doesn't send an enormous email
+const notifyFailureListLimit int = 20
+
+func (n *BatchNotifications) send(subject string, message string) {
+	for _, notifier := range n.Notifiers {
+		err := notifier.Notify(subject, message)
+		if err != nil {
+			log.Printf("Failed to send notification %q: %v", subject, err)
+		}
+	}
+}
+
+func (n *BatchNotifications) failureList() string {
+	var list strings.Builder
+	for i, id := range n.failed {
+		if i == notifyFailureListLimit {
+			fmt.Fprintf(&list, "...and %d more\n", len(n.failed)-i)
+			break
+		}
+		fmt.Fprintf(&list, "%s\n", id)
+	}
+	return list.String()
+}
+
+func (n *BatchNotifications) PaperDone(paperID string, err error) {
+	if n == nil || len(n.Notifiers) == 0 {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if err == nil {
+		n.succeeded += 1
+		return
+	}
+	n.failed = append(n.failed, fmt.Sprintf("%s: %v", paperID, err))
+
+	if n.FailureThreshold > 0 && len(n.failed) >= n.FailureThreshold && n.alerted == false {
+		n.alerted = true
+		n.send(fmt.Sprintf("ScienceSourceIngest run %s: %d papers have failed", n.RunID, len(n.failed)),
+			fmt.Sprintf("The run is carrying on, but may need looking at. Failures so far:\n\n%s", n.failureList()))
+	}
+}
+
+func (n *BatchNotifications) BatchDone(stopErr error) {
+	if n == nil || len(n.Notifiers) == 0 {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "%d papers succeeded and %d failed.\n", n.succeeded, len(n.failed))
+	if stopErr != nil {
+		fmt.Fprintf(&message, "The run stopped early: %v\n", stopErr)
+	}
+	if len(n.failed) > 0 {
+		fmt.Fprintf(&message, "\nFailures:\n\n%s", n.failureList())
+		fmt.Fprintf(&message, "\nRetry them with: rerun -failed %s\n", n.RunID)
+	}
+
+	n.send(fmt.Sprintf("ScienceSourceIngest run %s finished", n.RunID), message.String())
+}
@@ -0,0 +1,84 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Authors' affiliations are more use as items than as text, as then all the articles from an
+// institution link to the same place. Organisations are identified by their ROR ID, so each gets one
+// item on the server with a "ROR ID" statement, found by that statement or created the first time an
+// article needs it, which the "affiliation" qualifiers on author statements then point to.
+
+// The property on organisation items for their ROR ID, and the author qualifier that links to them
+const rorIDProperty string = "ROR ID"
+const affiliationProperty string = "affiliation"
+
+// organisationItem finds the item for the affiliation's organisation by its ROR ID, creating one if
+// there isn't one yet, and remembers it for other articles.
+func (c *ScienceSourceClient) organisationItem(ctx context.Context, affiliation ArticleAffiliation) (string, error) {
+	c.organisationsLock.Lock()
+	defer c.organisationsLock.Unlock()
+
+	if id, ok := c.organisations[affiliation.ROR]; ok {
+		return id, nil
+	}
+	if c.organisations == nil {
+		c.organisations = make(map[string]string)
+	}
+
+	property, err := c.extraProperty(ctx, rorIDProperty, "external-id")
+	if err != nil {
+		return "", err
+	}
+	ids, err := c.network.FindEntitiesWithStatement(ctx, property, affiliation.ROR)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) > 0 {
+		c.organisations[affiliation.ROR] = ids[0]
+		return ids[0], nil
+	}
+
+	label := affiliation.Name
+	if len(label) == 0 {
+		label = affiliation.Text
+	}
+	logging.Logf(logging.LogNormal, "Creating organisation item %q for ROR ID %s", label, affiliation.ROR)
+	id, err := c.network.CreateEntity(ctx, "item", label, "")
+	if err != nil {
+		return "", err
+	}
+	_, err = c.network.CreateClaim(ctx, id, property, affiliation.ROR)
+	if err != nil {
+		return "", err
+	}
+	if len(affiliation.WikiDataItemCode) != 0 {
+		wikidataPropertyID, err := c.PropertyID("Wikidata item code")
+		if err != nil {
+			return "", err
+		}
+		_, err = c.network.CreateClaim(ctx, id, wikidataPropertyID, affiliation.WikiDataItemCode)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	c.organisations[affiliation.ROR] = id
+	return id, nil
+}
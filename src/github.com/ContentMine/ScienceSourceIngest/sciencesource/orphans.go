@@ -0,0 +1,145 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// If a run dies part way through creating an article's items then a later run may create them again,
+// leaving items on the server that say they belong to the article but that can't be reached by
+// following its anchor chain. These are orphans, and want reviewing and tidying up.
+
+type OrphanItem struct {
+	ID     string
+	Title  string // of the item's page
+	Kind   string // "anchor point" or "annotation"
+	Reason string
+}
+
+// itemClaim returns the first item a statement with the given property refers to, or "" if there isn't one.
+func (c *ScienceSourceClient) itemClaim(entity wikibase.Entity, propertyLabel string) string {
+	propertyID, err := c.PropertyID(propertyLabel)
+	if err != nil {
+		return ""
+	}
+	values := entity.ItemClaims(propertyID)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// FindOrphans lists the anchor point and annotation items on the server that refer to the article item
+// but that aren't reachable from it.
+func (c *ScienceSourceClient) FindOrphans(ctx context.Context, articleID string) ([]OrphanItem, error) {
+
+	entities, err := c.network.GetEntities(ctx, []string{articleID})
+	if err != nil {
+		return nil, err
+	}
+	article, ok := entities[articleID]
+	if !ok || !article.Exists() {
+		return nil, fmt.Errorf("Article item %s is not on the server", articleID)
+	}
+
+	anchors, err := c.itemsReferencing(ctx, article, "anchor point in")
+	if err != nil {
+		return nil, err
+	}
+	anchorsByID := make(map[string]wikibase.Entity, len(anchors))
+	for _, anchor := range anchors {
+		anchorsByID[anchor.ID] = anchor
+	}
+
+	// Walk the chain from the article to the terminus to see which anchors are in use
+	reachable := make(map[string]bool, len(anchors))
+	next := c.itemClaim(article, "following anchor point")
+	for {
+		anchor, ok := anchorsByID[next]
+		if !ok || reachable[next] {
+			break
+		}
+		reachable[next] = true
+		next = c.itemClaim(anchor, "following anchor point")
+	}
+
+	res := make([]OrphanItem, 0)
+	for _, anchor := range anchors {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !reachable[anchor.ID] {
+			res = append(res, OrphanItem{ID: anchor.ID, Title: anchor.Title, Kind: "anchor point", Reason: "not in the anchor chain"})
+		}
+
+		// Each anchor should have exactly one annotation, which it points to and which points back
+		annotations, err := c.itemsReferencing(ctx, anchor, "based on")
+		if err != nil {
+			return nil, err
+		}
+		linked := c.itemClaim(anchor, "anchors")
+		for _, annotation := range annotations {
+			switch {
+			case !reachable[anchor.ID]:
+				res = append(res, OrphanItem{ID: annotation.ID, Title: annotation.Title, Kind: "annotation",
+					Reason: fmt.Sprintf("based on orphaned anchor point %s", anchor.ID)})
+			case annotation.ID != linked:
+				res = append(res, OrphanItem{ID: annotation.ID, Title: annotation.Title, Kind: "annotation",
+					Reason: fmt.Sprintf("anchor point %s anchors %s instead", anchor.ID, linked)})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// CheckOrphanCreator makes sure an orphan was created by the account we're using, as anything made by
+// someone else isn't ours to tidy up, even if it looks like it is in the wrong place.
+func (c *ScienceSourceClient) CheckOrphanCreator(ctx context.Context, orphan OrphanItem) error {
+
+	if len(c.user) == 0 {
+		user, err := c.network.CurrentUser(ctx)
+		if err != nil {
+			return err
+		}
+		c.user = user
+	}
+
+	creator, err := c.network.PageCreator(ctx, orphan.Title)
+	if err != nil {
+		return err
+	}
+	if creator != c.user {
+		return fmt.Errorf("Item %s was created by %s rather than %s", orphan.ID, creator, c.user)
+	}
+	return nil
+}
+
+// DeleteOrphan removes an orphaned item from the server, having first checked we created it.
+func (c *ScienceSourceClient) DeleteOrphan(ctx context.Context, orphan OrphanItem) error {
+
+	err := c.CheckOrphanCreator(ctx, orphan)
+	if err != nil {
+		return err
+	}
+
+	return c.network.DeletePage(ctx, orphan.Title,
+		fmt.Sprintf("Orphaned %s left by an earlier ingest: %s (cleanup run %s)", orphan.Kind, orphan.Reason, c.RunID))
+}
@@ -0,0 +1,777 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	europmc "github.com/ContentMine/go-europmc"
+	"github.com/hashicorp/errwrap"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/ContentMine/ScienceSourceIngest/tracing"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+type PaperProcessor struct {
+	Paper               Paper
+	XSLTProcPath        string
+	TargetDirectory     string
+	Categories          []string
+	HeaderTemplate      *template.Template
+	Confirmer           *Confirmer
+	Hooks               Hooks
+	TimeCode            time.Time
+	PhraseSize          int // roughly how many bytes of text to record either side of each annotation
+	UploadMedia         bool
+	MediaTemplate       *template.Template
+	ScienceSourceRecord *ScienceSourceArticle
+	Sources             []PaperSource // Where to fetch the paper XML from, in order of preference
+	JournalCheck        *JournalCheck // If set, the journal must pass this before the paper is converted
+	RetractionCheck     string        // If set, whether to refuse or flag retracted papers
+	MeSH                bool          // Add the paper's MeSH headings as subject annotations
+	Funding             bool          // Add statements for the paper's funders and grants
+	Affiliations        bool          // Add statements for the paper's authors, with their affiliations from ROR
+	AbstractOnly        bool          // Only annotate terms found in the paper's abstract
+	Keywords            bool          // Add the authors' keywords that match Wikidata items as main subjects
+	MainSubjectMinimum  int           // If set, items annotated at least this many times are added as main subjects
+	Claims              ClaimStore    // Where claims on the paper are kept, if not in its folder
+}
+
+const HTMLHeader string = `{{articleheader
+| Wikidata_code = %s
+| title = %s
+| publication_date = %04d-%02d-%02d
+| author1 = %s
+| Generator = %s/%s
+}}
+`
+
+const HTMLFooter string = `{{articlefooter
+| pmcid = %s
+| license = %s
+| main_subject = %s
+| batch_date = %04d-%02d-%02d
+}}
+`
+
+// Generic helpers
+
+func fetchResource(ctx context.Context, url string, filename string) error {
+
+	// if it already exists, don't fetch it again
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	err = ratelimit.WaitForURL(ctx, url)
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	resp, resp_err := http.DefaultClient.Do(req)
+	if resp_err != nil {
+		os.Remove(filename)
+		return resp_err
+	}
+	defer resp.Body.Close()
+
+	_, copy_err := io.Copy(f, resp.Body)
+	if copy_err != nil {
+		// don't leave a partial file around, or we'd not try to fetch it again next time
+		os.Remove(filename)
+	}
+	return copy_err
+}
+
+// Computed properties
+
+func (processor PaperProcessor) folderName() string {
+	return path.Join(processor.TargetDirectory, processor.Paper.ID())
+}
+
+func (processor PaperProcessor) targetXMLFileName() string {
+	return path.Join(processor.folderName(), "paper.xml")
+}
+
+func (processor PaperProcessor) targetHTMLFileName() string {
+	return path.Join(processor.folderName(), "paper.html")
+}
+
+func (processor PaperProcessor) targetTextFileName() string {
+	return path.Join(processor.folderName(), "paper.txt")
+}
+
+func (processor PaperProcessor) targetScienceSourceStateFileName() string {
+	return stateFileName(processor.folderName(), "scisource")
+}
+
+func (processor PaperProcessor) targetClaimDiscrepanciesFileName() string {
+	return path.Join(processor.folderName(), "claim_discrepancies.json")
+}
+
+func (processor PaperProcessor) targetSupplementaryArchiveFileName() string {
+	return path.Join(processor.folderName(), "supplementary.zip")
+}
+
+// The time code recorded on items is the day of the run unless the operator says otherwise
+func (processor PaperProcessor) timeCode() time.Time {
+	if processor.TimeCode.IsZero() == false {
+		return wikibase.TruncateTime(processor.TimeCode, wikibase.TimePrecisionDay)
+	}
+	return wikibase.TruncateTime(time.Now(), wikibase.TimePrecisionDay)
+}
+
+// Side effect heavy functions
+
+func (processor PaperProcessor) createFolderIfRequired() error {
+	return os.MkdirAll(processor.folderName(), 0755)
+}
+
+// fetchPaperTextToDisk fetches the paper XML from the first of our sources that has it, unless we have it
+// already, returning which source it came from and where.
+func (processor PaperProcessor) fetchPaperTextToDisk(ctx context.Context) (string, string, error) {
+
+	if _, err := os.Stat(processor.targetXMLFileName()); err == nil {
+		return "", "", nil
+	}
+
+	sources := processor.Sources
+	if len(sources) == 0 {
+		source, err := LookupPaperSource(DefaultPaperSource)
+		if err != nil {
+			return "", "", err
+		}
+		sources = []PaperSource{source}
+	}
+	return fetchPaperXML(ctx, sources, processor.Paper, processor.targetXMLFileName())
+}
+
+func (processor PaperProcessor) fetchPaperSupplementaryFilesToDisk(ctx context.Context) error {
+	return fetchResource(ctx, processor.Paper.SupplementaryFilesURL(), processor.targetSupplementaryArchiveFileName())
+}
+
+// Main processing functions
+
+func (processor PaperProcessor) populateScienceSourceArticle() (*ScienceSourceArticle, error) {
+
+	pubDate, precision, err := processor.Paper.PublicationDate()
+	if err != nil {
+		return nil, err
+	}
+
+	article := &ScienceSourceArticle{
+		WikiDataItemCode:          processor.Paper.WikiDataID(),
+		ArticleTextTitle:          processor.Paper.Title.Value,
+		ScienceSourceArticleTitle: processor.Paper.ScienceSourceArticleTitle(),
+		TimeCode:                  processor.timeCode(),
+	}
+	article.SetPublicationDate(pubDate, precision)
+	article.SetIdentifiers(processor.Paper.DOI.Value, "", processor.Paper.ID())
+
+	return article, nil
+}
+
+func (processor PaperProcessor) processXMLToHTML(FirstAuthor *europmc.ContributorName, customHeader string) error {
+
+	firstName := ""
+	surname := ""
+	if FirstAuthor != nil {
+		surname = FirstAuthor.Surname
+		firstName = FirstAuthor.GivenNames // TODO!
+	}
+
+	pub_date, _, err := processor.Paper.PublicationDate()
+	if err != nil {
+		return errwrap.Wrapf("Error finding publication date: {{err}}", err)
+	}
+	header := fmt.Sprintf(HTMLHeader,
+		processor.Paper.WikiDataID(),
+		processor.Paper.Title.Value,
+		pub_date.Year(), pub_date.Month(), pub_date.Day(),
+		fmt.Sprintf("%s %s", firstName, surname),
+		Remote, Version,
+	)
+
+	now := time.Now()
+	footer := fmt.Sprintf(HTMLFooter,
+		processor.Paper.PMCID.Value,
+		processor.Paper.LicenseLabel.Value,
+		processor.Paper.MainSubjectLabel.Value,
+		now.Year(), now.Month(), now.Day(),
+	)
+
+	converter := convert.Converter{XSLTProcPath: processor.XSLTProcPath}
+	return converter.ToHTML(processor.targetXMLFileName(), processor.targetHTMLFileName(), customHeader+header, footer)
+}
+
+func (processor PaperProcessor) processXMLToText() ([]convert.TextRange, error) {
+	converter := convert.Converter{XSLTProcPath: processor.XSLTProcPath}
+	return converter.ToTextWithAbstracts(processor.targetXMLFileName(), processor.targetTextFileName())
+}
+
+// setLanguage records the language of the paper, taking the paper's word for it if it gives one, and
+// otherwise guessing from the text.
+func (processor PaperProcessor) setLanguage(language string) error {
+
+	if len(language) == 0 {
+		data, err := ioutil.ReadFile(processor.targetTextFileName())
+		if err != nil {
+			return err
+		}
+		language = convert.DetectLanguage(data)
+	}
+	if len(language) == 0 {
+		log.Printf("Couldn't tell what language paper %s is in", processor.Paper.ID())
+		return nil
+	}
+	logging.Logf(logging.LogVerbose, "Paper %s is in language %s", processor.Paper.ID(), language)
+	processor.ScienceSourceRecord.Language = &language
+	return nil
+}
+
+func (processor PaperProcessor) findAnnotations(annotators []annotate.Annotator, article *ScienceSourceArticle,
+	articleTitle string, journalTitle string, bodyOffset int, abstracts []convert.TextRange) error {
+
+	data, err := ioutil.ReadFile(processor.targetTextFileName())
+	if err != nil {
+		return errwrap.Wrapf("Error reading text mining file: {{err}}", err)
+	}
+
+	// The paper's language decides which annotators suit it, and how they should match
+	language := ""
+	if article.Language != nil {
+		language = *article.Language
+	}
+	total_matches, err := annotate.RunAnnotators(annotate.AnnotatorsForLanguage(annotators, language), data)
+	if err != nil {
+		return errwrap.Wrapf("Error running annotators: {{err}}", err)
+	}
+
+	// For a quick first pass over a batch of papers we might only want terms in the abstract
+	if processor.AbstractOnly {
+		if len(abstracts) == 0 {
+			log.Printf("Paper %s has no abstract, so no annotations will be made", processor.Paper.ID())
+		}
+		in_abstract := total_matches[:0]
+		for _, match := range total_matches {
+			for _, abstract := range abstracts {
+				if abstract.Contains(match.Offset, len(match.Term)) {
+					in_abstract = append(in_abstract, match)
+					break
+				}
+			}
+		}
+		total_matches = in_abstract
+		article.AbstractOnly = true
+	}
+
+	res := make([]ScienceSourceAnchorPoint, len(total_matches))
+
+	for i := 0; i < len(total_matches); i++ {
+		match := total_matches[i]
+
+		today := processor.timeCode()
+
+		annotation := ScienceSourceAnnotation{
+			TermFound:                 match.Term,
+			DictionaryName:            match.Source,
+			DictionaryVersion:         match.Version,
+			WikiDataItemCode:          match.WikiDataID,
+			LengthOfTermFound:         len(match.Term),
+			TimeCode:                  today,
+			ScienceSourceArticleTitle: article.ScienceSourceArticleTitle,
+		}
+
+		anchorPoint := ScienceSourceAnchorPoint{
+			CharacterNumber:           match.Offset + bodyOffset,
+			TimeCode:                  today,
+			ScienceSourceArticleTitle: article.ScienceSourceArticleTitle,
+
+			Annotation: annotation,
+		}
+
+		res[i] = anchorPoint
+	}
+
+	article.BodyOffset = bodyOffset
+	article.Annotations = res
+	return article.FillAnchorContext(data, processor.PhraseSize)
+}
+
+// PreparePaper fetches, converts, and annotates the paper without talking to the server, so it can be
+// done on a machine without credentials for it, and the paper's folder handed on to be uploaded. Papers
+// that have already got that far are left alone.
+func (processor PaperProcessor) PreparePaper(ctx context.Context, annotators []annotate.Annotator) error {
+
+	err := processor.createFolderIfRequired()
+	if err != nil {
+		return errwrap.Wrapf("Failed to create folder for paper: {{err}}", err)
+	}
+	if _, err := processor.Article(); err == nil {
+		return nil
+	}
+	_, err = processor.preparePaper(ctx, annotators, nil)
+	return err
+}
+
+// preparePaper takes the paper from the feed as far as having annotations, ready to upload. The client
+// is only used to measure any header template, and can be nil if there isn't one.
+func (processor PaperProcessor) preparePaper(ctx context.Context, annotators []annotate.Annotator, sciSourceClient *ScienceSourceClient) (*ScienceSourceArticle, error) {
+
+	var err error
+	processor.ScienceSourceRecord, err = processor.populateScienceSourceArticle()
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to populate record: {{err}}", err)
+	}
+
+	err = processor.checkRetractions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = processor.Hooks.Run(HookPre, PaperStateFetched, processor)
+	if err != nil {
+		return nil, err
+	}
+	_, span := tracing.Start(ctx, "fetch")
+	source, text_url, err := processor.fetchPaperTextToDisk(ctx)
+	span.SetAttributes(tracing.String("paper.source", source))
+	span.Finish(err)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to fetch paper text: {{err}}", err)
+	}
+	if len(source) != 0 {
+		processor.ScienceSourceRecord.TextSource = source
+		processor.ScienceSourceRecord.TextURL = text_url
+	}
+	err = processor.Hooks.Run(HookPost, PaperStateFetched, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	/*err = processor.fetchPaperSupplementaryFilesToDisk(ctx)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to fetch paper supplementary files: {{err}}", err)
+	}*/
+
+	metadata, err := convert.LoadPaperMetadataFromFile(processor.targetXMLFileName())
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to load paper XML: {{err}}", err)
+	}
+
+	if processor.JournalCheck != nil {
+		err = processor.JournalCheck.Check(ctx, metadata.JournalTitle, metadata.JournalISSNs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	processor.ScienceSourceRecord.SetIdentifiers(metadata.DOI, metadata.PMID, "")
+	processor.ScienceSourceRecord.Funding = FundingFromMetadata(metadata.Funding)
+	if processor.Affiliations {
+		processor.ScienceSourceRecord.Authors, err = FindAuthorAffiliations(ctx, metadata.Authors)
+		if err != nil {
+			return nil, errwrap.Wrapf("Failed to look up author affiliations: {{err}}", err)
+		}
+	}
+	if processor.Keywords {
+		err = findKeywordSubjects(ctx, processor.ScienceSourceRecord, metadata.Keywords)
+		if err != nil {
+			return nil, errwrap.Wrapf("Failed to reconcile keywords: {{err}}", err)
+		}
+	}
+
+	// The paper itself is a better source of how precise the publication date is than Wikidata
+	if metadata.PublicationDatePrecision != 0 {
+		processor.ScienceSourceRecord.SetPublicationDate(metadata.PublicationDate, metadata.PublicationDatePrecision)
+	}
+
+	customHeader, err := processor.renderHeaderTemplate()
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to render header template: {{err}}", err)
+	}
+	bodyOffset := 0
+	if len(strings.TrimSpace(customHeader)) != 0 {
+		if sciSourceClient == nil {
+			return nil, fmt.Errorf("A header template has to be measured on the server, so can't be used when only preparing papers")
+		}
+		bodyOffset, err = sciSourceClient.Network().RenderedTextLength(ctx, customHeader)
+		if err != nil {
+			return nil, errwrap.Wrapf("Failed to measure header template: {{err}}", err)
+		}
+	}
+
+	err = processor.Hooks.Run(HookPre, PaperStateConverted, processor)
+	if err != nil {
+		return nil, err
+	}
+	_, span = tracing.Start(ctx, "convert")
+	err = processor.processXMLToHTML(metadata.FirstAuthor, customHeader)
+	if err != nil {
+		span.Finish(err)
+		return nil, errwrap.Wrapf("Failed to convert paper to HTML: {{err}}", err)
+	}
+
+	abstracts, err := processor.processXMLToText()
+	span.Finish(err)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to generate text for mining: {{err}}", err)
+	}
+	err = processor.Hooks.Run(HookPost, PaperStateConverted, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = processor.setLanguage(metadata.Language)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to find paper language: {{err}}", err)
+	}
+
+	err = processor.Hooks.Run(HookPre, PaperStateAnnotated, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span = tracing.Start(ctx, "annotate")
+	err = processor.findAnnotations(annotators, processor.ScienceSourceRecord,
+		metadata.Title, metadata.JournalTitle, bodyOffset, abstracts)
+	span.SetAttributes(tracing.Int("annotations", len(processor.ScienceSourceRecord.Annotations)))
+	span.Finish(err)
+	if err != nil {
+		return nil, errwrap.Wrapf("Error when finding annotations: {{err}}", err)
+	}
+
+	if processor.MeSH {
+		err = processor.findSubjectAnnotations(ctx, processor.ScienceSourceRecord)
+		if err != nil {
+			return nil, errwrap.Wrapf("Failed to fetch MeSH headings: {{err}}", err)
+		}
+	}
+
+	// We can only do this now as categories can depend on which dictionaries had matches
+	err = processor.appendCategoriesToHTML(processor.ScienceSourceRecord)
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to add categories to HTML: {{err}}", err)
+	}
+
+	// Save the record with annotations
+	err = processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	if err != nil {
+		return nil, errwrap.Wrapf("Failed to save paper record: {{err}}", err)
+	}
+	err = processor.Hooks.Run(HookPost, PaperStateAnnotated, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	return processor.ScienceSourceRecord, nil
+}
+
+// main entry point
+
+// ProcessPaper takes the paper as far through the pipeline as it can go, recording a trace span for the
+// paper with one for each stage inside it.
+func (processor PaperProcessor) ProcessPaper(ctx context.Context, annotators []annotate.Annotator, sciSourceClient *ScienceSourceClient) error {
+	ctx, span := tracing.Start(ctx, "process paper",
+		tracing.String("paper.id", processor.Paper.ID()),
+		tracing.String("paper.wikidata", processor.Paper.WikiDataID()))
+	err := processor.processPaper(ctx, annotators, sciSourceClient)
+	span.SetAttributes(tracing.String("paper.state", string(processor.State())))
+	span.Finish(err)
+	return err
+}
+
+func (processor PaperProcessor) processPaper(ctx context.Context, annotators []annotate.Annotator, sciSourceClient *ScienceSourceClient) error {
+
+	err := processor.createFolderIfRequired()
+	if err != nil {
+		return errwrap.Wrapf("Failed to create folder for paper: {{err}}", err)
+	}
+
+	// Has anyone else already put this paper on the server?
+	local, load_err := processor.Article()
+	if load_err != nil {
+		local = nil
+	}
+	existing_id, err := sciSourceClient.alreadyIngested(ctx, processor.Paper, local)
+	if err != nil {
+		return errwrap.Wrapf("Failed to check server for existing article: {{err}}", err)
+	}
+	if len(existing_id) != 0 {
+		logging.Logf(logging.LogNormal, "Paper %s is already on the server as %s, skipping", processor.Paper.ID(), existing_id)
+		return nil
+	}
+
+	// Have we already processed this paper?
+	processor.ScienceSourceRecord, err = LoadScienceSourceArticle(processor.targetScienceSourceStateFileName())
+	if err != nil {
+		processor.ScienceSourceRecord, err = processor.preparePaper(ctx, annotators, sciSourceClient)
+		if err != nil {
+			return err
+		}
+	}
+	logging.Logf(logging.LogVerbose, "Count %d", len(processor.ScienceSourceRecord.Annotations))
+
+	// Check the record before we start talking to the server about it
+	err = processor.ScienceSourceRecord.Validate()
+	if err != nil {
+		return errwrap.Wrapf("Invalid paper record: {{err}}", err)
+	}
+
+	if processor.Confirmer != nil {
+		ok, err := processor.Confirmer.Confirm(processor.Paper, processor.ScienceSourceRecord)
+		if err != nil {
+			return errwrap.Wrapf("Failed to get confirmation: {{err}}", err)
+		}
+		if ok == false {
+			logging.Logf(logging.LogNormal, "Skipping upload of paper %s", processor.Paper.ID())
+			return nil
+		}
+	}
+
+	if processor.ScienceSourceRecord.PageID == 0 {
+		err = processor.Hooks.Run(HookPre, PaperStateUploaded, processor)
+		if err != nil {
+			return err
+		}
+		err = sciSourceClient.checkForDuplicates(ctx, processor.Paper)
+		if err != nil {
+			return err
+		}
+		err = processor.cleanContent(processor.ScienceSourceRecord)
+		if err != nil {
+			return errwrap.Wrapf("Failed to clean paper text: {{err}}", err)
+		}
+		logging.Logf(logging.LogNormal, "Uploading paper %s", processor.Paper.ID())
+		upload_ctx, span := tracing.Start(ctx, "upload")
+		err = sciSourceClient.UploadPaper(upload_ctx, processor.ScienceSourceRecord, processor.targetHTMLFileName())
+		span.Finish(err)
+		if err != nil {
+			return errwrap.Wrapf("Failed to upload paper: {{err}}", err)
+		}
+
+		logging.Logf(logging.LogVerbose, "Page ID is %d", processor.ScienceSourceRecord.PageID)
+
+		if sciSourceClient.CreateTalkPages {
+			err = sciSourceClient.CreateTalkPage(ctx, processor.ScienceSourceRecord, processor.Paper)
+			if err != nil {
+				return errwrap.Wrapf("Failed to create talk page: {{err}}", err)
+			}
+		}
+
+		// Save the record again as it'll have an updated Page ID
+		err = processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+		if err != nil {
+			return errwrap.Wrapf("Failed to re-save paper record: {{err}}", err)
+		}
+		err = processor.Hooks.Run(HookPost, PaperStateUploaded, processor)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Creating all the wikibase items related to the paper is a two pass process, due to the fact that
+	// the virtual data structure that is described in [0] and related examples has two way links between
+	// items (e.g., an Anchor Node item references an Annotation item, and that Annotation item needs to
+	// refer to the Anchor Node).
+	//
+	// So to simplify the logic we only add properties to items once we have created all the items, as that's
+	// the only time when we have all the information about all properties for each item. Each item's ID is
+	// saved as soon as it is created, so if we stop part way through, or between the two passes, we carry on
+	// from where we got to next time.
+	//
+	// [0] https://sciencesource.wmflabs.org/wiki/Data_schema
+	//
+	// If we've been here before then some items may already be on the server, and some we think are there
+	// may not be, so work out what's actually there first and only create what is missing.
+	var existing map[string]wikibase.Entity
+	if len(processor.ScienceSourceRecord.ItemIDs()) != 0 && processor.ScienceSourceRecord.Complete == false {
+		existing, err = sciSourceClient.RemoveMissingItems(ctx, processor.ScienceSourceRecord)
+		if err != nil {
+			return errwrap.Wrapf("Failed to check existing items: {{err}}", err)
+		}
+		err = sciSourceClient.CheckItemsForEdits(ctx, processor.ScienceSourceRecord, existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remember what was there before, so we know what to roll back if this attempt fails
+	before := processor.ScienceSourceRecord.ItemIDs()
+
+	if processor.ScienceSourceRecord.AllItemsCreated() == false {
+		err = processor.Hooks.Run(HookPre, PaperStateCreated, processor)
+		if err != nil {
+			return err
+		}
+
+		create_ctx, span := tracing.Start(ctx, "create items")
+		upload_err := sciSourceClient.CreateArticleItemTree(create_ctx, processor.ScienceSourceRecord, func() error {
+			sciSourceClient.RecordRevisions(processor.ScienceSourceRecord)
+			return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+		})
+		span.Finish(upload_err)
+		if upload_err != nil {
+			upload_err = processor.rollback(ctx, sciSourceClient, before, upload_err)
+		}
+		sciSourceClient.RecordRevisions(processor.ScienceSourceRecord)
+		// regardless of whether we error, do another save to record any partial changes to the tree
+		err = processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+		if err != nil || upload_err != nil {
+
+			// if we had two errors combine them into one
+			if err != nil && upload_err != nil {
+				err = fmt.Errorf("Failed to both create wikibase items (%v) and save state (%v)", upload_err, err)
+			} else if upload_err != nil {
+				err = errwrap.Wrapf("Failed to create article tree: {{err}}", upload_err)
+			}
+
+			return err
+		}
+
+		err = processor.Hooks.Run(HookPost, PaperStateCreated, processor)
+		if err != nil {
+			return err
+		}
+	}
+
+	logging.Logf(logging.LogVerbose, "Reconsiling paper %s", processor.Paper.ID())
+
+	// If we got here then now we have an item for every part of the data structure, so upload all the properties.
+	err = sciSourceClient.ReconsileArticleItemTree(processor.ScienceSourceRecord)
+	if err != nil {
+		return errwrap.Wrapf("Error when reconciling article tree: {{err}}", err)
+	}
+	if len(existing) != 0 {
+		fixed, err := sciSourceClient.FixItemLinks(ctx, processor.ScienceSourceRecord, existing)
+		if err != nil {
+			return errwrap.Wrapf("Error when fixing links between existing items: {{err}}", err)
+		}
+		logging.Logf(logging.LogVerbose, "Fixed %d links between existing items", fixed)
+	}
+	populate_ctx, span := tracing.Start(ctx, "populate items")
+	err = sciSourceClient.PopulateAritcleItemTree(populate_ctx, processor.ScienceSourceRecord, func() error {
+		sciSourceClient.RecordRevisions(processor.ScienceSourceRecord)
+		return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	})
+	span.Finish(err)
+	sciSourceClient.RecordRevisions(processor.ScienceSourceRecord)
+	if err != nil {
+		err = processor.rollback(ctx, sciSourceClient, before, err)
+		if save_err := processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName()); save_err != nil {
+			log.Printf("Failed to save paper record after rollback: %v", save_err)
+		}
+		return errwrap.Wrapf("Error when populating article tree: {{err}}", err)
+	}
+	if sciSourceClient.VerifyClaims {
+		discrepancies, err := sciSourceClient.VerifyArticleClaims(ctx, processor.ScienceSourceRecord)
+		if err != nil {
+			return errwrap.Wrapf("Failed to verify article items: {{err}}", err)
+		}
+		for _, discrepancy := range discrepancies {
+			log.Printf("Paper %s item not as uploaded: %v", processor.Paper.ID(), discrepancy)
+		}
+		err = SaveClaimDiscrepancies(processor.targetClaimDiscrepanciesFileName(), discrepancies)
+		if err != nil {
+			return errwrap.Wrapf("Failed to save claim discrepancies: {{err}}", err)
+		}
+	}
+	err = processor.uploadFigures(ctx, sciSourceClient)
+	if err != nil {
+		return err
+	}
+	err = sciSourceClient.CreateSubjectAnnotations(ctx, processor.ScienceSourceRecord, func() error {
+		return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	})
+	if err != nil {
+		return errwrap.Wrapf("Failed to create subject annotations: {{err}}", err)
+	}
+	err = sciSourceClient.RecordAuthors(ctx, processor.ScienceSourceRecord, func() error {
+		return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	})
+	if err != nil {
+		return errwrap.Wrapf("Failed to record authors: {{err}}", err)
+	}
+	if processor.Funding {
+		err = sciSourceClient.RecordFunding(ctx, processor.ScienceSourceRecord, func() error {
+			return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+		})
+		if err != nil {
+			return errwrap.Wrapf("Failed to record funding: {{err}}", err)
+		}
+	}
+	if processor.MainSubjectMinimum > 0 {
+		added := processor.ScienceSourceRecord.AddAnnotationSubjects(processor.MainSubjectMinimum)
+		logging.Logf(logging.LogVerbose, "Found %d main subjects in annotations", added)
+	}
+	err = sciSourceClient.RecordMainSubjects(ctx, processor.ScienceSourceRecord, func() error {
+		return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	})
+	if err != nil {
+		return errwrap.Wrapf("Failed to record main subjects: {{err}}", err)
+	}
+	err = sciSourceClient.RecordNotices(ctx, processor.ScienceSourceRecord, func() error {
+		return processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	})
+	if err != nil {
+		return errwrap.Wrapf("Failed to record retraction notices: {{err}}", err)
+	}
+	sciSourceClient.RecordRevisions(processor.ScienceSourceRecord)
+	processor.ScienceSourceRecord.Complete = true
+	err = processor.ScienceSourceRecord.Save(processor.targetScienceSourceStateFileName())
+	if err != nil {
+		return errwrap.Wrapf("Failed on final save of paper record: {{err}}", err)
+	}
+
+	err = sciSourceClient.UpdateWatchlist(ctx, processor.ScienceSourceRecord, sciSourceClient.Watchlist)
+	if err != nil {
+		return errwrap.Wrapf("Failed to update watchlist: {{err}}", err)
+	}
+
+	if sciSourceClient.RefreshPages {
+		err = sciSourceClient.Network().RefreshPage(ctx, processor.ScienceSourceRecord.PageID)
+		if err != nil {
+			return errwrap.Wrapf("Failed to refresh article page: {{err}}", err)
+		}
+	}
+
+	logging.Logf(logging.LogNormal, "Completed paper %s", processor.Paper.ID())
+
+	return nil
+}
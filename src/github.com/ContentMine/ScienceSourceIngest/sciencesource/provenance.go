@@ -0,0 +1,193 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alongside each run record we save a PROV-O description of the run as JSON-LD, saying which sources
+// were used, by what software with which dictionaries, and which pages and items on the server it wrote,
+// so that anyone using the data can trace where it came from and reproduce it.
+// See https://www.w3.org/TR/prov-o/
+
+var provenanceContext = map[string]string{
+	"prov":   "http://www.w3.org/ns/prov#",
+	"xsd":    "http://www.w3.org/2001/XMLSchema#",
+	"rdfs":   "http://www.w3.org/2000/01/rdf-schema#",
+	"schema": "http://schema.org/",
+}
+
+type provNode map[string]interface{}
+
+func provRef(id string) provNode {
+	return provNode{"@id": id}
+}
+
+func provTime(t time.Time) provNode {
+	return provNode{"@type": "xsd:dateTime", "@value": t.UTC().Format(time.RFC3339)}
+}
+
+func runProvenanceFileName(record *RunRecord) string {
+	return strings.TrimSuffix(record.filename, ".json") + ".prov.jsonld"
+}
+
+// SaveRunProvenance saves the provenance of a finished run next to its record. Only the pages and items
+// this client wrote to are described as generated by the run.
+func (c *ScienceSourceClient) SaveRunProvenance(record *RunRecord, processors []PaperProcessor) error {
+
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	inRun := make(map[string]bool, len(record.Papers))
+	for _, paper := range record.Papers {
+		inRun[paper.ID] = true
+	}
+
+	runID := fmt.Sprintf("urn:sciencesource:run:%s", record.ID)
+	agentID := "urn:sciencesource:software:ScienceSourceIngest"
+	if len(Version) != 0 {
+		agentID += ":" + Version
+	}
+
+	run := provNode{
+		"@id":                    runID,
+		"@type":                  "prov:Activity",
+		"rdfs:label":             fmt.Sprintf("ScienceSourceIngest run %s", record.ID),
+		"prov:startedAtTime":     provTime(record.Started),
+		"prov:wasAssociatedWith": provRef(agentID),
+	}
+	if record.Finished != nil {
+		run["prov:endedAtTime"] = provTime(*record.Finished)
+	}
+	agent := provNode{
+		"@id":                    agentID,
+		"@type":                  "prov:SoftwareAgent",
+		"rdfs:label":             "ScienceSourceIngest",
+		"schema:softwareVersion": Version,
+	}
+	if len(Remote) != 0 {
+		agent["schema:codeRepository"] = provRef(Remote)
+	}
+
+	graph := []provNode{run, agent}
+	used := make([]provNode, 0)
+	dictionaries := make(map[string]provNode)
+
+	for _, processor := range processors {
+		if inRun[processor.Paper.ID()] == false {
+			continue
+		}
+		article, err := processor.Article()
+		if err != nil {
+			continue
+		}
+
+		source := processor.Paper.FullTextURL()
+		if len(article.TextURL) != 0 {
+			source = article.TextURL
+		}
+		graph = append(graph, provNode{
+			"@id":              source,
+			"@type":            "prov:Entity",
+			"rdfs:label":       processor.Paper.Title.Value,
+			"prov:alternateOf": provRef(processor.Paper.Item.Value),
+		})
+		used = append(used, provRef(source))
+
+		articleDictionaries := make([]provNode, 0)
+		seen := make(map[string]bool)
+		for _, anchor := range article.Annotations {
+			name := anchor.Annotation.DictionaryName
+			id := fmt.Sprintf("urn:sciencesource:dictionary:%s", name)
+			if len(anchor.Annotation.DictionaryVersion) != 0 {
+				id += ":" + anchor.Annotation.DictionaryVersion
+			}
+			if _, ok := dictionaries[id]; !ok {
+				dictionary := provNode{
+					"@id":        id,
+					"@type":      "prov:Entity",
+					"rdfs:label": name,
+				}
+				if len(anchor.Annotation.DictionaryVersion) != 0 {
+					dictionary["schema:version"] = anchor.Annotation.DictionaryVersion
+				}
+				dictionaries[id] = dictionary
+			}
+			if seen[id] == false {
+				seen[id] = true
+				articleDictionaries = append(articleDictionaries, provRef(id))
+			}
+		}
+
+		// The page and items we wrote to, at the revision we left them in
+		if revision := c.network.PageRevision(article.PageID); revision != 0 {
+			graph = append(graph, provNode{
+				"@id":                   fmt.Sprintf("%s/w/index.php?oldid=%d", c.network.URLBase, revision),
+				"@type":                 "prov:Entity",
+				"rdfs:label":            article.ScienceSourceArticleTitle,
+				"prov:specializationOf": provRef(fmt.Sprintf("%s/w/index.php?curid=%d", c.network.URLBase, article.PageID)),
+				"prov:wasGeneratedBy":   provRef(runID),
+				"prov:wasDerivedFrom":   provRef(source),
+			})
+		}
+		for _, id := range article.ItemIDs() {
+			revision := c.network.EntityRevision(id)
+			if revision == 0 {
+				continue
+			}
+			derivedFrom := []provNode{provRef(source)}
+			if id != string(article.ID) {
+				derivedFrom = append(derivedFrom, articleDictionaries...)
+			}
+			graph = append(graph, provNode{
+				"@id":                   fmt.Sprintf("%s/w/index.php?oldid=%d", c.network.URLBase, revision),
+				"@type":                 "prov:Entity",
+				"prov:specializationOf": provRef(fmt.Sprintf("%s/entity/%s", c.network.URLBase, id)),
+				"prov:wasGeneratedBy":   provRef(runID),
+				"prov:wasDerivedFrom":   derivedFrom,
+			})
+		}
+	}
+
+	ids := make([]string, 0, len(dictionaries))
+	for id := range dictionaries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		graph = append(graph, dictionaries[id])
+		used = append(used, provRef(id))
+	}
+	run["prov:used"] = used
+
+	f, err := os.Create(runProvenanceFileName(record))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(provNode{
+		"@context": provenanceContext,
+		"@graph":   graph,
+	})
+}
@@ -0,0 +1,63 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Publication dates are often only known to the year or month. The article's publication date field is
+// uploaded at the day precision datamodel.json gives it, so it only gets given the date when we know the
+// day, otherwise we keep hold of it and upload the claim with the right precision separately.
+
+func (article *ScienceSourceArticle) SetPublicationDate(date time.Time, precision wikibase.TimePrecision) {
+	if precision == wikibase.TimePrecisionDay {
+		date = wikibase.TruncateTime(date, precision)
+		article.PublicationDate = &date
+		article.PublicationDateValue = nil
+	} else {
+		value := wikibase.NewWikibaseTime(date, precision)
+		article.PublicationDate = nil
+		article.PublicationDateValue = &value
+	}
+}
+
+func (c *ScienceSourceClient) UploadPublicationDate(ctx context.Context, article *ScienceSourceArticle) error {
+
+	if article.PublicationDateValue == nil || len(article.PublicationDateClaim) != 0 {
+		return nil
+	}
+
+	err := article.PublicationDateValue.Validate()
+	if err != nil {
+		return err
+	}
+
+	property, err := c.PropertyID("publication date")
+	if err != nil {
+		return err
+	}
+
+	claim, err := c.network.CreateClaim(ctx, string(article.ID), property, article.PublicationDateValue)
+	if err != nil {
+		return err
+	}
+	article.PublicationDateClaim = claim
+
+	return nil
+}
@@ -0,0 +1,283 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ingest can be split over machines with work queues on a Redis server, so that fetching, converting,
+// and annotating papers can happen away from the one machine that holds the credentials for the wiki.
+// The enqueue command puts papers from the feed on a queue; ingest with -prepareonly takes them off,
+// prepares them, and puts each paper along with its files on a second queue; and an ingest with the
+// credentials takes those, unpacks them into its output directory, and uploads them as usual. Queues
+// are Redis lists, pushed on the left and popped from the right, so jobs are handled in the order they
+// were queued, and each by just one worker.
+//
+// A popped job is atomically moved onto a list of the jobs that worker is working on, and only removed
+// from there once it is done or has failed, so a worker that crashes part way through a job doesn't lose
+// it. The jobs left on that list are put back on the queue when the worker next starts, or by the
+// enqueue command with -requeue if the worker isn't coming back.
+
+const (
+	DefaultPaperQueue    string = "sciencesource:papers"
+	DefaultPreparedQueue string = "sciencesource:prepared"
+
+	// How long to block on the server at a time waiting for a job, so cancelling is noticed
+	queuePollInterval time.Duration = 5 * time.Second
+
+	// Workers' lists of the jobs they are working on are named after the queue with this and the worker
+	queueProcessingInfix string = ":processing:"
+)
+
+type QueueJob struct {
+	Paper Paper             `json:"paper"`
+	Files map[string][]byte `json:"files,omitempty"` // The prepared paper's files, by path within its folder
+
+	raw string // As it was on the queue, so it can be found on the worker's list again
+}
+
+type JobQueue struct {
+	Name   string
+	Worker string
+
+	client *redisClient
+}
+
+// NewJobQueue connects to a queue. Jobs popped off it are kept on a list for the worker until they are
+// done, so the worker's name should stay the same if it is restarted.
+func NewJobQueue(redisURL string, name string, worker string) (*JobQueue, error) {
+	client, err := newRedisClient(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &JobQueue{Name: name, Worker: worker, client: client}, nil
+}
+
+func (q *JobQueue) processingList() string {
+	return q.Name + queueProcessingInfix + q.Worker
+}
+
+func (q *JobQueue) Close() error {
+	return q.client.close()
+}
+
+func (q *JobQueue) Push(ctx context.Context, job QueueJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.do(ctx, 0, "LPUSH", q.Name, string(data))
+	return err
+}
+
+// Length returns how many jobs are waiting on the queue.
+func (q *JobQueue) Length(ctx context.Context) (int, error) {
+	reply, err := q.client.do(ctx, 0, "LLEN", q.Name)
+	if err != nil {
+		return 0, err
+	}
+	length, ok := reply.(int64)
+	if ok == false {
+		return 0, fmt.Errorf("Unexpected reply to LLEN from Redis: %v", reply)
+	}
+	return int(length), nil
+}
+
+// Failed puts a job that couldn't be done on a queue of its own, named after this one with :failed on
+// the end, so that it isn't lost and can be looked at or queued again.
+func (q *JobQueue) Failed(ctx context.Context, job QueueJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.do(ctx, 0, "LPUSH", q.Name+":failed", string(data))
+	if err != nil {
+		return err
+	}
+	return q.Done(ctx, job)
+}
+
+// Done removes a popped job from the worker's list, once it no longer needs doing.
+func (q *JobQueue) Done(ctx context.Context, job QueueJob) error {
+	if len(job.raw) == 0 {
+		return nil
+	}
+	_, err := q.client.do(ctx, 0, "LREM", q.processingList(), "1", job.raw)
+	return err
+}
+
+// requeueList moves every job on a worker's list back onto the queue, behind those already waiting.
+func (q *JobQueue) requeueList(ctx context.Context, list string) (int, error) {
+	count := 0
+	for {
+		reply, err := q.client.do(ctx, 0, "RPOPLPUSH", list, q.Name)
+		if err != nil || reply == nil {
+			return count, err
+		}
+		count += 1
+	}
+}
+
+// Requeue puts back on the queue the jobs this worker took but never finished, such as when it was
+// stopped or crashed part way through them. Returns how many there were.
+func (q *JobQueue) Requeue(ctx context.Context) (int, error) {
+	return q.requeueList(ctx, q.processingList())
+}
+
+// RequeueAll puts back on the queue the jobs any worker took but never finished, for when those workers
+// have stopped for good. Returns how many there were.
+func (q *JobQueue) RequeueAll(ctx context.Context) (int, error) {
+
+	lists := make([]string, 0)
+	cursor := "0"
+	for {
+		reply, err := q.client.do(ctx, 0, "SCAN", cursor, "MATCH", q.Name+queueProcessingInfix+"*")
+		if err != nil {
+			return 0, err
+		}
+		pair, ok := reply.([]interface{})
+		if ok == false || len(pair) != 2 {
+			return 0, fmt.Errorf("Unexpected reply to SCAN from Redis: %v", reply)
+		}
+		cursor, ok = pair[0].(string)
+		keys, keys_ok := pair[1].([]interface{})
+		if ok == false || keys_ok == false {
+			return 0, fmt.Errorf("Unexpected reply to SCAN from Redis: %v", reply)
+		}
+		for _, key := range keys {
+			if name, ok := key.(string); ok {
+				lists = append(lists, name)
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	count := 0
+	for _, list := range lists {
+		moved, err := q.requeueList(ctx, list)
+		count += moved
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Pop takes the oldest job off the queue, waiting up to wait for one to arrive, or for ever if wait is
+// zero. If none arrives in time the job is nil. The job stays on the worker's list until Done or Failed
+// is called with it.
+func (q *JobQueue) Pop(ctx context.Context, wait time.Duration) (*QueueJob, error) {
+
+	start := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		slice := queuePollInterval
+		if wait > 0 {
+			remaining := wait - time.Since(start)
+			if remaining <= 0 {
+				return nil, nil
+			}
+			if remaining < slice {
+				slice = remaining
+			}
+		}
+		seconds := int(slice.Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+
+		// BRPOPLPUSH rather than BLMOVE, so older servers will do
+		reply, err := q.client.do(ctx, time.Duration(seconds)*time.Second, "BRPOPLPUSH", q.Name,
+			q.processingList(), strconv.Itoa(seconds))
+		if err != nil {
+			return nil, err
+		}
+		if reply == nil {
+			continue
+		}
+
+		data, ok := reply.(string)
+		if ok == false {
+			return nil, fmt.Errorf("Unexpected reply to BRPOPLPUSH from Redis: %v", reply)
+		}
+		job := QueueJob{raw: data}
+		err = json.Unmarshal([]byte(data), &job)
+		if err != nil {
+			// Not something any worker can do, so out of the way with it
+			if _, failed_err := q.client.do(ctx, 0, "LPUSH", q.Name+":failed", data); failed_err == nil {
+				q.Done(ctx, job)
+			}
+			return nil, fmt.Errorf("Bad job on queue %s: %v", q.Name, err)
+		}
+		return &job, nil
+	}
+}
+
+// PreparedJob packs up what uploading the paper needs from its folder to be passed on: the paper's XML,
+// HTML, and text, and its state. Anything else there, such as claims, backups, and logs, is this
+// worker's own business.
+func (processor PaperProcessor) PreparedJob() (QueueJob, error) {
+
+	job := QueueJob{Paper: processor.Paper, Files: make(map[string][]byte)}
+	for _, filename := range []string{processor.targetXMLFileName(), processor.targetHTMLFileName(),
+		processor.targetTextFileName(), processor.targetScienceSourceStateFileName()} {
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return job, err
+		}
+		job.Files[path.Base(filename)] = data
+	}
+	return job, nil
+}
+
+// UnpackJob writes the files of a prepared paper into its folder, replacing any already there.
+func (processor PaperProcessor) UnpackJob(job QueueJob) error {
+
+	folder := processor.folderName()
+	for name, data := range job.Files {
+		relative := filepath.Clean(filepath.FromSlash(name))
+		if filepath.IsAbs(relative) || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("Prepared paper %s has a file outside its folder: %s", processor.Paper.ID(), name)
+		}
+		filename := filepath.Join(folder, relative)
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
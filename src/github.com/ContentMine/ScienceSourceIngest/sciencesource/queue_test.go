@@ -0,0 +1,293 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves just the list commands the job queue uses, with lists kept left first. Blocking pops
+// don't block, as the queue polls anyway.
+type fakeRedis struct {
+	lock  sync.Mutex
+	lists map[string][]string
+}
+
+func startFakeRedis(t *testing.T) (*fakeRedis, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeRedis{lists: make(map[string][]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, "redis://" + listener.Addr().String()
+}
+
+func (server *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	// The client's reply reader reads commands just as well
+	reader := &redisClient{reader: bufio.NewReader(conn)}
+	for {
+		command, err := reader.readReply()
+		if err != nil {
+			return
+		}
+		parts := command.([]interface{})
+		args := make([]string, len(parts))
+		for i, part := range parts {
+			args[i] = part.(string)
+		}
+		conn.Write([]byte(server.do(args)))
+	}
+}
+
+func bulkReply(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// rpop takes from the right of one list and pushes on the left of another, as RPOPLPUSH does.
+func (server *fakeRedis) rpop(source string, destination string) string {
+	list := server.lists[source]
+	if len(list) == 0 {
+		return "$-1\r\n"
+	}
+	value := list[len(list)-1]
+	server.lists[source] = list[:len(list)-1]
+	server.lists[destination] = append([]string{value}, server.lists[destination]...)
+	return bulkReply(value)
+}
+
+func (server *fakeRedis) do(args []string) string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "LPUSH":
+		server.lists[args[1]] = append([]string{args[2]}, server.lists[args[1]]...)
+		return fmt.Sprintf(":%d\r\n", len(server.lists[args[1]]))
+	case "LLEN":
+		return fmt.Sprintf(":%d\r\n", len(server.lists[args[1]]))
+	case "RPOPLPUSH", "BRPOPLPUSH":
+		return server.rpop(args[1], args[2])
+	case "LREM":
+		list := server.lists[args[1]]
+		for i, value := range list {
+			if value == args[3] {
+				server.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+				return ":1\r\n"
+			}
+		}
+		return ":0\r\n"
+	case "SCAN":
+		keys := make([]string, 0)
+		for key, list := range server.lists {
+			if matched, _ := path.Match(args[3], key); matched && len(list) != 0 {
+				keys = append(keys, key)
+			}
+		}
+		reply := fmt.Sprintf("*2\r\n%s*%d\r\n", bulkReply("0"), len(keys))
+		for _, key := range keys {
+			reply += bulkReply(key)
+		}
+		return reply
+	default:
+		return fmt.Sprintf("-ERR unknown command %s\r\n", args[0])
+	}
+}
+
+func (server *fakeRedis) length(list string) int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return len(server.lists[list])
+}
+
+func testQueue(t *testing.T, redisURL string, worker string) *JobQueue {
+	queue, err := NewJobQueue(redisURL, "test", worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queue.Close() })
+	return queue
+}
+
+func testJob(id string) QueueJob {
+	var job QueueJob
+	job.Paper.PMCID.Value = id
+	return job
+}
+
+func TestJobQueueKeepsJobsUntilDone(t *testing.T) {
+
+	server, redisURL := startFakeRedis(t)
+	ctx := context.Background()
+	queue := testQueue(t, redisURL, "w1")
+
+	for _, id := range []string{"PMC1", "PMC2", "PMC3"} {
+		if err := queue.Push(ctx, testJob(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		action     func() error
+		queued     int
+		processing int
+		failed     int
+	}{
+		// Popping keeps the job on the worker's list until it's done
+		{action: func() error {
+			job, err := queue.Pop(ctx, time.Millisecond)
+			if err == nil && job.Paper.ID() != "PMC1" {
+				err = fmt.Errorf("Popped %s, expected PMC1", job.Paper.ID())
+			}
+			if err == nil {
+				err = queue.Done(ctx, *job)
+			}
+			return err
+		}, queued: 2, processing: 0},
+		// A job the worker never finishes stays on its list
+		{action: func() error {
+			_, err := queue.Pop(ctx, time.Millisecond)
+			return err
+		}, queued: 1, processing: 1},
+		// Until the worker starts again and puts it back
+		{action: func() error {
+			count, err := testQueue(t, redisURL, "w1").Requeue(ctx)
+			if err == nil && count != 1 {
+				err = fmt.Errorf("Requeued %d jobs, expected 1", count)
+			}
+			return err
+		}, queued: 2, processing: 0},
+		// A failed job moves to the failed queue
+		{action: func() error {
+			job, err := queue.Pop(ctx, time.Millisecond)
+			if err == nil {
+				err = queue.Failed(ctx, *job)
+			}
+			return err
+		}, queued: 1, processing: 0, failed: 1},
+	}
+
+	for i, step := range steps {
+		if err := step.action(); err != nil {
+			t.Fatalf("Step %d: %v", i, err)
+		}
+		queued, processing, failed := server.length("test"), server.length("test:processing:w1"), server.length("test:failed")
+		if queued != step.queued || processing != step.processing || failed != step.failed {
+			t.Errorf("Step %d: %d queued, %d processing, %d failed, expected %d, %d, %d", i, queued, processing,
+				failed, step.queued, step.processing, step.failed)
+		}
+	}
+
+	// Once the queue's empty, Pop gives up after the wait
+	queue.Pop(ctx, time.Millisecond)
+	job, err := queue.Pop(ctx, time.Millisecond)
+	if err != nil || job != nil {
+		t.Errorf("Pop of empty queue: expected nothing, got %v, %v", job, err)
+	}
+}
+
+func TestJobQueueRequeueAll(t *testing.T) {
+
+	server, redisURL := startFakeRedis(t)
+	ctx := context.Background()
+
+	for _, worker := range []string{"w1", "w2"} {
+		queue := testQueue(t, redisURL, worker)
+		if err := queue.Push(ctx, testJob("PMC"+worker)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := queue.Pop(ctx, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.length("test") != 0 {
+		t.Fatalf("Expected both jobs to be taken")
+	}
+
+	count, err := testQueue(t, redisURL, "").RequeueAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || server.length("test") != 2 {
+		t.Errorf("Requeued %d jobs, %d now queued, expected 2 and 2", count, server.length("test"))
+	}
+}
+
+func TestPreparedJob(t *testing.T) {
+
+	processor := PaperProcessor{TargetDirectory: t.TempDir()}
+	processor.Paper.PMCID.Value = "PMC6"
+	if err := processor.createFolderIfRequired(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"paper.xml", "paper.html", "paper.txt", "scisource." + StateFormat,
+		"scisource." + StateFormat + ".bak", claimFileName, "claim_discrepancies.json", "hooks.log"} {
+		if err := ioutil.WriteFile(path.Join(processor.folderName(), name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(path.Join(processor.folderName(), "media"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := processor.PreparedJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(job.Files))
+	for name, data := range job.Files {
+		if string(data) != name {
+			t.Errorf("File %s has the contents of %s", name, data)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"paper.html", "paper.txt", "paper.xml", "scisource." + StateFormat}
+	sort.Strings(expected)
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("Packed %v, expected %v", names, expected)
+	}
+
+	// And the next stage gets them back as they were
+	unpacked := PaperProcessor{Paper: processor.Paper, TargetDirectory: t.TempDir()}
+	if err := unpacked.UnpackJob(job); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(unpacked.targetScienceSourceStateFileName())
+	if err != nil || string(data) != "scisource."+StateFormat {
+		t.Errorf("Unpacked state: %q, %v", data, err)
+	}
+}
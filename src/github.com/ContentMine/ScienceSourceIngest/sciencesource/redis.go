@@ -0,0 +1,216 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal Redis client, speaking just enough of the protocol (RESP) for the job queue: commands are
+// sent as arrays of bulk strings, and replies can be any of the basic types. A dropped connection is
+// redialled on the next command.
+
+const redisDialTimeout time.Duration = 10 * time.Second
+
+type RedisError struct {
+	Message string
+}
+
+func (e RedisError) Error() string {
+	return fmt.Sprintf("Redis error: %s", e.Message)
+}
+
+type redisClient struct {
+	address  string
+	password string
+	database int
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisClient takes the server as redis://[:password@]host[:port][/database]
+func newRedisClient(redisURL string) (*redisClient, error) {
+
+	parsed, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "redis" || len(parsed.Hostname()) == 0 {
+		return nil, fmt.Errorf("Expected redis://host:port, not %s", redisURL)
+	}
+
+	c := &redisClient{address: parsed.Host}
+	if len(parsed.Port()) == 0 {
+		c.address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok {
+			c.password = password
+		} else {
+			c.password = parsed.User.Username()
+		}
+	}
+	if database := strings.Trim(parsed.Path, "/"); len(database) != 0 {
+		c.database, err = strconv.Atoi(database)
+		if err != nil {
+			return nil, fmt.Errorf("Bad Redis database number %s", database)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisClient) close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.drop()
+}
+
+// drop forgets the connection, so the next command dials again. The caller must hold the lock.
+func (c *redisClient) drop() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	return err
+}
+
+// dial connects and selects the database, if not already connected. The caller must hold the lock.
+func (c *redisClient) dial(ctx context.Context) error {
+
+	if c.conn != nil {
+		return nil
+	}
+
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if len(c.password) != 0 {
+		if _, err := c.send(ctx, 0, "AUTH", c.password); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	if c.database != 0 {
+		if _, err := c.send(ctx, 0, "SELECT", strconv.Itoa(c.database)); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+// do sends a command and waits for its reply, allowing the server block for up to wait before replying.
+func (c *redisClient) do(ctx context.Context, wait time.Duration, args ...string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.dial(ctx); err != nil {
+		return nil, err
+	}
+	reply, err := c.send(ctx, wait, args...)
+	if _, ok := err.(RedisError); err != nil && ok == false {
+		// After a network error we can't tell where we are in the stream
+		c.drop()
+	}
+	return reply, err
+}
+
+// send writes a command and reads its reply. The caller must hold the lock.
+func (c *redisClient) send(ctx context.Context, wait time.Duration, args ...string) (interface{}, error) {
+
+	deadline := time.Now().Add(wait + redisDialTimeout)
+	if ctx_deadline, ok := ctx.Deadline(); ok && ctx_deadline.Before(deadline) {
+		deadline = ctx_deadline
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// readReply returns a string, int64, []interface{}, or nil for Redis's null replies.
+func (c *redisClient) readReply() (interface{}, error) {
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, fmt.Errorf("Empty reply from Redis")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError{Message: line[1:]}
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		res := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := c.readReply()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, item)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("Unexpected reply from Redis: %q", line)
+	}
+}
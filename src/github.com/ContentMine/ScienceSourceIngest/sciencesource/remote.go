@@ -0,0 +1,140 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// What is on the server for an article, so we can tell if it matches what we think we uploaded.
+
+type RemoteStatus struct {
+	PageID            int // the ID of the article page on the server, or 0 if there isn't one
+	ArticleItemExists bool
+	Anchors           int // how many anchor point items say they are in the article
+	Problems          []string
+}
+
+func (status *RemoteStatus) problem(format string, args ...interface{}) {
+	status.Problems = append(status.Problems, fmt.Sprintf(format, args...))
+}
+
+// itemsReferencing finds all the items on the server that refer to the target item with the given
+// property, e.g. the anchor points that are "anchor point in" an article. Items don't know what refers
+// to them, but the wiki records links between pages, so we use that to find candidates and then check
+// their statements.
+func (c *ScienceSourceClient) itemsReferencing(ctx context.Context, target wikibase.Entity, propertyLabel string) ([]wikibase.Entity, error) {
+
+	propertyID, err := c.PropertyID(propertyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	titles, err := c.network.LinksTo(ctx, target.Title)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if id := wikibase.EntityIDFromTitle(title); len(id) != 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	entities, err := c.network.GetEntities(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]wikibase.Entity, 0)
+	for _, id := range ids {
+		entity, ok := entities[id]
+		if !ok || !entity.Exists() {
+			continue
+		}
+		for _, value := range entity.ItemClaims(propertyID) {
+			if value == target.ID {
+				res = append(res, entity)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// RemoteStatus checks the server for the article page and items of a paper. The article is what we have
+// saved locally, and may be nil if we've not uploaded anything yet.
+func (c *ScienceSourceClient) RemoteStatus(ctx context.Context, paper Paper, article *ScienceSourceArticle) (RemoteStatus, error) {
+
+	var status RemoteStatus
+
+	if article != nil && article.PageID != 0 {
+		exists, err := c.network.PageExists(ctx, article.PageID)
+		if err != nil {
+			return status, err
+		}
+		if exists {
+			status.PageID = article.PageID
+		} else {
+			status.problem("page %d is not on the server", article.PageID)
+		}
+	} else {
+		// We may have uploaded the page and lost track of it, so look for it by name
+		page_id, err := c.network.PageIDForTitle(ctx, paper.ScienceSourceArticleTitle())
+		if err != nil {
+			return status, err
+		}
+		status.PageID = page_id
+		if page_id != 0 {
+			status.problem("page %d is on the server but not recorded locally", page_id)
+		}
+	}
+
+	if article == nil || len(article.ID) == 0 {
+		return status, nil
+	}
+
+	entities, err := c.network.GetEntities(ctx, []string{string(article.ID)})
+	if err != nil {
+		return status, err
+	}
+	item, ok := entities[string(article.ID)]
+	status.ArticleItemExists = ok && item.Exists()
+	if !status.ArticleItemExists {
+		status.problem("article item %s is not on the server", article.ID)
+		return status, nil
+	}
+
+	anchors, err := c.itemsReferencing(ctx, item, "anchor point in")
+	if err != nil {
+		return status, err
+	}
+	status.Anchors = len(anchors)
+
+	local := 0
+	for _, anchor := range article.Annotations {
+		if len(anchor.ID) != 0 {
+			local += 1
+		}
+	}
+	if local != status.Anchors {
+		status.problem("%d anchor points created locally but %d on the server", local, status.Anchors)
+	}
+
+	return status, nil
+}
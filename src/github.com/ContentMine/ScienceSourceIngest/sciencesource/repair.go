@@ -0,0 +1,157 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// If an article's anchor chain on the server gets broken, for instance by a half finished upload or by
+// hand edits, we can work out what it should be from the character numbers of the anchor points, as the
+// chain runs through them in the order they appear in the text, and fix the links in place.
+
+type LinkFix struct {
+	ItemID   string
+	Property string
+	From     string // "" if the statement was missing
+	To       string
+}
+
+func (fix LinkFix) String() string {
+	if len(fix.From) == 0 {
+		return fmt.Sprintf("%s: add %s %s", fix.ItemID, fix.Property, fix.To)
+	}
+	return fmt.Sprintf("%s: change %s from %s to %s", fix.ItemID, fix.Property, fix.From, fix.To)
+}
+
+// RepairAnchorChain checks the preceding and following anchor point links of the article's anchor
+// points on the server, and fixes any that are missing or wrong. Only the anchor points we have a record
+// of are put in the chain, so that orphans stay out of it. If dryRun is set the fixes are worked out but
+// not made.
+func (c *ScienceSourceClient) RepairAnchorChain(ctx context.Context, article *ScienceSourceArticle, dryRun bool) ([]LinkFix, error) {
+
+	if len(article.ID) == 0 {
+		return nil, fmt.Errorf("No article item recorded to repair")
+	}
+	characterNumberID, err := c.PropertyID("character number")
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := c.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return nil, err
+	}
+	articleEntity, ok := entities[string(article.ID)]
+	if !ok || !articleEntity.Exists() {
+		return nil, fmt.Errorf("Article item %s is not on the server", article.ID)
+	}
+	if dryRun == false {
+		err = c.CheckItemsForEdits(ctx, article, entities)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Order the anchors by the character number on the server, falling back to what we uploaded
+	type chainAnchor struct {
+		entity          wikibase.Entity
+		characterNumber float64
+	}
+	anchors := make([]chainAnchor, 0, len(article.Annotations))
+	for _, anchor := range article.Annotations {
+		entity, ok := entities[string(anchor.ID)]
+		if len(anchor.ID) == 0 || !ok || !entity.Exists() {
+			continue
+		}
+		position := float64(anchor.CharacterNumber)
+		if statements := entity.Claims[characterNumberID]; len(statements) > 0 {
+			if amount, ok := statements[0].MainSnak.Quantity(); ok {
+				position = amount
+			}
+		}
+		anchors = append(anchors, chainAnchor{entity, position})
+	}
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return anchors[i].characterNumber < anchors[j].characterNumber
+	})
+
+	fixes := make([]LinkFix, 0)
+	check := func(entity wikibase.Entity, property string, target string) error {
+		propertyID, err := c.PropertyID(property)
+		if err != nil {
+			return err
+		}
+
+		statements := entity.Claims[propertyID]
+		current := ""
+		if len(statements) > 0 {
+			current = statements[0].MainSnak.ItemID()
+			if current == target {
+				return nil
+			}
+		}
+		fixes = append(fixes, LinkFix{ItemID: entity.ID, Property: property, From: current, To: target})
+		if dryRun {
+			return nil
+		}
+
+		value, err := wikibase.NewItemValue(target)
+		if err != nil {
+			return err
+		}
+		if len(statements) > 0 {
+			return c.network.SetClaimValue(ctx, statements[0].ID, value)
+		}
+		_, err = c.network.CreateClaim(ctx, entity.ID, propertyID, value)
+		return err
+	}
+
+	terminus := string(c.wikiBaseClient.ItemMap["terminus"])
+	first := terminus
+	if len(anchors) > 0 {
+		first = anchors[0].entity.ID
+	}
+	err = check(articleEntity, "following anchor point", first)
+	if err != nil {
+		return fixes, err
+	}
+
+	for i, anchor := range anchors {
+		if err := ctx.Err(); err != nil {
+			return fixes, err
+		}
+		if i != 0 {
+			err = check(anchor.entity, "preceding anchor point", anchors[i-1].entity.ID)
+			if err != nil {
+				return fixes, err
+			}
+		}
+		next := terminus
+		if i != len(anchors)-1 {
+			next = anchors[i+1].entity.ID
+		}
+		err = check(anchor.entity, "following anchor point", next)
+		if err != nil {
+			return fixes, err
+		}
+	}
+
+	return fixes, nil
+}
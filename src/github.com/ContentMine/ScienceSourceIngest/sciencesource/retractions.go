@@ -0,0 +1,194 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Retracted papers shouldn't be presented as sound science, so papers can be checked for retractions
+// and expressions of concern before they're ingested, using Crossref, which includes the Retraction
+// Watch database. Depending on how the operator wants to handle it, a retracted paper is either refused
+// or ingested with a statement on its article item saying it was retracted, and the retractions command
+// adds the same statements to articles retracted after they were ingested.
+// See https://www.crossref.org/documentation/retrieve-metadata/rest-api/
+
+const crossrefWorkURL string = "https://api.crossref.org/works/%s"
+
+// What to do about retracted papers
+const (
+	RetractionsRefuse = "refuse"
+	RetractionsFlag   = "flag"
+)
+
+// Kinds of editorial notice we record
+const (
+	NoticeRetraction          = "retraction"
+	NoticeExpressionOfConcern = "expression_of_concern"
+)
+
+// The properties on article items for the DOIs of the notices
+var noticeProperties = map[string]string{
+	NoticeRetraction:          "retraction notice",
+	NoticeExpressionOfConcern: "expression of concern",
+}
+
+type EditorialNotice struct {
+	Type   string `json:"type"`
+	DOI    string `json:"doi"`
+	Source string `json:"source,omitempty"` // Who told Crossref, e.g. the publisher or retraction-watch
+	Claim  string `json:"claim,omitempty"`  // Set once the article item has a statement for it
+}
+
+func (notice EditorialNotice) String() string {
+	return fmt.Sprintf("%s %s", strings.Replace(notice.Type, "_", " ", -1), notice.DOI)
+}
+
+type RetractedPaperError struct {
+	Paper   string
+	Notices []EditorialNotice
+}
+
+func (e *RetractedPaperError) Error() string {
+	descriptions := make([]string, len(e.Notices))
+	for i, notice := range e.Notices {
+		descriptions[i] = notice.String()
+	}
+	return fmt.Sprintf("Paper %s has been retracted (%s)", e.Paper, strings.Join(descriptions, "; "))
+}
+
+type crossrefWorkResponse struct {
+	Message struct {
+		UpdatedBy []struct {
+			DOI    string `json:"DOI"`
+			Type   string `json:"type"`
+			Source string `json:"source"`
+		} `json:"updated-by"`
+	} `json:"message"`
+}
+
+// FetchEditorialNotices asks Crossref for any retractions or expressions of concern for the DOI.
+func FetchEditorialNotices(ctx context.Context, doi string) ([]EditorialNotice, error) {
+
+	var response crossrefWorkResponse
+	err := getJSON(ctx, fmt.Sprintf(crossrefWorkURL, url.PathEscape(doi)), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]EditorialNotice, 0)
+	for _, update := range response.Message.UpdatedBy {
+		kind := ""
+		switch update.Type {
+		case "retraction", "withdrawal", "removal":
+			kind = NoticeRetraction
+		case "expression_of_concern":
+			kind = NoticeExpressionOfConcern
+		default:
+			// Corrections and the like don't stop the paper being sound
+			continue
+		}
+		res = append(res, EditorialNotice{Type: kind, DOI: NormaliseDOI(update.DOI), Source: update.Source})
+	}
+	return res, nil
+}
+
+// AddNotices adds any notices the article doesn't have already, returning those that were new.
+func (article *ScienceSourceArticle) AddNotices(notices []EditorialNotice) []EditorialNotice {
+
+	added := make([]EditorialNotice, 0)
+	for _, notice := range notices {
+		known := false
+		for _, existing := range article.Notices {
+			if existing.Type == notice.Type && existing.DOI == notice.DOI {
+				known = true
+				break
+			}
+		}
+		if known == false {
+			article.Notices = append(article.Notices, notice)
+			added = append(added, notice)
+		}
+	}
+	return added
+}
+
+func (article *ScienceSourceArticle) Retracted() bool {
+	for _, notice := range article.Notices {
+		if notice.Type == NoticeRetraction {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRetractions looks for notices on the paper before we ingest it, stopping if it has been
+// retracted and we're refusing those.
+func (processor PaperProcessor) checkRetractions(ctx context.Context) error {
+
+	if len(processor.RetractionCheck) == 0 {
+		return nil
+	}
+	if len(processor.Paper.DOI.Value) == 0 {
+		log.Printf("Paper %s has no DOI, so can't be checked for retraction", processor.Paper.ID())
+		return nil
+	}
+
+	notices, err := FetchEditorialNotices(ctx, NormaliseDOI(processor.Paper.DOI.Value))
+	if err != nil {
+		return fmt.Errorf("Failed to check for retraction: %v", err)
+	}
+	article := processor.ScienceSourceRecord
+	for _, notice := range article.AddNotices(notices) {
+		log.Printf("WARNING: paper %s has a %v", processor.Paper.ID(), notice)
+	}
+	if article.Retracted() && processor.RetractionCheck == RetractionsRefuse {
+		return &RetractedPaperError{Paper: processor.Paper.ID(), Notices: article.Notices}
+	}
+	return nil
+}
+
+// RecordNotices makes a statement on the article item for each notice that doesn't have one yet. The
+// checkpoint is called after each so the caller can save the article.
+func (c *ScienceSourceClient) RecordNotices(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) error {
+
+	for i := range article.Notices {
+		notice := &article.Notices[i]
+		if len(notice.Claim) != 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		property, err := c.extraProperty(ctx, noticeProperties[notice.Type], "external-id")
+		if err != nil {
+			return err
+		}
+		notice.Claim, err = c.network.CreateClaim(ctx, string(article.ID), property, notice.DOI)
+		if err != nil {
+			return err
+		}
+		err = checkpoint()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
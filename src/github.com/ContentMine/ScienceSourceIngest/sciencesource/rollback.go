@@ -0,0 +1,120 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/hashicorp/errwrap"
+)
+
+// An article's items only make sense as a whole, so if creating or linking them fails part way we can
+// treat the attempt as a transaction and delete whatever it created, rather than leaving a half wired
+// anchor chain on the server. Deleting needs rights that not every account has, so anything we can't
+// delete is kept in the article record, and will be reused by the next attempt.
+
+// forgetItem clears the ID of the item in the article tree with the given ID.
+func (article *ScienceSourceArticle) forgetItem(id string) {
+	if string(article.ID) == id {
+		article.ID = ""
+	}
+	for i := 0; i < len(article.Annotations); i++ {
+		if string(article.Annotations[i].ID) == id {
+			article.Annotations[i].ID = ""
+		}
+		if string(article.Annotations[i].Annotation.ID) == id {
+			article.Annotations[i].Annotation.ID = ""
+		}
+	}
+}
+
+// RollbackItems deletes the items of the article that aren't in the before list, and forgets their IDs.
+func (c *ScienceSourceClient) RollbackItems(ctx context.Context, article *ScienceSourceArticle, before []string) error {
+
+	existed := make(map[string]bool, len(before))
+	for _, id := range before {
+		existed[id] = true
+	}
+	created := make([]string, 0)
+	for _, id := range article.ItemIDs() {
+		if !existed[id] {
+			created = append(created, id)
+		}
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	deleted, err := c.deleteItems(ctx, article, created, fmt.Sprintf("Rolling back failed upload of article items (run %s)", c.RunID))
+	if err != nil {
+		return err
+	}
+
+	logging.Logf(logging.LogNormal, "Rolled back %d items", deleted)
+	return nil
+}
+
+// deleteItems deletes the given items of the article from the server, forgetting their IDs, and returns
+// how many there were to delete.
+func (c *ScienceSourceClient) deleteItems(ctx context.Context, article *ScienceSourceArticle, ids []string, reason string) (int, error) {
+
+	entities, err := c.network.GetEntities(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		entity, ok := entities[id]
+		if !ok || !entity.Exists() {
+			article.forgetItem(id)
+			continue
+		}
+		err := c.network.DeletePage(ctx, entity.Title, reason)
+		if err != nil {
+			// No point trying the rest if we can't delete
+			return deleted, errwrap.Wrapf("Failed to delete item "+id+": {{err}}", err)
+		}
+		article.forgetItem(id)
+		deleted += 1
+	}
+
+	return deleted, nil
+}
+
+// rollback undoes the items created by a failed attempt, if asked to, and returns the error that caused
+// the failure, along with any from the rollback.
+func (processor PaperProcessor) rollback(ctx context.Context, sciSourceClient *ScienceSourceClient, before []string, cause error) error {
+
+	if !sciSourceClient.RollbackOnFailure {
+		return cause
+	}
+
+	// If we've been interrupted then the operator wants us to stop, and the items will be picked up
+	// again when the run is resumed
+	if ctx.Err() != nil {
+		return cause
+	}
+
+	err := sciSourceClient.RollbackItems(ctx, processor.ScienceSourceRecord, before)
+	if err != nil {
+		log.Printf("Items created for paper %s have been kept for the next attempt: %v", processor.Paper.ID(), err)
+		return fmt.Errorf("%v (and rollback failed: %v)", cause, err)
+	}
+	return cause
+}
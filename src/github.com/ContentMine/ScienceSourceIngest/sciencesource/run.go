@@ -0,0 +1,38 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// NewRunID generates an identifier for this invocation of the tool, so that anything we write to the
+// server can be traced back to the run that did it. It is the UTC start time followed by a few random
+// bytes in case two runs start in the same second.
+func NewRunID() string {
+	now := time.Now().UTC()
+
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		// Not being random isn't fatal here, it's just for disambiguation
+		return now.Format("20060102T150405Z")
+	}
+
+	return fmt.Sprintf("%s-%s", now.Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
@@ -0,0 +1,170 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every ingest run records the arguments it was given and the papers it set out to process, along with
+// how each one went, under its run ID in the output directory. That's enough to replay the run later,
+// for instance once a dictionary or converter has been fixed, or to retry just the papers that failed.
+
+const RunRecordDirectory string = "runs"
+
+// Every write any run makes to the server is also appended to this file in the output directory
+const WriteLogFileName string = "writes.jsonl"
+
+const (
+	RunOutcomePending = "pending"
+	RunOutcomeOK      = "ok"
+	RunOutcomeFailed  = "failed"
+)
+
+type RunPaper struct {
+	ID      string `json:"id"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+type RunRecord struct {
+	ID        string     `json:"id"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Directory string     `json:"directory"` // Where the tool was run from, as arguments may be relative paths
+	Args      []string   `json:"args"`
+	Papers    []RunPaper `json:"papers"`
+
+	lock     sync.Mutex
+	filename string
+}
+
+func runRecordFileName(targetDirectory string, runID string) string {
+	return path.Join(targetDirectory, RunRecordDirectory, runID+".json")
+}
+
+// NewRunRecord starts the record of a run, which is saved under the target directory.
+func NewRunRecord(targetDirectory string, runID string, args []string, paperIDs []string) (*RunRecord, error) {
+
+	directory, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(path.Join(targetDirectory, RunRecordDirectory), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := append([]string{}, paperIDs...)
+	sort.Strings(ids)
+	papers := make([]RunPaper, 0, len(ids))
+	for _, id := range ids {
+		papers = append(papers, RunPaper{ID: id, Outcome: RunOutcomePending})
+	}
+
+	return &RunRecord{
+		ID:        runID,
+		Started:   time.Now().UTC(),
+		Directory: directory,
+		Args:      args,
+		Papers:    papers,
+		filename:  runRecordFileName(targetDirectory, runID),
+	}, nil
+}
+
+func LoadRunRecord(targetDirectory string, runID string) (*RunRecord, error) {
+
+	filename := runRecordFileName(targetDirectory, runID)
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var record RunRecord
+	err = json.NewDecoder(f).Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+	record.filename = filename
+	return &record, nil
+}
+
+func (record *RunRecord) save() error {
+	f, err := os.Create(record.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(record)
+}
+
+func (record *RunRecord) Save() error {
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	return record.save()
+}
+
+// SetOutcome records how a paper went, and saves the record so it survives the run being killed.
+func (record *RunRecord) SetOutcome(paperID string, processErr error) error {
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	for i := range record.Papers {
+		if record.Papers[i].ID == paperID {
+			if processErr != nil {
+				record.Papers[i].Outcome = RunOutcomeFailed
+				record.Papers[i].Error = processErr.Error()
+			} else {
+				record.Papers[i].Outcome = RunOutcomeOK
+				record.Papers[i].Error = ""
+			}
+			return record.save()
+		}
+	}
+	return fmt.Errorf("Paper %s is not part of run %s", paperID, record.ID)
+}
+
+func (record *RunRecord) Finish() error {
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	now := time.Now().UTC()
+	record.Finished = &now
+	return record.save()
+}
+
+// PaperIDs returns the papers in the run, or just those that didn't succeed. Papers still pending were
+// never finished, for instance because the run was interrupted, so count as not succeeding.
+func (record *RunRecord) PaperIDs(failedOnly bool) []string {
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	res := make([]string, 0, len(record.Papers))
+	for _, paper := range record.Papers {
+		if failedOnly == false || paper.Outcome != RunOutcomeOK {
+			res = append(res, paper.ID)
+		}
+	}
+	return res
+}
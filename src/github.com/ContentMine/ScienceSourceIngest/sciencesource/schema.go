@@ -0,0 +1,312 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The properties and items we need on the server are listed in datamodel.json, from which the
+// schemaProperties and schemaItems tables are generated. Normally we find each one on the server by
+// its label, creating it if it doesn't exist yet. As an alternative the server can describe its own
+// schema on a wiki page (e.g. Data_schema), as a table where each row starts with the label we use,
+// and somewhere in the row has the ID of the property or item on the server, either as a plain ID or
+// a link to it:
+//
+//   {| class="wikitable"
+//   ! Label !! Type !! Example instance
+//   |-
+//   | instance of || Item || https://sciencesource.wmflabs.org/wiki/Property:P3
+//   |}
+//
+// That way if a property is relabelled or replaced on the server, the schema page can be updated to
+// match and the tool carries on working without needing a new build.
+
+type SchemaProperty struct {
+	Label    string
+	DataType string
+}
+
+var schemaEntityIDPattern = regexp.MustCompile(`\b([PQ][0-9]+)\b`)
+var wikiLinkPattern = regexp.MustCompile(`\[\[(?:[^|\]]*\|)?([^\]]*)\]\]`)
+var externalLinkPattern = regexp.MustCompile(`\[[a-z]+://[^ \]]*(?: ([^\]]*))?\]`)
+
+func cleanSchemaCell(cell string) string {
+	cell = wikiLinkPattern.ReplaceAllString(cell, "$1")
+	cell = externalLinkPattern.ReplaceAllString(cell, "$1")
+	cell = strings.Replace(cell, "'''", "", -1)
+	cell = strings.Replace(cell, "''", "", -1)
+	return strings.TrimSpace(cell)
+}
+
+// ParseSchemaPage reads the tables on a schema page, returning a map of label to entity ID.
+func ParseSchemaPage(wikitext string) map[string]string {
+
+	res := make(map[string]string)
+
+	for _, row := range strings.Split(wikitext, "\n|-") {
+		var cells []string
+		for _, line := range strings.Split(row, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) == 0 || strings.HasPrefix(line, "{|") || strings.HasPrefix(line, "|}") ||
+				strings.HasPrefix(line, "|+") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "|-") {
+				continue
+			}
+			if strings.HasPrefix(line, "|") {
+				cells = append(cells, strings.Split(line[1:], "||")...)
+			}
+		}
+		if len(cells) < 2 {
+			continue
+		}
+
+		label := cleanSchemaCell(cells[0])
+		for _, cell := range cells[1:] {
+			if match := schemaEntityIDPattern.FindStringSubmatch(cell); match != nil {
+				if len(label) > 0 {
+					res[label] = match[1]
+				}
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// ApplySchemaPage configures the client's property and item maps from the schema page. If the page
+// doesn't cover everything we need we fail, rather than fall back to looking up labels, as that might
+// end up creating properties the server has deliberately renamed.
+func (c *ScienceSourceClient) ApplySchemaPage(ctx context.Context, title string) error {
+
+	wikitext, err := c.network.FetchPageTextByTitle(ctx, title)
+	if err != nil {
+		return err
+	}
+	schema := ParseSchemaPage(wikitext)
+
+	missing := make([]string, 0)
+	for _, property := range schemaProperties {
+		id, ok := schema[property.Label]
+		if !ok || strings.HasPrefix(id, "P") == false {
+			missing = append(missing, property.Label)
+			continue
+		}
+		c.wikiBaseClient.PropertyMap[property.Label] = id
+	}
+	for _, label := range schemaItems {
+		id, ok := schema[label]
+		if !ok || strings.HasPrefix(id, "Q") == false {
+			missing = append(missing, label)
+			continue
+		}
+		c.wikiBaseClient.ItemMap[label] = wikibase.ItemPropertyType(id)
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Schema page %s does not define: %s", title, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// extraProperty finds a property that isn't part of the core data model, creating it if we're allowed to
+// create schema entries, and remembers it for next time.
+func (c *ScienceSourceClient) extraProperty(ctx context.Context, label string, dataType string) (string, error) {
+	c.extraLock.Lock()
+	defer c.extraLock.Unlock()
+
+	if id, ok := c.extraProperties[label]; ok {
+		return id, nil
+	}
+	if c.extraProperties == nil {
+		c.extraProperties = make(map[string]string)
+	}
+	if id, ok := c.wikiBaseClient.PropertyMap[label]; ok {
+		c.extraProperties[label] = id
+		return id, nil
+	}
+
+	id, err := c.resolveEntity(ctx, label, "property", dataType, len(c.SchemaPage) == 0)
+	if err != nil {
+		return "", err
+	}
+	c.extraProperties[label] = id
+	return id, nil
+}
+
+func (c *ScienceSourceClient) resolveEntity(ctx context.Context, label string, entityType string, dataType string, create bool) (string, error) {
+
+	ids, err := c.network.FindEntitiesByLabel(ctx, label, entityType)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(ids) {
+	case 0:
+		id, err := c.fuzzyResolveEntity(ctx, label, entityType)
+		if err != nil {
+			return "", err
+		}
+		if len(id) != 0 {
+			return id, nil
+		}
+		if !create {
+			return "", fmt.Errorf("No %s found with label %q", entityType, label)
+		}
+		logging.Logf(logging.LogNormal, "Creating %s %q", entityType, label)
+		return c.network.CreateEntity(ctx, entityType, label, dataType)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("Label %q is not unique, found %ss %s", label, entityType, strings.Join(ids, ", "))
+	}
+}
+
+// Looking up each label is a round trip to the server, which adds up against a remote instance, so we
+// do a few at once. As with papers we don't want to hammer the server, so keep it small.
+const schemaConcurrencyLimit int = 4
+
+// ResolveSchemaByLabel finds all the properties and items we need on the server by label, creating
+// them if allowed. Every label is tried, and all the failures are reported together.
+func (c *ScienceSourceClient) ResolveSchemaByLabel(ctx context.Context, create bool) error {
+
+	var lock sync.Mutex
+	failures := make([]string, 0)
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, schemaConcurrencyLimit)
+	resolve := func(label string, entityType string, dataType string, store func(id string)) {
+		wg.Add(1)
+		sem <- true
+		go func() {
+			defer func() {
+				wg.Done()
+				<-sem
+			}()
+			id, err := c.resolveEntity(ctx, label, entityType, dataType, create)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failures = append(failures, err.Error())
+			} else {
+				store(id)
+			}
+		}()
+	}
+
+	for _, property := range schemaProperties {
+		label := property.Label
+		resolve(label, "property", property.DataType, func(id string) {
+			c.wikiBaseClient.PropertyMap[label] = id
+		})
+	}
+	for _, label := range schemaItems {
+		label := label
+		resolve(label, "item", "", func(id string) {
+			c.wikiBaseClient.ItemMap[label] = wikibase.ItemPropertyType(id)
+		})
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("Failed to resolve %d labels on the server: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// A SchemaEntry is how one of the properties or items we need has been mapped onto the server, so the
+// operator can check it before anything is uploaded.
+type SchemaEntry struct {
+	Kind           string `json:"kind"` // property or item
+	Label          string `json:"label"`
+	ID             string `json:"id,omitempty"`
+	DataType       string `json:"datatype,omitempty"`        // What we expect the property to hold
+	ServerDataType string `json:"server_datatype,omitempty"` // What the server says it holds
+	Problem        string `json:"problem,omitempty"`
+}
+
+// SchemaEntries lists every property and item the tool needs, with the ID it has been mapped to, if it
+// has, and for properties the data type the server has for it. Entries that are unmapped, missing from
+// the server, or of the wrong data type have a problem saying so.
+func (c *ScienceSourceClient) SchemaEntries(ctx context.Context) ([]SchemaEntry, error) {
+
+	res := make([]SchemaEntry, 0, len(schemaProperties)+len(schemaItems))
+	for _, property := range schemaProperties {
+		res = append(res, SchemaEntry{Kind: "property", Label: property.Label, DataType: property.DataType,
+			ID: c.wikiBaseClient.PropertyMap[property.Label]})
+	}
+	for _, label := range schemaItems {
+		res = append(res, SchemaEntry{Kind: "item", Label: label, ID: string(c.wikiBaseClient.ItemMap[label])})
+	}
+
+	// Two labels mapped to the same entity is as much a mistake as one not being mapped at all
+	ids := make([]string, 0, len(res))
+	labels := make(map[string][]string)
+	for _, entry := range res {
+		if len(entry.ID) != 0 {
+			if len(labels[entry.ID]) == 0 {
+				ids = append(ids, entry.ID)
+			}
+			labels[entry.ID] = append(labels[entry.ID], entry.Label)
+		}
+	}
+
+	dataTypes, err := c.network.EntityDataTypes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range res {
+		entry := &res[i]
+		switch {
+		case len(entry.ID) == 0:
+			entry.Problem = "not found on server"
+		case len(labels[entry.ID]) > 1:
+			others := make([]string, 0, len(labels[entry.ID])-1)
+			for _, label := range labels[entry.ID] {
+				if label != entry.Label {
+					others = append(others, label)
+				}
+			}
+			entry.Problem = fmt.Sprintf("same %s as %s", entry.Kind, strings.Join(others, ", "))
+		default:
+			serverType, ok := dataTypes[entry.ID]
+			entry.ServerDataType = serverType
+			if ok == false {
+				entry.Problem = fmt.Sprintf("%s does not exist on server", entry.Kind)
+			} else if entry.Kind == "item" && len(serverType) != 0 {
+				entry.Problem = "is a property, not an item"
+			} else if entry.Kind == "property" && len(serverType) == 0 {
+				entry.Problem = "is an item, not a property"
+			} else if serverType != entry.DataType {
+				entry.Problem = fmt.Sprintf("data type is %s, not %s", serverType, entry.DataType)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,476 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package sciencesource ingests papers into a ScienceSource wikibase server: fetching and converting
+// them, finding annotations, and creating the article, anchor point, and annotation items that
+// describe them.
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Encoding of structures in json comes from data schema found here:
+//      https://sciencesource.wmflabs.org/wiki/Data_schema
+//
+// The structs for articles, anchor points, and annotations are generated from datamodel.json, so
+// edit that rather than datamodel_gen.go.
+
+//go:generate go run gen_datamodel.go
+
+// These are recorded on the pages we create, and will be set by the build script to something meaningful
+var Remote string
+var Version string
+
+type ScienceSourceClient struct {
+	wikiBaseClient *wikibase.Client
+	network        *wikibase.NetworkClient
+
+	// If set then article pages are purged and null edited once all their items are uploaded
+	RefreshPages bool
+
+	// If set then each article's items are fetched back once populated, and any statements that aren't
+	// as we uploaded them are logged and recorded alongside the paper
+	VerifyClaims bool
+
+	// If set then a talk page with a provenance notice is created alongside each article page
+	CreateTalkPages bool
+
+	// The level to protect article pages at once uploaded, or "none"
+	ProtectionLevel string
+
+	// Whether to add created pages and items to the account's watchlist, or remove them from it
+	Watchlist wikibase.WatchlistAction
+
+	// If set, the wiki page to read property and item IDs from rather than looking them up by label
+	SchemaPage string
+
+	// Identifies the run of the tool that made changes on the server
+	RunID string
+
+	// If set then papers that we've no record of an article item for are skipped if the server has one
+	SkipExisting bool
+
+	// If set then articles are uploaded even if they look like duplicates of pages on the server, and
+	// pages and items are changed even if someone else has edited them since we last did
+	Force bool
+
+	// If set then items created during a failed attempt to upload an article's items are deleted again.
+	// This is off unless asked for, since it deletes from the server.
+	RollbackOnFailure bool
+
+	// The article's state is saved after creating or populating this many of its items, so a crash loses
+	// at most that much progress. Zero saves after every item, as does one.
+	CheckpointInterval int
+
+	// How many of an article's items to create or populate at once. Zero or one does them one at a time.
+	ItemConcurrency int
+
+	// Labels and descriptions to give the items we create, by kind of item and language
+	ItemTerms ItemTermsConfig
+
+	// When a label has no exact match on the server, the closest match found by search is used if it's at
+	// least this similar (0 to 1), or 0 to never accept one automatically
+	LabelAcceptThreshold float64
+
+	// If set then the operator is asked whether to use the closest match for a label with no exact match
+	LabelConfirmer *Confirmer
+
+	// Properties outside the core data model, such as the one linking articles to their figure files,
+	// looked up when first needed
+	extraLock       sync.Mutex
+	extraProperties map[string]string
+
+	// Items for funders, by name, found or created when first needed
+	fundersLock sync.Mutex
+	funders     map[string]string
+
+	// Items for organisations, by ROR ID, found or created when first needed
+	organisationsLock sync.Mutex
+	organisations     map[string]string
+
+	// The account we're logged in as, once we've needed to ask
+	user string
+
+	// The users all our accounts write as, once we've needed to ask
+	usersLock sync.Mutex
+	users     map[string]bool
+}
+
+// NewScienceSourceClient makes a client that writes as the first account, unless there are several, in
+// which case writes are spread over them.
+func NewScienceSourceClient(credentials []*wikibase.Credentials, urlbase string, options wikibase.NetworkOptions) (*ScienceSourceClient, error) {
+
+	oauth_client, err := wikibase.NewPooledNetworkClient(credentials, urlbase, options)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ScienceSourceClient{
+		wikiBaseClient: wikibase.NewClient(oauth_client),
+		network:        oauth_client,
+	}
+
+	return res, nil
+}
+
+// Network gives access to the underlying API client, for calls this package doesn't wrap
+func (c *ScienceSourceClient) Network() *wikibase.NetworkClient {
+	return c.network
+}
+
+// PropertyID looks up the ID of a property that has been mapped from the server.
+func (c *ScienceSourceClient) PropertyID(label string) (string, error) {
+	id, ok := c.wikiBaseClient.PropertyMap[label]
+	if !ok || len(id) == 0 {
+		return "", fmt.Errorf("No property found on server for label %s", label)
+	}
+	return id, nil
+}
+
+func (c *ScienceSourceClient) GetConfigurationFromServer(ctx context.Context) error {
+
+	if len(c.SchemaPage) != 0 {
+		return c.ApplySchemaPage(ctx, c.SchemaPage)
+	}
+
+	return c.ResolveSchemaByLabel(ctx, true)
+}
+
+func (c *ScienceSourceClient) UploadPaper(ctx context.Context, article *ScienceSourceArticle, htmlFileName string) error {
+
+	data, err := ioutil.ReadFile(htmlFileName)
+	if err != nil {
+		return err
+	}
+	err = checkUTF8(htmlFileName, data)
+	if err != nil {
+		return err
+	}
+
+	// The wikibase library doesn't take a context, so the best we can do is not start the upload
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	page_id, upload_error := c.wikiBaseClient.CreateOrUpdateArticle(article.ScienceSourceArticleTitle, string(data))
+	if upload_error != nil {
+
+		// if we get a page exists error then ignore for now and move on, as we assume the title is unique
+		ignore_error := false
+		if err, ok := upload_error.(*wikibase.APIError); ok {
+			ignore_error = err.Code == "articleexists"
+		}
+
+		if ignore_error != true {
+			return upload_error
+		}
+	}
+
+	article.PageID = page_id
+
+	// Check the server stored what we sent before anything gets anchored to it
+	if article.PageID != 0 {
+		err = c.network.VerifyPageContent(ctx, article.PageID, string(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The article text is meant to be immutable once annotations are anchored to character offsets in
+	// it, so by default we protect the page
+	err = c.network.ProtectPage(ctx, article.PageID, c.ProtectionLevel, "Article text is referenced by annotations")
+	if err != nil {
+		return err
+	}
+
+	c.RecordRevisions(article)
+	return nil
+}
+
+// RecordRevisions notes in the article the revisions made by our latest writes to its page and items,
+// so we can tell later if anyone else has edited them since.
+func (c *ScienceSourceClient) RecordRevisions(article *ScienceSourceArticle) {
+
+	if revision := c.network.PageRevision(article.PageID); revision != 0 {
+		article.PageRevision = revision
+	}
+	if revision := c.network.EntityRevision(string(article.ID)); revision != 0 {
+		article.Revision = revision
+	}
+	for i := range article.Annotations {
+		anchor := &article.Annotations[i]
+		if revision := c.network.EntityRevision(string(anchor.ID)); revision != 0 {
+			anchor.Revision = revision
+		}
+		if revision := c.network.EntityRevision(string(anchor.Annotation.ID)); revision != 0 {
+			anchor.Annotation.Revision = revision
+		}
+	}
+	for i := range article.Subjects {
+		if revision := c.network.EntityRevision(string(article.Subjects[i].ID)); revision != 0 {
+			article.Subjects[i].Revision = revision
+		}
+	}
+}
+
+// Article helper functions
+
+// Save replaces the article's saved state, such that a crash part way through can't corrupt it. The
+// state is saved as CBOR if the file name ends .cbor, and as JSON otherwise, and is compressed if
+// CompressState is set or it was compressed already.
+func (article *ScienceSourceArticle) Save(filename string) error {
+	return saveStateFile(filename, func(w io.Writer) error {
+		if isCBORStateFile(filename) {
+			return encodeCBOR(w, article)
+		}
+		return json.NewEncoder(w).Encode(article)
+	})
+}
+
+// LoadScienceSourceArticle loads the article's saved state, or the state before that if it is unreadable,
+// whether it is compressed or not, going by the file name as to whether it is CBOR or JSON.
+func LoadScienceSourceArticle(filename string) (*ScienceSourceArticle, error) {
+
+	var article ScienceSourceArticle
+	err := loadStateFile(filename, func(r io.Reader) error {
+		article = ScienceSourceArticle{}
+		if isCBORStateFile(filename) {
+			return decodeCBOR(r, &article)
+		}
+		return json.NewDecoder(r).Decode(&article)
+	})
+	return &article, err
+}
+
+// Wiki base item related code
+
+// checkpoints calls a checkpoint function once every so many items, rather than after every one, as
+// saving the state of an article with thousands of annotations after each item gets slow. Items can be
+// written several at once, so each one's change to the article is made under the same lock as the save,
+// and a save never sees the article half updated.
+type checkpoints struct {
+	interval int
+	save     func() error
+
+	lock    sync.Mutex
+	pending int
+}
+
+func (c *ScienceSourceClient) newCheckpoints(save func() error) *checkpoints {
+	interval := c.CheckpointInterval
+	if interval < 1 {
+		interval = 1
+	}
+	return &checkpoints{interval: interval, save: save}
+}
+
+// record makes the change for an item that has been written, if there is one, and saves if a chunk's
+// worth have been written since the last save.
+func (p *checkpoints) record(change func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if change != nil {
+		change()
+	}
+	p.pending += 1
+	if p.pending < p.interval {
+		return nil
+	}
+	return p.saveLocked()
+}
+
+// flush saves anything not yet saved.
+func (p *checkpoints) flush() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.saveLocked()
+}
+
+func (p *checkpoints) saveLocked() error {
+	if p.pending == 0 {
+		return nil
+	}
+	p.pending = 0
+	return p.save()
+}
+
+// CreateArticleItemTree creates an item for every part of the article that doesn't have one yet, without
+// any of the links between them. The checkpoint is called after every CheckpointInterval items are
+// created, and once more at the end, so the caller can save their IDs, as otherwise items created just
+// before a crash would be lost track of.
+//
+// The article item is created first, then each annotation followed by its anchor point, with up to
+// ItemConcurrency items being created at once. Each item's ID is written back as part of its checkpoint,
+// so the save never sees the article half updated. Items an earlier attempt created but lost track of are
+// found and used rather than being created again.
+func (c *ScienceSourceClient) CreateArticleItemTree(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) (err error) {
+
+	err = c.beginItemCreation(ctx, article, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	saves := c.newCheckpoints(checkpoint)
+	defer func() {
+		// Save what was created even if we're failing, so long as the save itself wasn't what failed
+		if flush_err := saves.flush(); err == nil {
+			err = flush_err
+		}
+	}()
+
+	article.InstanceOf = c.wikiBaseClient.ItemMap["article"]
+	for i := range article.Annotations {
+		article.Annotations[i].InstanceOf = c.wikiBaseClient.ItemMap["anchor point"]
+		article.Annotations[i].Annotation.InstanceOf = c.wikiBaseClient.ItemMap["annotation"]
+	}
+
+	var graph taskGraph
+
+	// Create the node for the article in the wiki base if necessary
+	var article_task *task
+	if len(article.ID) == 0 {
+		article_task = graph.add(func(ctx context.Context) error {
+			id, err := c.createItem(ctx, article.itemKey("article", 0), "article instance")
+			if err != nil {
+				return err
+			}
+			return saves.record(func() { article.ID = id })
+		})
+	}
+
+	// Create an item for all the anchors and their annotations
+	for i := range article.Annotations {
+		index := i
+
+		var annotation_task *task
+		if len(article.Annotations[index].Annotation.ID) == 0 {
+			annotation_task = graph.add(func(ctx context.Context) error {
+				id, err := c.createItem(ctx, article.itemKey("annotation", index), "annotation instance")
+				if err != nil {
+					return err
+				}
+				return saves.record(func() { article.Annotations[index].Annotation.ID = id })
+			}, article_task)
+		}
+
+		if len(article.Annotations[index].ID) == 0 {
+			graph.add(func(ctx context.Context) error {
+				id, err := c.createItem(ctx, article.itemKey("anchor", index), "anchor instance")
+				if err != nil {
+					return err
+				}
+				return saves.record(func() { article.Annotations[index].ID = id })
+			}, article_task, annotation_task)
+		}
+	}
+
+	err = graph.run(ctx, c.ItemConcurrency)
+	if err != nil {
+		return err
+	}
+	article.endItemCreation()
+	return nil
+}
+
+func (c *ScienceSourceClient) ReconsileArticleItemTree(article *ScienceSourceArticle) error {
+
+	// Patch the article first
+	if len(article.Annotations) == 0 {
+		article.FollowingAnchorPoint = c.wikiBaseClient.ItemMap["terminus"]
+	} else {
+		article.FollowingAnchorPoint = article.Annotations[0].ID
+	}
+
+	for i := 0; i < len(article.Annotations); i++ {
+
+		// Patch anchor point first
+		if i != 0 {
+			article.Annotations[i].PrecedingAnchorPoint = &article.Annotations[i-1].ID
+		} else {
+			article.Annotations[i].PrecedingAnchorPoint = nil
+		}
+		if i != len(article.Annotations)-1 {
+			article.Annotations[i].FollowingAnchorPoint = article.Annotations[i+1].ID
+		} else {
+			article.Annotations[i].FollowingAnchorPoint = c.wikiBaseClient.ItemMap["terminus"]
+		}
+		article.Annotations[i].AnchorPoint = article.ID
+		article.Annotations[i].Anchors = article.Annotations[i].Annotation.ID
+
+		// Patch annotation second
+		article.Annotations[i].Annotation.BasedOn = article.Annotations[i].ID
+	}
+
+	return nil
+}
+
+// PopulateAritcleItemTree uploads the statements for all the article's items. The checkpoint is called
+// as for CreateArticleItemTree, so that the revisions we've made are recorded as we go, and a resumed
+// upload doesn't take our own edits for someone else's.
+//
+// Every item already exists by now, so apart from each anchor point's statements, which link up the
+// chain, waiting for its annotation's, the items can be populated in any order, up to ItemConcurrency at
+// once. Nothing changes the article whilst this happens other than the checkpoint, which is only called
+// by one of them at a time.
+func (c *ScienceSourceClient) PopulateAritcleItemTree(ctx context.Context, article *ScienceSourceArticle, checkpoint func() error) (err error) {
+
+	saves := c.newCheckpoints(checkpoint)
+	defer func() {
+		if flush_err := saves.flush(); err == nil {
+			err = flush_err
+		}
+	}()
+
+	var graph taskGraph
+	graph.add(func(ctx context.Context) error {
+		err := c.uploadItemClaims(ctx, article)
+		if err != nil {
+			return err
+		}
+		err = c.UploadPublicationDate(ctx, article)
+		if err != nil {
+			return err
+		}
+		return saves.record(nil)
+	})
+
+	for i := range article.Annotations {
+		anchor := &article.Annotations[i]
+		annotation_task := graph.add(func(ctx context.Context) error {
+			return c.uploadItemClaims(ctx, &anchor.Annotation)
+		})
+		graph.add(func(ctx context.Context) error {
+			err := c.uploadItemClaims(ctx, anchor)
+			if err != nil {
+				return err
+			}
+			return saves.record(nil)
+		}, annotation_task)
+	}
+
+	err = graph.run(ctx, c.ItemConcurrency)
+	if err != nil {
+		return err
+	}
+
+	return c.SetArticleItemTerms(ctx, article)
+}
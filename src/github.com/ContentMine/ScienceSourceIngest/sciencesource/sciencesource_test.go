@@ -0,0 +1,135 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckpointInterval(t *testing.T) {
+
+	tests := []struct {
+		interval int
+		items    int
+		saves    int // before the final flush
+		flushed  int // after it
+	}{
+		{interval: 0, items: 5, saves: 5, flushed: 5},
+		{interval: 1, items: 5, saves: 5, flushed: 5},
+		{interval: 3, items: 7, saves: 2, flushed: 3},
+		{interval: 3, items: 6, saves: 2, flushed: 2},
+		{interval: 10, items: 4, saves: 0, flushed: 1},
+		{interval: 10, items: 0, saves: 0, flushed: 0},
+	}
+
+	for _, test := range tests {
+		c := &ScienceSourceClient{CheckpointInterval: test.interval}
+		saves := 0
+		checkpoints := c.newCheckpoints(func() error {
+			saves += 1
+			return nil
+		})
+		for i := 0; i < test.items; i++ {
+			if err := checkpoints.record(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if saves != test.saves {
+			t.Errorf("Interval %d, %d items: saved %d times, expected %d", test.interval, test.items, saves, test.saves)
+		}
+		if err := checkpoints.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if saves != test.flushed {
+			t.Errorf("Interval %d, %d items: saved %d times after flush, expected %d", test.interval, test.items,
+				saves, test.flushed)
+		}
+	}
+}
+
+func TestCheckpointSaveError(t *testing.T) {
+
+	c := &ScienceSourceClient{CheckpointInterval: 2}
+	failure := errors.New("disk full")
+	checkpoints := c.newCheckpoints(func() error {
+		return failure
+	})
+	if err := checkpoints.record(nil); err != nil {
+		t.Errorf("Saved before the interval: %v", err)
+	}
+	if err := checkpoints.record(nil); err != failure {
+		t.Errorf("Got %v, expected the save's error", err)
+	}
+	if err := checkpoints.flush(); err != nil {
+		t.Errorf("Nothing left to save, but got %v", err)
+	}
+}
+
+// Changes recorded from many tasks at once must never overlap each other or a save, so every save sees a
+// consistent article.
+func TestCheckpointLocking(t *testing.T) {
+
+	for _, interval := range []int{1, 3, 50} {
+		const items = 200
+		var inside int32
+		var overlaps int32
+		changed := 0
+		saved := 0
+
+		enter := func() {
+			if atomic.AddInt32(&inside, 1) != 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+		}
+		leave := func() {
+			atomic.AddInt32(&inside, -1)
+		}
+
+		c := &ScienceSourceClient{CheckpointInterval: interval}
+		checkpoints := c.newCheckpoints(func() error {
+			enter()
+			defer leave()
+			saved = changed
+			return nil
+		})
+
+		var graph taskGraph
+		for i := 0; i < items; i++ {
+			graph.add(func(ctx context.Context) error {
+				return checkpoints.record(func() {
+					enter()
+					defer leave()
+					changed += 1
+				})
+			})
+		}
+		if err := graph.run(context.Background(), 8); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkpoints.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if overlaps != 0 {
+			t.Errorf("Interval %d: changes and saves overlapped %d times", interval, overlaps)
+		}
+		if changed != items || saved != items {
+			t.Errorf("Interval %d: made %d changes and saved %d, expected %d", interval, changed, saved, items)
+		}
+	}
+}
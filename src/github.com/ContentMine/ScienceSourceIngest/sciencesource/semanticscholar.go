@@ -0,0 +1,188 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	europmc "github.com/ContentMine/go-europmc"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Semantic Scholar has metadata and abstracts for most papers, and links to an open access PDF for many
+// of them. We take the text of the PDF using pdftotext, from poppler, which needs to be installed, and
+// wrap that and the metadata up as JATS. Papers are found by their Semantic Scholar ID, DOI, arXiv ID,
+// or PMCID, whichever the feed has first. An API key isn't needed, but gets a higher rate limit, and can
+// be given in the SEMANTIC_SCHOLAR_API_KEY environment variable.
+// See https://api.semanticscholar.org/api-docs/graph
+
+const semanticScholarPaperURL string = "https://api.semanticscholar.org/graph/v1/paper/%s?fields=%s"
+const semanticScholarFields string = "paperId,title,abstract,authors,venue,journal,publicationDate,year,externalIds,openAccessPdf"
+
+type semanticScholarSource struct{}
+
+func (semanticScholarSource) Name() string {
+	return "semanticscholar"
+}
+
+type semanticScholarPaper struct {
+	PaperID         string `json:"paperId"`
+	Title           string `json:"title"`
+	Abstract        string `json:"abstract"`
+	Venue           string `json:"venue"`
+	PublicationDate string `json:"publicationDate"`
+	Year            int    `json:"year"`
+	Authors         []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Journal *struct {
+		Name string `json:"name"`
+	} `json:"journal"`
+	ExternalIDs   map[string]interface{} `json:"externalIds"`
+	OpenAccessPDF *struct {
+		URL string `json:"url"`
+	} `json:"openAccessPdf"`
+}
+
+// semanticScholarID gives the ID to look the paper up by, in the form the API wants
+func semanticScholarID(paper Paper) string {
+	switch {
+	case len(paper.SemanticScholar.Value) != 0:
+		return paper.SemanticScholar.Value
+	case len(paper.DOI.Value) != 0:
+		return "DOI:" + NormaliseDOI(paper.DOI.Value)
+	case len(paper.ArXiv.Value) != 0:
+		return "ARXIV:" + paper.ArXiv.Value
+	default:
+		return "PMCID:" + strings.TrimPrefix(paper.ID(), "PMC")
+	}
+}
+
+func (s semanticScholarPaper) plainTextPaper(paper Paper, text string) convert.PlainTextPaper {
+
+	res := convert.PlainTextPaper{
+		Title:    s.Title,
+		Journal:  s.Venue,
+		PMCID:    paper.ID(),
+		Abstract: s.Abstract,
+		Text:     text,
+		Authors:  make([]europmc.ContributorName, 0, len(s.Authors)),
+	}
+	if s.Journal != nil && len(s.Journal.Name) != 0 {
+		res.Journal = s.Journal.Name
+	}
+	if doi, ok := s.ExternalIDs["DOI"].(string); ok {
+		res.DOI = doi
+	}
+	for _, author := range s.Authors {
+		res.Authors = append(res.Authors, convert.SplitAuthorName(author.Name))
+	}
+	if date, err := time.Parse("2006-01-02", s.PublicationDate); err == nil {
+		res.PublicationDate, res.PublicationDatePrecision = date, wikibase.TimePrecisionDay
+	} else if s.Year != 0 {
+		res.PublicationDate = time.Date(s.Year, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.PublicationDatePrecision = wikibase.TimePrecisionYear
+	}
+	return res
+}
+
+// fetchPDFText downloads the PDF and returns its text
+func fetchPDFText(ctx context.Context, pdfURL string, filename string) (string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pdfURL, nil)
+	if err != nil {
+		return "", err
+	}
+	err = ratelimit.WaitForURL(ctx, pdfURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Fetching %s returned %s", pdfURL, resp.Status)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(filename)
+	_, err = io.Copy(f, resp.Body)
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Publishers' "open access" links sometimes lead to a login page rather than the PDF, which
+	// pdftotext will refuse
+	cmd := exec.CommandContext(ctx, "pdftotext", "-enc", "UTF-8", filename, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get text from %s: %v: %s", pdfURL, err, stderr.String())
+	}
+	return string(output), nil
+}
+
+func (semanticScholarSource) FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error) {
+
+	header := http.Header{}
+	if key := os.Getenv("SEMANTIC_SCHOLAR_API_KEY"); len(key) != 0 {
+		header.Set("x-api-key", key)
+	}
+
+	var response semanticScholarPaper
+	err := getJSON(ctx, fmt.Sprintf(semanticScholarPaperURL, url.PathEscape(semanticScholarID(paper)), semanticScholarFields),
+		header, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.OpenAccessPDF == nil || len(response.OpenAccessPDF.URL) == 0 {
+		return "", fmt.Errorf("Semantic Scholar has no open access PDF for %s", paper.ID())
+	}
+
+	text, err := fetchPDFText(ctx, response.OpenAccessPDF.URL, filename+".pdf")
+	if err != nil {
+		return "", err
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		return "", fmt.Errorf("The open access PDF for %s has no text", paper.ID())
+	}
+
+	err = convert.SavePlainTextAsJATS(filename, response.plainTextPaper(paper, text))
+	if err != nil {
+		return "", err
+	}
+	return response.OpenAccessPDF.URL, nil
+}
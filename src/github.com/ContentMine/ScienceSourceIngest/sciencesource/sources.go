@@ -0,0 +1,285 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+)
+
+// Paper XML normally comes from Europe PMC, but that's not always available, so other sources can be
+// tried in turn until one has the paper. Each article records which source its text came from, and
+// where exactly, so that its provenance is clear when the text didn't come from the usual place.
+
+type PaperSource interface {
+	Name() string
+
+	// FetchPaperXML saves the JATS XML for the paper to the file, returning the URL it came from. A
+	// source that doesn't have the paper returns an error.
+	FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error)
+}
+
+var paperSourcesLock sync.Mutex
+var paperSources = make(map[string]PaperSource)
+
+func RegisterPaperSource(source PaperSource) {
+	paperSourcesLock.Lock()
+	defer paperSourcesLock.Unlock()
+
+	paperSources[source.Name()] = source
+}
+
+func LookupPaperSource(name string) (PaperSource, error) {
+	paperSourcesLock.Lock()
+	defer paperSourcesLock.Unlock()
+
+	source, ok := paperSources[name]
+	if !ok {
+		known := make([]string, 0, len(paperSources))
+		for name := range paperSources {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("Unknown paper source %s, expected one of %s", name, strings.Join(known, ", "))
+	}
+	return source, nil
+}
+
+// The source used if none are given
+const DefaultPaperSource string = "europepmc"
+
+func init() {
+	RegisterPaperSource(europePMCSource{})
+	RegisterPaperSource(fatcatSource{})
+	RegisterPaperSource(waybackSource{})
+	RegisterPaperSource(coreSource{})
+	RegisterPaperSource(semanticScholarSource{})
+}
+
+// fetchXML fetches the URL to the file, making sure it is XML so that an error page doesn't get taken
+// for the paper.
+func fetchXML(ctx context.Context, url string, filename string) error {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	err = ratelimit.WaitForURL(ctx, url)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fetching %s returned %s", url, resp.Status)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	start, _ := reader.Peek(512)
+	start = bytes.TrimPrefix(bytes.TrimSpace(start), []byte("\xef\xbb\xbf"))
+	if len(start) == 0 || start[0] != '<' || bytes.Contains(bytes.ToLower(start), []byte("<html")) {
+		return fmt.Errorf("Fetching %s didn't return XML", url)
+	}
+
+	return writeFileAtomically(filename, func(w io.Writer) error {
+		_, err := io.Copy(w, reader)
+		return err
+	})
+}
+
+// fetchPaperXML tries each source in turn until one has the paper, returning which source that was and
+// the URL the XML came from.
+func fetchPaperXML(ctx context.Context, sources []PaperSource, paper Paper, filename string) (string, string, error) {
+
+	failures := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			return "", "", err
+		}
+		url, err := source.FetchPaperXML(ctx, paper, filename)
+		if err == nil {
+			if len(failures) != 0 {
+				logging.Logf(logging.LogNormal, "Fetched paper %s from %s", paper.ID(), source.Name())
+			}
+			return source.Name(), url, nil
+		}
+		logging.Logf(logging.LogVerbose, "Paper %s not available from %s: %v", paper.ID(), source.Name(), err)
+		failures = append(failures, fmt.Sprintf("%s: %v", source.Name(), err))
+	}
+	return "", "", fmt.Errorf("No source had the paper (%s)", strings.Join(failures, "; "))
+}
+
+// Europe PMC
+
+type europePMCSource struct{}
+
+func (europePMCSource) Name() string {
+	return "europepmc"
+}
+
+func (europePMCSource) FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error) {
+	url := paper.FullTextURL()
+	return url, fetchXML(ctx, url, filename)
+}
+
+// The Internet Archive's Wayback Machine, via its availability API, for a copy of the paper XML as
+// Europe PMC served it in the past.
+
+const waybackAvailableURL string = "https://archive.org/wayback/available?url=%s"
+
+type waybackSource struct{}
+
+func (waybackSource) Name() string {
+	return "wayback"
+}
+
+type waybackAvailableResponse struct {
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Status    string `json:"status"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// Wayback URLs give the archived page wrapped in the archive's own navigation unless asked for the
+// original with id_ after the timestamp
+var waybackURLPattern = regexp.MustCompile(`^(https?://web\.archive\.org/web/)([0-9]+)(/.*)$`)
+
+func rawWaybackURL(archived string) string {
+	return waybackURLPattern.ReplaceAllString(archived, "${1}${2}id_${3}")
+}
+
+// getJSON fetches JSON from one of the services we use, with any extra headers it needs, such as for
+// an API key.
+func getJSON(ctx context.Context, url string, header http.Header, out interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	err = ratelimit.WaitForURL(ctx, url)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fetching %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (waybackSource) FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error) {
+
+	var response waybackAvailableResponse
+	err := getJSON(ctx, fmt.Sprintf(waybackAvailableURL, url.QueryEscape(paper.FullTextURL())), nil, &response)
+	if err != nil {
+		return "", err
+	}
+	closest := response.ArchivedSnapshots.Closest
+	if closest == nil || closest.Available == false || closest.Status != "200" {
+		return "", fmt.Errorf("No archived copy of %s", paper.FullTextURL())
+	}
+
+	archived := rawWaybackURL(closest.URL)
+	return archived, fetchXML(ctx, archived, filename)
+}
+
+// Fatcat, the Internet Archive's catalogue of scholarly works, which knows of archived copies of papers
+// from many places. We only want copies of the JATS XML, not PDFs.
+
+const fatcatLookupURL string = "https://api.fatcat.wiki/v0/release/lookup?%s&expand=files"
+
+type fatcatSource struct{}
+
+func (fatcatSource) Name() string {
+	return "fatcat"
+}
+
+type fatcatRelease struct {
+	Files []struct {
+		MimeType string `json:"mimetype"`
+		URLs     []struct {
+			URL string `json:"url"`
+			Rel string `json:"rel"`
+		} `json:"urls"`
+	} `json:"files"`
+}
+
+func (fatcatSource) FetchPaperXML(ctx context.Context, paper Paper, filename string) (string, error) {
+
+	query := url.Values{}
+	if len(paper.DOI.Value) != 0 {
+		query.Set("doi", NormaliseDOI(paper.DOI.Value))
+	} else {
+		query.Set("pmcid", paper.ID())
+	}
+	var release fatcatRelease
+	err := getJSON(ctx, fmt.Sprintf(fatcatLookupURL, query.Encode()), nil, &release)
+	if err != nil {
+		return "", err
+	}
+
+	// Archived copies first, as they don't go away
+	candidates := make([]string, 0)
+	for _, rel := range []string{"webarchive", ""} {
+		for _, file := range release.Files {
+			if strings.Contains(file.MimeType, "xml") == false {
+				continue
+			}
+			for _, location := range file.URLs {
+				if (rel == "webarchive") == (location.Rel == "webarchive") {
+					candidates = append(candidates, rawWaybackURL(location.URL))
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("Fatcat knows of no XML copy of %s", paper.ID())
+	}
+
+	for _, candidate := range candidates {
+		err = fetchXML(ctx, candidate, filename)
+		if err == nil {
+			return candidate, nil
+		}
+	}
+	return "", err
+}
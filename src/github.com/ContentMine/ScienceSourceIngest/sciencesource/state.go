@@ -0,0 +1,103 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"io/ioutil"
+	"os"
+	"unicode/utf8"
+)
+
+// How far through the pipeline a paper has got, as worked out from what is in its output folder.
+
+type PaperState string
+
+const (
+	PaperStateNew       PaperState = "new"
+	PaperStateFetched   PaperState = "fetched"
+	PaperStateConverted PaperState = "converted"
+	PaperStateAnnotated PaperState = "annotated"
+	PaperStateUploaded  PaperState = "uploaded"
+	PaperStateCreated   PaperState = "created"
+	PaperStateComplete  PaperState = "complete"
+)
+
+var PaperStates = []PaperState{
+	PaperStateNew,
+	PaperStateFetched,
+	PaperStateConverted,
+	PaperStateAnnotated,
+	PaperStateUploaded,
+	PaperStateCreated,
+	PaperStateComplete,
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// AllItemsCreated is true if every part of the article tree has an item on the server.
+func (article *ScienceSourceArticle) AllItemsCreated() bool {
+	return len(article.ItemIDs()) == 1+(len(article.Annotations)*2)
+}
+
+// Article loads what we last saved about the paper's upload, if we've got that far.
+func (processor PaperProcessor) Article() (*ScienceSourceArticle, error) {
+	return LoadScienceSourceArticle(processor.targetScienceSourceStateFileName())
+}
+
+// SaveArticle saves what we know about the paper's upload, for commands that change it outside ingest.
+func (processor PaperProcessor) SaveArticle(article *ScienceSourceArticle) error {
+	return article.Save(processor.targetScienceSourceStateFileName())
+}
+
+// Text is the text we mined the paper's annotations from, which their offsets refer to.
+func (processor PaperProcessor) Text() ([]byte, error) {
+	return ioutil.ReadFile(processor.targetTextFileName())
+}
+
+// TextLength is the number of characters in the text we mined the paper's annotations from.
+func (processor PaperProcessor) TextLength() (int, error) {
+	data, err := processor.Text()
+	if err != nil {
+		return 0, err
+	}
+	return utf8.RuneCount(data), nil
+}
+
+func (processor PaperProcessor) State() PaperState {
+
+	if article, err := processor.Article(); err == nil {
+		switch {
+		case article.Complete:
+			return PaperStateComplete
+		case article.AllItemsCreated():
+			return PaperStateCreated
+		case article.PageID != 0:
+			return PaperStateUploaded
+		default:
+			return PaperStateAnnotated
+		}
+	}
+
+	if fileExists(processor.targetHTMLFileName()) && fileExists(processor.targetTextFileName()) {
+		return PaperStateConverted
+	}
+	if fileExists(processor.targetXMLFileName()) {
+		return PaperStateFetched
+	}
+	return PaperStateNew
+}
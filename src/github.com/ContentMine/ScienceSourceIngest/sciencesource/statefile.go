@@ -0,0 +1,208 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// An article's saved state is the only record of which items we've made for it on the server, so it must
+// never be left half written. It is saved with writeFileAtomically, so a crash part way through leaves
+// either the old state or the new. The previous state is kept as a backup, in case the new one turns out
+// to be unreadable anyway, say because the disk filled up or a file system didn't honour the sync.
+
+const stateBackupSuffix string = ".bak"
+
+// If set then article state is saved gzip compressed, which for articles with tens of thousands of
+// annotations makes it a fraction of the size. Compressed state is recognised when loading, whatever this
+// is set to, and state that is already compressed stays compressed when saved again.
+var CompressState bool
+
+// Article state is kept as JSON, which anything can read, unless StateFormat says to use CBOR for new
+// papers, which is smaller and quicker to load and save. Papers keep whichever format they were first
+// saved in, and anything loading state goes by the file name.
+const (
+	StateFormatJSON = "json"
+	StateFormatCBOR = "cbor"
+)
+
+var StateFormat string = StateFormatJSON
+
+// ValidateStateFormat checks StateFormat is one we know.
+func ValidateStateFormat() error {
+	switch StateFormat {
+	case StateFormatJSON, StateFormatCBOR:
+		return nil
+	}
+	return fmt.Errorf("State format must be %s or %s, not %s", StateFormatJSON, StateFormatCBOR, StateFormat)
+}
+
+func isCBORStateFile(filename string) bool {
+	return path.Ext(filename) == "."+StateFormatCBOR
+}
+
+// stateFileName picks the file in the folder to keep state in, with the given base name: whichever
+// format's file is there already, or the file in StateFormat if neither is.
+func stateFileName(folder string, base string) string {
+	preferred := path.Join(folder, base+"."+StateFormat)
+	if fileExists(preferred) {
+		return preferred
+	}
+	for _, format := range []string{StateFormatJSON, StateFormatCBOR} {
+		if other := path.Join(folder, base+"."+format); fileExists(other) {
+			return other
+		}
+	}
+	return preferred
+}
+
+// Every gzip stream starts with these
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isCompressed says whether the file exists and is gzip compressed.
+func isCompressed(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(gzipMagic))
+	_, err = io.ReadFull(f, header)
+	return err == nil && bytes.Equal(header, gzipMagic)
+}
+
+// saveStateFile saves the state atomically, compressing it if asked to or if it was compressed already.
+func saveStateFile(filename string, write func(w io.Writer) error) error {
+
+	if !CompressState && !isCompressed(filename) {
+		return saveFileAtomically(filename, write)
+	}
+	return saveFileAtomically(filename, func(w io.Writer) error {
+		compressor := gzip.NewWriter(w)
+		err := write(compressor)
+		if err != nil {
+			return err
+		}
+		return compressor.Close()
+	})
+}
+
+// loadStateFile loads the state, or its backup if need be, decompressing it if it is compressed.
+func loadStateFile(filename string, read func(r io.Reader) error) error {
+	return loadFileWithBackup(filename, func(r io.Reader) error {
+		buffered := bufio.NewReader(r)
+		header, err := buffered.Peek(len(gzipMagic))
+		if err != nil || !bytes.Equal(header, gzipMagic) {
+			return read(buffered)
+		}
+
+		decompressor, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer decompressor.Close()
+		return read(decompressor)
+	})
+}
+
+// saveFileAtomically replaces the file with whatever write writes, keeping the old file as a backup.
+func saveFileAtomically(filename string, write func(w io.Writer) error) error {
+
+	// Hard linking the backup means the file is always there under its own name, even mid save
+	backup := filename + stateBackupSuffix
+	err := os.Remove(backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Link(filename, backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return writeFileAtomically(filename, write)
+}
+
+// writeFileAtomically writes the file through a temporary file alongside it, which is synced and renamed
+// into place only once write has succeeded, so a failure part way never leaves a partial file under the
+// real name.
+func writeFileAtomically(filename string, write func(w io.Writer) error) error {
+
+	f, err := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	temp := f.Name()
+
+	err = f.Chmod(0644)
+	if err == nil {
+		err = write(f)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(temp, filename)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	// The rename itself is only durable once the directory is synced, though not every platform can
+	dir, err := os.Open(path.Dir(filename))
+	if err != nil {
+		return nil
+	}
+	dir.Sync()
+	dir.Close()
+	return nil
+}
+
+// loadFileWithBackup reads the file with read, falling back to its backup if the file can't be read.
+func loadFileWithBackup(filename string, read func(r io.Reader) error) error {
+
+	err := readFile(filename, read)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+
+	backup := filename + stateBackupSuffix
+	if backup_err := readFile(backup, read); backup_err != nil {
+		return err
+	}
+	log.Printf("%s is unreadable (%v), so using the previous state saved in %s", filename, err, backup)
+	return nil
+}
+
+func readFile(filename string, read func(r io.Reader) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
@@ -0,0 +1,199 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+)
+
+// Statistics on the annotations found in a set of papers, which help judge whether a dictionary is
+// finding sensible things before committing to a big upload.
+
+type DictionaryStats struct {
+	Name        string         `json:"name"`
+	Annotations int            `json:"annotations"`
+	Papers      int            `json:"papers"`
+	Terms       map[string]int `json:"terms"` // number of annotations for each term found
+	WikiDataIDs map[string]int `json:"-"`
+}
+
+type AnnotationStats struct {
+	Papers       int                         `json:"papers"`
+	Characters   int                         `json:"characters"`
+	Annotations  int                         `json:"annotations"`
+	WikiDataIDs  map[string]int              `json:"-"`
+	Dictionaries map[string]*DictionaryStats `json:"dictionaries"`
+}
+
+func NewAnnotationStats() *AnnotationStats {
+	return &AnnotationStats{
+		WikiDataIDs:  make(map[string]int),
+		Dictionaries: make(map[string]*DictionaryStats),
+	}
+}
+
+// Add counts the annotations in an article, along with the length of the text they were found in.
+func (stats *AnnotationStats) Add(article *ScienceSourceArticle, textLength int) {
+
+	stats.Papers += 1
+	stats.Characters += textLength
+
+	seen := make(map[string]bool)
+	for _, anchor := range article.Annotations {
+		annotation := anchor.Annotation
+
+		dict, ok := stats.Dictionaries[annotation.DictionaryName]
+		if !ok {
+			dict = &DictionaryStats{
+				Name:        annotation.DictionaryName,
+				Terms:       make(map[string]int),
+				WikiDataIDs: make(map[string]int),
+			}
+			stats.Dictionaries[annotation.DictionaryName] = dict
+		}
+		if !seen[dict.Name] {
+			dict.Papers += 1
+			seen[dict.Name] = true
+		}
+
+		stats.Annotations += 1
+		dict.Annotations += 1
+		dict.Terms[annotation.TermFound] += 1
+		if len(annotation.WikiDataItemCode) != 0 {
+			stats.WikiDataIDs[annotation.WikiDataItemCode] += 1
+			dict.WikiDataIDs[annotation.WikiDataItemCode] += 1
+		}
+	}
+}
+
+// Density is the number of annotations per 1,000 characters of text.
+func (stats *AnnotationStats) Density(annotations int) float64 {
+	if stats.Characters == 0 {
+		return 0
+	}
+	return float64(annotations) * 1000 / float64(stats.Characters)
+}
+
+// DictionaryNames lists the dictionaries that found anything, in name order.
+func (stats *AnnotationStats) DictionaryNames() []string {
+	res := make([]string, 0, len(stats.Dictionaries))
+	for name := range stats.Dictionaries {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// UnmatchedTerms lists the terms in the dictionary that weren't found in any paper.
+func (stats *AnnotationStats) UnmatchedTerms(dictionary annotate.Dictionary) []string {
+
+	var found map[string]int
+	if dict, ok := stats.Dictionaries[dictionary.Identifier]; ok {
+		found = dict.Terms
+	}
+
+	res := make([]string, 0)
+	for _, entry := range dictionary.Entries {
+		if found[entry.Term] == 0 {
+			res = append(res, entry.Term)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// A summary of how each dictionary did, for saving as JSON so dictionary maintainers can see how their
+// dictionaries are getting on.
+
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+type DictionaryHitSummary struct {
+	Dictionary       string      `json:"dictionary"`
+	Entries          int         `json:"entries,omitempty"` // only known for dictionaries we loaded
+	Papers           int         `json:"papers"`
+	Annotations      int         `json:"annotations"`
+	TermsMatched     int         `json:"terms_matched"`
+	TermsWithoutHits []string    `json:"terms_without_hits,omitempty"`
+	TopTerms         []TermCount `json:"top_terms"`
+}
+
+// How many of the most found terms to list for each dictionary
+const hitSummaryTopTerms int = 20
+
+// HitSummary summarises each dictionary that found anything, along with each of the given dictionaries,
+// which may have found nothing at all.
+func (stats *AnnotationStats) HitSummary(dictionaries []annotate.Dictionary) []DictionaryHitSummary {
+
+	loaded := make(map[string]annotate.Dictionary, len(dictionaries))
+	names := stats.DictionaryNames()
+	for _, dictionary := range dictionaries {
+		loaded[dictionary.Identifier] = dictionary
+		if _, ok := stats.Dictionaries[dictionary.Identifier]; !ok {
+			names = append(names, dictionary.Identifier)
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]DictionaryHitSummary, 0, len(names))
+	for _, name := range names {
+		summary := DictionaryHitSummary{Dictionary: name, TopTerms: []TermCount{}}
+
+		if dict, ok := stats.Dictionaries[name]; ok {
+			summary.Papers = dict.Papers
+			summary.Annotations = dict.Annotations
+			summary.TermsMatched = len(dict.Terms)
+			for term, count := range dict.Terms {
+				summary.TopTerms = append(summary.TopTerms, TermCount{Term: term, Count: count})
+			}
+			sort.Slice(summary.TopTerms, func(i, j int) bool {
+				if summary.TopTerms[i].Count != summary.TopTerms[j].Count {
+					return summary.TopTerms[i].Count > summary.TopTerms[j].Count
+				}
+				return summary.TopTerms[i].Term < summary.TopTerms[j].Term
+			})
+			if len(summary.TopTerms) > hitSummaryTopTerms {
+				summary.TopTerms = summary.TopTerms[:hitSummaryTopTerms]
+			}
+		}
+
+		if dictionary, ok := loaded[name]; ok {
+			summary.Entries = len(dictionary.Entries)
+			summary.TermsWithoutHits = stats.UnmatchedTerms(dictionary)
+		}
+
+		res = append(res, summary)
+	}
+	return res
+}
+
+func (stats *AnnotationStats) SaveHitSummary(filename string, dictionaries []annotate.Dictionary) error {
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats.HitSummary(dictionaries))
+}
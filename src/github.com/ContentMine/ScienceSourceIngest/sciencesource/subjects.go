@@ -0,0 +1,63 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"sort"
+)
+
+// Finding which articles are about something by following anchor chains is slow, so the Wikidata items
+// annotated most often in an article are also added to its item as main subjects. An item has to be
+// annotated at least a given number of times to count, and only the most frequent few are used, as a
+// term mentioned once in passing isn't what the article is about.
+
+// The most main subjects we'll add from annotations
+const maxAnnotationSubjects int = 10
+
+// AddAnnotationSubjects adds the Wikidata items annotated at least minimum times as main subjects of the
+// article, most frequent first, returning how many were new.
+func (article *ScienceSourceArticle) AddAnnotationSubjects(minimum int) int {
+
+	counts := make(map[string]int)
+	for _, anchor := range article.Annotations {
+		if qid := anchor.Annotation.WikiDataItemCode; len(qid) != 0 {
+			counts[qid] += 1
+		}
+	}
+
+	qids := make([]string, 0, len(counts))
+	for qid, count := range counts {
+		if count >= minimum {
+			qids = append(qids, qid)
+		}
+	}
+	sort.Slice(qids, func(i, j int) bool {
+		if counts[qids[i]] != counts[qids[j]] {
+			return counts[qids[i]] > counts[qids[j]]
+		}
+		return qids[i] < qids[j]
+	})
+	if len(qids) > maxAnnotationSubjects {
+		qids = qids[:maxAnnotationSubjects]
+	}
+
+	added := 0
+	for _, qid := range qids {
+		if article.AddSubject(ArticleSubject{WikiDataItemCode: qid, Source: SubjectSourceAnnotations}) {
+			added += 1
+		}
+	}
+	return added
+}
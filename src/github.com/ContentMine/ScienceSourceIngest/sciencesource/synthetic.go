@@ -0,0 +1,99 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Synthetic articles are made up, for measuring how fast a server takes uploads without needing real
+// papers. They have the same shape as real ones, with an anchor point and annotation per term, but
+// nothing about them means anything: every term is linked to Q1, and the text is filler.
+
+const syntheticFiller string = "This is filler text for a synthetic article, which exists only to measure uploads, and mentions "
+
+// NewSyntheticArticle makes an article with the given number of annotations, returning it along with
+// its text.
+func NewSyntheticArticle(title string, annotations int, timeCode time.Time) (*ScienceSourceArticle, []byte, error) {
+
+	timeCode = wikibase.TruncateTime(timeCode, wikibase.TimePrecisionDay)
+	article := &ScienceSourceArticle{
+		WikiDataItemCode:          "Q1",
+		ArticleTextTitle:          title,
+		ScienceSourceArticleTitle: title,
+		TimeCode:                  timeCode,
+	}
+	article.SetPublicationDate(timeCode, wikibase.TimePrecisionDay)
+
+	var text strings.Builder
+	article.Annotations = make([]ScienceSourceAnchorPoint, 0, annotations)
+	for i := 0; i < annotations; i++ {
+		text.WriteString(syntheticFiller)
+		term := fmt.Sprintf("synthetic term %d", i)
+		offset := text.Len()
+		text.WriteString(term)
+		text.WriteString(". ")
+
+		article.Annotations = append(article.Annotations, ScienceSourceAnchorPoint{
+			CharacterNumber:           offset,
+			TimeCode:                  timeCode,
+			ScienceSourceArticleTitle: title,
+			Annotation: ScienceSourceAnnotation{
+				TermFound:                 term,
+				LengthOfTermFound:         len(term),
+				WikiDataItemCode:          "Q1",
+				DictionaryName:            "synthetic",
+				TimeCode:                  timeCode,
+				ScienceSourceArticleTitle: title,
+			},
+		})
+	}
+	text.WriteString(syntheticFiller)
+	text.WriteString("nothing else.\n")
+
+	data := []byte(text.String())
+	err := article.FillAnchorContext(data, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return article, data, nil
+}
+
+// DeleteArticle deletes an article's items and page from the server, such as once a synthetic article
+// has served its purpose. This needs an account with delete rights.
+func (c *ScienceSourceClient) DeleteArticle(ctx context.Context, article *ScienceSourceArticle, reason string) error {
+
+	ids := article.ItemIDs()
+	if len(ids) != 0 {
+		_, err := c.deleteItems(ctx, article, ids, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	if article.PageID != 0 {
+		err := c.network.DeletePage(ctx, article.ScienceSourceArticleTitle, reason)
+		if err != nil {
+			return err
+		}
+		article.PageID = 0
+	}
+	return nil
+}
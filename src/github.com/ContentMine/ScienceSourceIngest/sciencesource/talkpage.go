@@ -0,0 +1,66 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// ScienceSource asks that bot imported content has a provenance notice on its talk page, so
+// that human editors can see where the text came from and which tool put it there.
+const TalkPageProvenance string = `{{ingest provenance
+| source = %s
+| license = %s
+| doi = %s
+| pmcid = %s
+| run_id = %s
+| generator = %s/%s
+}}
+`
+
+func (c *ScienceSourceClient) CreateTalkPage(ctx context.Context, article *ScienceSourceArticle, paper Paper) error {
+
+	source := paper.FullTextURL()
+	if len(article.TextURL) != 0 {
+		source = article.TextURL
+	}
+	text := fmt.Sprintf(TalkPageProvenance,
+		source,
+		paper.LicenseLabel.Value,
+		paper.DOI.Value,
+		paper.ID(),
+		c.RunID,
+		Remote, Version,
+	)
+
+	err := c.network.PostWithToken(ctx, map[string]string{
+		"action":     "edit",
+		"title":      fmt.Sprintf("Talk:%s", article.ScienceSourceArticleTitle),
+		"text":       text,
+		"summary":    "Provenance notice for imported article",
+		"createonly": "1",
+	}, nil)
+
+	// If the talk page already exists then we, or a human, have already been here, and we shouldn't
+	// overwrite it
+	if apiErr, ok := err.(*wikibase.APIError); ok && apiErr.Code == "articleexists" {
+		return nil
+	}
+
+	return err
+}
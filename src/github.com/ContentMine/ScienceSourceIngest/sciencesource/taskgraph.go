@@ -0,0 +1,111 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+)
+
+// Uploading an article is a lot of small writes to the server, many of which don't depend on each
+// other, so rather than walking the annotations one at a time they can be run as a graph of tasks, each
+// started once the tasks it depends on are done, with as many running at once as allowed. With one
+// worker the tasks run one after another in the order they were added, except that a task runs as soon
+// as the last thing it depends on is done.
+
+type task struct {
+	run        func(ctx context.Context) error
+	waiting    int
+	dependents []*task
+}
+
+type taskGraph struct {
+	tasks []*task
+}
+
+type taskResult struct {
+	task *task
+	err  error
+}
+
+// add puts a task in the graph that only starts once all of deps have finished without error.
+func (g *taskGraph) add(run func(ctx context.Context) error, deps ...*task) *task {
+	t := &task{run: run}
+	for _, dep := range deps {
+		if dep == nil {
+			continue
+		}
+		dep.dependents = append(dep.dependents, t)
+		t.waiting += 1
+	}
+	g.tasks = append(g.tasks, t)
+	return t
+}
+
+// run carries out every task, with at most workers running at once. On the first error, or if the
+// context is cancelled, no more tasks are started, but those already running are left to finish so
+// that nothing they wrote to the server is lost track of. The first error is returned.
+func (g *taskGraph) run(ctx context.Context, workers int) error {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	ready := make([]*task, 0, len(g.tasks))
+	for _, t := range g.tasks {
+		if t.waiting == 0 {
+			ready = append(ready, t)
+		}
+	}
+
+	results := make(chan taskResult)
+	running := 0
+	var first_err error
+	for {
+		for first_err == nil && running < workers && len(ready) > 0 {
+			if err := ctx.Err(); err != nil {
+				first_err = err
+				break
+			}
+			t := ready[0]
+			ready = ready[1:]
+			running += 1
+			go func() {
+				results <- taskResult{task: t, err: t.run(ctx)}
+			}()
+		}
+		if running == 0 {
+			return first_err
+		}
+
+		result := <-results
+		running -= 1
+		if result.err != nil {
+			if first_err == nil {
+				first_err = result.err
+			}
+			continue
+		}
+		// Tasks freed up go ahead of those already waiting, so work is finished off as it goes rather
+		// than everything being left half done until the end
+		freed := make([]*task, 0, len(result.task.dependents))
+		for _, dependent := range result.task.dependents {
+			dependent.waiting -= 1
+			if dependent.waiting == 0 {
+				freed = append(freed, dependent)
+			}
+		}
+		ready = append(freed, ready...)
+	}
+}
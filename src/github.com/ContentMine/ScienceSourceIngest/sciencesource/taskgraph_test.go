@@ -0,0 +1,236 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// taskSpec describes a task for a test graph by the indices of the tasks it depends on
+type taskSpec struct {
+	name string
+	deps []int
+	fail bool
+}
+
+// buildGraph adds the tasks to a graph, with each recording its name in order when it runs.
+func buildGraph(specs []taskSpec) (*taskGraph, func() []string) {
+	var graph taskGraph
+	var lock sync.Mutex
+	order := make([]string, 0, len(specs))
+	tasks := make([]*task, len(specs))
+	for i, spec := range specs {
+		spec := spec
+		deps := make([]*task, 0, len(spec.deps))
+		for _, dep := range spec.deps {
+			deps = append(deps, tasks[dep])
+		}
+		tasks[i] = graph.add(func(ctx context.Context) error {
+			lock.Lock()
+			order = append(order, spec.name)
+			lock.Unlock()
+			if spec.fail {
+				return errors.New(spec.name + " failed")
+			}
+			return nil
+		}, deps...)
+	}
+	return &graph, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, order...)
+	}
+}
+
+func TestTaskGraphOneWorker(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		specs []taskSpec
+		order []string
+		err   string
+	}{
+		{
+			name:  "empty",
+			specs: nil,
+			order: []string{},
+		},
+		{
+			name:  "independent tasks run in the order added",
+			specs: []taskSpec{{name: "a"}, {name: "b"}, {name: "c"}},
+			order: []string{"a", "b", "c"},
+		},
+		{
+			name: "article, annotations, and anchors",
+			specs: []taskSpec{
+				{name: "article"},
+				{name: "annotation 1", deps: []int{0}},
+				{name: "anchor 1", deps: []int{0, 1}},
+				{name: "annotation 2", deps: []int{0}},
+				{name: "anchor 2", deps: []int{0, 3}},
+			},
+			order: []string{"article", "annotation 1", "anchor 1", "annotation 2", "anchor 2"},
+		},
+		{
+			name:  "freed tasks go ahead of those already waiting",
+			specs: []taskSpec{{name: "x"}, {name: "z"}, {name: "y", deps: []int{0}}},
+			order: []string{"x", "y", "z"},
+		},
+		{
+			name:  "a failure stops anything else starting",
+			specs: []taskSpec{{name: "a"}, {name: "b", fail: true}, {name: "c"}, {name: "d", deps: []int{1}}},
+			order: []string{"a", "b"},
+			err:   "b failed",
+		},
+		{
+			name:  "dependents of a failed task never run",
+			specs: []taskSpec{{name: "a", fail: true}, {name: "b", deps: []int{0}}},
+			order: []string{"a"},
+			err:   "a failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph, order := buildGraph(test.specs)
+			err := graph.run(context.Background(), 1)
+			if len(test.err) == 0 && err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if len(test.err) != 0 && (err == nil || err.Error() != test.err) {
+				t.Errorf("Got error %v, expected %s", err, test.err)
+			}
+			if got := order(); !reflect.DeepEqual(got, test.order) {
+				t.Errorf("Ran %v, expected %v", got, test.order)
+			}
+		})
+	}
+}
+
+func TestTaskGraphNilDependency(t *testing.T) {
+	var graph taskGraph
+	ran := false
+	graph.add(func(ctx context.Context) error {
+		ran = true
+		return nil
+	}, nil, nil)
+	if err := graph.run(context.Background(), 1); err != nil || !ran {
+		t.Errorf("Task with only nil dependencies didn't run: %v", err)
+	}
+}
+
+func TestTaskGraphWorkers(t *testing.T) {
+
+	for _, workers := range []int{0, 1, 2, 4, 16} {
+		var graph taskGraph
+		var running, most int32
+		var done sync.Map
+		var failures int32
+
+		// A chain of pairs like the anchors and annotations, each checking its dependency finished first
+		var previous *task
+		for i := 0; i < 40; i++ {
+			index := i
+			var deps []*task
+			if index%2 == 1 {
+				deps = append(deps, previous)
+			}
+			previous = graph.add(func(ctx context.Context) error {
+				now := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					seen := atomic.LoadInt32(&most)
+					if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+						break
+					}
+				}
+				if index%2 == 1 {
+					if _, ok := done.Load(index - 1); !ok {
+						atomic.AddInt32(&failures, 1)
+					}
+				}
+				time.Sleep(time.Millisecond)
+				done.Store(index, true)
+				return nil
+			}, deps...)
+		}
+
+		err := graph.run(context.Background(), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limit := int32(workers)
+		if limit < 1 {
+			limit = 1
+		}
+		if most > limit {
+			t.Errorf("%d workers: %d tasks ran at once", workers, most)
+		}
+		if failures != 0 {
+			t.Errorf("%d workers: %d tasks ran before their dependency finished", workers, failures)
+		}
+		count := 0
+		done.Range(func(key, value interface{}) bool {
+			count += 1
+			return true
+		})
+		if count != 40 {
+			t.Errorf("%d workers: %d of 40 tasks ran", workers, count)
+		}
+	}
+}
+
+func TestTaskGraphWaitsForRunningTasks(t *testing.T) {
+
+	var graph taskGraph
+	var finished int32
+	graph.add(func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	})
+	graph.add(func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+
+	err := graph.run(context.Background(), 2)
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("Got error %v, expected failed", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("Returned before the running task finished")
+	}
+}
+
+func TestTaskGraphCancelled(t *testing.T) {
+
+	graph, order := buildGraph([]taskSpec{{name: "a"}, {name: "b"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := graph.run(ctx, 1)
+	if err != context.Canceled {
+		t.Errorf("Got error %v, expected %v", err, context.Canceled)
+	}
+	if got := order(); len(got) != 0 {
+		t.Errorf("Ran %v after the context was cancelled", got)
+	}
+}
@@ -0,0 +1,156 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"bytes"
+)
+
+// To carry annotations over to a new version of an article's text we need to know which parts of the
+// text changed. Papers are long but corrections to them are usually small, so a line by line diff,
+// narrowed down to the bytes that actually differ within each changed block of lines, is enough.
+
+// A textEdit replaces old[OldStart:OldEnd] with new[NewStart:NewEnd]
+type textEdit struct {
+	OldStart int
+	OldEnd   int
+	NewStart int
+	NewEnd   int
+}
+
+// Beyond this many line comparisons the changed block is treated as one edit rather than diffed, to
+// keep memory use sensible if the whole text has changed.
+const maxDiffCells int = 16 * 1024 * 1024
+
+// diffText returns the edits that turn the old text into the new, in order.
+func diffText(old []byte, new []byte) []textEdit {
+
+	oldLines := bytes.SplitAfter(old, []byte("\n"))
+	newLines := bytes.SplitAfter(new, []byte("\n"))
+
+	// Most of the text will be the same at the start and end, so don't diff that
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && bytes.Equal(oldLines[prefix], newLines[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		bytes.Equal(oldLines[len(oldLines)-1-suffix], newLines[len(newLines)-1-suffix]) {
+		suffix++
+	}
+	oldMiddle := oldLines[prefix : len(oldLines)-suffix]
+	newMiddle := newLines[prefix : len(newLines)-suffix]
+
+	oldOffsets := lineOffsets(oldLines)
+	newOffsets := lineOffsets(newLines)
+	edit := func(oldFrom, oldTo, newFrom, newTo int) textEdit {
+		return narrowEdit(old, new, textEdit{
+			OldStart: oldOffsets[prefix+oldFrom], OldEnd: oldOffsets[prefix+oldTo],
+			NewStart: newOffsets[prefix+newFrom], NewEnd: newOffsets[prefix+newTo],
+		})
+	}
+
+	if len(oldMiddle) == 0 && len(newMiddle) == 0 {
+		return nil
+	}
+	if len(oldMiddle)*len(newMiddle) > maxDiffCells {
+		return []textEdit{edit(0, len(oldMiddle), 0, len(newMiddle))}
+	}
+
+	// Longest common subsequence of lines, working back from the end so we can walk forwards
+	n, m := len(oldMiddle), len(newMiddle)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if bytes.Equal(oldMiddle[i], newMiddle[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	res := make([]textEdit, 0)
+	i, j := 0, 0
+	startI, startJ := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && bytes.Equal(oldMiddle[i], newMiddle[j]) {
+			if startI != i || startJ != j {
+				res = append(res, edit(startI, i, startJ, j))
+			}
+			i++
+			j++
+			startI, startJ = i, j
+		} else if j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+		} else {
+			j++
+		}
+	}
+	if startI != n || startJ != m {
+		res = append(res, edit(startI, n, startJ, m))
+	}
+	return res
+}
+
+// lineOffsets gives the byte offset of the start of each line, plus the end of the text
+func lineOffsets(lines [][]byte) []int {
+	res := make([]int, len(lines)+1)
+	for i, line := range lines {
+		res[i+1] = res[i] + len(line)
+	}
+	return res
+}
+
+// narrowEdit trims the bytes an edit leaves the same off either end of it
+func narrowEdit(old []byte, new []byte, edit textEdit) textEdit {
+	for edit.OldStart < edit.OldEnd && edit.NewStart < edit.NewEnd && old[edit.OldStart] == new[edit.NewStart] {
+		edit.OldStart++
+		edit.NewStart++
+	}
+	for edit.OldEnd > edit.OldStart && edit.NewEnd > edit.NewStart && old[edit.OldEnd-1] == new[edit.NewEnd-1] {
+		edit.OldEnd--
+		edit.NewEnd--
+	}
+	return edit
+}
+
+// mapSpan finds where old[start:end] ended up in the new text, returning false if an edit touches it.
+func mapSpan(edits []textEdit, start int, end int) (int, bool) {
+	shift := 0
+	for _, edit := range edits {
+		if edit.OldEnd < start || (edit.OldEnd == start && edit.OldStart < start) {
+			shift += (edit.NewEnd - edit.NewStart) - (edit.OldEnd - edit.OldStart)
+			continue
+		}
+		if edit.OldStart > end || (edit.OldStart == end && edit.OldEnd > end) {
+			break
+		}
+		// Insertions right at either end don't change the span itself
+		if edit.OldStart == edit.OldEnd && (edit.OldStart == start || edit.OldStart == end) {
+			if edit.OldStart == start {
+				shift += edit.NewEnd - edit.NewStart
+			}
+			continue
+		}
+		return 0, false
+	}
+	return start + shift, true
+}
@@ -0,0 +1,304 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/hashicorp/errwrap"
+
+	"github.com/ContentMine/ScienceSourceIngest/convert"
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Papers get corrected after we've ingested them, and sometimes our conversion is improved, but the
+// annotations hang off character offsets in the article text so can't just be thrown away and redone
+// without losing any curation done on them. Instead we upload the new text as a new revision of the
+// page, work out what changed, and move each anchor point along to where its text now is. Anchor points
+// whose term or phrases were in a changed part of the text are left where they are and flagged for
+// someone to look at, as only a person can tell whether the annotation still stands.
+
+type AnchorShift struct {
+	Anchor int // index in the article's annotations
+	Term   string
+	From   int
+	To     int
+}
+
+type TextUpdate struct {
+	Edits   int           // How many separate parts of the text changed
+	Shifted []AnchorShift // Anchor points that have moved
+	Changed []int         // Anchor points whose text changed, by index
+}
+
+func (update TextUpdate) HasChanges() bool {
+	return update.Edits != 0
+}
+
+// planTextUpdate works out where each anchor point goes in the new text, which has the given body
+// offset.
+func (article *ScienceSourceArticle) planTextUpdate(old []byte, new []byte, bodyOffset int) TextUpdate {
+
+	edits := diffText(old, new)
+	update := TextUpdate{Edits: len(edits), Shifted: make([]AnchorShift, 0), Changed: make([]int, 0)}
+	if len(edits) == 0 && bodyOffset == article.BodyOffset {
+		return update
+	}
+
+	for i, anchor := range article.Annotations {
+		offset := anchor.CharacterNumber - article.BodyOffset
+		start := offset - len(anchor.PrecedingPhrase)
+		end := offset + len(anchor.Annotation.TermFound) + len(anchor.FollowingPhrase)
+
+		moved, ok := mapSpan(edits, start, end)
+		if !ok {
+			update.Changed = append(update.Changed, i)
+			continue
+		}
+		to := moved + len(anchor.PrecedingPhrase) + bodyOffset
+		if to != anchor.CharacterNumber {
+			update.Shifted = append(update.Shifted, AnchorShift{Anchor: i, Term: anchor.Annotation.TermFound,
+				From: anchor.CharacterNumber, To: to})
+		}
+	}
+	return update
+}
+
+// setQuantityClaim makes sure the entity has the property with the given amount
+func (c *ScienceSourceClient) setQuantityClaim(ctx context.Context, entity wikibase.Entity, property string, amount int) error {
+
+	propertyID, err := c.PropertyID(property)
+	if err != nil {
+		return err
+	}
+	value := wikibase.NewQuantityValue(amount)
+
+	statements := entity.Claims[propertyID]
+	if len(statements) == 0 {
+		_, err = c.network.CreateClaim(ctx, entity.ID, propertyID, value)
+		return err
+	}
+	if current, ok := statements[0].MainSnak.Quantity(); ok && int(current) == amount {
+		return nil
+	}
+	return c.network.SetClaimValue(ctx, statements[0].ID, value)
+}
+
+// applyTextUpdate moves the anchor points on the server and in the record, and updates the distances
+// between them to match.
+func (c *ScienceSourceClient) applyTextUpdate(ctx context.Context, article *ScienceSourceArticle, update TextUpdate, bodyOffset int) error {
+
+	for _, shift := range update.Shifted {
+		article.Annotations[shift.Anchor].CharacterNumber = shift.To
+	}
+	for _, changed := range update.Changed {
+		article.Annotations[changed].TextChanged = true
+	}
+	article.BodyOffset = bodyOffset
+
+	entities, err := c.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return err
+	}
+
+	for i := range article.Annotations {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		anchor := &article.Annotations[i]
+		entity, ok := entities[string(anchor.ID)]
+		if !ok || !entity.Exists() {
+			return fmt.Errorf("Anchor point item %s is not on the server", anchor.ID)
+		}
+
+		err = c.setQuantityClaim(ctx, entity, "character number", anchor.CharacterNumber)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			distance := anchor.CharacterNumber - article.Annotations[i-1].CharacterNumber
+			anchor.DistanceToPreceding = &distance
+			err = c.setQuantityClaim(ctx, entity, "distance to preceding", distance)
+			if err != nil {
+				return err
+			}
+		}
+		if i < len(article.Annotations)-1 {
+			distance := article.Annotations[i+1].CharacterNumber - anchor.CharacterNumber
+			anchor.DistanceToFollowing = &distance
+			err = c.setQuantityClaim(ctx, entity, "distance to following", distance)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReplacePaperText uploads new HTML for an article as a new revision of its page.
+func (c *ScienceSourceClient) ReplacePaperText(ctx context.Context, article *ScienceSourceArticle, htmlFileName string) error {
+
+	data, err := ioutil.ReadFile(htmlFileName)
+	if err != nil {
+		return err
+	}
+	err = checkUTF8(htmlFileName, data)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	page_id, err := c.wikiBaseClient.CreateOrUpdateArticle(article.ScienceSourceArticleTitle, string(data))
+	if err != nil {
+		return err
+	}
+	if page_id != article.PageID {
+		return fmt.Errorf("Updated page %d, but the article is on page %d", page_id, article.PageID)
+	}
+	return c.network.VerifyPageContent(ctx, article.PageID, string(data))
+}
+
+// UpdateText converts the paper XML again, refetching it first if asked, and if that changes the text
+// uploads it and moves the anchor points to match. Nothing on the server or on disk is changed in a dry
+// run, which just reports what would happen.
+func (processor PaperProcessor) UpdateText(ctx context.Context, sciSourceClient *ScienceSourceClient, refetch bool, dryRun bool) (TextUpdate, error) {
+
+	article, err := processor.Article()
+	if err != nil {
+		return TextUpdate{}, err
+	}
+	if article.Complete == false {
+		return TextUpdate{}, fmt.Errorf("Paper %s has not finished being ingested", processor.Paper.ID())
+	}
+	old, err := processor.Text()
+	if err != nil {
+		return TextUpdate{}, err
+	}
+
+	// Make the new version of the files alongside the current ones, so that they're only replaced once
+	// the server has the new text.
+	staging, err := ioutil.TempDir(processor.TargetDirectory, processor.Paper.ID()+".update")
+	if err != nil {
+		return TextUpdate{}, err
+	}
+	defer os.RemoveAll(staging)
+	next := processor
+	next.TargetDirectory = staging
+	err = next.createFolderIfRequired()
+	if err != nil {
+		return TextUpdate{}, err
+	}
+
+	source, text_url := article.TextSource, article.TextURL
+	if refetch {
+		source, text_url, err = next.fetchPaperTextToDisk(ctx)
+		if err != nil {
+			return TextUpdate{}, errwrap.Wrapf("Failed to fetch paper text: {{err}}", err)
+		}
+	} else {
+		data, err := ioutil.ReadFile(processor.targetXMLFileName())
+		if err != nil {
+			return TextUpdate{}, err
+		}
+		err = ioutil.WriteFile(next.targetXMLFileName(), data, 0644)
+		if err != nil {
+			return TextUpdate{}, err
+		}
+	}
+
+	metadata, err := convert.LoadPaperMetadataFromFile(next.targetXMLFileName())
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to load paper XML: {{err}}", err)
+	}
+	customHeader, err := next.renderHeaderTemplate()
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to render header template: {{err}}", err)
+	}
+	bodyOffset, err := sciSourceClient.Network().RenderedTextLength(ctx, customHeader)
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to measure header template: {{err}}", err)
+	}
+	err = next.processXMLToHTML(metadata.FirstAuthor, customHeader)
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to convert paper to HTML: {{err}}", err)
+	}
+	_, err = next.processXMLToText()
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to generate text for mining: {{err}}", err)
+	}
+	err = next.appendCategoriesToHTML(article)
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to add categories to HTML: {{err}}", err)
+	}
+	err = next.cleanFiles()
+	if err != nil {
+		return TextUpdate{}, errwrap.Wrapf("Failed to clean paper text: {{err}}", err)
+	}
+
+	new, err := next.Text()
+	if err != nil {
+		return TextUpdate{}, err
+	}
+	update := article.planTextUpdate(old, new, bodyOffset)
+	if update.HasChanges() == false || dryRun {
+		return update, nil
+	}
+
+	// Don't overwrite anyone's curation of the page or the annotations
+	err = sciSourceClient.CheckPageForEdits(ctx, article)
+	if err != nil {
+		return update, err
+	}
+	entities, err := sciSourceClient.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return update, err
+	}
+	err = sciSourceClient.CheckItemsForEdits(ctx, article, entities)
+	if err != nil {
+		return update, err
+	}
+
+	logging.Logf(logging.LogNormal, "Updating text of paper %s: %d changes, %d anchor points moved, %d need checking",
+		processor.Paper.ID(), update.Edits, len(update.Shifted), len(update.Changed))
+	err = sciSourceClient.ReplacePaperText(ctx, article, next.targetHTMLFileName())
+	if err != nil {
+		return update, errwrap.Wrapf("Failed to upload new text: {{err}}", err)
+	}
+
+	// The server has the new text now, so our copy of it has to be that too
+	for _, name := range []string{"paper.xml", "paper.html", "paper.txt"} {
+		err = os.Rename(path.Join(next.folderName(), name), path.Join(processor.folderName(), name))
+		if err != nil {
+			return update, err
+		}
+	}
+
+	article.TextSource, article.TextURL = source, text_url
+	err = sciSourceClient.applyTextUpdate(ctx, article, update, bodyOffset)
+	sciSourceClient.RecordRevisions(article)
+	if save_err := article.Save(processor.targetScienceSourceStateFileName()); save_err != nil && err == nil {
+		err = save_err
+	}
+	if err != nil {
+		return update, errwrap.Wrapf("Failed to move anchor points: {{err}}", err)
+	}
+	return update, nil
+}
@@ -0,0 +1,291 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Rolling back a failed upload only covers the items of one attempt at one article, so to take back
+// something finer or broader, say a run that went wrong in a way nobody noticed until later, we work from
+// the write log instead. The writes picked out are gone through newest first, and everything they touched
+// is put back: entities and pages they created are deleted, and ones they edited are reverted to how they
+// were before the first of the writes. Anything someone has edited since is left alone, as is anything
+// that isn't ours to delete.
+
+const (
+	UndoDelete = "delete"
+	UndoRevert = "revert"
+)
+
+// ErrNothingToUndo is returned for a step whose writes have already been undone, or whose target has gone.
+var ErrNothingToUndo = errors.New("Nothing to undo")
+
+// UndoStep puts back one entity or page that the writes touched.
+type UndoStep struct {
+	Action string
+	RunID  string    // of the latest write
+	Time   time.Time // of the latest write
+
+	// Either the entity, or for wikitext pages the page ID or title
+	Entity string
+	PageID int
+	Title  string
+
+	// Our first and latest revisions in the writes picked out
+	First  int
+	Latest int
+}
+
+// Target describes what the step puts back.
+func (step UndoStep) Target() string {
+	switch {
+	case len(step.Entity) != 0:
+		return step.Entity
+	case len(step.Title) != 0:
+		return step.Title
+	default:
+		return strconv.Itoa(step.PageID)
+	}
+}
+
+// UndoConflictError is returned for a step whose target has been edited since our latest write to it.
+type UndoConflictError struct {
+	Step    UndoStep
+	Current int
+	Editors []string
+}
+
+func (e *UndoConflictError) Error() string {
+	return fmt.Sprintf("%s has been edited since revision %d by %s, use -force to undo anyway", e.Step.Target(),
+		e.Step.Latest, strings.Join(e.Editors, ", "))
+}
+
+// Writes we have no way to take back
+var undoUnsupported = map[string]bool{
+	"delete":  true,
+	"protect": true,
+	"upload":  true,
+}
+
+// PlanUndo works out the steps to undo the successful writes that are picked out, newest first, along
+// with any of them that can't be undone.
+func PlanUndo(records []wikibase.WriteRecord, picked func(wikibase.WriteRecord) bool) ([]UndoStep, []wikibase.WriteRecord) {
+
+	steps := make(map[string]*UndoStep)
+	unsupported := make([]wikibase.WriteRecord, 0)
+
+	for _, record := range records {
+		if len(record.Error) != 0 || picked(record) == false {
+			continue
+		}
+		if undoUnsupported[record.Action] {
+			unsupported = append(unsupported, record)
+			continue
+		}
+		// Writes that change nothing, such as purges, have no revision
+		if record.Revision == 0 && record.Created == false {
+			continue
+		}
+
+		var key string
+		switch {
+		case len(record.Entity) != 0:
+			key = record.Entity
+		case record.PageID != 0:
+			key = "page " + strconv.Itoa(record.PageID)
+		case len(record.Title) != 0:
+			key = "title " + record.Title
+		default:
+			unsupported = append(unsupported, record)
+			continue
+		}
+
+		step, ok := steps[key]
+		if !ok {
+			step = &UndoStep{Action: UndoRevert, Entity: record.Entity, First: record.Revision}
+			steps[key] = step
+		}
+		if record.PageID != 0 {
+			step.PageID = record.PageID
+		}
+		if len(record.Title) != 0 {
+			step.Title = record.Title
+		}
+		if record.Created {
+			step.Action = UndoDelete
+		}
+		if record.Revision != 0 {
+			if step.First == 0 {
+				step.First = record.Revision
+			}
+			step.Latest = record.Revision
+		}
+		step.RunID = record.RunID
+		step.Time = record.Time
+	}
+
+	// Protecting a page we then delete needs no undoing
+	deleted := make(map[string]bool)
+	res := make([]UndoStep, 0, len(steps))
+	for _, step := range steps {
+		res = append(res, *step)
+		if step.Action == UndoDelete {
+			deleted[step.Title] = true
+			deleted[strconv.Itoa(step.PageID)] = true
+		}
+	}
+	remaining := unsupported[:0]
+	for _, record := range unsupported {
+		if record.Action != "protect" || deleted[record.Target] == false {
+			remaining = append(remaining, record)
+		}
+	}
+	unsupported = remaining
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.After(res[j].Time)
+	})
+	return res, unsupported
+}
+
+// undoTarget finds the title and current revision of the step's target, or returns ErrNothingToUndo if it
+// no longer exists.
+func (c *ScienceSourceClient) undoTarget(ctx context.Context, step UndoStep) (string, int, error) {
+
+	if len(step.Entity) != 0 {
+		entities, err := c.network.GetEntities(ctx, []string{step.Entity})
+		if err != nil {
+			return "", 0, err
+		}
+		entity, ok := entities[step.Entity]
+		if !ok || !entity.Exists() {
+			return "", 0, ErrNothingToUndo
+		}
+		return entity.Title, entity.LastRevID, nil
+	}
+
+	page_id := step.PageID
+	if page_id == 0 {
+		var err error
+		page_id, err = c.network.PageIDForTitle(ctx, step.Title)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	if page_id == 0 {
+		return "", 0, ErrNothingToUndo
+	}
+	current, err := c.network.CurrentPageRevision(ctx, page_id)
+	if err != nil {
+		return "", 0, err
+	}
+	if current == 0 {
+		return "", 0, ErrNothingToUndo
+	}
+	return step.Title, current, nil
+}
+
+// CheckUndoStep makes sure the step can be applied, returning ErrNothingToUndo if there's nothing left
+// for it to do, and an UndoConflictError if its target has been edited since, unless we're forcing. We
+// only ever delete what one of our accounts created, forcing or not.
+func (c *ScienceSourceClient) CheckUndoStep(ctx context.Context, step UndoStep, force bool) error {
+	_, _, err := c.checkUndoStep(ctx, step, force)
+	return err
+}
+
+// checkUndoStep does the checks for CheckUndoStep, returning the title and current revision of the target.
+func (c *ScienceSourceClient) checkUndoStep(ctx context.Context, step UndoStep, force bool) (string, int, error) {
+
+	title, current, err := c.undoTarget(ctx, step)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if step.Action == UndoDelete {
+		ours, err := c.ourUsers(ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		creator, err := c.network.PageCreator(ctx, title)
+		if err != nil {
+			return "", 0, err
+		}
+		if ours[creator] == false {
+			return "", 0, fmt.Errorf("%s was created by %s, which isn't one of our accounts", step.Target(), creator)
+		}
+	} else {
+		parent, err := c.network.ParentRevision(ctx, step.First)
+		if err != nil {
+			return "", 0, err
+		}
+		if current == parent {
+			return "", 0, ErrNothingToUndo
+		}
+	}
+
+	if current != step.Latest && !force {
+		editors := []string{"unknown"}
+		if len(title) != 0 {
+			editors, err = c.network.EditorsSince(ctx, title, step.Latest)
+			if err != nil {
+				return "", 0, err
+			}
+		}
+		return "", 0, &UndoConflictError{Step: step, Current: current, Editors: editors}
+	}
+	return title, current, nil
+}
+
+// ApplyUndoStep deletes or reverts the step's target, having checked that it can.
+func (c *ScienceSourceClient) ApplyUndoStep(ctx context.Context, step UndoStep, force bool) error {
+
+	title, current, err := c.checkUndoStep(ctx, step, force)
+	if err != nil {
+		return err
+	}
+	summary := fmt.Sprintf("Undoing edits made by run %s", step.RunID)
+
+	if step.Action == UndoDelete {
+		return c.network.DeletePage(ctx, title, fmt.Sprintf("Undoing creation by run %s", step.RunID))
+	}
+
+	parent, err := c.network.ParentRevision(ctx, step.First)
+	if err != nil {
+		return err
+	}
+	if parent == 0 {
+		return fmt.Errorf("%s has no revision from before revision %d to go back to", step.Target(), step.First)
+	}
+	if len(step.Entity) != 0 {
+		return c.network.RevertEntity(ctx, step.Entity, parent, current, summary)
+	}
+	page_id := step.PageID
+	if page_id == 0 {
+		page_id, err = c.network.PageIDForTitle(ctx, title)
+		if err != nil {
+			return err
+		}
+	}
+	return c.network.RevertPage(ctx, page_id, parent, current, summary)
+}
@@ -0,0 +1,126 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Before we make any API calls for an article we check the record is sane, as it's much easier to fix
+// a problem locally than to tidy up half an anchor chain on the server. All the problems found are
+// reported together rather than one per run.
+
+type ValidationErrors []string
+
+func (v ValidationErrors) Error() string {
+	return fmt.Sprintf("%d problems found:\n\t%s", len(v), strings.Join(v, "\n\t"))
+}
+
+func (v *ValidationErrors) add(format string, args ...interface{}) {
+	*v = append(*v, fmt.Sprintf(format, args...))
+}
+
+func checkItemID(problems *ValidationErrors, context string, id wikibase.ItemPropertyType) {
+	if len(id) != 0 && wikiDataIDPattern.MatchString(string(id)) == false {
+		problems.add("%s has malformed item ID %q", context, id)
+	}
+}
+
+func checkWikiDataCode(problems *ValidationErrors, context string, code string, required bool) {
+	if len(code) == 0 {
+		if required {
+			problems.add("%s has no Wikidata item code", context)
+		}
+	} else if wikiDataIDPattern.MatchString(code) == false {
+		problems.add("%s has malformed Wikidata item code %q", context, code)
+	}
+}
+
+func (article *ScienceSourceArticle) Validate() error {
+
+	var problems ValidationErrors
+
+	if len(strings.TrimSpace(article.ScienceSourceArticleTitle)) == 0 {
+		problems.add("Article has no ScienceSource title")
+	}
+	if len(strings.TrimSpace(article.ArticleTextTitle)) == 0 {
+		problems.add("Article has no text title")
+	}
+	checkWikiDataCode(&problems, "Article", article.WikiDataItemCode, true)
+	checkItemID(&problems, "Article", article.ID)
+	if article.CharacterNumber < 0 || article.BodyOffset < 0 {
+		problems.add("Article has negative offset")
+	}
+	if article.PublicationDate == nil && article.PublicationDateValue == nil {
+		problems.add("Article has no publication date")
+	}
+	if article.PublicationDateValue != nil {
+		if err := article.PublicationDateValue.Validate(); err != nil {
+			problems.add("Article publication date: %v", err)
+		}
+	}
+	if err := wikibase.ValidateTimeCode(article.TimeCode); err != nil {
+		problems.add("Article: %v", err)
+	}
+
+	previous := -1
+	for i, anchor := range article.Annotations {
+		context := fmt.Sprintf("Anchor %d (%q at %d)", i, anchor.Annotation.TermFound, anchor.CharacterNumber)
+
+		checkItemID(&problems, context, anchor.ID)
+		checkItemID(&problems, context+" annotation", anchor.Annotation.ID)
+
+		if anchor.CharacterNumber < 0 {
+			problems.add("%s has negative character number", context)
+		}
+		if anchor.CharacterNumber < previous {
+			problems.add("%s comes before the previous anchor at %d", context, previous)
+		}
+		previous = anchor.CharacterNumber
+
+		if anchor.DistanceToPreceding != nil && *anchor.DistanceToPreceding < 0 {
+			problems.add("%s has negative distance to preceding", context)
+		}
+		if anchor.DistanceToFollowing != nil && *anchor.DistanceToFollowing < 0 {
+			problems.add("%s has negative distance to following", context)
+		}
+		if err := wikibase.ValidateTimeCode(anchor.TimeCode); err != nil {
+			problems.add("%s: %v", context, err)
+		}
+
+		annotation := anchor.Annotation
+		if len(annotation.TermFound) == 0 {
+			problems.add("%s has no term", context)
+		}
+		if annotation.LengthOfTermFound != len(annotation.TermFound) {
+			problems.add("%s has term length %d, expected %d", context, annotation.LengthOfTermFound, len(annotation.TermFound))
+		}
+		if len(annotation.DictionaryName) == 0 {
+			problems.add("%s has no dictionary name", context)
+		}
+		checkWikiDataCode(&problems, context, annotation.WikiDataItemCode, false)
+		if err := wikibase.ValidateTimeCode(annotation.TimeCode); err != nil {
+			problems.add("%s annotation: %v", context, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The API will sometimes quietly store something other than what we sent, such as a string with its
+// whitespace tidied or cut short, and a write that dies part way can leave an item with only some of
+// its statements. So once an article's items are populated we can fetch them back and check every
+// statement we meant to make is there with the value we meant it to have.
+
+type ClaimDiscrepancy struct {
+	ItemID   string   `json:"item"`
+	Property string   `json:"property"`
+	Expected string   `json:"expected"`
+	Found    []string `json:"found"` // Empty if the statement is missing
+}
+
+func (d ClaimDiscrepancy) String() string {
+	if len(d.Found) == 0 {
+		return fmt.Sprintf("%s: %s is missing, expected %q", d.ItemID, d.Property, d.Expected)
+	}
+	return fmt.Sprintf("%s: %s is %q, expected %q", d.ItemID, d.Property, strings.Join(d.Found, `", "`), d.Expected)
+}
+
+// Times are uploaded to at most day precision, so only the date part of a time value is compared
+const claimDateLayout string = "2006-01-02"
+
+// claimDate gives the date part of a Wikibase time value, without its sign.
+func claimDate(when string) string {
+	when = strings.TrimPrefix(when, "+")
+	if len(when) >= len(claimDateLayout) {
+		return when[:len(claimDateLayout)]
+	}
+	return when
+}
+
+// expected gives the claim's value in the same form snakValue gives for what's on the server.
+func (claim itemClaim) expected() string {
+	switch value := claim.Value.(type) {
+	case string:
+		return value
+	case wikibase.ItemPropertyType:
+		return string(value)
+	case int:
+		return strconv.Itoa(value)
+	case time.Time:
+		return claimDate(wikibase.NewWikibaseTime(value, claim.Precision).Time)
+	}
+	return fmt.Sprintf("%v", claim.Value)
+}
+
+// expectedClaims gets the value we uploaded for each of the item's statements, in the same form
+// snakValue gives for what's on the server. Values we wouldn't have uploaded are left out.
+func expectedClaims(item claimedItem) map[string]string {
+
+	res := make(map[string]string)
+	for _, claim := range item.claims() {
+		if claim.empty() {
+			continue
+		}
+		res[claim.Property] = claim.expected()
+	}
+	return res
+}
+
+// snakValue gives the value of a statement in the same form as expectedClaims
+func snakValue(snak wikibase.Snak) string {
+	if id := snak.ItemID(); len(id) != 0 {
+		return id
+	}
+	if amount, ok := snak.Quantity(); ok {
+		return strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+	if text, ok := snak.StringValue(); ok {
+		return text
+	}
+	if when, ok := snak.TimeValue(); ok {
+		return claimDate(when)
+	}
+	return snak.SnakType
+}
+
+func (c *ScienceSourceClient) compareClaims(entity wikibase.Entity, item claimedItem) ([]ClaimDiscrepancy, error) {
+
+	res := make([]ClaimDiscrepancy, 0)
+	for label, expected := range expectedClaims(item) {
+		propertyID, err := c.PropertyID(label)
+		if err != nil {
+			return nil, err
+		}
+
+		found := make([]string, 0)
+		matched := false
+		for _, statement := range entity.Claims[propertyID] {
+			value := snakValue(statement.MainSnak)
+			found = append(found, value)
+			if value == expected {
+				matched = true
+			}
+		}
+		if matched == false {
+			res = append(res, ClaimDiscrepancy{ItemID: entity.ID, Property: label, Expected: expected, Found: found})
+		}
+	}
+	return res, nil
+}
+
+type verifiedItem struct {
+	id   wikibase.ItemPropertyType
+	item claimedItem
+	path string // Where the item is in the saved article
+}
+
+// verifiedItems lists the article's items whose statements we check.
+func (article *ScienceSourceArticle) verifiedItems() []verifiedItem {
+	res := []verifiedItem{{article.ID, article, "article"}}
+	for i := range article.Annotations {
+		res = append(res, verifiedItem{article.Annotations[i].ID, &article.Annotations[i],
+			fmt.Sprintf("annotations[%d]", i)})
+		res = append(res, verifiedItem{article.Annotations[i].Annotation.ID, &article.Annotations[i].Annotation,
+			fmt.Sprintf("annotations[%d].annotation", i)})
+	}
+	return res
+}
+
+// VerifyArticleClaims fetches the article's items back from the server and returns every statement
+// that isn't as we uploaded it.
+func (c *ScienceSourceClient) VerifyArticleClaims(ctx context.Context, article *ScienceSourceArticle) ([]ClaimDiscrepancy, error) {
+
+	entities, err := c.network.GetEntities(ctx, article.ItemIDs())
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ClaimDiscrepancy, 0)
+	for _, item := range article.verifiedItems() {
+		entity, ok := entities[string(item.id)]
+		if len(item.id) == 0 || !ok || !entity.Exists() {
+			return nil, fmt.Errorf("Item %s is not on the server to verify", item.id)
+		}
+		discrepancies, err := c.compareClaims(entity, item.item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, discrepancies...)
+	}
+	return res, nil
+}
+
+// SaveClaimDiscrepancies records what verification found, removing any earlier record if there was
+// nothing wrong this time.
+func SaveClaimDiscrepancies(filename string, discrepancies []ClaimDiscrepancy) error {
+	if len(discrepancies) == 0 {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.MarshalIndent(discrepancies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
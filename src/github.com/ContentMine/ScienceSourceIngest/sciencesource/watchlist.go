@@ -0,0 +1,61 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The wikibase package does the watching, this just gathers up everything we created for an article.
+
+func (article *ScienceSourceArticle) ItemIDs() []string {
+
+	res := make([]string, 0, 1+(len(article.Annotations)*2))
+	if len(article.ID) != 0 {
+		res = append(res, string(article.ID))
+	}
+	for _, anchor := range article.Annotations {
+		if len(anchor.ID) != 0 {
+			res = append(res, string(anchor.ID))
+		}
+		if len(anchor.Annotation.ID) != 0 {
+			res = append(res, string(anchor.Annotation.ID))
+		}
+	}
+
+	return res
+}
+
+func (c *ScienceSourceClient) UpdateWatchlist(ctx context.Context, article *ScienceSourceArticle, action wikibase.WatchlistAction) error {
+
+	if action == wikibase.WatchlistNone {
+		return nil
+	}
+
+	if article.PageID != 0 {
+		err := c.network.WatchPage(ctx, article.PageID, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	titles, err := c.network.EntityPageTitles(ctx, article.ItemIDs())
+	if err != nil {
+		return err
+	}
+
+	return c.network.WatchTitles(ctx, titles, action)
+}
@@ -0,0 +1,144 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sciencesource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// Articles on ScienceSource are copies of papers that already have items on Wikidata, so once an article
+// is finished we can point the paper's Wikidata item back at it. Writing to Wikidata is writing to
+// someone else's wiki, so this is kept to the one claim, made with its own credentials, and only ever
+// from a plan that a dry run wrote out and an operator has looked over.
+
+const (
+	// "full work available at URL" on Wikidata
+	WikidataFullWorkProperty string = "P953"
+
+	DefaultWikidataURL string = "https://www.wikidata.org"
+
+	// Plans older than this are refused, as Wikidata may have changed since they were checked
+	WikidataPlanLifetime time.Duration = 24 * time.Hour
+)
+
+type WikidataEdit struct {
+	Paper    string `json:"paper"`
+	Item     string `json:"item"`
+	Property string `json:"property"`
+	URL      string `json:"url"`
+}
+
+type WikidataPlan struct {
+	Created     time.Time      `json:"created"`
+	WikidataURL string         `json:"wikidata_url"`
+	URLBase     string         `json:"urlbase"` // The ScienceSource server the URLs point at
+	Edits       []WikidataEdit `json:"edits"`
+}
+
+// ArticleURL is the address of the article page on the server, by page ID so that it still works if
+// the page is ever renamed.
+func ArticleURL(urlBase string, article *ScienceSourceArticle) string {
+	return fmt.Sprintf("%s/w/index.php?curid=%d", urlBase, article.PageID)
+}
+
+// WikidataEditFor works out the write back needed for an article, returning nil if the paper's
+// Wikidata item already points at it.
+func WikidataEditFor(ctx context.Context, wikidata *wikibase.NetworkClient, urlBase string, paperID string, article *ScienceSourceArticle) (*WikidataEdit, error) {
+
+	if article.Complete == false || article.PageID == 0 || len(article.WikiDataItemCode) == 0 {
+		return nil, fmt.Errorf("Article for paper %s isn't finished, or has no Wikidata item", paperID)
+	}
+
+	entities, err := wikidata.GetEntities(ctx, []string{article.WikiDataItemCode})
+	if err != nil {
+		return nil, err
+	}
+	entity, ok := entities[article.WikiDataItemCode]
+	if ok == false || entity.Exists() == false {
+		return nil, fmt.Errorf("Wikidata item %s for paper %s does not exist", article.WikiDataItemCode, paperID)
+	}
+
+	url := ArticleURL(urlBase, article)
+	for _, statement := range entity.Claims[WikidataFullWorkProperty] {
+		if value, ok := statement.MainSnak.StringValue(); ok && value == url {
+			return nil, nil
+		}
+	}
+
+	return &WikidataEdit{
+		Paper:    paperID,
+		Item:     article.WikiDataItemCode,
+		Property: WikidataFullWorkProperty,
+		URL:      url,
+	}, nil
+}
+
+// ApplyWikidataEdit makes a planned edit, checking first that it is still for this article and still
+// needed. The claim ID is empty if there was nothing to do.
+func ApplyWikidataEdit(ctx context.Context, wikidata *wikibase.NetworkClient, urlBase string, edit WikidataEdit, article *ScienceSourceArticle) (string, error) {
+
+	current, err := WikidataEditFor(ctx, wikidata, urlBase, edit.Paper, article)
+	if err != nil || current == nil {
+		return "", err
+	}
+	if *current != edit {
+		return "", fmt.Errorf("Planned edit for paper %s no longer matches the article, make a new plan", edit.Paper)
+	}
+
+	return wikidata.CreateClaim(ctx, edit.Item, edit.Property, edit.URL)
+}
+
+func (plan WikidataPlan) Save(filename string) error {
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// LoadWikidataPlan reads a plan, refusing it if it is out of date or was made for other servers.
+func LoadWikidataPlan(filename string, wikidataURL string, urlBase string) (*WikidataPlan, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var plan WikidataPlan
+	err = json.NewDecoder(f).Decode(&plan)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.WikidataURL != wikidataURL || plan.URLBase != urlBase {
+		return nil, fmt.Errorf("Plan %s was made for %s and %s, not %s and %s", filename, plan.WikidataURL, plan.URLBase, wikidataURL, urlBase)
+	}
+	if age := time.Since(plan.Created); age > WikidataPlanLifetime {
+		return nil, fmt.Errorf("Plan %s is %v old, make a new one with a dry run", filename, age.Round(time.Minute))
+	}
+	return &plan, nil
+}
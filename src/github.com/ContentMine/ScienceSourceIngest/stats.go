@@ -0,0 +1,94 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/annotate"
+)
+
+// The stats command summarises the annotations found in the papers that have got that far, without
+// talking to the server.
+
+func runStats(args []string) {
+
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	var options commandFlags
+	var dictionaries_path string
+	var list_unmatched bool
+	var hit_summary_path string
+	options.register(flags)
+	flags.StringVar(&dictionaries_path, "dictionaries", "", "Directory of dictionaries, to count the entries that weren't found.")
+	flags.BoolVar(&list_unmatched, "unmatched", false, "List each dictionary entry that wasn't found in any paper.")
+	flags.StringVar(&hit_summary_path, "hitsummary", "", "File to save a JSON summary of how each dictionary did to.")
+	flags.Parse(args)
+
+	stats := collectStats(options.processors())
+
+	var dictionaries []annotate.Dictionary
+	if len(dictionaries_path) > 0 {
+		var err error
+		dictionaries, err = annotate.LoadDictionariesFromDirectory(dictionaries_path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(hit_summary_path) > 0 {
+		err := stats.SaveHitSummary(hit_summary_path, dictionaries)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	fmt.Printf("Papers:\t\t%d\n", stats.Papers)
+	fmt.Printf("Characters:\t%d\n", stats.Characters)
+	fmt.Printf("Annotations:\t%d (%.2f per 1,000 characters)\n", stats.Annotations, stats.Density(stats.Annotations))
+	fmt.Printf("Unique QIDs:\t%d\n\n", len(stats.WikiDataIDs))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DICTIONARY\tPAPERS\tANNOTATIONS\tPER 1,000\tTERMS\tQIDS\tUNMATCHED")
+	for _, name := range stats.DictionaryNames() {
+		dict := stats.Dictionaries[name]
+		unmatched := "-"
+		for _, dictionary := range dictionaries {
+			if dictionary.Identifier == name {
+				unmatched = fmt.Sprintf("%d of %d", len(stats.UnmatchedTerms(dictionary)), len(dictionary.Entries))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%d\t%d\t%s\n", name, dict.Papers, dict.Annotations,
+			stats.Density(dict.Annotations), len(dict.Terms), len(dict.WikiDataIDs), unmatched)
+	}
+	// Dictionaries that found nothing at all are worth knowing about too
+	for _, dictionary := range dictionaries {
+		if _, ok := stats.Dictionaries[dictionary.Identifier]; !ok {
+			fmt.Fprintf(w, "%s\t0\t0\t0.00\t0\t0\t%d of %d\n", dictionary.Identifier, len(dictionary.Entries), len(dictionary.Entries))
+		}
+	}
+	w.Flush()
+
+	if list_unmatched {
+		for _, dictionary := range dictionaries {
+			fmt.Printf("\nUnmatched in %s:\n", dictionary.Identifier)
+			for _, term := range stats.UnmatchedTerms(dictionary) {
+				fmt.Printf("  %s\n", term)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The status command lists each paper in the feed with what we've recorded about it locally, and with
+// -remote checks the server agrees, for instance if pages or items have been deleted by hand or a run
+// died before saving what it had done.
+
+func runStatus(args []string) {
+
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	var options commandFlags
+	var remote bool
+	options.register(flags)
+	options.registerServer(flags)
+	flags.BoolVar(&remote, "remote", false, "Check each paper's page and items on the server against local state.")
+	flags.Parse(args)
+
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sciSourceClient *sciencesource.ScienceSourceClient
+	if remote {
+		// We only read from the server, so there's no need to assert who we are
+		sciSourceClient = options.connect(ctx, wikibase.NetworkOptions{Assert: "none"})
+	}
+
+	problems := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if remote {
+		fmt.Fprintln(w, "PAPER\tSTATE\tPAGE\tITEM\tANCHORS\tSERVER PAGE\tSERVER ITEM\tSERVER ANCHORS\tPROBLEMS")
+	} else {
+		fmt.Fprintln(w, "PAPER\tSTATE\tPAGE\tITEM\tANCHORS")
+	}
+
+	for _, processor := range processors {
+		id := processor.Paper.ID()
+
+		page, item, anchors := "-", "-", 0
+		article, load_err := processor.Article()
+		if load_err != nil {
+			article = nil
+		} else {
+			if article.PageID != 0 {
+				page = fmt.Sprintf("%d", article.PageID)
+			}
+			if len(article.ID) != 0 {
+				item = string(article.ID)
+			}
+			for _, anchor := range article.Annotations {
+				if len(anchor.ID) != 0 {
+					anchors += 1
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d", id, processor.State(), page, item, anchors)
+
+		if remote {
+			if err := ctx.Err(); err != nil {
+				w.Flush()
+				log.Printf("Stopping before all papers were checked: %v", err)
+				os.Exit(1)
+			}
+
+			status, err := sciSourceClient.RemoteStatus(ctx, processor.Paper, article)
+			if err != nil {
+				status.Problems = append(status.Problems, fmt.Sprintf("failed to check: %v", err))
+			}
+			server_page, server_item := "-", "-"
+			if status.PageID != 0 {
+				server_page = fmt.Sprintf("%d", status.PageID)
+			}
+			if article != nil && len(article.ID) != 0 {
+				server_item = "missing"
+				if status.ArticleItemExists {
+					server_item = "yes"
+				}
+			}
+			fmt.Fprintf(w, "\t%s\t%s\t%d\t%s", server_page, server_item, status.Anchors, strings.Join(status.Problems, "; "))
+			problems += len(status.Problems)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	// Let scripts tell if anything needs looking at
+	if problems != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,364 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package tracing records spans for the stages of processing each paper and the API calls they make,
+// and sends them to an OpenTelemetry collector, so operators can see where the time goes for a slow
+// article and follow a failure across stages.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans are sent with OTLP over HTTP, encoded as JSON, which any OpenTelemetry collector (and many
+// tracing backends directly) accept. That's a small enough protocol to speak ourselves rather than pull
+// in the OpenTelemetry SDK and its dependencies. Until Configure is called tracing is off, and starting
+// a span does nothing more than return a nil span, whose methods are all safe to call.
+//
+// Only the part of OTLP we need is spoken, which is:
+//
+//   - one resource, with service.name and service.version, and one instrumentation scope
+//   - spans with trace, span, and parent IDs, name, internal or client kind, start and end times,
+//     attributes, and an ok or error status with the error's message
+//   - string, int, and bool attribute values, with anything else sent as a string
+//   - a JSON POST to /v1/traces, uncompressed, with no headers for authentication
+//
+// Not supported are span events and links, trace state, sampling (every span is sent), protobuf, gzip,
+// retrying a failed or throttled export, and partial success responses, which are ignored. A failed
+// export loses its spans. testdata/export.json records what a collector gets, and the tests check it.
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type Span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   *[8]byte
+	name       string
+	kind       SpanKind
+	start      time.Time
+	attributes []Attribute
+}
+
+type spanKey struct{}
+
+// Start begins a span as a child of any span in the context, returning a context carrying the new span.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return StartKind(ctx, SpanKindInternal, name, attributes...)
+}
+
+func StartKind(ctx context.Context, kind SpanKind, name string, attributes ...Attribute) (context.Context, *Span) {
+
+	if currentExporter() == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	rand.Read(span.spanID[:])
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		span.traceID = parent.traceID
+		parentID := parent.spanID
+		span.parentID = &parentID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// Finish ends the span, marking it as failed if there was an error.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	exporter := currentExporter()
+	if exporter == nil {
+		return
+	}
+	exporter.add(s.encode(time.Now(), err))
+}
+
+// TraceParent is the W3C trace context header value for the span, so the server can tie its own logs to
+// our requests.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// OTLP JSON encoding
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func encodeAttributes(attributes []Attribute) []otlpAttribute {
+	res := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value otlpValue
+		switch v := attribute.Value.(type) {
+		case string:
+			value.StringValue = &v
+		case int:
+			// 64 bit integers are strings in OTLP JSON
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		case bool:
+			value.BoolValue = &v
+		default:
+			s := fmt.Sprintf("%v", v)
+			value.StringValue = &s
+		}
+		res = append(res, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return res
+}
+
+func (s *Span) encode(end time.Time, err error) otlpSpan {
+	res := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        encodeAttributes(s.attributes),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if s.parentID != nil {
+		res.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		res.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+	return res
+}
+
+// Exporting
+
+// Spans are sent in batches, every so often or when enough have built up
+const (
+	exportInterval  = 5 * time.Second
+	exportBatchSize = 512
+	exportTimeout   = 30 * time.Second
+)
+
+type exporter struct {
+	url        string
+	resource   []otlpAttribute
+	scope      string
+	version    string
+	client     http.Client
+	lock       sync.Mutex
+	spans      []otlpSpan
+	done       chan bool
+	finished   chan bool
+	failedOnce bool
+}
+
+var exporterLock sync.Mutex
+var globalExporter *exporter
+
+func currentExporter() *exporter {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	return globalExporter
+}
+
+// Configure turns tracing on, sending spans to the collector at endpoint (e.g. http://localhost:4318),
+// as the given service and version.
+func Configure(endpoint string, service string, version string) {
+
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	e := &exporter{
+		url:      url,
+		resource: encodeAttributes([]Attribute{String("service.name", service), String("service.version", version)}),
+		scope:    "github.com/ContentMine/ScienceSourceIngest",
+		version:  version,
+		client:   http.Client{Timeout: exportTimeout},
+		done:     make(chan bool),
+		finished: make(chan bool),
+	}
+	go e.run()
+
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	globalExporter = e
+}
+
+// Shutdown sends any spans not yet sent and turns tracing off. Call it before the program exits.
+func Shutdown() {
+	exporterLock.Lock()
+	e := globalExporter
+	globalExporter = nil
+	exporterLock.Unlock()
+
+	if e != nil {
+		close(e.done)
+		<-e.finished
+	}
+}
+
+func (e *exporter) add(span otlpSpan) {
+	e.lock.Lock()
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= exportBatchSize
+	e.lock.Unlock()
+
+	if full {
+		e.flush()
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.done:
+			e.flush()
+			close(e.finished)
+			return
+		}
+	}
+}
+
+func (e *exporter) flush() {
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": e.resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": e.scope, "version": e.version},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	err := e.send(request)
+	if err != nil {
+		// Losing some spans isn't worth stopping for, or filling the log with the same complaint
+		e.lock.Lock()
+		first := e.failedOnce == false
+		e.failedOnce = true
+		e.lock.Unlock()
+		if first {
+			log.Printf("Failed to send %d trace spans to %s: %v", len(spans), e.url, err)
+		}
+	}
+}
+
+func (e *exporter) send(request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testdata/export.json is the request a collector should get for the spans made in TestExport, in the
+// OTLP/HTTP JSON encoding. It's compared as decoded JSON, so layout and key order don't matter.
+
+func mustDecodeHex(t *testing.T, s string, into []byte) {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(into) {
+		t.Fatalf("Bad hex ID %s", s)
+	}
+	copy(into, data)
+}
+
+func TestExport(t *testing.T) {
+
+	requests := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- body
+	}))
+	defer server.Close()
+
+	e := &exporter{
+		url:      server.URL,
+		resource: encodeAttributes([]Attribute{String("service.name", "ingest"), String("service.version", "1.2.3")}),
+		scope:    "github.com/ContentMine/ScienceSourceIngest",
+		version:  "1.2.3",
+	}
+
+	start := time.Unix(1544712659, 0)
+	parent := &Span{name: "paper", kind: SpanKindInternal, start: start}
+	mustDecodeHex(t, "5b8efff798038103d269b633813fc60c", parent.traceID[:])
+	mustDecodeHex(t, "eee19b7ec3c1b173", parent.spanID[:])
+
+	child := &Span{name: "upload", kind: SpanKindClient, start: start.Add(time.Second), traceID: parent.traceID}
+	mustDecodeHex(t, "eee19b7ec3c1b174", child.spanID[:])
+	parentID := parent.spanID
+	child.parentID = &parentID
+	child.SetAttributes(String("paper", "PMC123"), Int("attempt", 2), Attribute{Key: "cached", Value: false},
+		Attribute{Key: "ratio", Value: 0.5})
+
+	e.add(child.encode(start.Add(2500*time.Millisecond), fmt.Errorf("Server said no")))
+	e.add(parent.encode(start.Add(3*time.Second), nil))
+	e.flush()
+
+	var got, expected interface{}
+	if err := json.Unmarshal(<-requests, &got); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := ioutil.ReadFile("testdata/export.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(recorded, &expected); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		data, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("Export doesn't match testdata/export.json, got:\n%s", data)
+	}
+
+	// Having sent them, there's nothing more to send
+	e.flush()
+	select {
+	case <-requests:
+		t.Error("Spans sent twice")
+	default:
+	}
+}
+
+func TestStart(t *testing.T) {
+
+	// Until tracing is configured spans are nil, and safe to use
+	ctx, span := Start(context.Background(), "off")
+	if span != nil {
+		t.Fatal("Expected no span with tracing off")
+	}
+	span.SetAttributes(String("a", "b"))
+	span.Finish(nil)
+	if span.TraceParent() != "" {
+		t.Error("Expected no trace parent with tracing off")
+	}
+
+	// Nothing listens here, so the spans sent on shutdown are lost, which is only logged
+	Configure("http://127.0.0.1:1/", "ingest", "test")
+	if globalExporter.url != "http://127.0.0.1:1/v1/traces" {
+		t.Errorf("Expected the traces path to be added to the endpoint, got %s", globalExporter.url)
+	}
+	defer Shutdown()
+
+	ctx, parent := Start(ctx, "parent")
+	_, child := StartKind(ctx, SpanKindClient, "child")
+	if parent == nil || child == nil {
+		t.Fatal("Expected spans with tracing on")
+	}
+	if child.traceID != parent.traceID || child.parentID == nil || *child.parentID != parent.spanID {
+		t.Error("Expected the child span to be in its parent's trace")
+	}
+	if child.spanID == parent.spanID {
+		t.Error("Expected the spans to have different IDs")
+	}
+
+	expected := "00-" + hex.EncodeToString(child.traceID[:]) + "-" + hex.EncodeToString(child.spanID[:]) + "-01"
+	if child.TraceParent() != expected {
+		t.Errorf("Expected trace parent %s, got %s", expected, child.TraceParent())
+	}
+}
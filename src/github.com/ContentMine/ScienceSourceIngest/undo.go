@@ -0,0 +1,158 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The undo command takes back the writes an earlier run made, or those made in a window of time, going
+// by the write log in the output directory. Like cleanup it only says what it would do unless told to
+// apply it, as deleting and reverting on a server others are curating needs checking first.
+
+// parseUndoTime reads a time given to -since or -until, or returns the zero time if none was.
+func parseUndoTime(value string, name string) time.Time {
+	if len(value) == 0 {
+		return time.Time{}
+	}
+	res, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-%s should be a time like 2018-11-20T16:00:00Z: %v\n", name, err)
+		os.Exit(2)
+	}
+	return res
+}
+
+func runUndo(args []string) {
+
+	flags := flag.NewFlagSet("undo", flag.ExitOnError)
+	var options commandFlags
+	var since_value string
+	var until_value string
+	var apply bool
+	var force bool
+	var assert_user string
+	flags.StringVar(&options.targetPath, "output", ".", "Directory whose write log to undo from.")
+	flags.BoolVar(&options.quiet, "quiet", false, "Only log failures and warnings.")
+	flags.BoolVar(&options.verbose, "v", false, "Log each API call.")
+	flags.BoolVar(&options.veryVerbose, "vv", false, "Log full API requests and responses.")
+	options.registerServer(flags)
+	flags.StringVar(&since_value, "since", "", "Undo writes made at or after this time (RFC3339).")
+	flags.StringVar(&until_value, "until", "", "Undo writes made before this time (RFC3339).")
+	flags.BoolVar(&apply, "apply", false, "Actually delete and revert, rather than just listing what would be undone.")
+	flags.BoolVar(&force, "force", false, "Revert and delete things that have been edited since, losing those edits.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check edits are made as a logged in user or bot, or none.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s undo [flags] [run id]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	run_id := flags.Arg(0)
+	since := parseUndoTime(since_value, "since")
+	until := parseUndoTime(until_value, "until")
+	if len(run_id) == 0 && since.IsZero() && until.IsZero() {
+		fmt.Fprintf(os.Stderr, "Give a run ID, or -since or -until, to say which writes to undo\n")
+		os.Exit(2)
+	}
+	if apply {
+		options.guardProduction("undo -apply")
+	}
+	logging.SetLogLevel(options.quiet, options.verbose, options.veryVerbose)
+
+	records, err := wikibase.LoadWriteLog(path.Join(options.targetPath, sciencesource.WriteLogFileName))
+	if err != nil {
+		panic(err)
+	}
+	steps, unsupported := sciencesource.PlanUndo(records, func(record wikibase.WriteRecord) bool {
+		if len(run_id) != 0 && record.RunID != run_id {
+			return false
+		}
+		if !since.IsZero() && record.Time.Before(since) {
+			return false
+		}
+		if !until.IsZero() && !record.Time.Before(until) {
+			return false
+		}
+		return true
+	})
+	for _, record := range unsupported {
+		log.Printf("Can't undo %s of %s by run %s at %s", record.Action, record.Target, record.RunID,
+			record.Time.Format(time.RFC3339))
+	}
+	if len(steps) == 0 {
+		logging.Logf(logging.LogNormal, "Found no writes to undo")
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tRUN\tUNDO\tACTION")
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			w.Flush()
+			log.Printf("Stopping before all writes were undone: %v", err)
+			os.Exit(1)
+		}
+
+		action := "would " + step.Action
+		if apply {
+			err = sciSourceClient.ApplyUndoStep(ctx, step, force)
+			action = step.Action + "ed"
+			if step.Action == sciencesource.UndoDelete {
+				action = "deleted"
+			}
+		} else {
+			err = sciSourceClient.CheckUndoStep(ctx, step, force)
+		}
+		switch {
+		case err == sciencesource.ErrNothingToUndo:
+			action = "already undone"
+		case err != nil:
+			action = fmt.Sprintf("left alone: %v", err)
+			failed += 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", step.Target(), step.RunID, step.Action, action)
+	}
+	w.Flush()
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
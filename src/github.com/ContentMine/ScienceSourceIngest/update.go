@@ -0,0 +1,128 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The update command uploads a new version of the text of articles already on the server, either
+// because the paper was corrected or because we convert it better now, keeping the annotations that
+// were made on the old text.
+
+func runUpdate(args []string) {
+
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	var options commandFlags
+	var xslt_proc_path string
+	var header_template_path string
+	var categories stringListFlag
+	var refetch bool
+	var source_names stringListFlag
+	var dry_run bool
+	var assert_user string
+	var force bool
+	options.register(flags)
+	options.registerServer(flags)
+	flags.StringVar(&xslt_proc_path, "xsltproc", "/usr/bin/xsltproc", "Location off xsltproc tool.")
+	flags.StringVar(&header_template_path, "header", "", "Template file of wikitext to put at the top of each article page.")
+	flags.Var(&categories, "category", "Category to add to article pages, can be repeated. May use {journal}, {subject}, {batch}, and {dictionary}.")
+	flags.BoolVar(&refetch, "refetch", false, "Fetch the paper XML again rather than converting the copy already downloaded.")
+	flags.Var(&source_names, "source", "Where to fetch paper XML from with -refetch, as for ingest. Can be repeated.")
+	flags.BoolVar(&dry_run, "dryrun", false, "Only list the anchor points that would move or need checking, rather than uploading the new text.")
+	flags.StringVar(&assert_user, "assert", "user", "Have the server check writes are made as a logged in user or bot, or none.")
+	flags.BoolVar(&force, "force", false, "Carry on uploading text even if someone else has edited the article's page or items since we last did.")
+	flags.StringVar(&sciencesource.NormalisationForm, "normalise", sciencesource.NormalisationNFC, "Unicode normalisation form to put the new text in, as for ingest.")
+	flags.StringVar(&sciencesource.ControlCharacters, "controlchars", sciencesource.ControlCharactersStrip, "What to do with control characters in the new text, as for ingest.")
+	flags.Parse(args)
+
+	if dry_run == false {
+		options.guardProduction("update")
+	}
+	if err := sciencesource.ValidateNormalisationForm(); err != nil {
+		panic(err)
+	}
+	if err := sciencesource.ValidateControlCharacters(); err != nil {
+		panic(err)
+	}
+	processors := options.processors()
+	sources := paperSources(source_names)
+
+	var err error
+	var header_template *template.Template
+	if len(header_template_path) > 0 {
+		header_template, err = sciencesource.LoadHeaderTemplate(header_template_path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sciSourceClient := options.connect(ctx, wikibase.NetworkOptions{Assert: assert_user})
+	sciSourceClient.SetRunID(sciencesource.NewRunID())
+	sciSourceClient.Force = force
+	logging.Logf(logging.LogNormal, "Run ID is %s", sciSourceClient.RunID)
+
+	failed := 0
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all papers were updated: %v", err)
+			os.Exit(1)
+		}
+
+		article, err := processor.Article()
+		if err != nil || article.Complete == false {
+			continue
+		}
+
+		processor.XSLTProcPath = xslt_proc_path
+		processor.HeaderTemplate = header_template
+		processor.Categories = categories
+		processor.Sources = sources
+
+		update, err := processor.UpdateText(ctx, sciSourceClient, refetch, dry_run)
+		for _, shift := range update.Shifted {
+			fmt.Printf("%s\tmoved\t%s\t%d\t%d\n", processor.Paper.ID(), shift.Term, shift.From, shift.To)
+		}
+		for _, index := range update.Changed {
+			anchor := article.Annotations[index]
+			fmt.Printf("%s\tcheck\t%s\t%d\t%s\n", processor.Paper.ID(), anchor.Annotation.TermFound,
+				anchor.CharacterNumber, anchor.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to update paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+		logging.Logf(logging.LogVerbose, "Paper %s had %d changes to its text", processor.Paper.ID(), update.Edits)
+	}
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
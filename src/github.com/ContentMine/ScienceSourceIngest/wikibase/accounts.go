@@ -0,0 +1,261 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrjones/oauth"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Servers limit how fast each account can edit, so a big ingest campaign can be spread over several
+// accounts. Each write goes out as whichever account has made the fewest writes in the last minute,
+// with an optional limit on writes per account per minute, and an account the server says is rate
+// limited is rested for a while. Reads don't count towards the limits, so they all use the first
+// account.
+
+// How long to leave an account alone once the server has told us it's rate limited
+const accountRateLimitPause time.Duration = time.Minute
+
+type account struct {
+	name   string
+	client *http.Client
+
+	tokenLock sync.Mutex
+	tokens    map[string]string
+
+	// These are guarded by the pool's lock
+	writes      []time.Time // In the last minute
+	total       int
+	limited     int
+	pausedUntil time.Time
+}
+
+func newAccount(credentials *Credentials, urlbase string, transport http.RoundTripper) (*account, error) {
+
+	res := &account{name: credentials.Name()}
+
+	tokens := credentials.Tokens()
+	if tokens.IsOAuth2() {
+		res.client = &http.Client{Transport: bearerTransport{transport, credentials, urlbase}}
+		return res, nil
+	}
+
+	consumer := oauth.NewCustomHttpClientConsumer(tokens.Consumer.Key, tokens.Consumer.Secret,
+		oauth.ServiceProvider{}, &http.Client{Transport: transport})
+
+	var err error
+	res.client, err = consumer.MakeHttpClient(&oauth.AccessToken{
+		Token:  tokens.Access.Token,
+		Secret: tokens.Access.Secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (a *account) forgetTokens() {
+	a.tokenLock.Lock()
+	defer a.tokenLock.Unlock()
+	a.tokens = nil
+}
+
+// tokenTypeFor says which kind of token a write action needs
+func tokenTypeFor(action string) string {
+	switch action {
+	case "watch", "rollback":
+		return action
+	}
+	return "csrf"
+}
+
+type accountPool struct {
+	accounts []*account
+	rate     int
+
+	lock sync.Mutex
+}
+
+func newAccountPool(accounts []*account, rate int) *accountPool {
+	return &accountPool{accounts: accounts, rate: rate}
+}
+
+func (p *accountPool) primary() *account {
+	return p.accounts[0]
+}
+
+// pick chooses the account for the next write, waiting until one is free if they're all at their limit
+func (p *accountPool) pick(ctx context.Context) (*account, error) {
+
+	// The common case of one account with no limit needs no accounting
+	if len(p.accounts) == 1 && p.rate == 0 {
+		return p.accounts[0], nil
+	}
+
+	for {
+		p.lock.Lock()
+		now := time.Now()
+		var best *account
+		var wait time.Duration
+		for _, a := range p.accounts {
+			recent := a.writes[:0]
+			for _, when := range a.writes {
+				if now.Sub(when) < time.Minute {
+					recent = append(recent, when)
+				}
+			}
+			a.writes = recent
+
+			free := now
+			if a.pausedUntil.After(free) {
+				free = a.pausedUntil
+			}
+			if p.rate > 0 && len(a.writes) >= p.rate && a.writes[0].Add(time.Minute).After(free) {
+				free = a.writes[0].Add(time.Minute)
+			}
+			if free.After(now) {
+				if wait == 0 || free.Sub(now) < wait {
+					wait = free.Sub(now)
+				}
+				continue
+			}
+			if best == nil || len(a.writes) < len(best.writes) {
+				best = a
+			}
+		}
+		if best != nil {
+			best.writes = append(best.writes, now)
+			best.total += 1
+			p.lock.Unlock()
+			return best, nil
+		}
+		p.lock.Unlock()
+
+		logging.Logf(logging.LogVerbose, "All accounts are at their write limit, waiting %v", wait.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// record looks at a write's response for anything that's down to the account
+func (p *accountPool) record(a *account, response []byte) {
+	var errorResponse struct {
+		Error *APIError `json:"error"`
+	}
+	if json.Unmarshal(response, &errorResponse) != nil || errorResponse.Error == nil {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(errorResponse.Error.Code, "ratelimited"):
+		p.lock.Lock()
+		a.limited += 1
+		a.pausedUntil = time.Now().Add(accountRateLimitPause)
+		p.lock.Unlock()
+		logging.Logf(logging.LogNormal, "Account %s is rate limited, resting it for %v", a.name, accountRateLimitPause)
+	case errorResponse.Error.Code == "badtoken":
+		// The session has gone, so get new tokens next time
+		a.forgetTokens()
+	}
+}
+
+// LogAccountStats logs how many writes each account made, if there's more than one.
+func (c *NetworkClient) LogAccountStats() {
+	p := c.accounts
+	if len(p.accounts) < 2 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, a := range p.accounts {
+		logging.Logf(logging.LogNormal, "Account %s: %d writes, rate limited %d times", a.name, a.total, a.limited)
+	}
+}
+
+// AccountUsers returns the names of the users that all the accounts write as.
+func (c *NetworkClient) AccountUsers(ctx context.Context) ([]string, error) {
+
+	rights, err := c.AccountRights(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(rights))
+	for _, account := range rights {
+		res = append(res, account.User)
+	}
+	return res, nil
+}
+
+// AccountInfo says which user an account writes as and what that user is allowed to do.
+type AccountInfo struct {
+	Account string
+	User    string
+	Rights  []string
+}
+
+func (a AccountInfo) Has(right string) bool {
+	for _, r := range a.Rights {
+		if r == right {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountRights looks up the user and rights of each account, in the order they were given.
+func (c *NetworkClient) AccountRights(ctx context.Context) ([]AccountInfo, error) {
+
+	values := encodeArguments(map[string]string{
+		"action": "query",
+		"meta":   "userinfo",
+		"uiprop": "rights",
+	})
+
+	res := make([]AccountInfo, 0, len(c.accounts.accounts))
+	for _, a := range c.accounts.accounts {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?%s", c.apiURL(), values.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.do(ctx, a, req, values)
+		if err != nil {
+			return nil, err
+		}
+		var response userInfoResponse
+		err = decodeAPIResponse(body, &response)
+		if err != nil {
+			return nil, err
+		}
+		if response.Query.UserInfo.ID == 0 {
+			return nil, fmt.Errorf("Account %s is not logged in to the server", a.name)
+		}
+		res = append(res, AccountInfo{Account: a.name, User: response.Query.UserInfo.Name,
+			Rights: response.Query.UserInfo.Rights})
+	}
+	return res, nil
+}
@@ -0,0 +1,62 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A long running ingest needs to be able to say whether it can still talk to the server, so the client
+// keeps track of when the last request the server answered was, and the last one it didn't. Requests made
+// only to check on the server, such as by a health probe, aren't counted, as otherwise a probe would make
+// the server look reachable however ingest's own requests were going.
+
+type apiActivity struct {
+	lock        sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   error
+}
+
+type unrecordedKey struct{}
+
+// WithoutActivity returns a context whose requests aren't counted by APIActivity.
+func WithoutActivity(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+func (a *apiActivity) record(ctx context.Context, err error) {
+	if unrecorded, _ := ctx.Value(unrecordedKey{}).(bool); unrecorded {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err != nil {
+		a.lastFailure = time.Now()
+		a.lastError = err
+	} else {
+		a.lastSuccess = time.Now()
+	}
+}
+
+// APIActivity returns when the server last answered a request, and when a request last failed along
+// with why. Either time is zero if there hasn't been one.
+func (c *NetworkClient) APIActivity() (time.Time, time.Time, error) {
+	c.activity.lock.Lock()
+	defer c.activity.lock.Unlock()
+	return c.activity.lastSuccess, c.activity.lastFailure, c.activity.lastError
+}
@@ -0,0 +1,135 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// The wikibase library covers items and article creation, but some of what we do with the
+// MediaWiki API isn't wrapped there, so these helpers let us (and users of this package) make the odd
+// direct API call whilst still sharing the same network client and error reporting.
+
+type apiErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func decodeAPIResponse(body io.ReadCloser, out interface{}) error {
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	var errorResponse apiErrorResponse
+	err = json.Unmarshal(data, &errorResponse)
+	if err != nil {
+		return err
+	}
+	if errorResponse.Error != nil {
+		return errorResponse.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *NetworkClient) GetJSON(ctx context.Context, args map[string]string, out interface{}) error {
+	body, err := c.GetContext(ctx, args)
+	if err != nil {
+		return err
+	}
+	return decodeAPIResponse(body, out)
+}
+
+type tokenResponse struct {
+	Query struct {
+		Tokens map[string]string `json:"tokens"`
+	} `json:"query"`
+}
+
+// Token fetches a token of the given type (e.g. "csrf" or "watch") for write actions. Tokens are
+// valid for the session, so we hang on to them once we have them.
+func (c *NetworkClient) Token(ctx context.Context, tokenType string) (string, error) {
+	return c.accountToken(ctx, c.accounts.primary(), tokenType)
+}
+
+func (c *NetworkClient) accountToken(ctx context.Context, account *account, tokenType string) (string, error) {
+	account.tokenLock.Lock()
+	defer account.tokenLock.Unlock()
+
+	if token, ok := account.tokens[tokenType]; ok {
+		return token, nil
+	}
+
+	values := encodeArguments(map[string]string{
+		"action": "query",
+		"meta":   "tokens",
+		"type":   tokenType,
+	})
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?%s", c.apiURL(), values.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := c.do(ctx, account, req, values)
+	if err != nil {
+		return "", err
+	}
+	var response tokenResponse
+	err = decodeAPIResponse(body, &response)
+	if err != nil {
+		return "", err
+	}
+	token := response.Query.Tokens[tokenType+"token"]
+	if len(token) == 0 {
+		return "", fmt.Errorf("Server did not return a %s token", tokenType)
+	}
+
+	if account.tokens == nil {
+		account.tokens = make(map[string]string)
+	}
+	account.tokens[tokenType] = token
+	return token, nil
+}
+
+func (c *NetworkClient) EditToken(ctx context.Context) (string, error) {
+	return c.Token(ctx, "csrf")
+}
+
+// PostWithToken adds an edit token to the arguments, as required for any write action.
+func (c *NetworkClient) PostWithToken(ctx context.Context, args map[string]string, out interface{}) error {
+	token, err := c.EditToken(ctx)
+	if err != nil {
+		return err
+	}
+	args["token"] = token
+	return c.PostJSON(ctx, args, out)
+}
+
+func (c *NetworkClient) PostJSON(ctx context.Context, args map[string]string, out interface{}) error {
+	body, err := c.PostContext(ctx, args)
+	if err != nil {
+		return err
+	}
+	return decodeAPIResponse(body, out)
+}
@@ -0,0 +1,293 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/hashicorp/errwrap"
+)
+
+// Consumers that aren't owner-only need the account's owner to authorise them in a browser before we
+// get access tokens. These are the steps of that for OAuth 1.0a and OAuth 2, leaving talking to the
+// person to the caller.
+//
+// The OAuth library we use for signing API requests can't sign MediaWiki's token requests, as they go
+// to index.php with the special page as a query parameter which has to be part of the signature, so
+// these few requests are signed here.
+
+// OAuthRequestToken is the temporary token that the account owner authorises.
+type OAuthRequestToken struct {
+	Token  string
+	Secret string
+}
+
+// oauthEscape is the percent encoding from RFC 5849, which differs from URL query encoding in how it
+// treats spaces.
+func oauthEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func oauthNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// oauth1Get makes a GET signed with the consumer and, if we have one, token, and decodes the JSON reply
+func oauth1Get(ctx context.Context, urlbase string, query url.Values, oauth map[string]string, consumerSecret string, tokenSecret string) (map[string]interface{}, error) {
+
+	endpoint := fmt.Sprintf("%s/w/index.php", urlbase)
+
+	nonce, err := oauthNonce()
+	if err != nil {
+		return nil, err
+	}
+	oauth["oauth_nonce"] = nonce
+	oauth["oauth_timestamp"] = strconv.FormatInt(time.Now().Unix(), 10)
+	oauth["oauth_signature_method"] = "HMAC-SHA1"
+	oauth["oauth_version"] = "1.0"
+
+	// The signature covers the query and OAuth parameters together, sorted
+	params := make([]string, 0, len(query)+len(oauth))
+	for key := range query {
+		params = append(params, oauthEscape(key)+"="+oauthEscape(query.Get(key)))
+	}
+	for key, value := range oauth {
+		params = append(params, oauthEscape(key)+"="+oauthEscape(value))
+	}
+	sort.Strings(params)
+	base := "GET&" + oauthEscape(endpoint) + "&" + oauthEscape(strings.Join(params, "&"))
+
+	mac := hmac.New(sha1.New, []byte(oauthEscape(consumerSecret)+"&"+oauthEscape(tokenSecret)))
+	mac.Write([]byte(base))
+	oauth["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := make([]string, 0, len(oauth))
+	for key, value := range oauth {
+		header = append(header, fmt.Sprintf("%s=\"%s\"", oauthEscape(key), oauthEscape(value)))
+	}
+	sort.Strings(header)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+
+	return doTokenRequest(req)
+}
+
+func doTokenRequest(req *http.Request) (map[string]interface{}, error) {
+
+	err := ratelimit.Wait(req.Context(), ratelimit.Wiki)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply map[string]interface{}
+	err = json.Unmarshal(data, &reply)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected reply from server: %s: %s", resp.Status, data)
+	}
+	if message, ok := reply["error"]; ok {
+		if description, ok := reply["message"]; ok {
+			return nil, fmt.Errorf("%v: %v", message, description)
+		}
+		return nil, fmt.Errorf("%v", message)
+	}
+	return reply, nil
+}
+
+func replyString(reply map[string]interface{}, key string) (string, error) {
+	value, ok := reply[key].(string)
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("Server reply has no %s", key)
+	}
+	return value, nil
+}
+
+// RequestOAuthToken starts authorising an OAuth 1.0a consumer, returning the request token and the URL
+// the account owner needs to visit to authorise it. Having done so they're given a verification code
+// to pass to AuthoriseOAuthToken.
+func RequestOAuthToken(ctx context.Context, urlbase string, consumerKey string, consumerSecret string) (OAuthRequestToken, string, error) {
+
+	query := url.Values{}
+	query.Set("title", "Special:OAuth/initiate")
+	query.Set("format", "json")
+	query.Set("oauth_callback", "oob")
+
+	reply, err := oauth1Get(ctx, urlbase, query, map[string]string{"oauth_consumer_key": consumerKey},
+		consumerSecret, "")
+	if err != nil {
+		return OAuthRequestToken{}, "", errwrap.Wrapf("Failed to get OAuth request token: {{err}}", err)
+	}
+
+	var token OAuthRequestToken
+	token.Token, err = replyString(reply, "key")
+	if err == nil {
+		token.Secret, err = replyString(reply, "secret")
+	}
+	if err != nil {
+		return OAuthRequestToken{}, "", errwrap.Wrapf("Failed to get OAuth request token: {{err}}", err)
+	}
+
+	authorise := url.Values{}
+	authorise.Set("title", "Special:OAuth/authorize")
+	authorise.Set("oauth_token", token.Token)
+	authorise.Set("oauth_consumer_key", consumerKey)
+
+	return token, fmt.Sprintf("%s/w/index.php?%s", urlbase, authorise.Encode()), nil
+}
+
+// AuthoriseOAuthToken swaps an authorised request token and its verification code for access tokens.
+func AuthoriseOAuthToken(ctx context.Context, urlbase string, consumerKey string, consumerSecret string, request OAuthRequestToken, verifier string) (CredentialTokens, error) {
+
+	query := url.Values{}
+	query.Set("title", "Special:OAuth/token")
+	query.Set("format", "json")
+
+	oauth := map[string]string{
+		"oauth_consumer_key": consumerKey,
+		"oauth_token":        request.Token,
+		"oauth_verifier":     strings.TrimSpace(verifier),
+	}
+	reply, err := oauth1Get(ctx, urlbase, query, oauth, consumerSecret, request.Secret)
+	if err != nil {
+		return CredentialTokens{}, errwrap.Wrapf("Failed to get OAuth access token: {{err}}", err)
+	}
+
+	var tokens CredentialTokens
+	tokens.Consumer.Key = consumerKey
+	tokens.Consumer.Secret = consumerSecret
+	tokens.Access.Token, err = replyString(reply, "key")
+	if err == nil {
+		tokens.Access.Secret, err = replyString(reply, "secret")
+	}
+	if err != nil {
+		return CredentialTokens{}, errwrap.Wrapf("Failed to get OAuth access token: {{err}}", err)
+	}
+	return tokens, nil
+}
+
+// OAuth2AuthoriseURL is where the account owner authorises an OAuth 2 consumer. Once they have, they're
+// sent to the consumer's callback URL with a code to pass to AuthoriseOAuth2Code.
+func OAuth2AuthoriseURL(urlbase string, clientID string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", clientID)
+	return fmt.Sprintf("%s/w/rest.php/oauth2/authorize?%s", urlbase, query.Encode())
+}
+
+// AuthoriseOAuth2Code swaps an authorisation code for access and refresh tokens.
+func AuthoriseOAuth2Code(ctx context.Context, urlbase string, clientID string, clientSecret string, code string) (CredentialTokens, error) {
+
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", strings.TrimSpace(code))
+
+	var tokens CredentialTokens
+	tokens.Consumer.Key = clientID
+	tokens.Consumer.Secret = clientSecret
+	err := oauth2TokenRequest(ctx, http.DefaultClient, urlbase, &tokens, values)
+	if err != nil {
+		return CredentialTokens{}, errwrap.Wrapf("Failed to get OAuth access token: {{err}}", err)
+	}
+	return tokens, nil
+}
+
+// oauth2TokenRequest asks the token endpoint for new tokens, for either a grant or a refresh, and
+// updates the tokens with them.
+func oauth2TokenRequest(ctx context.Context, client *http.Client, urlbase string, tokens *CredentialTokens, values url.Values) error {
+
+	values.Set("client_id", tokens.Consumer.Key)
+	values.Set("client_secret", tokens.Consumer.Secret)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/w/rest.php/oauth2/access_token", urlbase),
+		strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = ratelimit.Wait(ctx, ratelimit.Wiki)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, data)
+	}
+
+	var reply struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(data, &reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.AccessToken) == 0 {
+		return fmt.Errorf("Server didn't give an access token: %s", data)
+	}
+
+	tokens.Access.Token = reply.AccessToken
+	tokens.Access.Secret = ""
+	if len(reply.RefreshToken) != 0 {
+		tokens.Access.RefreshToken = reply.RefreshToken
+	}
+	tokens.Access.Expires = nil
+	if reply.ExpiresIn > 0 {
+		expires := time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second)
+		tokens.Access.Expires = &expires
+	}
+	return nil
+}
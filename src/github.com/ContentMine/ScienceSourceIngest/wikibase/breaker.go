@@ -0,0 +1,157 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// If the server is broken, or rate limiting us, then every write will fail, and without some care a
+// batch run will work its way through hundreds of articles leaving each with a failed upload. So we
+// count consecutive failed writes, and once there have been too many we stop making writes for a
+// while. Each paper saves its state as it fails, so nothing is lost, and once the pause is over we let
+// writes through again to see if the server has recovered.
+
+type CircuitOpenError struct {
+	Failures int
+	Until    time.Time
+}
+
+func (e CircuitOpenError) Error() string {
+	if e.Until.IsZero() {
+		return fmt.Sprintf("Not writing to server after %d consecutive failures", e.Failures)
+	}
+	return fmt.Sprintf("Not writing to server after %d consecutive failures, pausing until %v", e.Failures,
+		e.Until.Format(time.Kitchen))
+}
+
+// API error codes that tell us the server can't take writes right now, as opposed to there being
+// something wrong with the particular write
+var serverTroubleCodes = []string{
+	"ratelimited",
+	"maxlag",
+	"readonly",
+	"assertuserfailed",
+	"assertbotfailed",
+	"internal_api_error",
+	"failed-save",
+}
+
+type circuitBreaker struct {
+	threshold int
+	pause     time.Duration
+
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, pause time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, pause: pause}
+}
+
+// check returns an error if writes aren't allowed. With no pause set the breaker never closes again,
+// and the batch should stop.
+func (b *circuitBreaker) check() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.pause == 0 {
+		return CircuitOpenError{Failures: b.failures}
+	}
+	until := b.openedAt.Add(b.pause)
+	if time.Now().Before(until) {
+		return CircuitOpenError{Failures: b.failures, Until: until}
+	}
+	// The pause is over, so let writes through until the next failure
+	return nil
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if failed == false {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.failures == b.threshold {
+			logging.Logf(logging.LogQuiet, "%d consecutive writes to the server failed, pausing writes", b.failures)
+		}
+	}
+}
+
+// isServerTrouble looks at a write response to see if it's an error that counts against the server
+func isServerTrouble(response []byte) bool {
+	var errorResponse struct {
+		Error *APIError `json:"error"`
+	}
+	if json.Unmarshal(response, &errorResponse) != nil || errorResponse.Error == nil {
+		return false
+	}
+	for _, code := range serverTroubleCodes {
+		if strings.HasPrefix(errorResponse.Error.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForServer blocks until writes are allowed again, returning an error if they never will be or
+// the context is cancelled first. A batch calls this before starting each paper.
+func (c *NetworkClient) WaitForServer(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := c.breaker.check()
+		if err == nil {
+			return nil
+		}
+		open := err.(CircuitOpenError)
+		if open.Until.IsZero() {
+			return err
+		}
+		logging.Logf(logging.LogNormal, "%v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(open.Until)):
+		}
+	}
+}
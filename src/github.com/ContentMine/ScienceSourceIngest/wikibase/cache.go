@@ -0,0 +1,214 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// Checking and linking fetch the same entities over and over, such as the article item for every
+// annotation, so entity fetches and searches can be remembered for the rest of the run. The cache is
+// bounded by the size of the responses it holds, dropping the least recently used, and anything a
+// write might have changed is forgotten as the write is made.
+
+// Only these lookups are cached, as other queries are used for things like tokens and page state that
+// can change under us.
+func isCachedAction(action string) bool {
+	return action == "wbgetentities" || action == "wbsearchentities"
+}
+
+type readCacheEntry struct {
+	key      string
+	data     []byte
+	entities []string // Empty for searches
+}
+
+type readCache struct {
+	lock     sync.Mutex
+	maxBytes int
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List // Most recently used at the front
+
+	hits   int
+	misses int
+
+	// Bumped on every invalidation, so a read that was in flight during a write isn't cached
+	generation int
+}
+
+func newReadCache(maxBytes int) *readCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &readCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func readCacheKey(values url.Values) string {
+	// Encode sorts by key, so the same arguments in a different order still match
+	return values.Encode()
+}
+
+// get returns the cached response if there is one, and otherwise the generation to pass to put
+func (c *readCache) get(values url.Values) ([]byte, int, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[readCacheKey(values)]
+	if !ok {
+		c.misses += 1
+		return nil, c.generation, false
+	}
+	c.hits += 1
+	c.order.MoveToFront(element)
+	return element.Value.(*readCacheEntry).data, c.generation, true
+}
+
+func (c *readCache) put(values url.Values, data []byte, generation int) {
+	if c == nil || len(data) > c.maxBytes {
+		return
+	}
+
+	// Don't remember failures, as they may well be transient
+	var response struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &response) != nil || len(response.Error) != 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	key := readCacheKey(values)
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	var entities []string
+	if values.Get("action") == "wbgetentities" {
+		entities = strings.Split(values.Get("ids"), "|")
+	}
+	c.entries[key] = c.order.PushFront(&readCacheEntry{key: key, data: data, entities: entities})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove expects the lock to be held
+func (c *readCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*readCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.data)
+}
+
+// invalidate forgets everything that the write could have changed. Any write could change what a
+// search finds, so searches always go, but entity fetches are only forgotten if they include an entity
+// the write touches, where we can tell.
+func (c *readCache) invalidate(values url.Values) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation += 1
+	touched, known := touchedEntities(values)
+	var next *list.Element
+	for element := c.order.Front(); element != nil; element = next {
+		next = element.Next()
+		entry := element.Value.(*readCacheEntry)
+		if len(entry.entities) == 0 || known == false {
+			c.remove(element)
+			continue
+		}
+		for _, id := range entry.entities {
+			if touched[id] {
+				c.remove(element)
+				break
+			}
+		}
+	}
+}
+
+// touchedEntities works out which entities a write changes from its arguments, returning false if it
+// can't tell.
+func touchedEntities(values url.Values) (map[string]bool, bool) {
+	touched := make(map[string]bool)
+	action := values.Get("action")
+
+	switch action {
+	case "edit", "upload", "protect", "watch":
+		// Page writes, which don't change entities unless they're of an entity page
+		title := values.Get("title")
+		return touched, !strings.HasPrefix(title, "Item:") && !strings.HasPrefix(title, "Property:")
+	}
+	if strings.HasPrefix(action, "wb") == false {
+		return nil, false
+	}
+	if len(values.Get("new")) != 0 {
+		// A brand new entity can't be in the cache yet
+		return touched, true
+	}
+
+	for _, key := range []string{"id", "entity"} {
+		if id := values.Get(key); len(id) != 0 {
+			touched[id] = true
+		}
+	}
+	// Statement IDs start with the entity ID, as in Q42$5627445f-43cb-ed6d-3adb-760e85bd17ee
+	for _, key := range []string{"claim", "claims", "statement"} {
+		for _, guid := range strings.Split(values.Get(key), "|") {
+			if index := strings.Index(guid, "$"); index > 0 {
+				touched[strings.ToUpper(guid[:index])] = true
+			}
+		}
+	}
+	return touched, len(touched) != 0
+}
+
+// LogReadCacheStats logs how well the read cache did, if there is one.
+func (c *NetworkClient) LogReadCacheStats() {
+	c.cache.logStats()
+}
+
+func (c *readCache) logStats() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	logging.Logf(logging.LogVerbose, "Read cache: %d hits, %d misses, holding %d responses in %d bytes", c.hits,
+		c.misses, len(c.entries), c.size)
+}
@@ -0,0 +1,146 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Claims are made directly with the API rather than through the wikibase library, so that the caller
+// decides what value each property gets, and can make any type of data value, such as a time with less
+// than day precision.
+
+type createClaimResponse struct {
+	Claim struct {
+		ID string `json:"id"`
+	} `json:"claim"`
+}
+
+// CreateClaim adds a claim to an entity, where value is anything that marshals to the JSON form of a
+// Wikibase data value (e.g. a WikibaseTime, or a string for string and external ID properties). The ID
+// of the new claim is returned so the caller can record that it has been done.
+func (c *NetworkClient) CreateClaim(ctx context.Context, entityID string, propertyID string, value interface{}) (string, error) {
+
+	if len(entityID) == 0 || len(propertyID) == 0 {
+		return "", fmt.Errorf("Can not create claim without both entity (%s) and property (%s)", entityID, propertyID)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	var response createClaimResponse
+	err = c.PostWithToken(ctx, map[string]string{
+		"action":   "wbcreateclaim",
+		"entity":   entityID,
+		"property": propertyID,
+		"snaktype": "value",
+		"value":    string(encoded),
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Claim.ID, nil
+}
+
+type getClaimsResponse struct {
+	Claims map[string][]json.RawMessage `json:"claims"`
+}
+
+// ClaimedProperties gets the IDs of the properties an entity already has claims for.
+func (c *NetworkClient) ClaimedProperties(ctx context.Context, entityID string) (map[string]bool, error) {
+
+	var response getClaimsResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action": "wbgetclaims",
+		"entity": entityID,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]bool, len(response.Claims))
+	for propertyID, claims := range response.Claims {
+		if len(claims) > 0 {
+			res[propertyID] = true
+		}
+	}
+	return res, nil
+}
+
+// ItemValue is the JSON form of a Wikibase item data value.
+type ItemValue struct {
+	EntityType string `json:"entity-type"`
+	NumericID  int    `json:"numeric-id"`
+}
+
+func NewItemValue(itemID string) (ItemValue, error) {
+	if len(itemID) < 2 || itemID[0] != 'Q' {
+		return ItemValue{}, fmt.Errorf("Not an item ID: %s", itemID)
+	}
+	id, err := strconv.Atoi(itemID[1:])
+	if err != nil {
+		return ItemValue{}, fmt.Errorf("Not an item ID: %s", itemID)
+	}
+	return ItemValue{EntityType: "item", NumericID: id}, nil
+}
+
+// QuantityValue is the JSON form of a Wikibase quantity data value, with no unit.
+type QuantityValue struct {
+	Amount string `json:"amount"`
+	Unit   string `json:"unit"`
+}
+
+func NewQuantityValue(amount int) QuantityValue {
+	return QuantityValue{Amount: fmt.Sprintf("%+d", amount), Unit: "1"}
+}
+
+// SetClaimValue changes the value of an existing claim, which keeps its ID and any qualifiers.
+func (c *NetworkClient) SetClaimValue(ctx context.Context, claimID string, value interface{}) error {
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.PostWithToken(ctx, map[string]string{
+		"action":   "wbsetclaimvalue",
+		"claim":    claimID,
+		"snaktype": "value",
+		"value":    string(encoded),
+	}, nil)
+}
+
+// SetQualifier adds a qualifier to an existing claim, where value is as for CreateClaim.
+func (c *NetworkClient) SetQualifier(ctx context.Context, claimID string, propertyID string, value interface{}) error {
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.PostWithToken(ctx, map[string]string{
+		"action":   "wbsetqualifier",
+		"claim":    claimID,
+		"property": propertyID,
+		"snaktype": "value",
+		"value":    string(encoded),
+	}, nil)
+}
@@ -0,0 +1,253 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+)
+
+// The -oauth file started out as just the OAuth 1.0a consumer and access tokens, which last until
+// they're revoked, so could be treated as fixed configuration. MediaWiki's OAuth 2 access tokens only
+// last a few hours though, so for those we also keep the refresh token and when the access token
+// expires, refresh it as needed, and save the new tokens back to the file so the next run starts with
+// them. The file format is a superset of the original one, so old files still work.
+
+// Refresh OAuth 2 tokens this long before they expire, so a request doesn't go out with a token that
+// expires whilst it's in flight.
+const tokenExpiryMargin time.Duration = 2 * time.Minute
+
+type CredentialTokens struct {
+	Consumer struct {
+		Key    string `json:"key"`
+		Secret string `json:"secret"`
+	} `json:"consumer"`
+	Access struct {
+		Token  string `json:"token"`
+		Secret string `json:"secret,omitempty"`
+
+		// OAuth 2 only
+		RefreshToken string     `json:"refresh_token,omitempty"`
+		Expires      *time.Time `json:"expires,omitempty"`
+	} `json:"access"`
+
+	// The file is also the nearest thing we have to a profile for the server, so it can say whether
+	// the server is production or staging, and whether mass edits to production are allowed without
+	// asking each time.
+	Environment     string `json:"environment,omitempty"`
+	AllowProduction bool   `json:"allow_production,omitempty"`
+}
+
+const (
+	EnvironmentProduction = "production"
+	EnvironmentStaging    = "staging"
+)
+
+// IsOAuth2 is true for tokens from an OAuth 2 consumer, which are sent as bearer tokens rather than used
+// to sign requests.
+func (t CredentialTokens) IsOAuth2() bool {
+	return len(t.Access.Secret) == 0 && len(t.Access.Token) != 0
+}
+
+// Credentials holds the tokens for an account, saving any changes back to where they were loaded from.
+type Credentials struct {
+	path string
+
+	lock   sync.Mutex
+	tokens CredentialTokens
+}
+
+func LoadCredentials(path string) (*Credentials, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens CredentialTokens
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("Failed to read credentials from %s: {{err}}", path), err)
+	}
+	if len(tokens.Consumer.Key) == 0 {
+		return nil, fmt.Errorf("No consumer key in credentials file %s", path)
+	}
+	switch tokens.Environment {
+	case "", EnvironmentProduction, EnvironmentStaging:
+	default:
+		return nil, fmt.Errorf("Environment in credentials file %s must be %s or %s, not %s", path,
+			EnvironmentProduction, EnvironmentStaging, tokens.Environment)
+	}
+	return &Credentials{path: path, tokens: tokens}, nil
+}
+
+// SaveCredentials writes new tokens to a file, replacing anything already there.
+func SaveCredentials(path string, tokens CredentialTokens) error {
+	c := Credentials{path: path, tokens: tokens}
+	return c.save()
+}
+
+// NewCredentials makes credentials that only live in memory, for when the tokens didn't come from a
+// file.
+func NewCredentials(tokens CredentialTokens) *Credentials {
+	return &Credentials{tokens: tokens}
+}
+
+// Name is for telling accounts apart in logs
+func (c *Credentials) Name() string {
+	if len(c.path) == 0 {
+		return c.Tokens().Consumer.Key
+	}
+	return filepath.Base(c.path)
+}
+
+// Tokens returns a copy of the current tokens
+func (c *Credentials) Tokens() CredentialTokens {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.tokens
+}
+
+// SetTokens replaces the tokens, saving them if the credentials came from a file.
+func (c *Credentials) SetTokens(tokens CredentialTokens) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.tokens = tokens
+	return c.save()
+}
+
+// save expects the lock to be held. The file is replaced in one go so that a crash part way through
+// doesn't lose the tokens, and is only readable by us as it holds secrets.
+func (c *Credentials) save() error {
+	if len(c.path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errwrap.Wrapf(fmt.Sprintf("Failed to save credentials to %s: {{err}}", c.path), err)
+	}
+	return nil
+}
+
+// accessToken returns an OAuth 2 access token that is good for a while yet, refreshing it first if it
+// isn't. If stale is given then that token has been rejected by the server, so a new one is needed even
+// if it hasn't expired.
+func (c *Credentials) accessToken(ctx context.Context, client *http.Client, urlbase string, stale string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Another request may have refreshed the token whilst we were waiting for the lock
+	if c.tokens.Access.Token != stale {
+		if c.tokens.Access.Expires == nil || time.Until(*c.tokens.Access.Expires) > tokenExpiryMargin {
+			return c.tokens.Access.Token, nil
+		}
+	}
+	if len(c.tokens.Access.RefreshToken) == 0 {
+		return "", fmt.Errorf("OAuth access token has expired and there is no refresh token, the account needs authorising again")
+	}
+
+	logging.Logf(logging.LogVerbose, "Refreshing OAuth access token")
+	values := url.Values{}
+	values.Set("grant_type", "refresh_token")
+	values.Set("refresh_token", c.tokens.Access.RefreshToken)
+	err := oauth2TokenRequest(ctx, client, urlbase, &c.tokens, values)
+	if err != nil {
+		return "", errwrap.Wrapf("Failed to refresh OAuth access token: {{err}}", err)
+	}
+
+	// The refresh token may have been replaced, in which case the old one no longer works, so failing
+	// to save is worth shouting about, but we can carry on with this run.
+	if err := c.save(); err != nil {
+		logging.Logf(logging.LogNormal, "Warning: %v", err)
+	}
+	return c.tokens.Access.Token, nil
+}
+
+// bearerTransport adds the OAuth 2 access token to each request, refreshing it when it has expired or
+// the server says it is no longer valid.
+type bearerTransport struct {
+	http.RoundTripper
+	credentials *Credentials
+	urlbase     string
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	refresher := &http.Client{Transport: t.RoundTripper}
+	token, err := t.credentials.accessToken(req.Context(), refresher, t.urlbase, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.GetBody == nil && req.Body != nil {
+		return resp, err
+	}
+
+	// Tokens can be revoked or expire early, so try once more with a fresh one
+	drainingReadCloser{resp.Body}.Close()
+	token, err = t.credentials.accessToken(req.Context(), refresher, t.urlbase, token)
+	if err != nil {
+		return nil, err
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.RoundTripper.RoundTrip(retry)
+}
+
+// withBearer copies the request, as the RoundTripper contract says we can't modify it.
+func withBearer(req *http.Request, token string) *http.Request {
+	out := new(http.Request)
+	*out = *req
+	out.Header = req.Header.Clone()
+	out.Header.Set("Authorization", "Bearer "+token)
+	return out
+}
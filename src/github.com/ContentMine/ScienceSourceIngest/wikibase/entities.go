@@ -0,0 +1,199 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Finding and creating items and properties by their label, which is how we find the schema on a
+// server without hard coding IDs that change between servers.
+
+// Labels are looked up in English unless the client is told otherwise
+const DefaultLabelLanguage string = "en"
+
+func (c *NetworkClient) labelLanguages() []string {
+	if len(c.options.LabelLanguages) == 0 {
+		return []string{DefaultLabelLanguage}
+	}
+	return c.options.LabelLanguages
+}
+
+type SearchResult struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type searchEntitiesResponse struct {
+	Search []SearchResult `json:"search"`
+}
+
+type editEntityResponse struct {
+	Entity struct {
+		ID string `json:"id"`
+	} `json:"entity"`
+}
+
+func (c *NetworkClient) searchEntities(ctx context.Context, label string, entityType string, language string) ([]SearchResult, error) {
+	var response searchEntitiesResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action":         "wbsearchentities",
+		"search":         label,
+		"language":       language,
+		"strictlanguage": "1",
+		"type":           entityType,
+		"limit":          "50",
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Search, nil
+}
+
+// SearchEntities returns the entities of the given type ("item" or "property") that search finds for
+// the label, which includes near misses and matches on aliases. Each label language is tried in turn
+// until one finds something.
+func (c *NetworkClient) SearchEntities(ctx context.Context, label string, entityType string) ([]SearchResult, error) {
+
+	for _, language := range c.labelLanguages() {
+		results, err := c.searchEntities(ctx, label, entityType, language)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) > 0 {
+			return results, nil
+		}
+	}
+	return []SearchResult{}, nil
+}
+
+// FindEntitiesByLabel returns the IDs of all entities of the given type ("item" or "property") whose
+// label exactly matches, as search also returns partial matches and matches on aliases. Each label
+// language is tried in turn until one has a match.
+func (c *NetworkClient) FindEntitiesByLabel(ctx context.Context, label string, entityType string) ([]string, error) {
+
+	for _, language := range c.labelLanguages() {
+		results, err := c.searchEntities(ctx, label, entityType, language)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]string, 0)
+		for _, result := range results {
+			if result.Label == label {
+				res = append(res, result.ID)
+			}
+		}
+		if len(res) > 0 {
+			return res, nil
+		}
+	}
+	return []string{}, nil
+}
+
+// CreateEntity makes a new item or property with the given label, in the first label language. Data
+// type is only needed for properties.
+func (c *NetworkClient) CreateEntity(ctx context.Context, entityType string, label string, dataType string) (string, error) {
+
+	language := c.labelLanguages()[0]
+	data := map[string]interface{}{
+		"labels": map[string]interface{}{
+			language: map[string]string{
+				"language": language,
+				"value":    label,
+			},
+		},
+	}
+	if entityType == "property" {
+		data["datatype"] = dataType
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	var response editEntityResponse
+	err = c.PostWithToken(ctx, map[string]string{
+		"action": "wbeditentity",
+		"new":    entityType,
+		"data":   string(encoded),
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return response.Entity.ID, nil
+}
+
+// The terms of an entity, each keyed by language
+type EntityTerms struct {
+	Labels       map[string]string
+	Descriptions map[string]string
+	Aliases      map[string][]string
+}
+
+// SetEntityTerms sets labels, descriptions, and aliases on an existing entity. Languages not given are
+// left alone, but aliases given for a language replace any it already has.
+func (c *NetworkClient) SetEntityTerms(ctx context.Context, id string, terms EntityTerms) error {
+
+	term := func(language string, value string) map[string]string {
+		return map[string]string{
+			"language": language,
+			"value":    value,
+		}
+	}
+
+	data := make(map[string]interface{})
+	if len(terms.Labels) > 0 {
+		labels := make(map[string]interface{}, len(terms.Labels))
+		for language, value := range terms.Labels {
+			labels[language] = term(language, value)
+		}
+		data["labels"] = labels
+	}
+	if len(terms.Descriptions) > 0 {
+		descriptions := make(map[string]interface{}, len(terms.Descriptions))
+		for language, value := range terms.Descriptions {
+			descriptions[language] = term(language, value)
+		}
+		data["descriptions"] = descriptions
+	}
+	if len(terms.Aliases) > 0 {
+		aliases := make(map[string]interface{}, len(terms.Aliases))
+		for language, values := range terms.Aliases {
+			list := make([]interface{}, 0, len(values))
+			for _, value := range values {
+				list = append(list, term(language, value))
+			}
+			aliases[language] = list
+		}
+		data["aliases"] = aliases
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var response editEntityResponse
+	return c.PostWithToken(ctx, map[string]string{
+		"action": "wbeditentity",
+		"id":     id,
+		"data":   string(encoded),
+	}, &response)
+}
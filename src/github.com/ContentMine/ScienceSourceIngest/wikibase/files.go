@@ -0,0 +1,91 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Uploading files needs a multipart POST, which the wikibase library doesn't do, so we do it ourselves.
+
+type uploadResponse struct {
+	Upload struct {
+		Result   string                     `json:"result"`
+		Filename string                     `json:"filename"`
+		Warnings map[string]json.RawMessage `json:"warnings"`
+	} `json:"upload"`
+}
+
+// The server refuses uploads it has warnings about unless told to ignore them. Some warnings, such as
+// the file already existing, are interesting to the caller, so we hand them back rather than ignoring
+// them all.
+type UploadWarningError struct {
+	Filename string
+	Warnings map[string]json.RawMessage
+}
+
+func (e *UploadWarningError) Error() string {
+	names := make([]string, 0, len(e.Warnings))
+	for name := range e.Warnings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("Upload of %s has warnings: %s", e.Filename, strings.Join(names, ", "))
+}
+
+func (e *UploadWarningError) Has(warning string) bool {
+	_, ok := e.Warnings[warning]
+	return ok
+}
+
+// UploadFile uploads a file with the given name (without the "File:" prefix), using text as the
+// wikitext of the new file page.
+func (c *NetworkClient) UploadFile(ctx context.Context, filename string, data []byte, text string, comment string) error {
+
+	token, err := c.EditToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.PostFileContext(ctx, map[string]string{
+		"action":   "upload",
+		"filename": filename,
+		"text":     text,
+		"comment":  comment,
+		"token":    token,
+	}, "file", filename, data)
+	if err != nil {
+		return err
+	}
+
+	var response uploadResponse
+	err = decodeAPIResponse(body, &response)
+	if err != nil {
+		return err
+	}
+
+	switch response.Upload.Result {
+	case "Success":
+		return nil
+	case "Warning":
+		return &UploadWarningError{Filename: filename, Warnings: response.Upload.Warnings}
+	default:
+		return fmt.Errorf("Upload of %s failed with result %q", filename, response.Upload.Result)
+	}
+}
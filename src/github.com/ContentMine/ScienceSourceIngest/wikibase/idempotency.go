@@ -0,0 +1,156 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Every write we make is stamped in its edit summary with the ID of the run that made it, and writes
+// that create something can also carry an idempotency key naming the operation, such as the third
+// anchor point of an article. If a create times out we can't tell whether the server made the entity or
+// not, so rather than try again blind the caller can look through our recent creations for one stamped
+// with the same key, and adopt it rather than make a duplicate.
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a context whose writes are stamped with the key. Keys must not contain
+// spaces, semicolons, or square brackets.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+func idempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// SetRunID sets the run ID every write is stamped with, and should be called before any are made.
+func (c *NetworkClient) SetRunID(runID string) {
+	c.runID = runID
+}
+
+// Which argument holds the edit summary, for the writes that take one
+var summaryArguments = map[string]string{
+	"edit":               "summary",
+	"delete":             "reason",
+	"protect":            "reason",
+	"upload":             "comment",
+	"wbeditentity":       "summary",
+	"wbcreateclaim":      "summary",
+	"wbsetclaim":         "summary",
+	"wbsetclaimvalue":    "summary",
+	"wbremoveclaims":     "summary",
+	"wbsetqualifier":     "summary",
+	"wbremovequalifiers": "summary",
+	"wbsetreference":     "summary",
+	"wbsetlabel":         "summary",
+	"wbsetdescription":   "summary",
+	"wbsetaliases":       "summary",
+}
+
+var summaryKeyPattern = regexp.MustCompile(`\[(?:run [^\];]+; )?key ([^\];\s]+)\]`)
+
+// stampSummary adds the run ID and any idempotency key to the write's summary, leaving out the run ID
+// if the summary already mentions it.
+func (c *NetworkClient) stampSummary(ctx context.Context, values url.Values) {
+
+	argument, ok := summaryArguments[values.Get("action")]
+	if !ok {
+		return
+	}
+	summary := values.Get(argument)
+
+	parts := make([]string, 0, 2)
+	if len(c.runID) != 0 && !strings.Contains(summary, c.runID) {
+		parts = append(parts, "run "+c.runID)
+	}
+	if key := idempotencyKeyFrom(ctx); len(key) != 0 {
+		parts = append(parts, "key "+key)
+	}
+	if len(parts) == 0 {
+		return
+	}
+
+	stamp := fmt.Sprintf("[%s]", strings.Join(parts, "; "))
+	if len(summary) != 0 {
+		stamp = summary + " " + stamp
+	}
+	values.Set(argument, stamp)
+}
+
+// IdempotencyKeyFromSummary returns the key a summary was stamped with, or "" if it wasn't.
+func IdempotencyKeyFromSummary(summary string) string {
+	match := summaryKeyPattern.FindStringSubmatch(summary)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+type userContribsResponse struct {
+	Continue map[string]string `json:"continue"`
+	Query    struct {
+		UserContribs []struct {
+			Title   string `json:"title"`
+			Comment string `json:"comment"`
+		} `json:"usercontribs"`
+	} `json:"query"`
+}
+
+// CreatedEntitiesByKey returns the IDs of the entities the users have created since the given time,
+// keyed by the idempotency key their creation was stamped with. Entities created without a key are left
+// out, as are any that have since been deleted, as deleted pages drop out of a user's contributions.
+func (c *NetworkClient) CreatedEntitiesByKey(ctx context.Context, users []string, since time.Time) (map[string]string, error) {
+
+	res := make(map[string]string)
+	args := map[string]string{
+		"action":        "query",
+		"list":          "usercontribs",
+		"ucuser":        strings.Join(users, "|"),
+		"ucshow":        "new",
+		"ucprop":        "title|comment",
+		"ucend":         since.UTC().Format(time.RFC3339),
+		"uclimit":       "max",
+		"formatversion": "2",
+	}
+
+	for {
+		var response userContribsResponse
+		err := c.GetJSON(ctx, args, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, contribution := range response.Query.UserContribs {
+			id := EntityIDFromTitle(contribution.Title)
+			key := IdempotencyKeyFromSummary(contribution.Comment)
+			if len(id) != 0 && len(key) != 0 {
+				res[key] = id
+			}
+		}
+
+		if len(response.Continue) == 0 {
+			return res, nil
+		}
+		for key, value := range response.Continue {
+			args[key] = value
+		}
+	}
+}
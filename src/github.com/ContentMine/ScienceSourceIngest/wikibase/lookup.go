@@ -0,0 +1,445 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Read only queries for finding out what is on the server, so we can compare it with what we think
+// we've done.
+
+type pageInfoResponse struct {
+	Query struct {
+		Pages []struct {
+			PageID  int    `json:"pageid"`
+			Title   string `json:"title"`
+			Missing bool   `json:"missing"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+func (c *NetworkClient) pageID(ctx context.Context, args map[string]string) (int, error) {
+
+	args["action"] = "query"
+	args["formatversion"] = "2"
+
+	var response pageInfoResponse
+	err := c.GetJSON(ctx, args, &response)
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Query.Pages) == 0 || response.Query.Pages[0].Missing {
+		return 0, nil
+	}
+	return response.Query.Pages[0].PageID, nil
+}
+
+func (c *NetworkClient) PageExists(ctx context.Context, pageID int) (bool, error) {
+	id, err := c.pageID(ctx, map[string]string{"pageids": strconv.Itoa(pageID)})
+	return id != 0, err
+}
+
+// PageIDForTitle returns the ID of the page with the given title, or zero if there isn't one.
+func (c *NetworkClient) PageIDForTitle(ctx context.Context, title string) (int, error) {
+	return c.pageID(ctx, map[string]string{"titles": title})
+}
+
+type userInfoResponse struct {
+	Query struct {
+		UserInfo struct {
+			ID     int      `json:"id"`
+			Name   string   `json:"name"`
+			Rights []string `json:"rights"`
+		} `json:"userinfo"`
+	} `json:"query"`
+}
+
+// CurrentUser returns the name of the account our credentials are for.
+func (c *NetworkClient) CurrentUser(ctx context.Context) (string, error) {
+
+	var response userInfoResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action": "query",
+		"meta":   "userinfo",
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Query.UserInfo.ID == 0 {
+		return "", fmt.Errorf("Not logged in to the server")
+	}
+	return response.Query.UserInfo.Name, nil
+}
+
+type revisionsResponse struct {
+	Query struct {
+		Pages []struct {
+			Missing   bool `json:"missing"`
+			Revisions []struct {
+				User string `json:"user"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// PageCreator returns the name of the user who made the first revision of the page.
+func (c *NetworkClient) PageCreator(ctx context.Context, title string) (string, error) {
+
+	var response revisionsResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action":        "query",
+		"prop":          "revisions",
+		"titles":        title,
+		"rvprop":        "user",
+		"rvdir":         "newer",
+		"rvlimit":       "1",
+		"formatversion": "2",
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Query.Pages) == 0 || response.Query.Pages[0].Missing || len(response.Query.Pages[0].Revisions) == 0 {
+		return "", fmt.Errorf("Page %s does not exist", title)
+	}
+	return response.Query.Pages[0].Revisions[0].User, nil
+}
+
+type backlinksResponse struct {
+	Continue map[string]string `json:"continue"`
+	Query    struct {
+		Backlinks []struct {
+			Title string `json:"title"`
+		} `json:"backlinks"`
+	} `json:"query"`
+}
+
+// LinksTo returns the titles of all pages that link to the given page. Wikibase records a link for
+// every entity a statement refers to, so for an item page this finds every entity that refers to it.
+func (c *NetworkClient) LinksTo(ctx context.Context, title string) ([]string, error) {
+
+	res := make([]string, 0)
+	args := map[string]string{
+		"action":        "query",
+		"list":          "backlinks",
+		"bltitle":       title,
+		"bllimit":       "max",
+		"formatversion": "2",
+	}
+
+	for {
+		var response backlinksResponse
+		err := c.GetJSON(ctx, args, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range response.Query.Backlinks {
+			res = append(res, link.Title)
+		}
+
+		if len(response.Continue) == 0 {
+			return res, nil
+		}
+		for key, value := range response.Continue {
+			args[key] = value
+		}
+	}
+}
+
+type searchResponse struct {
+	Continue map[string]string `json:"continue"`
+	Query    struct {
+		Search []struct {
+			Title string `json:"title"`
+		} `json:"search"`
+	} `json:"query"`
+}
+
+// SearchPages returns the titles of pages that match a search query, in all namespaces.
+func (c *NetworkClient) SearchPages(ctx context.Context, query string) ([]string, error) {
+
+	res := make([]string, 0)
+	args := map[string]string{
+		"action":        "query",
+		"list":          "search",
+		"srsearch":      query,
+		"srnamespace":   "*",
+		"srlimit":       "max",
+		"srinfo":        "",
+		"srprop":        "",
+		"formatversion": "2",
+	}
+
+	for {
+		var response searchResponse
+		err := c.GetJSON(ctx, args, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range response.Query.Search {
+			res = append(res, result.Title)
+		}
+
+		if len(response.Continue) == 0 {
+			return res, nil
+		}
+		for key, value := range response.Continue {
+			args[key] = value
+		}
+	}
+}
+
+// FindEntitiesWithStatement returns the IDs of entities that have a statement with the given property
+// and value. This uses the haswbstatement search keyword, so needs the server to have the
+// WikibaseCirrusSearch extension, and relies on the search index being up to date.
+func (c *NetworkClient) FindEntitiesWithStatement(ctx context.Context, propertyID string, value string) ([]string, error) {
+
+	titles, err := c.SearchPages(ctx, fmt.Sprintf("haswbstatement:%s=%s", propertyID, value))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if id := EntityIDFromTitle(title); len(id) != 0 {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+type allPagesResponse struct {
+	Continue map[string]string `json:"continue"`
+	Query    struct {
+		AllPages []struct {
+			Title string `json:"title"`
+		} `json:"allpages"`
+	} `json:"query"`
+}
+
+// PagesWithPrefix returns the titles of all main namespace pages whose title starts with the prefix.
+func (c *NetworkClient) PagesWithPrefix(ctx context.Context, prefix string) ([]string, error) {
+
+	res := make([]string, 0)
+	args := map[string]string{
+		"action":        "query",
+		"list":          "allpages",
+		"apprefix":      prefix,
+		"apnamespace":   "0",
+		"aplimit":       "max",
+		"formatversion": "2",
+	}
+
+	for {
+		var response allPagesResponse
+		err := c.GetJSON(ctx, args, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range response.Query.AllPages {
+			res = append(res, page.Title)
+		}
+
+		if len(response.Continue) == 0 {
+			return res, nil
+		}
+		for key, value := range response.Continue {
+			args[key] = value
+		}
+	}
+}
+
+var entityTitlePattern = regexp.MustCompile(`([PQ][0-9]+)$`)
+
+// EntityIDFromTitle gets the ID from an entity page title such as Item:Q123, or "" if it isn't one.
+func EntityIDFromTitle(title string) string {
+	return entityTitlePattern.FindString(title)
+}
+
+// Just enough of the Wikibase entity JSON for us to check statements against what we uploaded.
+// See https://www.mediawiki.org/wiki/Wikibase/DataModel/JSON
+
+type Snak struct {
+	SnakType  string `json:"snaktype"`
+	Property  string `json:"property"`
+	DataValue *struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"datavalue,omitempty"`
+}
+
+type Statement struct {
+	ID       string `json:"id"`
+	MainSnak Snak   `json:"mainsnak"`
+}
+
+type Entity struct {
+	ID        string                 `json:"id"`
+	Title     string                 `json:"title"`
+	Missing   *string                `json:"missing"` // present, but empty, if the entity doesn't exist
+	LastRevID int                    `json:"lastrevid"`
+	Claims    map[string][]Statement `json:"claims"`
+}
+
+func (e Entity) Exists() bool {
+	return e.Missing == nil
+}
+
+// ItemID returns the ID of the item the snak refers to, if it is an item value.
+func (s Snak) ItemID() string {
+	if s.DataValue == nil || s.DataValue.Type != "wikibase-entityid" {
+		return ""
+	}
+	var value struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(s.DataValue.Value, &value) != nil {
+		return ""
+	}
+	return value.ID
+}
+
+// Quantity returns the amount of a quantity value, if the snak has one.
+func (s Snak) Quantity() (float64, bool) {
+	if s.DataValue == nil || s.DataValue.Type != "quantity" {
+		return 0, false
+	}
+	var value struct {
+		Amount string `json:"amount"`
+	}
+	if json.Unmarshal(s.DataValue.Value, &value) != nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.TrimPrefix(value.Amount, "+"), 64)
+	if err != nil {
+		return 0, false
+	}
+	return amount, true
+}
+
+// StringValue returns the text of a string or external ID value, if the snak has one.
+func (s Snak) StringValue() (string, bool) {
+	if s.DataValue == nil || s.DataValue.Type != "string" {
+		return "", false
+	}
+	var value string
+	if json.Unmarshal(s.DataValue.Value, &value) != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// TimeValue returns the time of a time value as Wikibase writes it, e.g. +2018-06-01T00:00:00Z, if the
+// snak has one.
+func (s Snak) TimeValue() (string, bool) {
+	if s.DataValue == nil || s.DataValue.Type != "time" {
+		return "", false
+	}
+	var value struct {
+		Time string `json:"time"`
+	}
+	if json.Unmarshal(s.DataValue.Value, &value) != nil {
+		return "", false
+	}
+	return value.Time, true
+}
+
+// ItemClaims returns the IDs of all items the entity refers to with the given property.
+func (e Entity) ItemClaims(propertyID string) []string {
+	res := make([]string, 0)
+	for _, statement := range e.Claims[propertyID] {
+		if id := statement.MainSnak.ItemID(); len(id) != 0 {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+type getEntitiesResponse struct {
+	Entities map[string]Entity `json:"entities"`
+}
+
+// GetEntities fetches entities with their statements, keyed by ID.
+func (c *NetworkClient) GetEntities(ctx context.Context, ids []string) (map[string]Entity, error) {
+
+	res := make(map[string]Entity, len(ids))
+
+	for start := 0; start < len(ids); start += apiBatchSize {
+		end := start + apiBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var response getEntitiesResponse
+		err := c.GetJSON(ctx, map[string]string{
+			"action": "wbgetentities",
+			"ids":    strings.Join(ids[start:end], "|"),
+			"props":  "info|claims",
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, entity := range response.Entities {
+			res[id] = entity
+		}
+	}
+
+	return res, nil
+}
+
+type entityDataTypesResponse struct {
+	Entities map[string]struct {
+		Missing  *string `json:"missing"`
+		DataType string  `json:"datatype"`
+	} `json:"entities"`
+}
+
+// EntityDataTypes looks up which of the entities exist, keyed by ID, with the data type of those that are
+// properties. Items have an empty data type, and entities that don't exist are left out.
+func (c *NetworkClient) EntityDataTypes(ctx context.Context, ids []string) (map[string]string, error) {
+
+	res := make(map[string]string, len(ids))
+
+	for start := 0; start < len(ids); start += apiBatchSize {
+		end := start + apiBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var response entityDataTypesResponse
+		err := c.GetJSON(ctx, map[string]string{
+			"action": "wbgetentities",
+			"ids":    strings.Join(ids[start:end], "|"),
+			"props":  "datatype",
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, entity := range response.Entities {
+			if entity.Missing == nil {
+				res[id] = entity.DataType
+			}
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,559 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/ratelimit"
+	"github.com/ContentMine/ScienceSourceIngest/tracing"
+)
+
+// A batch run makes thousands of small API calls to the wikibase server, so rather than rely on the
+// default transport (which only keeps a couple of idle connections per host around) we use our own
+// that keeps a pool of connections open and will negotiate HTTP/2 where the server offers it.
+const maxIdleConnectionsPerHost int = 16
+const idleConnectionTimeout time.Duration = 90 * time.Second
+
+// Request bodies smaller than this aren't worth the CPU time to compress, this is mostly aimed at
+// article HTML uploads, which can be several megabytes for long papers.
+const compressionThreshold int = 16 * 1024
+
+type NetworkOptions struct {
+	// Not all servers are configured to accept compressed request bodies, so this is opt in. Responses
+	// are always requested gzip compressed, and are transparently decompressed by the transport.
+	CompressRequests bool
+
+	// If set to "user" or "bot" then every write request asks the server to check we're still logged
+	// in as such, so a session that has silently lost its authentication fails loudly rather than
+	// making edits as an anonymous IP.
+	Assert string
+
+	// How long to allow for each kind of request, including reading the response, where zero means no
+	// limit. Page uploads can have bodies of several megabytes so need much longer than a lookup, and
+	// entity writes can be slow on a busy server as they wait on locks.
+	LookupTimeout time.Duration
+	UploadTimeout time.Duration
+	WriteTimeout  time.Duration
+
+	// After this many writes in a row fail, stop writing for FailurePause, or for good if that is zero.
+	// Zero disables this.
+	FailureThreshold int
+	FailurePause     time.Duration
+
+	// Entity fetches and searches are cached for the life of the client, holding up to this many bytes
+	// of responses. Zero disables the cache.
+	ReadCacheBytes int
+
+	// With more than one account, limit each to this many writes a minute, waiting for one to be free
+	// if they're all at their limit. Zero means no limit.
+	AccountWriteRate int
+
+	// The languages to look labels up in, in order of preference, with new entities labelled in the
+	// first. Defaults to English.
+	LabelLanguages []string
+
+	// If set, every write is appended to this file, see WriteRecord.
+	WriteLogPath string
+}
+
+// Sensible defaults for the timeouts, for those not wanting to choose their own
+const DefaultLookupTimeout time.Duration = 30 * time.Second
+const DefaultUploadTimeout time.Duration = 5 * time.Minute
+const DefaultWriteTimeout time.Duration = 60 * time.Second
+
+// NetworkClient implements the wikibase.NetworkClientInterface, signing every request with the
+// OAuth credentials for the target server.
+type NetworkClient struct {
+	URLBase string
+
+	accounts *accountPool
+	assert   string
+	options  NetworkOptions
+	breaker  *circuitBreaker
+
+	cache     *readCache
+	revisions *revisionTracker
+
+	observer RequestObserver
+	activity apiActivity
+
+	// Stamped on the summary of every write, see SetRunID
+	runID string
+
+	writes *writeLog
+}
+
+// A RequestObserver is told about every API request once the server has responded, or the request has
+// failed, for measuring how the server is performing.
+type RequestObserver func(action string, elapsed time.Duration, err error)
+
+// SetRequestObserver sets the observer for all requests, and should be called before any are made.
+func (c *NetworkClient) SetRequestObserver(observer RequestObserver) {
+	c.observer = observer
+}
+
+// drainingReadCloser makes sure that any unread part of a response body is consumed before
+// the body is closed, otherwise the underlying connection can't be returned to the pool.
+type drainingReadCloser struct {
+	io.ReadCloser
+}
+
+func (d drainingReadCloser) Close() error {
+	io.Copy(ioutil.Discard, d.ReadCloser)
+	return d.ReadCloser.Close()
+}
+
+// cancellingReadCloser releases a request's timeout once the caller is done with the body, as the
+// timeout has to keep running whilst the body is read.
+type cancellingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancellingReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// compressingTransport gzips form bodies on their way out. It sits underneath the OAuth round
+// tripper, so the request is signed against the uncompressed parameters as the server expects.
+type compressingTransport struct {
+	http.RoundTripper
+}
+
+func (t compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.Body == nil || len(req.Header.Get("Content-Encoding")) != 0 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < compressionThreshold {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err = w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't modify the caller's request, as per the RoundTripper contract
+	out := new(http.Request)
+	*out = *req
+	out.Header = req.Header.Clone()
+	out.Header.Set("Content-Encoding", "gzip")
+	out.ContentLength = int64(compressed.Len())
+	out.Body = ioutil.NopCloser(bytes.NewReader(compressed.Bytes()))
+	out.GetBody = nil
+
+	return t.RoundTripper.RoundTrip(out)
+}
+
+func newPooledTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdleConnectionsPerHost,
+		IdleConnTimeout:       idleConnectionTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func NewNetworkClient(credentials *Credentials, urlbase string, options NetworkOptions) (*NetworkClient, error) {
+	return NewPooledNetworkClient([]*Credentials{credentials}, urlbase, options)
+}
+
+// NewPooledNetworkClient makes a client that spreads its writes over several accounts, for campaigns
+// big enough to run into the server's per account rate limits. Reads all use the first account.
+func NewPooledNetworkClient(credentials []*Credentials, urlbase string, options NetworkOptions) (*NetworkClient, error) {
+
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("No credentials given for the server")
+	}
+
+	var transport http.RoundTripper = newPooledTransport()
+	if options.CompressRequests {
+		transport = compressingTransport{transport}
+	}
+
+	accounts := make([]*account, 0, len(credentials))
+	for _, account_credentials := range credentials {
+		account, err := newAccount(account_credentials, urlbase, transport)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	res := &NetworkClient{
+		URLBase:  urlbase,
+		accounts: newAccountPool(accounts, options.AccountWriteRate),
+		assert:   options.Assert,
+		options:  options,
+		breaker:  newCircuitBreaker(options.FailureThreshold, options.FailurePause),
+		cache:    newReadCache(options.ReadCacheBytes),
+
+		revisions: newRevisionTracker(),
+	}
+
+	if len(options.WriteLogPath) != 0 {
+		res.writes = newWriteLog(options.WriteLogPath)
+	}
+
+	return res, nil
+}
+
+// Helpers
+
+func (c *NetworkClient) apiURL() string {
+	return fmt.Sprintf("%s/w/api.php", c.URLBase)
+}
+
+// Some lookups are made as POSTs as their arguments can be large
+func isLookupAction(action string) bool {
+	switch action {
+	case "query", "parse", "wbgetentities", "wbsearchentities":
+		return true
+	}
+	return false
+}
+
+// timeoutFor works out which kind of request this is from its arguments, as requests from the wikibase
+// library come through the same Get and Post as ours.
+func (c *NetworkClient) timeoutFor(method string, values url.Values) time.Duration {
+	if method == "GET" || isLookupAction(values.Get("action")) {
+		return c.options.LookupTimeout
+	}
+	switch values.Get("action") {
+	case "edit":
+		if len(values.Get("text")) != 0 {
+			return c.options.UploadTimeout
+		}
+	case "upload":
+		return c.options.UploadTimeout
+	}
+	return c.options.WriteTimeout
+}
+
+// do makes the request as the account with the appropriate timeout applied
+func (c *NetworkClient) do(ctx context.Context, account *account, req *http.Request, values url.Values) (io.ReadCloser, error) {
+
+	// Wait our turn before starting the clock on the request's timeout
+	err := ratelimit.Wait(ctx, ratelimit.Wiki)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.timeoutFor(req.Method, values)
+	cancel := context.CancelFunc(func() {})
+	if timeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, span := tracing.StartKind(ctx, tracing.SpanKindClient, "api "+values.Get("action"),
+		tracing.String("http.request.method", req.Method),
+		tracing.String("api.action", values.Get("action")))
+	if traceparent := span.TraceParent(); len(traceparent) != 0 {
+		req.Header.Set("traceparent", traceparent)
+	}
+
+	start := time.Now()
+	resp, err := account.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if timeout != 0 && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("API %s %s timed out after %v", req.Method, values.Get("action"), timeout)
+		}
+		span.Finish(err)
+		c.activity.record(ctx, err)
+		if c.observer != nil {
+			c.observer(values.Get("action"), time.Since(start), err)
+		}
+		return nil, err
+	}
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+
+	body, err := checkResponse(resp, req.Method, values, start)
+	span.Finish(err)
+	c.activity.record(ctx, err)
+	if c.observer != nil {
+		c.observer(values.Get("action"), time.Since(start), err)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return cancellingReadCloser{body, cancel}, nil
+}
+
+func encodeArguments(args map[string]string) url.Values {
+	values := url.Values{}
+	for key, value := range args {
+		values.Set(key, value)
+	}
+	if len(values.Get("format")) == 0 {
+		values.Set("format", "json")
+	}
+	return values
+}
+
+func checkResponse(resp *http.Response, method string, values url.Values, start time.Time) (io.ReadCloser, error) {
+
+	logging.Logf(logging.LogVerbose, "API %s %s: %s (%v)", method, values.Get("action"), resp.Status, time.Since(start))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		drainingReadCloser{resp.Body}.Close()
+		return nil, fmt.Errorf("Unexpected status from server: %s", resp.Status)
+	}
+
+	if logging.Enabled(logging.LogDebug) {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		logging.Logf(logging.LogDebug, "API request: %s", redactArguments(values))
+		logging.Logf(logging.LogDebug, "API response: %s", body)
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return drainingReadCloser{resp.Body}, nil
+}
+
+// redactArguments stops credentials ending up in log files when API payloads are logged.
+func redactArguments(values url.Values) string {
+	redacted := url.Values{}
+	for key, value := range values {
+		if key == "token" {
+			redacted.Set(key, "<redacted>")
+		} else {
+			redacted[key] = value
+		}
+	}
+	return redacted.Encode()
+}
+
+// GetContext and PostContext make requests that are abandoned if the context is cancelled or its
+// deadline passes.
+
+func (c *NetworkClient) GetContext(ctx context.Context, args map[string]string) (io.ReadCloser, error) {
+
+	values := encodeArguments(args)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?%s", c.apiURL(), values.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.read(ctx, req, values)
+}
+
+func (c *NetworkClient) PostContext(ctx context.Context, args map[string]string) (io.ReadCloser, error) {
+
+	values := encodeArguments(args)
+	if len(c.assert) != 0 && len(values.Get("assert")) == 0 {
+		values.Set("assert", c.assert)
+	}
+
+	build := func(values url.Values) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL(), strings.NewReader(values.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	if isLookupAction(values.Get("action")) {
+		req, err := build(values)
+		if err != nil {
+			return nil, err
+		}
+		return c.read(ctx, req, values)
+	}
+
+	return c.write(ctx, values, build)
+}
+
+// PostFileContext makes a multipart POST with the arguments and a file, as needed for uploads.
+func (c *NetworkClient) PostFileContext(ctx context.Context, args map[string]string, field string, filename string, data []byte) (io.ReadCloser, error) {
+
+	values := encodeArguments(args)
+	if len(c.assert) != 0 && len(values.Get("assert")) == 0 {
+		values.Set("assert", c.assert)
+	}
+
+	return c.write(ctx, values, func(values url.Values) (*http.Request, error) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for key := range values {
+			err := writer.WriteField(key, values.Get(key))
+			if err != nil {
+				return nil, err
+			}
+		}
+		part, err := writer.CreateFormFile(field, filename)
+		if err != nil {
+			return nil, err
+		}
+		_, err = part.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL(), &body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
+}
+
+// read makes a lookup, using the read cache where it applies
+func (c *NetworkClient) read(ctx context.Context, req *http.Request, values url.Values) (io.ReadCloser, error) {
+
+	if c.cache == nil || !isCachedAction(values.Get("action")) {
+		return c.do(ctx, c.accounts.primary(), req, values)
+	}
+
+	data, generation, ok := c.cache.get(values)
+	if ok {
+		logging.Logf(logging.LogVerbose, "API %s %s: cached", req.Method, values.Get("action"))
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	body, err := c.do(ctx, c.accounts.primary(), req, values)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(values, data, generation)
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// A requestBuilder makes the request for a write from its arguments, which may be changed before each
+// attempt, such as to use the token for a different account.
+type requestBuilder func(values url.Values) (*http.Request, error)
+
+// write makes a request that changes things on the server, keeping the circuit breaker informed
+func (c *NetworkClient) write(ctx context.Context, values url.Values, build requestBuilder) (io.ReadCloser, error) {
+
+	err := c.breaker.check()
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := c.accounts.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Tokens belong to an account, so whichever account the caller got theirs from, swap in one for the
+	// account we're using.
+	if len(values.Get("token")) != 0 && account != c.accounts.primary() {
+		token, err := c.accountToken(ctx, account, tokenTypeFor(values.Get("action")))
+		if err != nil {
+			return nil, err
+		}
+		values.Set("token", token)
+	}
+	c.stampSummary(ctx, values)
+	req, err := build(values)
+	if err != nil {
+		return nil, err
+	}
+
+	// Even a failed write may have changed something, so forget anything it could have touched
+	defer c.cache.invalidate(values)
+
+	body, err := c.do(ctx, account, req, values)
+	if err != nil {
+		// If our caller gave up that's not the server's fault
+		if ctx.Err() == nil {
+			c.breaker.record(true)
+		}
+		c.writes.record(c.runID, account, values, nil, err)
+		return nil, err
+	}
+
+	// Write responses are small, so we can read them here to see if the server is in trouble
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		c.breaker.record(true)
+		c.writes.record(c.runID, account, values, nil, err)
+		return nil, err
+	}
+	c.writes.record(c.runID, account, values, data, nil)
+	c.breaker.record(isServerTrouble(data))
+	c.accounts.record(account, data)
+	c.revisions.record(values, data)
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// wikibase.NetworkClientInterface
+//
+// The wikibase library doesn't know about contexts, so callers going through it should check their
+// context between calls.
+
+func (c *NetworkClient) Get(args map[string]string) (io.ReadCloser, error) {
+	return c.GetContext(context.Background(), args)
+}
+
+func (c *NetworkClient) Post(args map[string]string) (io.ReadCloser, error) {
+	return c.PostContext(context.Background(), args)
+}
@@ -0,0 +1,100 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Page maintenance actions, such as those done on article pages once they've been uploaded.
+
+// RefreshPage purges the page and then does a null edit, which between them cause the server to
+// rebuild its caches, search index entry, and links tables for the page. Without this the new
+// article can take a while to show up in search and usage tracking on the instance.
+func (c *NetworkClient) RefreshPage(ctx context.Context, pageID int) error {
+
+	err := c.PostJSON(ctx, map[string]string{
+		"action":          "purge",
+		"pageids":         strconv.Itoa(pageID),
+		"forcelinkupdate": "1",
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	// A null edit is an edit that changes nothing, and so doesn't create a new revision
+	return c.PostWithToken(ctx, map[string]string{
+		"action":     "edit",
+		"pageid":     strconv.Itoa(pageID),
+		"appendtext": "",
+		"nocreate":   "1",
+	}, nil)
+}
+
+// The level is configurable, as not every account doing uploads will have the rights to protect at
+// sysop level, and setting it to "none" skips protection altogether.
+const ProtectionLevelNone string = "none"
+
+func (c *NetworkClient) ProtectPage(ctx context.Context, pageID int, level string, reason string) error {
+
+	if level == ProtectionLevelNone || len(level) == 0 {
+		return nil
+	}
+
+	err := c.PostWithToken(ctx, map[string]string{
+		"action":      "protect",
+		"pageid":      strconv.Itoa(pageID),
+		"protections": fmt.Sprintf("edit=%s|move=%s", level, level),
+		"expiry":      "infinite",
+		"reason":      reason,
+	}, nil)
+
+	// Lacking the rights to protect isn't a reason to stop the upload, but we should tell someone. A level
+	// the wiki doesn't have is a mistake in how we were run though, so that is still an error.
+	if apiErr, ok := err.(*APIError); ok {
+		switch apiErr.Code {
+		case "permissiondenied", "cantedit", "protect-cantedit":
+			log.Printf("Unable to protect page %d at level %s: %v", pageID, level, err)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Protecting a page adds a revision to its history, but the response doesn't say which
+	revision, err := c.CurrentPageRevision(ctx, pageID)
+	if err != nil {
+		return err
+	}
+	c.revisions.lock.Lock()
+	c.revisions.pages[pageID] = revision
+	c.revisions.lock.Unlock()
+
+	return nil
+}
+
+// DeletePage deletes a page, which for an entity page deletes the entity. This needs the account to
+// have delete rights on the server.
+func (c *NetworkClient) DeletePage(ctx context.Context, title string, reason string) error {
+	return c.PostWithToken(ctx, map[string]string{
+		"action": "delete",
+		"title":  title,
+		"reason": reason,
+	}, nil)
+}
@@ -0,0 +1,75 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"html"
+	"strings"
+)
+
+// Text added around an article on the server (e.g. from a header template) shifts where the article
+// body starts, so we need to be able to ask the server what some wikitext renders to.
+
+type parseResponse struct {
+	Parse struct {
+		Text string `json:"text"`
+	} `json:"parse"`
+}
+
+// stripMarkup returns just the text content of a fragment of HTML, which is what anchors count
+// characters against.
+func stripMarkup(fragment string) string {
+
+	var text strings.Builder
+	inTag := false
+	for _, r := range fragment {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case inTag == false:
+			text.WriteRune(r)
+		}
+	}
+
+	return html.UnescapeString(text.String())
+}
+
+// RenderedTextLength returns how many characters of visible text the given wikitext becomes once
+// rendered by the server.
+func (c *NetworkClient) RenderedTextLength(ctx context.Context, wikitext string) (int, error) {
+
+	if len(strings.TrimSpace(wikitext)) == 0 {
+		return 0, nil
+	}
+
+	var response parseResponse
+	err := c.PostJSON(ctx, map[string]string{
+		"action":             "parse",
+		"text":               wikitext,
+		"contentmodel":       "wikitext",
+		"prop":               "text",
+		"disablelimitreport": "1",
+		"disableeditsection": "1",
+		"formatversion":      "2",
+	}, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(strings.TrimSpace(stripMarkup(response.Parse.Text))), nil
+}
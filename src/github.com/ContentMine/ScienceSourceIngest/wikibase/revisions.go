@@ -0,0 +1,285 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Every write tells us the revision it made of the entity or page, which we remember so that callers
+// can record what they last saw of each, for spotting other people's edits, undoing ours, and saying
+// exactly which revision a statement was made in.
+
+type revisionTracker struct {
+	lock     sync.Mutex
+	entities map[string]int
+	pages    map[int]int
+}
+
+func newRevisionTracker() *revisionTracker {
+	return &revisionTracker{
+		entities: make(map[string]int),
+		pages:    make(map[int]int),
+	}
+}
+
+// Enough of the write responses to find the revision, which is in a different place for each action
+type revisionResponse struct {
+	PageInfo *struct {
+		LastRevID int `json:"lastrevid"`
+	} `json:"pageinfo"`
+	Entity *struct {
+		ID        string `json:"id"`
+		LastRevID int    `json:"lastrevid"`
+	} `json:"entity"`
+	Claim *struct {
+		ID string `json:"id"`
+	} `json:"claim"`
+	Edit *struct {
+		PageID   int `json:"pageid"`
+		NewRevID int `json:"newrevid"`
+	} `json:"edit"`
+}
+
+// entityForClaim gets the entity from a statement ID, which is the entity ID, a $, and a GUID
+func entityForClaim(claimID string) string {
+	if i := strings.Index(claimID, "$"); i > 0 {
+		return strings.ToUpper(claimID[:i])
+	}
+	return ""
+}
+
+func (t *revisionTracker) record(values url.Values, data []byte) {
+
+	var response revisionResponse
+	if json.Unmarshal(data, &response) != nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if response.Edit != nil {
+		// A null edit doesn't make a revision
+		if response.Edit.NewRevID != 0 {
+			t.pages[response.Edit.PageID] = response.Edit.NewRevID
+		}
+		return
+	}
+
+	revision := 0
+	if response.PageInfo != nil {
+		revision = response.PageInfo.LastRevID
+	}
+	id := values.Get("id")
+	if response.Entity != nil {
+		if len(response.Entity.ID) != 0 {
+			id = response.Entity.ID
+		}
+		if response.Entity.LastRevID != 0 {
+			revision = response.Entity.LastRevID
+		}
+	}
+	if len(id) == 0 {
+		id = values.Get("entity")
+	}
+	if len(id) == 0 && response.Claim != nil {
+		id = entityForClaim(response.Claim.ID)
+	}
+	if len(id) == 0 {
+		id = entityForClaim(strings.Split(values.Get("claim"), "|")[0])
+	}
+
+	if len(id) != 0 && revision != 0 {
+		t.entities[id] = revision
+	}
+}
+
+// EntityRevision returns the revision of the entity made by our latest write to it, or zero if we
+// haven't written to it.
+func (c *NetworkClient) EntityRevision(id string) int {
+	c.revisions.lock.Lock()
+	defer c.revisions.lock.Unlock()
+	return c.revisions.entities[id]
+}
+
+// PageRevision returns the revision of the page made by our latest edit of it, or zero if we haven't
+// edited it.
+func (c *NetworkClient) PageRevision(pageID int) int {
+	c.revisions.lock.Lock()
+	defer c.revisions.lock.Unlock()
+	return c.revisions.pages[pageID]
+}
+
+type pageRevisionResponse struct {
+	Query struct {
+		Pages []struct {
+			PageID    int `json:"pageid"`
+			Revisions []struct {
+				RevID int `json:"revid"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// CurrentPageRevision looks up the latest revision of a page, whoever made it, or zero if the page
+// doesn't exist.
+func (c *NetworkClient) CurrentPageRevision(ctx context.Context, pageID int) (int, error) {
+
+	var response pageRevisionResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action":        "query",
+		"formatversion": "2",
+		"pageids":       strconv.Itoa(pageID),
+		"prop":          "revisions",
+		"rvprop":        "ids",
+	}, &response)
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Query.Pages) == 0 || len(response.Query.Pages[0].Revisions) == 0 {
+		return 0, nil
+	}
+	return response.Query.Pages[0].Revisions[0].RevID, nil
+}
+
+type editorsResponse struct {
+	Query struct {
+		Pages []struct {
+			Revisions []struct {
+				RevID int    `json:"revid"`
+				User  string `json:"user"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// EditorsSince returns the users who have made revisions of the page after the given one, with each
+// listed once in the order of their latest edit.
+func (c *NetworkClient) EditorsSince(ctx context.Context, title string, revision int) ([]string, error) {
+
+	var response editorsResponse
+	err := c.GetJSON(ctx, map[string]string{
+		"action":        "query",
+		"formatversion": "2",
+		"titles":        title,
+		"prop":          "revisions",
+		"rvprop":        "ids|user",
+		"rvendid":       strconv.Itoa(revision),
+		"rvlimit":       "max",
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, page := range response.Query.Pages {
+		for _, rev := range page.Revisions {
+			if rev.RevID == revision || seen[rev.User] {
+				continue
+			}
+			seen[rev.User] = true
+			res = append(res, rev.User)
+		}
+	}
+	return res, nil
+}
+
+type revisionContentResponse struct {
+	Query struct {
+		Pages []struct {
+			Revisions []struct {
+				RevID    int `json:"revid"`
+				ParentID int `json:"parentid"`
+				Slots    struct {
+					Main struct {
+						Content string `json:"content"`
+					} `json:"main"`
+				} `json:"slots"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// revision looks up a revision by its ID, optionally with its content.
+func (c *NetworkClient) revision(ctx context.Context, revision int, content bool) (int, string, error) {
+
+	args := map[string]string{
+		"action":        "query",
+		"formatversion": "2",
+		"prop":          "revisions",
+		"revids":        strconv.Itoa(revision),
+		"rvprop":        "ids",
+	}
+	if content {
+		args["rvprop"] = "ids|content"
+		args["rvslots"] = "main"
+	}
+
+	var response revisionContentResponse
+	err := c.GetJSON(ctx, args, &response)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(response.Query.Pages) == 0 || len(response.Query.Pages[0].Revisions) == 0 {
+		return 0, "", fmt.Errorf("Revision %d does not exist", revision)
+	}
+	rev := response.Query.Pages[0].Revisions[0]
+	return rev.ParentID, rev.Slots.Main.Content, nil
+}
+
+// ParentRevision returns the revision before the given one of the same page, or zero if it was the first.
+func (c *NetworkClient) ParentRevision(ctx context.Context, revision int) (int, error) {
+	parent, _, err := c.revision(ctx, revision, false)
+	return parent, err
+}
+
+// RevertEntity puts an entity back as it was at an earlier revision, so long as its latest revision is
+// still base.
+func (c *NetworkClient) RevertEntity(ctx context.Context, id string, revision int, base int, summary string) error {
+
+	_, content, err := c.revision(ctx, revision, true)
+	if err != nil {
+		return err
+	}
+
+	return c.PostWithToken(ctx, map[string]string{
+		"action":    "wbeditentity",
+		"id":        id,
+		"clear":     "1",
+		"data":      content,
+		"baserevid": strconv.Itoa(base),
+		"summary":   summary,
+	}, nil)
+}
+
+// RevertPage undoes the revisions of a wikitext page after the given one, up to and including latest.
+func (c *NetworkClient) RevertPage(ctx context.Context, pageID int, revision int, latest int, summary string) error {
+	return c.PostWithToken(ctx, map[string]string{
+		"action":    "edit",
+		"pageid":    strconv.Itoa(pageID),
+		"undo":      strconv.Itoa(latest),
+		"undoafter": strconv.Itoa(revision),
+		"nocreate":  "1",
+		"summary":   summary,
+	}, nil)
+}
@@ -0,0 +1,106 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Annotations anchor to character offsets in the article, so if MediaWiki has truncated or mangled the
+// page text on the way in then every anchor after that point is wrong. Rather than find that out later
+// we can fetch the stored text back straight after upload and compare it with what we sent.
+
+type pageRevisionsResponse struct {
+	Query struct {
+		Pages []struct {
+			PageID    int  `json:"pageid"`
+			Missing   bool `json:"missing"`
+			Revisions []struct {
+				Slots struct {
+					Main struct {
+						Content string `json:"content"`
+					} `json:"main"`
+				} `json:"slots"`
+			} `json:"revisions"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// normalisePageText applies the same normalisation MediaWiki does to page text before saving it: line
+// endings are converted to unix style and trailing whitespace is removed.
+func normalisePageText(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	return strings.TrimRight(text, " \t\n")
+}
+
+// fetchPageText takes either a pageids or titles argument to identify the page
+func (c *NetworkClient) fetchPageText(ctx context.Context, args map[string]string) (string, error) {
+
+	args["action"] = "query"
+	args["prop"] = "revisions"
+	args["rvprop"] = "content"
+	args["rvslots"] = "main"
+	args["formatversion"] = "2"
+
+	var response pageRevisionsResponse
+	err := c.GetJSON(ctx, args, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if len(response.Query.Pages) != 1 || response.Query.Pages[0].Missing ||
+		len(response.Query.Pages[0].Revisions) == 0 {
+		return "", fmt.Errorf("No revision found for page %s%s", args["pageids"], args["titles"])
+	}
+
+	return response.Query.Pages[0].Revisions[0].Slots.Main.Content, nil
+}
+
+func (c *NetworkClient) FetchPageText(ctx context.Context, pageID int) (string, error) {
+	return c.fetchPageText(ctx, map[string]string{"pageids": strconv.Itoa(pageID)})
+}
+
+func (c *NetworkClient) FetchPageTextByTitle(ctx context.Context, title string) (string, error) {
+	return c.fetchPageText(ctx, map[string]string{"titles": title})
+}
+
+func (c *NetworkClient) VerifyPageContent(ctx context.Context, pageID int, expected string) error {
+
+	stored, err := c.FetchPageText(ctx, pageID)
+	if err != nil {
+		return err
+	}
+
+	expected = normalisePageText(expected)
+	stored = normalisePageText(stored)
+
+	if sha1.Sum([]byte(expected)) == sha1.Sum([]byte(stored)) {
+		return nil
+	}
+
+	// Work out where things went wrong to make the error useful
+	offset := 0
+	for offset < len(expected) && offset < len(stored) && expected[offset] == stored[offset] {
+		offset++
+	}
+
+	return fmt.Errorf("Page %d content does not match upload: sent %d bytes, server has %d bytes, first difference at byte %d",
+		pageID, len(expected), len(stored), offset)
+}
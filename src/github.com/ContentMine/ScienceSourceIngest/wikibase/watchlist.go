@@ -0,0 +1,130 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ingest operators may want to keep an eye on what reviewers do to the content after upload, so we can
+// add pages and entities to (or take them off) the account's watchlist.
+
+type WatchlistAction string
+
+const (
+	WatchlistNone   WatchlistAction = ""
+	WatchlistAdd    WatchlistAction = "add"
+	WatchlistRemove WatchlistAction = "remove"
+)
+
+// The API won't take more than this many titles or IDs in one request for a normal account
+const apiBatchSize int = 50
+
+type entityInfoResponse struct {
+	Entities map[string]struct {
+		Title string `json:"title"`
+	} `json:"entities"`
+}
+
+func ParseWatchlistAction(value string) (WatchlistAction, error) {
+	switch value {
+	case "":
+		return WatchlistNone, nil
+	case "add":
+		return WatchlistAdd, nil
+	case "remove":
+		return WatchlistRemove, nil
+	}
+	return WatchlistNone, fmt.Errorf("Unknown watchlist action %s, expected add or remove", value)
+}
+
+// EntityPageTitles maps entity IDs to the title of the page they live on, as which namespace items
+// are in depends on the configuration of the server.
+func (c *NetworkClient) EntityPageTitles(ctx context.Context, ids []string) ([]string, error) {
+
+	res := make([]string, 0, len(ids))
+
+	for start := 0; start < len(ids); start += apiBatchSize {
+		end := start + apiBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var response entityInfoResponse
+		err := c.GetJSON(ctx, map[string]string{
+			"action": "wbgetentities",
+			"ids":    strings.Join(ids[start:end], "|"),
+			"props":  "info",
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids[start:end] {
+			if entity, ok := response.Entities[id]; ok && len(entity.Title) != 0 {
+				res = append(res, entity.Title)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (c *NetworkClient) watchPages(ctx context.Context, args map[string]string, action WatchlistAction) error {
+
+	token, err := c.Token(ctx, "watch")
+	if err != nil {
+		return err
+	}
+
+	args["action"] = "watch"
+	args["token"] = token
+	if action == WatchlistRemove {
+		args["unwatch"] = "1"
+	}
+
+	return c.PostJSON(ctx, args, nil)
+}
+
+func (c *NetworkClient) WatchPage(ctx context.Context, pageID int, action WatchlistAction) error {
+	if action == WatchlistNone {
+		return nil
+	}
+	return c.watchPages(ctx, map[string]string{"pageids": strconv.Itoa(pageID)}, action)
+}
+
+func (c *NetworkClient) WatchTitles(ctx context.Context, titles []string, action WatchlistAction) error {
+
+	if action == WatchlistNone {
+		return nil
+	}
+
+	for start := 0; start < len(titles); start += apiBatchSize {
+		end := start + apiBatchSize
+		if end > len(titles) {
+			end = len(titles)
+		}
+
+		err := c.watchPages(ctx, map[string]string{"titles": strings.Join(titles[start:end], "|")}, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wikibase time values are not just a timestamp: they carry a precision and calendar model, and the
+// timestamp itself has to be truncated to match the precision or the server will either reject it or
+// show a spurious time of day. This file handles getting from the various ways a time might be given
+// to us into a valid Wikibase time value.
+//
+// See https://www.mediawiki.org/wiki/Wikibase/DataModel/JSON#time
+
+type TimePrecision int
+
+const (
+	TimePrecisionYear  TimePrecision = 9
+	TimePrecisionMonth TimePrecision = 10
+	TimePrecisionDay   TimePrecision = 11
+)
+
+const GregorianCalendarModel string = "http://www.wikidata.org/entity/Q1985727"
+
+type WikibaseTime struct {
+	Time          string        `json:"time"`
+	Timezone      int           `json:"timezone"`
+	Before        int           `json:"before"`
+	After         int           `json:"after"`
+	Precision     TimePrecision `json:"precision"`
+	CalendarModel string        `json:"calendarmodel"`
+}
+
+// TruncateTime drops any part of the time that is finer than the precision.
+func TruncateTime(t time.Time, precision TimePrecision) time.Time {
+	t = t.UTC()
+	switch precision {
+	case TimePrecisionYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case TimePrecisionMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func NewWikibaseTime(t time.Time, precision TimePrecision) WikibaseTime {
+
+	t = TruncateTime(t, precision)
+
+	// Wikibase wants zeros rather than the first of the month/year for anything below the precision
+	month := int(t.Month())
+	day := t.Day()
+	if precision <= TimePrecisionMonth {
+		day = 0
+	}
+	if precision <= TimePrecisionYear {
+		month = 0
+	}
+
+	return WikibaseTime{
+		Time:          fmt.Sprintf("%+05d-%02d-%02dT00:00:00Z", t.Year(), month, day),
+		Precision:     precision,
+		CalendarModel: GregorianCalendarModel,
+	}
+}
+
+func (w WikibaseTime) Validate() error {
+	if w.Precision != TimePrecisionYear && w.Precision != TimePrecisionMonth && w.Precision != TimePrecisionDay {
+		return fmt.Errorf("Unsupported time precision %d", w.Precision)
+	}
+	if w.CalendarModel != GregorianCalendarModel {
+		return fmt.Errorf("Unexpected calendar model %s", w.CalendarModel)
+	}
+	if strings.HasPrefix(w.Time, "+") == false || strings.HasSuffix(w.Time, "T00:00:00Z") == false {
+		return fmt.Errorf("Time %s is not in Wikibase format", w.Time)
+	}
+	return nil
+}
+
+// ParseTimeInput accepts the forms a time is likely to be given to us in: RFC3339, a date, a year and
+// month, a year, or seconds since the Unix epoch. The precision returned reflects how much was given.
+func ParseTimeInput(value string) (time.Time, TimePrecision, error) {
+
+	value = strings.TrimSpace(value)
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), TimePrecisionDay, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, TimePrecisionDay, nil
+	}
+	if t, err := time.Parse("2006-01", value); err == nil {
+		return t, TimePrecisionMonth, nil
+	}
+	if len(value) == 4 {
+		if t, err := time.Parse("2006", value); err == nil {
+			return t, TimePrecisionYear, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), TimePrecisionDay, nil
+	}
+
+	return time.Time{}, 0, fmt.Errorf("Unrecognised time %q: expected RFC3339, YYYY-MM-DD, YYYY-MM, YYYY, or a Unix timestamp", value)
+}
+
+// ValidateTimeCode checks a time is sensible to upload as a time code, which is always day precision.
+func ValidateTimeCode(t time.Time) error {
+	if t.IsZero() {
+		return fmt.Errorf("Time code is not set")
+	}
+	if TruncateTime(t, TimePrecisionDay).Equal(t) == false {
+		return fmt.Errorf("Time code %v has a time of day, but is uploaded at day precision", t)
+	}
+	if t.After(time.Now().Add(24 * time.Hour)) {
+		return fmt.Errorf("Time code %v is in the future", t)
+	}
+	return NewWikibaseTime(t, TimePrecisionDay).Validate()
+}
@@ -0,0 +1,33 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package wikibase is the low level client for talking to a MediaWiki/Wikibase server. It wraps the
+// ContentMine wikibase library, which handles property and item mapping, item creation, and articles,
+// and adds the direct API calls that library doesn't cover, such as claims, page maintenance, and tokens.
+//
+// The library types we use are re-exported here so that callers only need to import this package.
+package wikibase
+
+import (
+	wbapi "github.com/ContentMine/wikibase"
+)
+
+type Client = wbapi.Client
+type OAuthInformation = wbapi.OAuthInformation
+type ItemHeader = wbapi.ItemHeader
+type ItemPropertyType = wbapi.ItemPropertyType
+type APIError = wbapi.APIError
+
+var NewClient = wbapi.NewClient
+var LoadOauthInformation = wbapi.LoadOauthInformation
@@ -0,0 +1,259 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package wikibase
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Every write we make can be appended to a local log file, one line of JSON per write, so that whatever
+// happens to the state saved alongside each paper there is a complete record of what we did to the
+// server, and by which run. The file is only ever appended to, and can be shared by several runs. A run
+// that crashes part way through appending can leave the last line cut short, so the next run to open the
+// log starts a fresh line after it, and truncated lines are skipped when the log is read back.
+
+// WriteRecord describes one write to the server, and what the server said it made.
+type WriteRecord struct {
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id,omitempty"`
+	Account string    `json:"account"`
+	Action  string    `json:"action"`
+
+	// What the write was aimed at: an entity or claim ID, a page title or ID, or "new item" and the like
+	Target string `json:"target,omitempty"`
+
+	// A hash of the arguments, less the edit token, so identical writes can be spotted
+	PayloadHash string `json:"payload_sha256"`
+
+	// From the response
+	Created  bool   `json:"created,omitempty"`
+	Entity   string `json:"entity,omitempty"`
+	Claim    string `json:"claim,omitempty"`
+	PageID   int    `json:"page_id,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+
+	// Set if the write failed, in which case the response fields may well be empty
+	Error string `json:"error,omitempty"`
+}
+
+// Enough of the responses to every kind of write to say what was written
+type writeLogResponse struct {
+	PageInfo *struct {
+		LastRevID int `json:"lastrevid"`
+	} `json:"pageinfo"`
+	Entity *struct {
+		ID        string `json:"id"`
+		LastRevID int    `json:"lastrevid"`
+	} `json:"entity"`
+	Claim *struct {
+		ID string `json:"id"`
+	} `json:"claim"`
+	Claims []string `json:"claims"`
+	Edit   *struct {
+		New      interface{} `json:"new"`
+		PageID   int         `json:"pageid"`
+		Title    string      `json:"title"`
+		NewRevID int         `json:"newrevid"`
+	} `json:"edit"`
+	Error *struct {
+		Code string `json:"code"`
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// The file is opened on the first write, so clients that only look things up don't create it
+type writeLog struct {
+	path string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+func newWriteLog(path string) *writeLog {
+	return &writeLog{path: path}
+}
+
+// writeTarget works out what a write was aimed at from its arguments
+func writeTarget(values url.Values) string {
+	if entityType := values.Get("new"); len(entityType) != 0 {
+		return "new " + entityType
+	}
+	for _, key := range []string{"id", "entity", "claim", "title", "pageid", "filename"} {
+		if value := values.Get(key); len(value) != 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+func payloadHash(values url.Values) string {
+	payload := url.Values{}
+	for key, value := range values {
+		if key != "token" {
+			payload[key] = value
+		}
+	}
+	// Encode sorts by key, so the same arguments always hash the same
+	sum := sha256.Sum256([]byte(payload.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+// record appends a line for the write, given either the response or the error that stopped us getting
+// one. A log we can't write to is warned about rather than failing the write, as that has been made.
+func (l *writeLog) record(runID string, account *account, values url.Values, data []byte, failure error) {
+
+	if l == nil {
+		return
+	}
+
+	record := WriteRecord{
+		Time:        time.Now().UTC(),
+		RunID:       runID,
+		Account:     account.name,
+		Action:      values.Get("action"),
+		Target:      writeTarget(values),
+		PayloadHash: payloadHash(values),
+		Created:     len(values.Get("new")) != 0,
+	}
+	if failure != nil {
+		record.Error = failure.Error()
+	}
+
+	var response writeLogResponse
+	if data != nil && json.Unmarshal(data, &response) == nil {
+		if response.Error != nil {
+			record.Error = response.Error.Code + ": " + response.Error.Info
+			record.Created = false
+		}
+		if response.PageInfo != nil {
+			record.Revision = response.PageInfo.LastRevID
+		}
+		if response.Entity != nil {
+			record.Entity = response.Entity.ID
+			if response.Entity.LastRevID != 0 {
+				record.Revision = response.Entity.LastRevID
+			}
+		}
+		if response.Claim != nil {
+			record.Claim = response.Claim.ID
+			record.Entity = entityForClaim(response.Claim.ID)
+		}
+		if len(response.Claims) != 0 {
+			record.Claim = response.Claims[0]
+			record.Entity = entityForClaim(response.Claims[0])
+		}
+		if response.Edit != nil {
+			record.Created = response.Edit.New != nil && response.Edit.New != false
+			record.PageID = response.Edit.PageID
+			record.Title = response.Edit.Title
+			record.Revision = response.Edit.NewRevID
+		}
+	}
+	if len(record.Title) == 0 {
+		record.Title = values.Get("title")
+	}
+	if len(record.Entity) == 0 {
+		if id := values.Get("id"); len(id) != 0 {
+			record.Entity = id
+		} else if claim := values.Get("claim"); len(claim) != 0 {
+			record.Claim = claim
+			record.Entity = entityForClaim(claim)
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to record write in the write log: %v", err)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		l.file, err = os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Printf("Failed to open the write log: %v", err)
+			return
+		}
+		if endsMidLine(l.file) {
+			l.file.Write([]byte{'\n'})
+		}
+	}
+
+	// One write call per line, so lines from several processes appending to the file don't interleave
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("Failed to record write in the write log: %v", err)
+	}
+}
+
+// endsMidLine says if the file doesn't end with a newline, as one cut short by a crash won't
+func endsMidLine(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	return err == nil && last[0] != '\n'
+}
+
+// LoadWriteLog reads back all the records in a write log, oldest first. Lines cut short by a crash while
+// they were being appended are skipped, but any other line that can't be read is an error.
+func LoadWriteLog(path string) ([]WriteRecord, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := make([]WriteRecord, 0)
+	reader := bufio.NewReader(f)
+	for line_number := 1; ; line_number++ {
+		line, read_err := reader.ReadBytes('\n')
+		if read_err != nil && read_err != io.EOF {
+			return nil, read_err
+		}
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			var record WriteRecord
+			err := json.NewDecoder(bytes.NewReader(line)).Decode(&record)
+			switch {
+			case err == io.ErrUnexpectedEOF:
+				log.Printf("Skipping truncated line %d of write log %s", line_number, path)
+			case err != nil:
+				return nil, fmt.Errorf("Failed to read line %d of write log %s: %v", line_number, path, err)
+			default:
+				res = append(res, record)
+			}
+		}
+		if read_err == io.EOF {
+			return res, nil
+		}
+	}
+}
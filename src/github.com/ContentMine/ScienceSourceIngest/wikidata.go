@@ -0,0 +1,188 @@
+//   Copyright 2018 Content Mine Ltd
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ContentMine/ScienceSourceIngest/logging"
+	"github.com/ContentMine/ScienceSourceIngest/sciencesource"
+	"github.com/ContentMine/ScienceSourceIngest/wikibase"
+)
+
+// The wikidata command points the Wikidata items of finished papers back at their ScienceSource
+// articles. It has to be run twice: first without -write, which checks Wikidata and writes a plan of
+// the edits needed, and then, once someone has read the plan, with -write to make exactly those edits.
+
+func runWikidata(args []string) {
+
+	flags := flag.NewFlagSet("wikidata", flag.ExitOnError)
+	var options commandFlags
+	var wikidata_url string
+	var wikidata_oauth_path string
+	var plan_path string
+	var write bool
+	var limit int
+	options.register(flags)
+	flags.StringVar(&options.urlBase, "urlbase", "", "Public base URL of the science source server the articles are on, required.")
+	flags.StringVar(&wikidata_url, "wikidataurl", sciencesource.DefaultWikidataURL, "Base URL for Wikidata.")
+	flags.StringVar(&wikidata_oauth_path, "wikidataoauth", "", "JSON file with oauth credentials for Wikidata, required. These must not be the science source credentials.")
+	flags.StringVar(&plan_path, "plan", "", "File the planned edits are written to, or read from with -write, required.")
+	flags.BoolVar(&write, "write", false, "Make the edits in the plan, rather than writing a new plan.")
+	flags.IntVar(&limit, "limit", 10, "The most edits to plan in one go.")
+	flags.Parse(args)
+
+	if len(options.urlBase) == 0 || len(wikidata_oauth_path) == 0 || len(plan_path) == 0 {
+		panic(fmt.Errorf("The wikidata command needs -urlbase, -wikidataoauth, and -plan"))
+	}
+	if strings.HasPrefix(options.urlBase, "https://") == false {
+		panic(fmt.Errorf("Wikidata should only link to a public https server, not %s", options.urlBase))
+	}
+	options.urlBase = strings.TrimSuffix(options.urlBase, "/")
+
+	processors := options.processors()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	credentials, err := wikibase.LoadCredentials(wikidata_oauth_path)
+	if err != nil {
+		panic(err)
+	}
+	wikidata, err := wikibase.NewNetworkClient(credentials, wikidata_url, wikibase.NetworkOptions{
+		Assert:        "user",
+		LookupTimeout: wikibase.DefaultLookupTimeout,
+		WriteTimeout:  wikibase.DefaultWriteTimeout,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if write {
+		os.Exit(writeWikidataPlan(ctx, wikidata, wikidata_url, options.urlBase, plan_path, processors))
+	}
+
+	plan := sciencesource.WikidataPlan{
+		Created:     time.Now().UTC(),
+		WikidataURL: wikidata_url,
+		URLBase:     options.urlBase,
+		Edits:       make([]sciencesource.WikidataEdit, 0),
+	}
+	failed := 0
+	for _, processor := range processors {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all papers were checked: %v", err)
+			os.Exit(1)
+		}
+		if len(plan.Edits) >= limit {
+			logging.Logf(logging.LogNormal, "Planned %d edits, leaving the rest for another plan", limit)
+			break
+		}
+
+		article, err := processor.Article()
+		if err != nil || article.Complete == false || len(article.WikidataClaim) != 0 {
+			continue
+		}
+		edit, err := sciencesource.WikidataEditFor(ctx, wikidata, options.urlBase, processor.Paper.ID(), article)
+		if err != nil {
+			log.Printf("Failed to check Wikidata for paper %s: %v", processor.Paper.ID(), err)
+			failed += 1
+			continue
+		}
+		if edit != nil {
+			plan.Edits = append(plan.Edits, *edit)
+			fmt.Printf("%s\t%s\t%s\t%s\n", edit.Paper, edit.Item, edit.Property, edit.URL)
+		}
+	}
+
+	if err := plan.Save(plan_path); err != nil {
+		panic(err)
+	}
+	logging.Logf(logging.LogNormal, "Wrote %d edits to %s, check them and then run again with -write", len(plan.Edits), plan_path)
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
+
+// writeWikidataPlan makes the edits in a plan, returning the exit code for the command.
+func writeWikidataPlan(ctx context.Context, wikidata *wikibase.NetworkClient, wikidata_url string, url_base string,
+	plan_path string, processors []sciencesource.PaperProcessor) int {
+
+	plan, err := sciencesource.LoadWikidataPlan(plan_path, wikidata_url, url_base)
+	if err != nil {
+		panic(err)
+	}
+
+	by_id := make(map[string]sciencesource.PaperProcessor)
+	for _, processor := range processors {
+		by_id[processor.Paper.ID()] = processor
+	}
+
+	failed := 0
+	for _, edit := range plan.Edits {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Stopping before all edits were made: %v", err)
+			return 1
+		}
+
+		processor, ok := by_id[edit.Paper]
+		if ok == false {
+			log.Printf("Paper %s in the plan isn't in the feed, skipping it", edit.Paper)
+			failed += 1
+			continue
+		}
+		article, err := processor.Article()
+		if err != nil {
+			log.Printf("Failed to load paper record for %s: %v", edit.Paper, err)
+			failed += 1
+			continue
+		}
+		if len(article.WikidataClaim) != 0 {
+			continue
+		}
+
+		claim, err := sciencesource.ApplyWikidataEdit(ctx, wikidata, url_base, edit, article)
+		if err != nil {
+			log.Printf("Failed to edit Wikidata item %s for paper %s: %v", edit.Item, edit.Paper, err)
+			failed += 1
+			continue
+		}
+		if len(claim) == 0 {
+			logging.Logf(logging.LogNormal, "Wikidata item %s already links to paper %s", edit.Item, edit.Paper)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", edit.Paper, edit.Item, claim)
+
+		article.WikidataClaim = claim
+		if save_err := processor.SaveArticle(article); save_err != nil {
+			log.Printf("Failed to save paper record for %s: %v", edit.Paper, save_err)
+			failed += 1
+		}
+	}
+
+	if failed != 0 {
+		return 1
+	}
+	return 0
+}